@@ -0,0 +1,22 @@
+package api
+
+import (
+	"recipes/db"
+	"strings"
+
+	"github.com/labstack/echo/v4"
+)
+
+// HeaderUserID holds the user making the request, recorded as the author of the revisions
+const HeaderUserID = "X-User-ID"
+
+// Put the user of the request in its context for the db package
+func actorMiddleware(next echo.HandlerFunc) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		if actor := strings.TrimSpace(c.Request().Header.Get(HeaderUserID)); actor != "" {
+			req := c.Request()
+			c.SetRequest(req.WithContext(db.WithActor(req.Context(), actor)))
+		}
+		return next(c)
+	}
+}
@@ -3,6 +3,8 @@ package api
 import (
 	"recipes/configuration"
 	"recipes/db"
+	"recipes/preferences"
+	"recipes/units"
 
 	"github.com/labstack/echo/v4"
 	"go.opentelemetry.io/otel"
@@ -10,16 +12,24 @@ import (
 )
 
 type ApiHandler struct {
-	dbh  *db.DbHandler
+	dbh    db.RecipeStore
 	tracer trace.Tracer
-	conf *configuration.Configuration
+	conf   *configuration.Configuration
+	stats  *statsCache
+	// The densities of the ingredients, for the conversions between a volume and a mass
+	densities *units.DensityRegistry
+	// The presentation preferences of the users
+	preferences *preferences.Store
 }
 
-func NewApiHandler(dbh *db.DbHandler, conf *configuration.Configuration) *ApiHandler {
+func NewApiHandler(dbh db.RecipeStore, conf *configuration.Configuration) *ApiHandler {
 	handler := ApiHandler{
-		dbh:  dbh,
-		tracer: otel.Tracer(conf.OtelServiceName),
-		conf: conf,
+		dbh:         dbh,
+		tracer:      otel.Tracer(conf.OtelServiceName),
+		conf:        conf,
+		stats:       &statsCache{ttl: conf.StatsCacheTTL},
+		densities:   units.NewDensityRegistry(),
+		preferences: preferences.NewStore(),
 	}
 	return &handler
 }
@@ -31,13 +41,41 @@ func (api *ApiHandler) Register(v1 *echo.Group, conf *configuration.Configuratio
 	health.GET("/live", api.getAliveStatus)
 	health.GET("/ready", api.getReadyStatus)
 
-	recipes := v1.Group("/recipe")
+	v1.GET("/cache/stats", api.getCacheStats)
+	v1.GET("/units/convert", api.convertAmount)
+
+	admin := v1.Group("/admin", api.adminMiddleware)
+	admin.GET("/densities", api.getDensities)
+	admin.GET("/densities/:ingredientId", api.getDensity)
+	admin.PUT("/densities/:ingredientId", api.putDensity)
+	admin.DELETE("/densities/:ingredientId", api.deleteDensity)
+
+	preferred := v1.Group("/preferences", api.tenantMiddleware)
+	preferred.GET("", api.getPreferences)
+	preferred.PUT("", api.putPreferences)
+
+	recipes := v1.Group("/recipe", api.tenantMiddleware, api.systemMiddleware)
 	recipes.GET("", api.getRecipes)
+	recipes.GET("/search", api.searchRecipes)
+	recipes.GET("/stats", api.getRecipeStats)
+	recipes.GET("/trash", api.getTrashedRecipes)
+	recipes.GET("/duplicates", api.getDuplicates)
+	recipes.GET("/export", api.exportRecipes)
+	recipes.POST("/import", api.importRecipes)
 	recipes.GET("/:id", api.getRecipeByID)
 	recipes.GET("/user/:id", api.getRecipesFromAuthor)
 	recipes.GET("/ingredient/:id", api.getRecipeByIngredientID)
 	recipes.GET("/title/:title", api.getRecipeByTitle)
 	recipes.POST("", api.saveRecipe)
 	recipes.PUT("/:id", api.updateRecipe)
+	recipes.PATCH("/:id", api.patchRecipe)
 	recipes.DELETE("/:id", api.deleteRecipe)
+	recipes.POST("/:id/restore", api.restoreRecipe)
+	recipes.GET("/:id/scaled", api.getScaledRecipe)
+	recipes.GET("/:id/revisions", api.getRevisions)
+	recipes.GET("/:id/revisions/:rev", api.getRevision)
+	recipes.GET("/:id/diff", api.diffRevisions)
+	recipes.POST("/:id/revert/:rev", api.revertRecipe)
+	recipes.POST("/:id/images", api.uploadRecipeImage)
+	recipes.GET("/:id/images/:imageId", api.getRecipeImage)
 }
@@ -0,0 +1,268 @@
+package api
+
+import (
+	"bufio"
+	"bytes"
+	"cmp"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"recipes/db"
+	"slices"
+
+	"github.com/labstack/echo/v4"
+	"github.com/sirupsen/logrus"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+const (
+	MIMEApplicationNDJSON = "application/x-ndjson"
+	// Number of valid recipes written to the store at once by an import
+	importBatchSize = 500
+	// Longest line of an import, a longer line is reported without being buffered
+	maxImportLineBytes = 1 << 20
+)
+
+var errImportLineTooLong = fmt.Errorf("the line is longer than %v bytes", maxImportLineBytes)
+
+// ImportLine reports what happened to one line of an import
+type ImportLine struct {
+	Line   int             `json:"line"`
+	ID     string          `json:"id,omitempty"`
+	Status db.ImportStatus `json:"status"`
+	// The status POST /recipe would answer a failed line with
+	Code   int      `json:"code,omitempty"`
+	Errors []string `json:"errors,omitempty"`
+}
+
+// ImportReport counts the outcomes of an import and details them line by line
+type ImportReport struct {
+	DryRun  bool         `json:"dry_run"`
+	Created int          `json:"created"`
+	Updated int          `json:"updated"`
+	Skipped int          `json:"skipped"`
+	Failed  int          `json:"failed"`
+	Lines   []ImportLine `json:"lines"`
+}
+
+func (r *ImportReport) add(line ImportLine) {
+	switch line.Status {
+	case db.ImportCreated:
+		r.Created++
+	case db.ImportUpdated:
+		r.Updated++
+	case db.ImportSkipped:
+		r.Skipped++
+	default:
+		r.Failed++
+	}
+	r.Lines = append(r.Lines, line)
+}
+
+// The messages of a validation error, translated when the translation is enabled
+func validationMessages(err error) []string {
+	var httpError *echo.HTTPError
+	if errors.As(err, &httpError) {
+		if validationErrors, ok := httpError.Message.(*ValidationErrors); ok {
+			return validationErrors.Errors
+		}
+	}
+	return []string{err.Error()}
+}
+
+// Import the NDJSON body, one recipe by line. The invalid lines are reported and the others imported by batches.
+func (api *ApiHandler) importRecipes(c echo.Context) error {
+	l := logger.WithField("request", "importRecipes")
+	params := new(ImportParams)
+	if err := (&echo.DefaultBinder{}).BindQueryParams(c, params); err != nil {
+		return NewBadRequestError(err)
+	}
+	if err := c.Validate(params); err != nil {
+		return err
+	}
+	opts := db.ImportOptions{Mode: db.ImportMode(params.Mode), DryRun: params.DryRun}
+
+	report := &ImportReport{DryRun: opts.DryRun, Lines: make([]ImportLine, 0)}
+	batch := make([]db.Recipe, 0, importBatchSize)
+	batchLines := make([]int, 0, importBatchSize)
+	flush := func() {
+		if len(batch) == 0 {
+			return
+		}
+		outcomes, err := api.dbh.ImportRecipes(c.Request().Context(), l, batch, opts)
+		for i := range batch {
+			line := ImportLine{Line: batchLines[i], ID: batch[i].ID.Hex()}
+			switch {
+			case err != nil:
+				line.Status, line.Code, line.Errors = db.ImportFailed, dbErrorStatus(err), []string{err.Error()}
+			case outcomes[i].Err != nil:
+				line.Status, line.Code, line.Errors = db.ImportFailed, dbErrorStatus(outcomes[i].Err), []string{outcomes[i].Err.Error()}
+			default:
+				line.Status = outcomes[i].Status
+			}
+			report.add(line)
+		}
+		batch, batchLines = batch[:0], batchLines[:0]
+	}
+
+	// The IDs met so far, a repeated ID would be imported twice
+	seen := make(map[primitive.ObjectID]int)
+	// The recipes accepted so far by normalized name, the ones of a dry run or of the pending batch are not stored yet
+	accepted := make(map[string][]db.Duplicate)
+	findDuplicates := func(recipe *db.Recipe) ([]db.Duplicate, error) {
+		duplicates, err := api.dbh.FindDuplicates(c.Request().Context(), l, recipe)
+		if err != nil {
+			return nil, err
+		}
+		for _, candidate := range accepted[db.NormalizeName(recipe.Name)] {
+			stored := slices.ContainsFunc(duplicates, func(d db.Duplicate) bool { return d.ID == candidate.ID })
+			if candidate.Score = db.IngredientSimilarity(recipe.Ingredients, candidate.Ingredients); !stored && candidate.Score >= db.DuplicateThreshold {
+				duplicates = append(duplicates, candidate)
+			}
+		}
+		slices.SortStableFunc(duplicates, func(a, b db.Duplicate) int { return cmp.Compare(b.Score, a.Score) })
+		return duplicates, nil
+	}
+	reader := bufio.NewReader(c.Request().Body)
+	for number := 1; ; number++ {
+		raw, err := readLine(reader, maxImportLineBytes)
+		if errors.Is(err, errImportLineTooLong) {
+			report.add(ImportLine{Line: number, Status: db.ImportFailed, Code: http.StatusRequestEntityTooLarge, Errors: []string{err.Error()}})
+			continue
+		}
+		if err != nil && !errors.Is(err, io.EOF) {
+			FailOnError(l, err, "Error when trying to read the import")
+			return NewBadRequestError(err)
+		}
+		if raw = bytes.TrimSpace(raw); len(raw) > 0 {
+			var recipe db.Recipe
+			if decodeErr := json.Unmarshal(raw, &recipe); decodeErr != nil {
+				report.add(ImportLine{Line: number, Status: db.ImportFailed, Code: http.StatusBadRequest, Errors: []string{decodeErr.Error()}})
+			} else if exactErr := checkExactAmounts(&recipe); exactErr != nil {
+				report.add(ImportLine{Line: number, ID: recipeHex(&recipe), Status: db.ImportFailed, Code: http.StatusBadRequest, Errors: []string{exactErr.Error()}})
+			} else if validateErr := c.Validate(&recipe); validateErr != nil {
+				report.add(ImportLine{Line: number, ID: recipeHex(&recipe), Status: db.ImportFailed, Code: http.StatusBadRequest, Errors: validationMessages(validateErr)})
+			} else if first, ok := seen[recipe.ID]; ok {
+				report.add(ImportLine{Line: number, ID: recipe.ID.Hex(), Status: db.ImportFailed, Code: http.StatusConflict,
+					Errors: []string{fmt.Sprintf("the ID is already imported by line %v", first)}})
+			} else {
+				if recipe.ID.IsZero() {
+					recipe.ID = api.dbh.NewID()
+				}
+				var duplicates []db.Duplicate
+				var findErr error
+				// The check is not atomic with the insertion, like the one of POST /recipe
+				if !params.AllowDuplicate {
+					duplicates, findErr = findDuplicates(&recipe)
+				}
+				switch {
+				case findErr != nil:
+					WarnOnError(l, findErr, "Error when trying to find duplicates")
+					report.add(ImportLine{Line: number, ID: recipe.ID.Hex(), Status: db.ImportFailed, Code: dbErrorStatus(findErr), Errors: []string{findErr.Error()}})
+				case len(duplicates) > 0:
+					duplicateErr := &db.DuplicateError{Duplicates: duplicates}
+					report.add(ImportLine{Line: number, ID: recipe.ID.Hex(), Status: db.ImportFailed, Code: http.StatusConflict, Errors: []string{duplicateErr.Error()}})
+				default:
+					seen[recipe.ID] = number
+					key := db.NormalizeName(recipe.Name)
+					accepted[key] = append(accepted[key], db.Duplicate{ID: recipe.ID, Name: recipe.Name, Author: recipe.Author, Ingredients: recipe.Ingredients})
+					batch = append(batch, recipe)
+					batchLines = append(batchLines, number)
+					if len(batch) == importBatchSize {
+						flush()
+					}
+				}
+			}
+		}
+		if errors.Is(err, io.EOF) {
+			break
+		}
+	}
+	flush()
+
+	l.WithFields(logrus.Fields{
+		"dryRun":  report.DryRun,
+		"created": report.Created,
+		"updated": report.Updated,
+		"skipped": report.Skipped,
+		"failed":  report.Failed,
+	}).Info("Recipes imported")
+	return c.JSON(http.StatusOK, report)
+}
+
+// Read the next line, up to its end included. A line longer than the limit is read to its end,
+// only the limit is kept in memory, and errImportLineTooLong is returned instead.
+func readLine(reader *bufio.Reader, limit int) ([]byte, error) {
+	var line []byte
+	for {
+		chunk, err := reader.ReadSlice('\n')
+		if len(line)+len(bytes.TrimSuffix(chunk, []byte("\n"))) > limit {
+			for errors.Is(err, bufio.ErrBufferFull) {
+				_, err = reader.ReadSlice('\n')
+			}
+			if err != nil && !errors.Is(err, io.EOF) {
+				return nil, err
+			}
+			return nil, errImportLineTooLong
+		}
+		line = append(line, chunk...)
+		if !errors.Is(err, bufio.ErrBufferFull) {
+			return line, err
+		}
+	}
+}
+
+// The status POST /recipe would answer the error of the store with
+func dbErrorStatus(err error) int {
+	var httpError *echo.HTTPError
+	if errors.As(NewDbError(err, NewInternalServerError), &httpError) {
+		return httpError.Code
+	}
+	return http.StatusInternalServerError
+}
+
+func recipeHex(recipe *db.Recipe) string {
+	if recipe.ID.IsZero() {
+		return ""
+	}
+	return recipe.ID.Hex()
+}
+
+// Stream the recipes as NDJSON, one recipe by line.
+// The status is sent with the first recipe, a later failure can only cut the stream short.
+func (api *ApiHandler) exportRecipes(c echo.Context) error {
+	l := logger.WithField("request", "exportRecipes")
+	res := c.Response()
+	encoder := json.NewEncoder(res)
+	exported := 0
+	err := api.dbh.ExportRecipes(c.Request().Context(), l, func(recipe *db.Recipe) error {
+		if !res.Committed {
+			res.Header().Set(echo.HeaderContentType, MIMEApplicationNDJSON)
+			res.WriteHeader(http.StatusOK)
+		}
+		if err := encoder.Encode(recipe); err != nil {
+			return err
+		}
+		exported++
+		if exported%importBatchSize == 0 {
+			res.Flush()
+		}
+		return nil
+	})
+	if err != nil && !res.Committed {
+		return NewDbError(err, NewInternalServerError)
+	}
+	if err != nil {
+		FailOnError(l, err, "Export interrupted")
+		return nil
+	}
+	if !res.Committed {
+		res.Header().Set(echo.HeaderContentType, MIMEApplicationNDJSON)
+		res.WriteHeader(http.StatusOK)
+	}
+	res.Flush()
+	l.WithField("exported", exported).Info("Recipes exported")
+	return nil
+}
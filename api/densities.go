@@ -0,0 +1,117 @@
+package api
+
+import (
+	"crypto/subtle"
+	"errors"
+	"net/http"
+	"recipes/units"
+	"strings"
+
+	"github.com/labstack/echo/v4"
+)
+
+// ConversionResponse is an amount converted into another unit, Density is the one used between a volume and a mass
+type ConversionResponse struct {
+	Amount  float64        `json:"amount"`
+	Unit    string         `json:"unit"`
+	Density *units.Density `json:"density,omitempty"`
+}
+
+// UseDensities replaces the density registry of the conversions, by default an empty one kept in memory
+func (api *ApiHandler) UseDensities(densities *units.DensityRegistry) {
+	api.densities = densities
+}
+
+// Refuse the requests without the admin token, the admin endpoints are disabled when it is not configured
+func (api *ApiHandler) adminMiddleware(next echo.HandlerFunc) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		if api.conf.AdminToken == "" {
+			return NewForbiddenError(errors.New("the admin endpoints are disabled, set ADMIN_TOKEN"))
+		}
+		token, ok := strings.CutPrefix(c.Request().Header.Get(echo.HeaderAuthorization), "Bearer ")
+		if !ok || subtle.ConstantTimeCompare([]byte(token), []byte(api.conf.AdminToken)) != 1 {
+			logger.WithField("request", "admin").Warn("Admin request refused without a valid token")
+			return NewUnauthorizedError(errors.New("expected the admin bearer token"))
+		}
+		return next(c)
+	}
+}
+
+func (api *ApiHandler) getDensities(c echo.Context) error {
+	return c.JSON(http.StatusOK, api.densities.All())
+}
+
+func (api *ApiHandler) getDensity(c echo.Context) error {
+	id := c.Param("ingredientId")
+	density, ok := api.densities.Get(id)
+	if !ok {
+		return NewNotFoundError(errors.New("no density for ingredient " + id))
+	}
+	return c.JSON(http.StatusOK, density)
+}
+
+// Set the density of an ingredient, 201 when it had none
+func (api *ApiHandler) putDensity(c echo.Context) error {
+	l := logger.WithField("request", "putDensity")
+	params := new(DensityParams)
+	if err := c.Bind(params); err != nil {
+		return NewBadRequestError(err)
+	}
+	if err := c.Validate(params); err != nil {
+		return err
+	}
+	density := units.Density{IngredientID: params.IngredientID, GramsPerML: params.GramsPerML, Note: params.Note}
+	created, err := api.densities.Set(density)
+	if errors.Is(err, units.ErrInvalidDensity) {
+		return NewBadRequestError(err)
+	}
+	if err != nil {
+		FailOnError(l, err, "Error when trying to save the density")
+		return NewInternalServerError(err)
+	}
+	if created {
+		return c.JSON(http.StatusCreated, density)
+	}
+	return c.JSON(http.StatusOK, density)
+}
+
+func (api *ApiHandler) deleteDensity(c echo.Context) error {
+	l := logger.WithField("request", "deleteDensity")
+	id := c.Param("ingredientId")
+	deleted, err := api.densities.Delete(id)
+	if err != nil {
+		FailOnError(l, err, "Error when trying to delete the density")
+		return NewInternalServerError(err)
+	}
+	if !deleted {
+		return NewNotFoundError(errors.New("no density for ingredient " + id))
+	}
+	return c.NoContent(http.StatusNoContent)
+}
+
+// Convert an amount between units, between a volume and a mass with the density of the ingredient.
+// An ingredient without density cannot be converted between a volume and a mass, it is unprocessable.
+func (api *ApiHandler) convertAmount(c echo.Context) error {
+	params := new(ConvertParams)
+	if err := c.Bind(params); err != nil {
+		return NewBadRequestError(err)
+	}
+	if err := c.Validate(params); err != nil {
+		return err
+	}
+	amount, err := api.densities.Convert(params.Ingredient, params.Amount, params.From, params.To)
+	switch {
+	case errors.Is(err, units.ErrUnknownDensity):
+		return NewUnprocessableEntityError(err)
+	case err != nil:
+		return NewBadRequestError(err)
+	}
+	from, _ := units.Lookup(params.From)
+	to, _ := units.Lookup(params.To)
+	response := ConversionResponse{Amount: amount, Unit: to.Abbreviation}
+	if from.Dimension != to.Dimension {
+		density, _ := api.densities.Get(params.Ingredient)
+		response.Density = &density
+	}
+	return c.JSON(http.StatusOK, response)
+}
@@ -1,7 +1,9 @@
 package api
 
 import (
+	"errors"
 	"net/http"
+	"recipes/db"
 	"time"
 
 	"github.com/labstack/echo/v4"
@@ -63,6 +65,16 @@ func NewUnauthorizedError(err error) error {
 	return echo.NewHTTPError(jsonError.Code, jsonError)
 }
 
+func NewForbiddenError(err error) error {
+	jsonError := EchoError{
+		Code:     http.StatusForbidden,
+		Message:  "Forbidden Error",
+		Error:    err.Error(),
+		IssuedAt: time.Now(),
+	}
+	return echo.NewHTTPError(jsonError.Code, jsonError)
+}
+
 func NewBadRequestError(err error) error {
 	jsonError := EchoError{
 		Code:     http.StatusBadRequest,
@@ -83,6 +95,74 @@ func NewUnprocessableEntityError(err error) error {
 	return echo.NewHTTPError(jsonError.Code, jsonError)
 }
 
+func NewGatewayTimeoutError(err error) error {
+	jsonError := EchoError{
+		Code:     http.StatusGatewayTimeout,
+		Message:  "Gateway Timeout Error",
+		Error:    err.Error(),
+		IssuedAt: time.Now(),
+	}
+	return echo.NewHTTPError(jsonError.Code, jsonError)
+}
+
+func NewServiceUnavailableError(err error) error {
+	jsonError := EchoError{
+		Code:     http.StatusServiceUnavailable,
+		Message:  "Service Unavailable Error",
+		Error:    err.Error(),
+		IssuedAt: time.Now(),
+	}
+	return echo.NewHTTPError(jsonError.Code, jsonError)
+}
+
+func NewPreconditionFailedError(err error) error {
+	jsonError := EchoError{
+		Code:     http.StatusPreconditionFailed,
+		Message:  "Precondition Failed Error",
+		Error:    err.Error(),
+		IssuedAt: time.Now(),
+	}
+	return echo.NewHTTPError(jsonError.Code, jsonError)
+}
+
+func NewRequestEntityTooLargeError(err error) error {
+	jsonError := EchoError{
+		Code:     http.StatusRequestEntityTooLarge,
+		Message:  "Request Entity Too Large Error",
+		Error:    err.Error(),
+		IssuedAt: time.Now(),
+	}
+	return echo.NewHTTPError(jsonError.Code, jsonError)
+}
+
+func NewUnsupportedMediaTypeError(err error) error {
+	jsonError := EchoError{
+		Code:     http.StatusUnsupportedMediaType,
+		Message:  "Unsupported Media Type Error",
+		Error:    err.Error(),
+		IssuedAt: time.Now(),
+	}
+	return echo.NewHTTPError(jsonError.Code, jsonError)
+}
+
+// NewDbError maps the errors of the db package having a dedicated HTTP status,
+// the other errors are built with fallback
+func NewDbError(err error, fallback func(error) error) error {
+	switch {
+	case errors.Is(err, db.ErrTimeout):
+		return NewGatewayTimeoutError(err)
+	case errors.Is(err, db.ErrUnavailable):
+		return NewServiceUnavailableError(err)
+	case errors.Is(err, db.ErrVersionMismatch):
+		return NewPreconditionFailedError(err)
+	case errors.Is(err, db.ErrDuplicateID):
+		return NewConflictError(err)
+	case errors.Is(err, db.ErrRecipeNotFound), errors.Is(err, db.ErrRevisionNotFound), errors.Is(err, db.ErrImageNotFound):
+		return NewNotFoundError(err)
+	}
+	return fallback(err)
+}
+
 // Show the log and return true if there was an error
 func FailOnError(logger *logrus.Entry, err error, msg string) bool {
 	if err != nil {
@@ -0,0 +1,84 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"recipes/db"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/labstack/echo/v4"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	HeaderETag    = "ETag"
+	HeaderIfMatch = "If-Match"
+)
+
+// The version of a recipe is its strong entity tag
+func versionETag(version int64) string {
+	return fmt.Sprintf(`"%d"`, version)
+}
+
+func setVersionETag(c echo.Context, version int64) {
+	c.Response().Header().Set(HeaderETag, versionETag(version))
+}
+
+// A representation derived from the stored recipe, e.g. scaled or with some fields only, is described by its variants.
+// It gets a weak entity tag of its own: If-Match only takes strong tags, a write is never conditioned on a derived body.
+func setDerivedETag(c echo.Context, version int64, variants ...string) {
+	variants = slices.DeleteFunc(variants, func(variant string) bool { return variant == "" })
+	if len(variants) == 0 {
+		setVersionETag(c, version)
+		return
+	}
+	hash := fnv.New32a()
+	hash.Write([]byte(strings.Join(variants, "&")))
+	c.Response().Header().Set(HeaderETag, fmt.Sprintf(`W/"%d-%x"`, version, hash.Sum32()))
+}
+
+// Parse the If-Match header into the version the write must find, db.AnyVersion when it is absent or *.
+// When several entity tags are listed, the one of the current recipe is picked if it is among them.
+func (api *ApiHandler) ifMatchVersion(ctx context.Context, c echo.Context, l *logrus.Entry, id string) (int64, error) {
+	header := strings.TrimSpace(c.Request().Header.Get(HeaderIfMatch))
+	if header == "" || header == "*" {
+		return db.AnyVersion, nil
+	}
+
+	versions := make([]int64, 0)
+	for _, tag := range strings.Split(header, ",") {
+		tag = strings.TrimSpace(tag)
+		// If-Match uses the strong comparison, a weak tag never matches
+		if strings.HasPrefix(tag, "W/") {
+			continue
+		}
+		version, err := strconv.ParseInt(strings.Trim(tag, `"`), 10, 64)
+		if err != nil || len(tag) < 3 || !strings.HasPrefix(tag, `"`) || !strings.HasSuffix(tag, `"`) {
+			return 0, NewBadRequestError(fmt.Errorf("invalid entity tag %v in %v", tag, HeaderIfMatch))
+		}
+		// No recipe has a version below the initial one, such a tag would disable the check (db.AnyVersion)
+		if version < db.InitialVersion {
+			return 0, NewPreconditionFailedError(fmt.Errorf("entity tag %v in %v matches no version", tag, HeaderIfMatch))
+		}
+		versions = append(versions, version)
+	}
+
+	switch len(versions) {
+	case 0:
+		return 0, NewPreconditionFailedError(errors.New("no strong entity tag in " + HeaderIfMatch))
+	case 1:
+		return versions[0], nil
+	}
+	recipe, err := api.dbh.FindRecipeByID(ctx, l, id)
+	if err != nil {
+		return 0, NewDbError(err, NewNotFoundError)
+	}
+	if !slices.Contains(versions, recipe.Version) {
+		return 0, NewPreconditionFailedError(db.ErrVersionMismatch)
+	}
+	return recipe.Version, nil
+}
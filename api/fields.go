@@ -0,0 +1,94 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"recipes/db"
+	"strings"
+
+	"github.com/labstack/echo/v4"
+)
+
+// sparsePage is a RecipePage whose recipes only hold the selected fields
+type sparsePage struct {
+	Recipes    []map[string]json.RawMessage `json:"recipes"`
+	NextCursor string                       `json:"next_cursor,omitempty"`
+	Total      int64                        `json:"total"`
+	Facets     *db.Facets                   `json:"facets,omitempty"`
+}
+
+// sparseSearchPage is a SearchPage whose results only hold the selected recipe fields
+type sparseSearchPage struct {
+	Results    []map[string]json.RawMessage `json:"results"`
+	NextCursor string                       `json:"next_cursor,omitempty"`
+	Total      int64                        `json:"total"`
+}
+
+// Bind and check the fields and exclude query parameters
+func bindFieldSet(c echo.Context) (db.FieldSet, error) {
+	params := new(FieldParams)
+	if err := (&echo.DefaultBinder{}).BindQueryParams(c, params); err != nil {
+		return db.FieldSet{}, NewBadRequestError(err)
+	}
+	fields, err := db.NewFieldSet(listValues(params.Fields), listValues(params.Exclude))
+	if err != nil {
+		return db.FieldSet{}, NewBadRequestError(err)
+	}
+	return fields, nil
+}
+
+// The variant of the representation of a recipe with the selected fields only, empty for every field
+func fieldsVariant(fields db.FieldSet) string {
+	if fields.IsZero() {
+		return ""
+	}
+	return "fields=" + strings.Join(fields.Include, ",") + "&exclude=" + strings.Join(fields.Exclude, ",")
+}
+
+// The JSON object of the value without the recipe fields left out by the field set
+func sparseJSON(value any, fields db.FieldSet) (map[string]json.RawMessage, error) {
+	data, err := json.Marshal(value)
+	if err != nil {
+		return nil, err
+	}
+	var object map[string]json.RawMessage
+	if err := json.Unmarshal(data, &object); err != nil {
+		return nil, err
+	}
+	for name := range object {
+		if !fields.Selected(name) {
+			delete(object, name)
+		}
+	}
+	return object, nil
+}
+
+// Send the recipe in the units system of the request, with the selected fields only
+func sendSparse(c echo.Context, recipe *db.Recipe, fields db.FieldSet) error {
+	recipe = presentRecipe(c, recipe)
+	if fields.IsZero() {
+		return c.JSON(http.StatusOK, recipe)
+	}
+	object, err := sparseJSON(recipe, fields)
+	if err != nil {
+		return NewInternalServerError(err)
+	}
+	return c.JSON(http.StatusOK, object)
+}
+
+// Send the search results in the units system of the request, with the selected recipe fields only
+func sendSearchPage(c echo.Context, page *db.SearchPage, fields db.FieldSet) error {
+	page = presentSearchPage(c, page)
+	if fields.IsZero() {
+		return c.JSON(http.StatusOK, page)
+	}
+	sparse := sparseSearchPage{Results: make([]map[string]json.RawMessage, len(page.Results)), NextCursor: page.NextCursor, Total: page.Total}
+	for i := range page.Results {
+		result, err := sparseJSON(&page.Results[i], fields)
+		if err != nil {
+			return NewInternalServerError(err)
+		}
+		sparse.Results[i] = result
+	}
+	return c.JSON(http.StatusOK, sparse)
+}
@@ -0,0 +1,65 @@
+package api
+
+import (
+	"fmt"
+	"recipes/db"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/labstack/echo/v4"
+)
+
+// Prefix of the query parameters matching a metadata value, e.g. metadata.origin=Bretagne
+const metadataParamPrefix = "metadata."
+
+var dishes = []db.Dish{db.Starter, db.Main, db.Dessert}
+
+// Split the values of a list parameter, given repeated or separated by commas
+func listValues(params []string) []string {
+	values := make([]string, 0, len(params))
+	for _, param := range params {
+		for _, value := range strings.Split(param, ",") {
+			if value = strings.TrimSpace(value); value != "" {
+				values = append(values, value)
+			}
+		}
+	}
+	return values
+}
+
+// Bind and validate the filter query parameters of the recipe listing
+func bindFilter(c echo.Context) (db.RecipeFilter, error) {
+	params := new(FilterParams)
+	if err := (&echo.DefaultBinder{}).BindQueryParams(c, params); err != nil {
+		return db.RecipeFilter{}, NewBadRequestError(err)
+	}
+	if err := c.Validate(params); err != nil {
+		return db.RecipeFilter{}, err
+	}
+
+	filter := db.RecipeFilter{
+		ServingsMin:        params.ServingsMin,
+		ServingsMax:        params.ServingsMax,
+		MaxTotalTime:       time.Duration(params.MaxTotalTime) * time.Minute,
+		WithIngredients:    listValues(params.WithIngredients),
+		WithoutIngredients: listValues(params.WithoutIngredients),
+		Author:             params.Author,
+	}
+	for _, value := range listValues(params.Dish) {
+		dish := db.Dish(value)
+		if !slices.Contains(dishes, dish) {
+			return db.RecipeFilter{}, NewBadRequestError(fmt.Errorf("unknown dish %v, expected one of %v", value, dishes))
+		}
+		filter.Dishes = append(filter.Dishes, dish)
+	}
+	for param, values := range c.QueryParams() {
+		if key, ok := strings.CutPrefix(param, metadataParamPrefix); ok {
+			if filter.Metadata == nil {
+				filter.Metadata = make(map[string]string)
+			}
+			filter.Metadata[key] = values[0]
+		}
+	}
+	return filter, nil
+}
@@ -1,6 +1,7 @@
 package api
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"reflect"
@@ -10,7 +11,7 @@ import (
 	"go.opentelemetry.io/otel/codes"
 )
 
-type queryDB func(*logrus.Entry, any) (any, error)
+type queryDB func(context.Context, *logrus.Entry, any) (any, error)
 
 type simpleRequest struct {
 	Context *echo.Context
@@ -46,7 +47,16 @@ func (api *ApiHandler) executeSimpleRequest(s *simpleRequest) (any, error) {
 
 	reqCtx, reqSpan := api.tracer.Start(ctx, fmt.Sprintf("%v.%v", s.Method, "executeDBQuery"))
 	l = l.WithContext(reqCtx)
-	resp, err := s.Dbfunc(l, s.Request)
+	resp, err := s.Dbfunc(reqCtx, l, s.Request)
+
+	if err != nil {
+		errMsg := fmt.Sprintf("Error when trying to call method %v with object %v", s.Method, s.Request)
+		reqSpan.RecordError(err)
+		reqSpan.SetStatus(codes.Error, errMsg)
+		FailOnError(l, err, errMsg)
+		reqSpan.End()
+		return nil, NewDbError(err, NewInternalServerError)
+	}
 
 	// Check with reflect that the response is a pointer to a struct
 	if reflect.ValueOf(resp).IsNil() {
@@ -76,14 +86,6 @@ func (api *ApiHandler) executeSimpleRequest(s *simpleRequest) (any, error) {
 		// )
 	}
 
-	if err != nil {
-		errMsg := fmt.Sprintf("Error when trying to call method %v with object %v", s.Method, s.Request)
-		reqSpan.RecordError(err)
-		reqSpan.SetStatus(codes.Error, errMsg)
-		FailOnError(l, err, errMsg)
-		reqSpan.End()
-		return nil, NewInternalServerError(err)
-	}
 	reqSpan.End()
 	l = l.WithContext(ctx)
 
@@ -0,0 +1,143 @@
+package api
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"recipes/db"
+	"recipes/images"
+	"slices"
+	"strconv"
+
+	"github.com/labstack/echo/v4"
+)
+
+const (
+	// Name of the multipart field holding the uploaded image
+	imageFormField = "image"
+	// Room left to the multipart boundaries and headers around the image
+	multipartOverhead = 64 << 10
+)
+
+// Tell if the size names the original or one of the thumbnails
+func validImageSize(size string) bool {
+	return size == "" || size == db.OriginalSize ||
+		slices.ContainsFunc(images.Sizes, func(s images.Size) bool { return s.Name == size })
+}
+
+// Map the errors of the image processing, the other ones come from an unreadable image
+func newImageError(err error) error {
+	switch {
+	case errors.Is(err, images.ErrUnsupportedType):
+		return NewUnsupportedMediaTypeError(err)
+	case errors.Is(err, images.ErrTooManyPixels):
+		return NewRequestEntityTooLargeError(err)
+	}
+	return NewUnprocessableEntityError(err)
+}
+
+// Store the image of the multipart body with its thumbnails.
+// The type is sniffed from the content and the image becomes the cover if the cover field is true or if the recipe has none.
+func (api *ApiHandler) uploadRecipeImage(c echo.Context) error {
+	l := logger.WithField("request", "uploadRecipeImage")
+	idParam := new(IDParam)
+	// Only the path is bound, the body is read below within the size limit
+	if err := (&echo.DefaultBinder{}).BindPathParams(c, idParam); err != nil {
+		return NewBadRequestError(err)
+	}
+	if err := c.Validate(idParam); err != nil {
+		return err
+	}
+
+	maxBytes := api.conf.ImageMaxBytes
+	tooLarge := fmt.Errorf("the image exceeds %d bytes", maxBytes)
+	req := c.Request()
+	req.Body = http.MaxBytesReader(c.Response(), req.Body, maxBytes+multipartOverhead)
+	header, err := c.FormFile(imageFormField)
+	var maxBytesError *http.MaxBytesError
+	if errors.As(err, &maxBytesError) {
+		l.WithError(err).Warn("Error when trying to read the image")
+		return NewRequestEntityTooLargeError(tooLarge)
+	}
+	if err != nil {
+		l.WithError(err).Warn("Error when trying to read the image")
+		return NewBadRequestError(err)
+	}
+	if header.Size > maxBytes {
+		l.WithField("size", header.Size).Warn("Error when trying to read the image")
+		return NewRequestEntityTooLargeError(tooLarge)
+	}
+	cover := false
+	if value := c.FormValue("cover"); len(value) > 0 {
+		if cover, err = strconv.ParseBool(value); err != nil {
+			return NewBadRequestError(fmt.Errorf("cover must be a boolean: %w", err))
+		}
+	}
+	file, err := header.Open()
+	if err != nil {
+		l.WithError(err).Error("Error when trying to open the image")
+		return NewInternalServerError(err)
+	}
+	defer file.Close()
+	data, err := io.ReadAll(file)
+	if err != nil {
+		l.WithError(err).Error("Error when trying to read the image")
+		return NewInternalServerError(err)
+	}
+
+	processed, err := images.Process(data)
+	if err != nil {
+		l.WithError(err).Warn("Error when trying to process the image")
+		return newImageError(err)
+	}
+	upload := &db.ImageUpload{
+		Image: db.Image{
+			ID:          api.dbh.NewID(),
+			ContentType: processed.ContentType,
+			Size:        int64(len(data)),
+			Width:       processed.Width,
+			Height:      processed.Height,
+			Thumbnails:  make([]db.Thumbnail, 0, len(processed.Thumbnails)),
+		},
+		Original:   db.ImageFile{ContentType: processed.ContentType, Data: data},
+		Thumbnails: make(map[string]db.ImageFile, len(processed.Thumbnails)),
+	}
+	for _, thumbnail := range processed.Thumbnails {
+		upload.Image.Thumbnails = append(upload.Image.Thumbnails, db.Thumbnail{
+			Name:   thumbnail.Name,
+			FileID: api.dbh.NewID(),
+			Width:  thumbnail.Width,
+			Height: thumbnail.Height,
+		})
+		upload.Thumbnails[thumbnail.Name] = db.ImageFile{ContentType: "image/jpeg", Data: thumbnail.Data}
+	}
+	image, err := api.dbh.AddRecipeImage(req.Context(), l, idParam.ID, upload, cover)
+	if err != nil {
+		return NewDbError(err, NewInternalServerError)
+	}
+	return c.JSON(http.StatusCreated, image)
+}
+
+// Serve the original of an image, or one of its thumbnails with the size query parameter
+func (api *ApiHandler) getRecipeImage(c echo.Context) error {
+	l := logger.WithField("request", "getRecipeImage")
+	params := new(ImageParams)
+	if err := c.Bind(params); err != nil {
+		return NewBadRequestError(err)
+	}
+	if err := c.Validate(params); err != nil {
+		return err
+	}
+	if !validImageSize(params.Size) {
+		return NewBadRequestError(fmt.Errorf("unknown image size %q", params.Size))
+	}
+	file, err := api.dbh.OpenRecipeImage(c.Request().Context(), l, params.ID, params.ImageID, params.Size)
+	if err != nil {
+		return NewDbError(err, NewInternalServerError)
+	}
+	// A new upload gets a new ID, the content behind an URL never changes
+	c.Response().Header().Set(echo.HeaderCacheControl, "private, max-age=31536000, immutable")
+	c.Response().Header().Set(echo.HeaderXContentTypeOptions, "nosniff")
+	return c.Blob(http.StatusOK, file.ContentType, file.Data)
+}
@@ -0,0 +1,100 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"recipes/db"
+	"strconv"
+	"strings"
+
+	"github.com/labstack/echo/v4"
+)
+
+var sortFields = map[string]db.SortField{
+	"name":     db.SortByName,
+	"servings": db.SortByServings,
+	"created":  db.SortByCreatedAt,
+}
+
+// Bind and validate the pagination query parameters into the db list options
+func bindListOptions(c echo.Context) (db.ListOptions, error) {
+	params := new(ListParams)
+	if err := (&echo.DefaultBinder{}).BindQueryParams(c, params); err != nil {
+		return db.ListOptions{}, NewBadRequestError(err)
+	}
+	if err := c.Validate(params); err != nil {
+		return db.ListOptions{}, err
+	}
+	if params.Cursor != "" && params.After != "" && params.Cursor != params.After {
+		return db.ListOptions{}, NewBadRequestError(errors.New("cursor and after must not hold different values"))
+	}
+
+	opts := db.ListOptions{
+		Limit:      params.Limit,
+		Cursor:     params.Cursor,
+		Sort:       sortFields[strings.TrimPrefix(params.Sort, "-")],
+		Descending: strings.HasPrefix(params.Sort, "-"),
+	}
+	if opts.Cursor == "" {
+		opts.Cursor = params.After
+	}
+	fields, err := bindFieldSet(c)
+	if err != nil {
+		return db.ListOptions{}, err
+	}
+	opts.Fields = fields
+	return opts, nil
+}
+
+// Send the page with the RFC 8288 Link header pointing to the first and next pages.
+// The recipes are in the units system of the request and only hold the selected fields, the store cleared the others.
+func sendRecipePage(c echo.Context, page *db.RecipePage, opts db.ListOptions) error {
+	setPageLinks(c, page.NextCursor, opts)
+	page = presentPage(c, page)
+	if opts.Fields.IsZero() {
+		return c.JSON(http.StatusOK, page)
+	}
+	sparse := sparsePage{Recipes: make([]map[string]json.RawMessage, len(page.Recipes)), NextCursor: page.NextCursor, Total: page.Total, Facets: page.Facets}
+	for i := range page.Recipes {
+		recipe, err := sparseJSON(&page.Recipes[i], opts.Fields)
+		if err != nil {
+			return NewInternalServerError(err)
+		}
+		sparse.Recipes[i] = recipe
+	}
+	return c.JSON(http.StatusOK, sparse)
+}
+
+func setPageLinks(c echo.Context, nextCursor string, opts db.ListOptions) {
+	links := []string{pageLink(c, "", opts, "first")}
+	if nextCursor != "" {
+		links = append(links, pageLink(c, nextCursor, opts, "next"))
+	}
+	c.Response().Header().Set("Link", strings.Join(links, ", "))
+}
+
+func pageLink(c echo.Context, cursor string, opts db.ListOptions, rel string) string {
+	req := c.Request()
+	query := req.URL.Query()
+	query.Del("after")
+	query.Del("cursor")
+	if cursor != "" {
+		query.Set("cursor", cursor)
+	}
+	if opts.Limit > 0 {
+		query.Set("limit", strconv.Itoa(opts.Limit))
+	}
+	link := url.URL{Scheme: c.Scheme(), Host: req.Host, Path: req.URL.Path, RawQuery: query.Encode()}
+	return fmt.Sprintf(`<%v>; rel="%v"`, link.String(), rel)
+}
+
+// Map the listing errors to their HTTP error
+func NewListError(err error) error {
+	if errors.Is(err, db.ErrInvalidCursor) || errors.Is(err, db.ErrInvalidFilter) || errors.Is(err, db.ErrInvalidFields) {
+		return NewBadRequestError(err)
+	}
+	return NewDbError(err, NewInternalServerError)
+}
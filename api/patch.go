@@ -0,0 +1,162 @@
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"recipes/db"
+	"slices"
+	"strings"
+
+	jsonpatch "github.com/evanphx/json-patch/v5"
+	"github.com/labstack/echo/v4"
+)
+
+const (
+	MIMEApplicationMergePatch = "application/merge-patch+json"
+	MIMEApplicationJSONPatch  = "application/json-patch+json"
+	HeaderAcceptPatch         = "Accept-Patch"
+)
+
+// The largest patch document read, far above any real recipe
+const maxPatchBytes = 1 << 20
+
+// The operations of RFC 6902
+var jsonPatchOperations = []string{"add", "remove", "replace", "move", "copy", "test"}
+
+// Apply the patch document of the media type to the JSON of the recipe.
+// A malformed document is a bad request, a document which cannot be applied to the recipe is a conflict.
+func applyPatch(mediaType string, document []byte, patch []byte) ([]byte, error) {
+	if mediaType == MIMEApplicationMergePatch {
+		if !json.Valid(patch) || !bytes.HasPrefix(bytes.TrimSpace(patch), []byte("{")) {
+			return nil, NewBadRequestError(errors.New("a merge patch must be a JSON object"))
+		}
+		patched, err := jsonpatch.MergePatch(document, patch)
+		if err != nil {
+			return nil, NewBadRequestError(err)
+		}
+		return patched, nil
+	}
+
+	operations, err := jsonpatch.DecodePatch(patch)
+	if err != nil {
+		return nil, NewBadRequestError(err)
+	}
+	for i, operation := range operations {
+		if !slices.Contains(jsonPatchOperations, operation.Kind()) {
+			return nil, NewBadRequestError(fmt.Errorf("operation %v: unknown op %q", i, operation.Kind()))
+		}
+		if _, err := operation.Path(); err != nil {
+			return nil, NewBadRequestError(fmt.Errorf("operation %v: %w", i, err))
+		}
+	}
+	patched, err := operations.Apply(document)
+	if err != nil {
+		return nil, NewConflictError(err)
+	}
+	return patched, nil
+}
+
+// Check the patch left alone the fields managed by the service
+func checkReadOnlyFields(stored *db.Recipe, patched *db.Recipe) error {
+	var changed []string
+	if patched.ID != stored.ID {
+		changed = append(changed, "id")
+	}
+	if patched.Version != stored.Version {
+		changed = append(changed, "version")
+	}
+	if !patched.CreatedAt.Equal(stored.CreatedAt) {
+		changed = append(changed, "created_at")
+	}
+	if patched.DeletedAt != nil {
+		changed = append(changed, "deleted_at")
+	}
+	storedImages, _ := json.Marshal(stored.Images)
+	patchedImages, _ := json.Marshal(patched.Images)
+	if !bytes.Equal(storedImages, patchedImages) {
+		changed = append(changed, "images")
+	}
+	if len(changed) > 0 {
+		return fmt.Errorf("read-only fields cannot be patched: %v", strings.Join(changed, ", "))
+	}
+	return nil
+}
+
+// Patch the recipe with a JSON Merge Patch (RFC 7396) or a JSON Patch (RFC 6902), according to the content type.
+// The patched recipe is validated like a PUT, then written at the version it was read.
+// A patch which cannot be applied to the recipe, e.g. a failed test operation, is a conflict.
+func (api *ApiHandler) patchRecipe(c echo.Context) error {
+	l := logger.WithField("request", "patchRecipe")
+	ctx := c.Request().Context()
+	id := c.Param("id")
+
+	mediaType, _, _ := mime.ParseMediaType(c.Request().Header.Get(echo.HeaderContentType))
+	if mediaType != MIMEApplicationMergePatch && mediaType != MIMEApplicationJSONPatch {
+		c.Response().Header().Set(HeaderAcceptPatch, MIMEApplicationMergePatch+", "+MIMEApplicationJSONPatch)
+		return NewUnsupportedMediaTypeError(fmt.Errorf("a patch must be %v or %v", MIMEApplicationMergePatch, MIMEApplicationJSONPatch))
+	}
+	req := c.Request()
+	req.Body = http.MaxBytesReader(c.Response(), req.Body, maxPatchBytes)
+	patch, err := io.ReadAll(req.Body)
+	var maxBytesError *http.MaxBytesError
+	if errors.As(err, &maxBytesError) {
+		WarnOnError(l, err, "Error when trying to read the patch")
+		return NewRequestEntityTooLargeError(fmt.Errorf("the patch exceeds %d bytes", maxPatchBytes))
+	}
+	if err != nil {
+		FailOnError(l, err, "Error when trying to read the patch")
+		return NewBadRequestError(err)
+	}
+
+	version, err := api.ifMatchVersion(ctx, c, l, id)
+	if err != nil {
+		return err
+	}
+	stored, err := api.dbh.FindRecipeByID(ctx, l, id)
+	if err != nil {
+		return NewDbError(err, NewNotFoundError)
+	}
+	if version != db.AnyVersion && version != stored.Version {
+		return NewPreconditionFailedError(db.ErrVersionMismatch)
+	}
+
+	document, err := json.Marshal(stored)
+	if err != nil {
+		return NewInternalServerError(err)
+	}
+	patched, err := applyPatch(mediaType, document, patch)
+	if err != nil {
+		WarnOnError(l, err, "Error when trying to apply the patch")
+		return err
+	}
+	recipe := new(db.Recipe)
+	decoder := json.NewDecoder(bytes.NewReader(patched))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(recipe); err != nil {
+		return NewBadRequestError(err)
+	}
+	if err := checkReadOnlyFields(stored, recipe); err != nil {
+		return NewBadRequestError(err)
+	}
+	if err := checkExactAmounts(recipe); err != nil {
+		return NewBadRequestError(err)
+	}
+	// Like a PUT, an invalid recipe is a bad request
+	if err := c.Validate(recipe); err != nil {
+		FailOnError(l, err, "Validation failed")
+		return NewBadRequestError(err)
+	}
+
+	// A write between the read and this one fails instead of being overwritten
+	if err := api.dbh.UpsertOne(ctx, l, recipe); err != nil {
+		FailOnError(l, err, "Error when trying to patch recipe")
+		return NewDbError(err, NewInternalServerError)
+	}
+	setVersionETag(c, recipe.Version)
+	return c.JSON(http.StatusOK, recipe)
+}
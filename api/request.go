@@ -3,3 +3,93 @@ package api
 type IDParam struct {
 	ID string `param:"id" validate:"required"`
 }
+
+// SaveParams are the query parameters of the creation of a recipe, allow_duplicate skips the duplicate detection
+type SaveParams struct {
+	AllowDuplicate bool `query:"allow_duplicate"`
+}
+
+// ListParams are the pagination and sorting query parameters of the recipe listings.
+// after is an alias of cursor, sort may be prefixed with - to sort in descending order.
+type ListParams struct {
+	Limit  int    `query:"limit" validate:"omitempty,min=1,max=100"`
+	Cursor string `query:"cursor"`
+	After  string `query:"after"`
+	Sort   string `query:"sort" validate:"omitempty,oneof=name -name servings -servings created -created"`
+}
+
+// FieldParams select the fields of the returned recipes by their JSON names, repeated or separated by commas.
+// The ID is always returned.
+type FieldParams struct {
+	Fields  []string `query:"fields"`
+	Exclude []string `query:"exclude"`
+}
+
+// FilterParams are the filters of the recipe listing, the list parameters are repeated or separated by commas.
+// max_total_time is in minutes, the metadata values are matched by the metadata.<key> parameters.
+type FilterParams struct {
+	Dish               []string `query:"dish"`
+	ServingsMin        int      `query:"servings_min" validate:"omitempty,min=1"`
+	ServingsMax        int      `query:"servings_max" validate:"omitempty,min=1,gtefield=ServingsMin"`
+	MaxTotalTime       int      `query:"max_total_time" validate:"omitempty,min=1"`
+	WithIngredients    []string `query:"with_ingredients"`
+	WithoutIngredients []string `query:"without_ingredients"`
+	Author             string   `query:"author"`
+}
+
+// SearchParams are the query parameters of the full-text search
+type SearchParams struct {
+	Query  string `query:"q" validate:"required"`
+	Limit  int    `query:"limit" validate:"omitempty,min=1,max=100"`
+	Cursor string `query:"cursor"`
+	After  string `query:"after"`
+}
+
+// RevisionParams identify a revision of a recipe
+type RevisionParams struct {
+	ID       string `param:"id" validate:"required"`
+	Revision int64  `param:"rev" validate:"required,min=1"`
+}
+
+// ImageParams identify an image of a recipe, size picks one of its thumbnails instead of the original
+type ImageParams struct {
+	ID      string `param:"id" validate:"required"`
+	ImageID string `param:"imageId" validate:"required"`
+	Size    string `query:"size"`
+}
+
+// DiffParams are the two revisions of a recipe to compare
+type DiffParams struct {
+	ID   string `param:"id" validate:"required"`
+	From int64  `query:"from" validate:"required,min=1"`
+	To   int64  `query:"to" validate:"required,min=1"`
+}
+
+// ImportParams are the query parameters of the NDJSON import, allow_duplicate skips the duplicate detection
+type ImportParams struct {
+	Mode           string `query:"mode" validate:"omitempty,oneof=skip upsert"`
+	DryRun         bool   `query:"dry_run"`
+	AllowDuplicate bool   `query:"allow_duplicate"`
+}
+
+// DensityParams are the density of an ingredient in grams per millilitre, set by an admin
+type DensityParams struct {
+	IngredientID string  `param:"ingredientId" json:"-" validate:"required"`
+	GramsPerML   float64 `json:"grams_per_ml" validate:"required,gt=0"`
+	Note         string  `json:"note"`
+}
+
+// ConvertParams are an amount to convert between units, ingredient gives the density between a volume and a mass
+type ConvertParams struct {
+	Amount     float64 `query:"amount" validate:"required,gt=0"`
+	From       string  `query:"from" validate:"required"`
+	To         string  `query:"to" validate:"required"`
+	Ingredient string  `query:"ingredient"`
+}
+
+// ScaleParams scale a recipe to a number of servings, or by a factor
+type ScaleParams struct {
+	ID       string  `param:"id" validate:"required"`
+	Servings int     `query:"servings" validate:"omitempty,min=1,max=10000"`
+	Factor   float64 `query:"factor" validate:"omitempty,gt=0,max=100"`
+}
@@ -0,0 +1,102 @@
+package api
+
+import (
+	"errors"
+	"net/http"
+	"recipes/db"
+
+	"github.com/labstack/echo/v4"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func (api *ApiHandler) getRevisions(c echo.Context) error {
+	l := logger.WithField("request", "getRevisions")
+	idParam := new(IDParam)
+	if err := c.Bind(idParam); err != nil {
+		return NewBadRequestError(err)
+	}
+	if err := c.Validate(idParam); err != nil {
+		return err
+	}
+	revisions, err := api.dbh.FindRevisions(c.Request().Context(), l, idParam.ID)
+	if err != nil {
+		return NewDbError(err, NewInternalServerError)
+	}
+	if len(revisions) == 0 {
+		err = errors.New("no revision found for recipe id")
+		l.Error(err)
+		return NewNotFoundError(err)
+	}
+	return c.JSON(http.StatusOK, revisions)
+}
+
+func (api *ApiHandler) getRevision(c echo.Context) error {
+	l := logger.WithField("request", "getRevision")
+	params := new(RevisionParams)
+	if err := c.Bind(params); err != nil {
+		return NewBadRequestError(err)
+	}
+	if err := c.Validate(params); err != nil {
+		return err
+	}
+	revision, err := api.dbh.FindRevision(c.Request().Context(), l, params.ID, params.Revision)
+	if err != nil {
+		return NewDbError(err, NewInternalServerError)
+	}
+	return c.JSON(http.StatusOK, revision)
+}
+
+func (api *ApiHandler) diffRevisions(c echo.Context) error {
+	l := logger.WithField("request", "diffRevisions")
+	params := new(DiffParams)
+	if err := c.Bind(params); err != nil {
+		return NewBadRequestError(err)
+	}
+	if err := c.Validate(params); err != nil {
+		return err
+	}
+	ctx := c.Request().Context()
+	from, err := api.dbh.FindRevision(ctx, l, params.ID, params.From)
+	if err != nil {
+		return NewDbError(err, NewInternalServerError)
+	}
+	to, err := api.dbh.FindRevision(ctx, l, params.ID, params.To)
+	if err != nil {
+		return NewDbError(err, NewInternalServerError)
+	}
+	return c.JSON(http.StatusOK, db.DiffRevisions(from, to))
+}
+
+// Write the snapshot of a revision as the next version of the recipe
+func (api *ApiHandler) revertRecipe(c echo.Context) error {
+	l := logger.WithField("request", "revertRecipe")
+	params := new(RevisionParams)
+	if err := c.Bind(params); err != nil {
+		return NewBadRequestError(err)
+	}
+	if err := c.Validate(params); err != nil {
+		return err
+	}
+	id, err := primitive.ObjectIDFromHex(params.ID)
+	if err != nil {
+		return NewNotFoundError(err)
+	}
+	ctx := c.Request().Context()
+	revision, err := api.dbh.FindRevision(ctx, l, params.ID, params.Revision)
+	if err != nil {
+		return NewDbError(err, NewInternalServerError)
+	}
+
+	recipe := revision.Recipe
+	recipe.ID = id
+	recipe.Version, err = api.ifMatchVersion(ctx, c, l, params.ID)
+	if err != nil {
+		return err
+	}
+	if err := api.dbh.UpsertOne(ctx, l, recipe); err != nil {
+		FailOnError(l, err, "Error when trying to revert recipe")
+		return NewDbError(err, NewInternalServerError)
+	}
+	setVersionETag(c, recipe.Version)
+	return c.JSON(http.StatusOK, recipe)
+}
@@ -1,7 +1,6 @@
 package api
 
 import (
-	"context"
 	"errors"
 	"net/http"
 	"recipes/db"
@@ -31,7 +30,7 @@ func (api *ApiHandler) getAliveStatus(c echo.Context) error {
 
 func (api *ApiHandler) getReadyStatus(c echo.Context) error {
 	l := logger.WithField("request", "getReadyStatus")
-	err := api.dbh.Client.Ping(context.Background(), nil)
+	err := api.dbh.Ping(c.Request().Context())
 	if err != nil {
 		WarnOnError(l, err, "Unable to ping database to check connection.")
 		return c.JSON(http.StatusServiceUnavailable, NewHealthResponse(NotReadyStatus))
@@ -42,47 +41,98 @@ func (api *ApiHandler) getReadyStatus(c echo.Context) error {
 
 func (api *ApiHandler) getRecipes(c echo.Context) error {
 	l := logger.WithField("request", "getRecipes")
-	recipes, err := api.dbh.FindAllRecipes(l)
+	opts, err := bindListOptions(c)
 	if err != nil {
-		return NewNotFoundError(err)
+		return err
+	}
+	opts.Filter, err = bindFilter(c)
+	if err != nil {
+		return err
+	}
+	opts.WithFacets = true
+	page, err := api.dbh.FindAllRecipes(c.Request().Context(), l, opts)
+	if err != nil {
+		return NewListError(err)
 	}
-	return c.JSON(http.StatusOK, recipes)
+	return sendRecipePage(c, page, opts)
+}
+
+func (api *ApiHandler) searchRecipes(c echo.Context) error {
+	l := logger.WithField("request", "searchRecipes")
+	params := new(SearchParams)
+	if err := (&echo.DefaultBinder{}).BindQueryParams(c, params); err != nil {
+		return NewBadRequestError(err)
+	}
+	if err := c.Validate(params); err != nil {
+		return err
+	}
+	opts := db.ListOptions{Limit: params.Limit, Cursor: params.Cursor}
+	if opts.Cursor == "" {
+		opts.Cursor = params.After
+	}
+	// The search reads the whole recipes for the highlights, the fields are only left out of the response
+	fields, err := bindFieldSet(c)
+	if err != nil {
+		return err
+	}
+
+	page, err := api.dbh.SearchRecipes(c.Request().Context(), l, params.Query, opts)
+	if err != nil {
+		return NewListError(err)
+	}
+	setPageLinks(c, page.NextCursor, opts)
+	return sendSearchPage(c, page, fields)
 }
 
 func (api *ApiHandler) getRecipeByTitle(c echo.Context) error {
 	l := logger.WithField("request", "getRecipeByTitle")
 	title := c.Param("title")
-	recipe, err := api.dbh.FindRecipeByTitle(l, title)
+	fields, err := bindFieldSet(c)
 	if err != nil {
-		return NewNotFoundError(err)
+		return err
 	}
-	return c.JSON(http.StatusOK, recipe)
+	recipe, err := api.dbh.FindRecipeByTitle(c.Request().Context(), l, title)
+	if err != nil {
+		return NewDbError(err, NewNotFoundError)
+	}
+	return sendSparse(c, recipe, fields)
 
 }
 
 func (api *ApiHandler) getRecipeByID(c echo.Context) error {
 	l := logger.WithField("request", "getRecipeByID")
 	id := c.Param("id")
-	recipe, err := api.dbh.FindRecipeByID(l, id)
+	fields, err := bindFieldSet(c)
 	if err != nil {
-		return NewNotFoundError(err)
+		return err
 	}
-	return c.JSON(http.StatusOK, recipe)
+	// The recipe is read whole, from the cache when there is one, and the fields left out of the response
+	recipe, err := api.dbh.FindRecipeByID(c.Request().Context(), l, id)
+	if err != nil {
+		return NewDbError(err, NewNotFoundError)
+	}
+	setDerivedETag(c, recipe.Version, fieldsVariant(fields), systemVariant(c))
+	return sendSparse(c, recipe, fields)
 }
 
 func (api *ApiHandler) getRecipeByIngredientID(c echo.Context) error {
 	l := logger.WithField("request", "getRecipeByIngredientID")
 	id := c.Param("id")
-
-	recipes, err := api.dbh.FindRecipesByIngredientID(l, id)
-	if len(*recipes) == 0 {
-		err = errors.Join(errors.New("no recipe found for ingredient id"), err)
-		l.Error(err)
+	opts, err := bindListOptions(c)
+	if err != nil {
+		return err
 	}
+
+	page, err := api.dbh.FindRecipesByIngredientID(c.Request().Context(), l, id, opts)
 	if err != nil {
+		return NewListError(err)
+	}
+	if page.Total == 0 {
+		err = errors.New("no recipe found for ingredient id")
+		l.Error(err)
 		return NewNotFoundError(err)
 	}
-	return c.JSON(http.StatusOK, recipes)
+	return sendRecipePage(c, page, opts)
 }
 
 func (api *ApiHandler) saveRecipe(c echo.Context) error {
@@ -92,31 +142,89 @@ func (api *ApiHandler) saveRecipe(c echo.Context) error {
 		FailOnError(l, err, "Request binding failed")
 		return NewInternalServerError(err)
 	}
+	if err := checkExactAmounts(recipe); err != nil {
+		WarnOnError(l, err, "Approximate amounts refused")
+		return NewBadRequestError(err)
+	}
 	if err := c.Validate(recipe); err != nil {
 		FailOnError(l, err, "Validation failed")
 		return NewBadRequestError(err)
 	}
-	if recipe.ID.String() == "" {
+	params := new(SaveParams)
+	if err := (&echo.DefaultBinder{}).BindQueryParams(c, params); err != nil {
+		FailOnError(l, err, "Query binding failed")
+		return NewBadRequestError(err)
+	}
+	if recipe.ID.IsZero() {
 		recipe.ID = api.dbh.NewID()
 	}
-	err := api.dbh.SaveRecipe(l, *recipe)
+	// The check is not atomic with the insertion, two concurrent copies may both be saved
+	if !params.AllowDuplicate {
+		duplicates, err := api.dbh.FindDuplicates(c.Request().Context(), l, recipe)
+		if err != nil {
+			FailOnError(l, err, "Error when trying to find duplicates")
+			return NewDbError(err, NewInternalServerError)
+		}
+		if len(duplicates) > 0 {
+			return NewConflictError(&db.DuplicateError{Duplicates: duplicates})
+		}
+	}
+	err := api.dbh.SaveRecipe(c.Request().Context(), l, recipe)
 	if err != nil {
 		FailOnError(l, err, "Error when trying to save recipe")
-		return NewInternalServerError(err)
+		return NewDbError(err, NewInternalServerError)
 	}
+	setVersionETag(c, recipe.Version)
 	return c.JSON(http.StatusCreated, recipe)
 }
 
+func (api *ApiHandler) getDuplicates(c echo.Context) error {
+	l := logger.WithField("request", "getDuplicates")
+	clusters, err := api.dbh.FindDuplicateClusters(c.Request().Context(), l)
+	if err != nil {
+		FailOnError(l, err, "Error when trying to find duplicates")
+		return NewDbError(err, NewInternalServerError)
+	}
+	return c.JSON(http.StatusOK, clusters)
+}
+
 func (api *ApiHandler) deleteRecipe(c echo.Context) error {
 	l := logger.WithField("request", "deleteRecipeByID")
 	id := c.Param("id")
-	err := api.dbh.DeleteRecipeByID(l, id)
+	version, err := api.ifMatchVersion(c.Request().Context(), c, l, id)
 	if err != nil {
-		return NewNotFoundError(err)
+		return err
+	}
+	err = api.dbh.DeleteRecipeByID(c.Request().Context(), l, id, version)
+	if err != nil {
+		return NewDbError(err, NewNotFoundError)
 	}
 	return c.NoContent(http.StatusNoContent)
 }
 
+func (api *ApiHandler) getTrashedRecipes(c echo.Context) error {
+	l := logger.WithField("request", "getTrashedRecipes")
+	opts, err := bindListOptions(c)
+	if err != nil {
+		return err
+	}
+	page, err := api.dbh.FindTrashedRecipes(c.Request().Context(), l, opts)
+	if err != nil {
+		return NewListError(err)
+	}
+	return sendRecipePage(c, page, opts)
+}
+
+func (api *ApiHandler) restoreRecipe(c echo.Context) error {
+	l := logger.WithField("request", "restoreRecipe")
+	recipe, err := api.dbh.RestoreRecipeByID(c.Request().Context(), l, c.Param("id"))
+	if err != nil {
+		return NewDbError(err, NewInternalServerError)
+	}
+	setVersionETag(c, recipe.Version)
+	return c.JSON(http.StatusOK, recipe)
+}
+
 func (api *ApiHandler) updateRecipe(c echo.Context) error {
 	l := logger.WithField("request", "updateRecipe")
 	recipe := new(db.Recipe)
@@ -124,6 +232,10 @@ func (api *ApiHandler) updateRecipe(c echo.Context) error {
 		FailOnError(l, err, "Request binding failed")
 		return NewBadRequestError(err)
 	}
+	if err := checkExactAmounts(recipe); err != nil {
+		WarnOnError(l, err, "Approximate amounts refused")
+		return NewBadRequestError(err)
+	}
 	if err := c.Validate(recipe); err != nil {
 		FailOnError(l, err, "Validation failed")
 		return NewBadRequestError(err)
@@ -133,12 +245,17 @@ func (api *ApiHandler) updateRecipe(c echo.Context) error {
 		return NewNotFoundError(err)
 	}
 	recipe.ID = id
-	err = api.dbh.UpsertOne(l, recipe)
+	recipe.Version, err = api.ifMatchVersion(c.Request().Context(), c, l, id.Hex())
+	if err != nil {
+		return err
+	}
+	err = api.dbh.UpsertOne(c.Request().Context(), l, recipe)
 	if err != nil {
 		FailOnError(l, err, "Error when trying to save recipe")
-		return NewInternalServerError(err)
+		return NewDbError(err, NewInternalServerError)
 	}
-	return c.JSON(http.StatusCreated, recipe)
+	setVersionETag(c, recipe.Version)
+	return c.JSON(http.StatusOK, recipe)
 }
 
 func (api *ApiHandler) getRecipesFromAuthor(c echo.Context) error {
@@ -160,10 +277,17 @@ func (api *ApiHandler) getRecipesFromAuthor(c echo.Context) error {
 		return NewBadRequestError(err)
 	}
 
-	recipes, err := api.dbh.FindRecipesByAuthorID(l, idParam.ID)
+	opts, err := bindListOptions(c)
 	if err != nil {
-		return NewInternalServerError(err)
+		span.RecordError(err)
+		span.SetStatus(codes.Error, "Request validation failed")
+		return err
+	}
+
+	page, err := api.dbh.FindRecipesByAuthorID(ctx, l, idParam.ID, opts)
+	if err != nil {
+		return NewListError(err)
 	}
 
-	return c.JSON(http.StatusOK, recipes)
+	return sendRecipePage(c, page, opts)
 }
@@ -0,0 +1,1225 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/jpeg"
+	"image/png"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"recipes/cache"
+	"recipes/configuration"
+	"recipes/db"
+	"recipes/validation"
+	"reflect"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt"
+	"github.com/labstack/echo/v4"
+	"github.com/sirupsen/logrus"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+const testRecipeJSON = `{
+	"name": "Pate tomates basilic",
+	"author": "arsene",
+	"description": "Le lundi c'est spaghetti",
+	"servings": 4,
+	"dish": "main",
+	"metadata": {"cook time": "30"},
+	"timers": [{"name": "cooking time", "amount": 10, "unit": "minutes"}],
+	"ingredients": [
+		{"id": "59b40d78cc5d6a001237265e", "amount": 480, "unit": "g"},
+		{"id": "598b5ebefd078b0011140a17", "amount": 1, "unit": "i"}
+	],
+	"steps": ["Cuire les pâtes", "Ajouter les tomates"]
+}`
+
+// newTestServer wires the API on top of an in-memory store
+func newTestServer(t *testing.T) (*echo.Echo, *db.MemoryStore) {
+	t.Helper()
+	conf := &configuration.Configuration{OtelServiceName: "recipes-test"}
+	store := db.NewMemoryStore()
+	e := New(validation.New(conf))
+	NewApiHandler(store, conf).Register(e.Group(""), conf)
+	return e, store
+}
+
+func doRequest(e *echo.Echo, method string, target string, body string, headers map[string]string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, target, strings.NewReader(body))
+	req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+	for k, v := range headers {
+		req.Header.Set(k, v)
+	}
+	rec := httptest.NewRecorder()
+	e.ServeHTTP(rec, req)
+	return rec
+}
+
+func createTestRecipe(t *testing.T, e *echo.Echo, body string) db.Recipe {
+	t.Helper()
+	rec := doRequest(e, http.MethodPost, "/recipe", body, nil)
+	if rec.Code != http.StatusCreated {
+		t.Fatalf("Expected 201 when creating a recipe, got %v: %v", rec.Code, rec.Body.String())
+	}
+	var recipe db.Recipe
+	if err := json.Unmarshal(rec.Body.Bytes(), &recipe); err != nil {
+		t.Fatalf("Error when trying to unmarshal recipe: %v", err)
+	}
+	return recipe
+}
+
+func TestSaveExistingID(t *testing.T) {
+	bolt, err := db.OpenBoltStore(filepath.Join(t.TempDir(), "recipes.db"), time.Second)
+	if err != nil {
+		t.Fatalf("Error when trying to open the bolt store: %v", err)
+	}
+	defer bolt.Close()
+
+	for name, store := range map[string]db.RecipeStore{"memory": db.NewMemoryStore(), "bolt": bolt} {
+		t.Run(name, func(t *testing.T) {
+			conf := &configuration.Configuration{OtelServiceName: "recipes-test"}
+			e := New(validation.New(conf))
+			NewApiHandler(store, conf).Register(e.Group(""), conf)
+			recipe := createTestRecipe(t, e, testRecipeJSON)
+
+			// The duplicate check is skipped, the ID is refused by the store
+			body := strings.Replace(testRecipeJSON, "{", fmt.Sprintf(`{"id": %q,`, recipe.ID.Hex()), 1)
+			rec := doRequest(e, http.MethodPost, "/recipe?allow_duplicate=true", body, nil)
+			if rec.Code != http.StatusConflict || !strings.Contains(rec.Body.String(), db.ErrDuplicateID.Error()) {
+				t.Errorf("Expected 409 for an existing ID, got %v: %v", rec.Code, rec.Body.String())
+			}
+		})
+	}
+}
+
+func TestRecipeRoutes(t *testing.T) {
+	e, _ := newTestServer(t)
+
+	t.Run("Ready with the in-memory store", func(t *testing.T) {
+		rec := doRequest(e, http.MethodGet, "/health/ready", "", nil)
+		if rec.Code != http.StatusOK {
+			t.Errorf("Expected 200, got %v", rec.Code)
+		}
+	})
+
+	recipe := createTestRecipe(t, e, testRecipeJSON)
+	if recipe.ID.IsZero() {
+		t.Fatalf("Expected an ID to be generated")
+	}
+
+	t.Run("Get the created recipe", func(t *testing.T) {
+		rec := doRequest(e, http.MethodGet, "/recipe/"+recipe.ID.Hex(), "", nil)
+		if rec.Code != http.StatusOK || !strings.Contains(rec.Body.String(), "Pate tomates basilic") {
+			t.Errorf("Expected the recipe, got %v: %v", rec.Code, rec.Body.String())
+		}
+	})
+
+	t.Run("Find by author and ingredient", func(t *testing.T) {
+		rec := doRequest(e, http.MethodGet, "/recipe/user/arsene", "", nil)
+		if rec.Code != http.StatusOK || !strings.Contains(rec.Body.String(), recipe.ID.Hex()) {
+			t.Errorf("Expected the recipe of arsene, got %v: %v", rec.Code, rec.Body.String())
+		}
+		rec = doRequest(e, http.MethodGet, "/recipe/ingredient/598b5ebefd078b0011140a17", "", nil)
+		if rec.Code != http.StatusOK {
+			t.Errorf("Expected 200, got %v: %v", rec.Code, rec.Body.String())
+		}
+		rec = doRequest(e, http.MethodGet, "/recipe/ingredient/unknown", "", nil)
+		if rec.Code != http.StatusNotFound {
+			t.Errorf("Expected 404, got %v: %v", rec.Code, rec.Body.String())
+		}
+	})
+
+	t.Run("Invalid recipes are rejected", func(t *testing.T) {
+		rec := doRequest(e, http.MethodPost, "/recipe", `{"name": "Nothing else"}`, nil)
+		if rec.Code != http.StatusBadRequest {
+			t.Errorf("Expected 400, got %v: %v", rec.Code, rec.Body.String())
+		}
+	})
+
+	t.Run("Update then delete the recipe", func(t *testing.T) {
+		body := strings.Replace(testRecipeJSON, `"servings": 4`, `"servings": 6`, 1)
+		rec := doRequest(e, http.MethodPut, "/recipe/"+recipe.ID.Hex(), body, nil)
+		if rec.Code != http.StatusOK {
+			t.Fatalf("Expected 200, got %v: %v", rec.Code, rec.Body.String())
+		}
+		rec = doRequest(e, http.MethodDelete, "/recipe/"+recipe.ID.Hex(), "", nil)
+		if rec.Code != http.StatusNoContent {
+			t.Errorf("Expected 204, got %v: %v", rec.Code, rec.Body.String())
+		}
+		rec = doRequest(e, http.MethodGet, "/recipe/"+recipe.ID.Hex(), "", nil)
+		if rec.Code != http.StatusNotFound {
+			t.Errorf("Expected 404, got %v: %v", rec.Code, rec.Body.String())
+		}
+	})
+}
+
+func TestConditionalWrites(t *testing.T) {
+	e, _ := newTestServer(t)
+	recipe := createTestRecipe(t, e, testRecipeJSON)
+	target := "/recipe/" + recipe.ID.Hex()
+
+	rec := doRequest(e, http.MethodGet, target, "", nil)
+	if etag := rec.Header().Get(HeaderETag); etag != `"1"` {
+		t.Fatalf(`Expected the ETag "1", got %v`, etag)
+	}
+
+	body := strings.Replace(testRecipeJSON, `"servings": 4`, `"servings": 6`, 1)
+	rec = doRequest(e, http.MethodPut, target, body, map[string]string{HeaderIfMatch: `"1"`})
+	if rec.Code != http.StatusOK || rec.Header().Get(HeaderETag) != `"2"` {
+		t.Fatalf(`Expected 200 with the ETag "2", got %v %v: %v`, rec.Code, rec.Header().Get(HeaderETag), rec.Body.String())
+	}
+
+	for name, ifMatch := range map[string]string{
+		"Stale version":      `"1"`,
+		"Weak tag only":      `W/"2"`,
+		"None of the listed": `"1", "3"`,
+		"Negative version":   `"-1"`,
+		"Zero version":       `"0", "2"`,
+	} {
+		t.Run(name, func(t *testing.T) {
+			rec := doRequest(e, http.MethodPut, target, body, map[string]string{HeaderIfMatch: ifMatch})
+			if rec.Code != http.StatusPreconditionFailed {
+				t.Errorf("Expected 412, got %v: %v", rec.Code, rec.Body.String())
+			}
+		})
+	}
+
+	rec = doRequest(e, http.MethodDelete, target, "", map[string]string{HeaderIfMatch: "2"})
+	if rec.Code != http.StatusBadRequest {
+		t.Errorf("Expected 400 for an unquoted entity tag, got %v: %v", rec.Code, rec.Body.String())
+	}
+	rec = doRequest(e, http.MethodDelete, target, "", map[string]string{HeaderIfMatch: `"1", "2"`})
+	if rec.Code != http.StatusNoContent {
+		t.Errorf("Expected 204, got %v: %v", rec.Code, rec.Body.String())
+	}
+}
+
+func TestRecipePatch(t *testing.T) {
+	e, _ := newTestServer(t)
+	recipe := createTestRecipe(t, e, testRecipeJSON)
+	target := "/recipe/" + recipe.ID.Hex()
+	patch := func(contentType string, body string, headers map[string]string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPatch, target, strings.NewReader(body))
+		req.Header.Set(echo.HeaderContentType, contentType)
+		for k, v := range headers {
+			req.Header.Set(k, v)
+		}
+		rec := httptest.NewRecorder()
+		e.ServeHTTP(rec, req)
+		return rec
+	}
+	decode := func(t *testing.T, rec *httptest.ResponseRecorder) db.Recipe {
+		t.Helper()
+		if rec.Code != http.StatusOK {
+			t.Fatalf("Expected 200, got %v: %v", rec.Code, rec.Body.String())
+		}
+		var patched db.Recipe
+		if err := json.Unmarshal(rec.Body.Bytes(), &patched); err != nil {
+			t.Fatalf("Error when trying to unmarshal recipe: %v", err)
+		}
+		return patched
+	}
+
+	t.Run("Merge patch the fields and the metadata", func(t *testing.T) {
+		rec := patch(MIMEApplicationMergePatch, `{"servings": 6, "metadata": {"cook time": null, "origin": "Italy"}}`, map[string]string{HeaderIfMatch: `"1"`})
+		patched := decode(t, rec)
+		if patched.Servings != 6 || patched.Name != recipe.Name || len(patched.Steps) != 2 {
+			t.Errorf("Expected only the servings changed, got %+v", patched)
+		}
+		if !reflect.DeepEqual(patched.Metadata, map[string]string{"origin": "Italy"}) {
+			t.Errorf("Expected the merged metadata, got %v", patched.Metadata)
+		}
+		if patched.Version != 2 || rec.Header().Get(HeaderETag) != `"2"` {
+			t.Errorf(`Expected the version 2, got %v %v`, patched.Version, rec.Header().Get(HeaderETag))
+		}
+	})
+
+	t.Run("JSON patch the steps, ingredients and timers", func(t *testing.T) {
+		patched := decode(t, patch(MIMEApplicationJSONPatch+"; charset=utf-8", `[
+			{"op": "test", "path": "/steps/0", "value": "Cuire les pâtes"},
+			{"op": "add", "path": "/steps/1", "value": "Égoutter"},
+			{"op": "add", "path": "/steps/-", "value": "Servir"},
+			{"op": "replace", "path": "/ingredients/0/amount", "value": 500},
+			{"op": "remove", "path": "/ingredients/1"},
+			{"op": "copy", "from": "/timers/0", "path": "/timers/-"},
+			{"op": "replace", "path": "/timers/1/name", "value": "resting time"}
+		]`, nil))
+		if !reflect.DeepEqual(patched.Steps, []string{"Cuire les pâtes", "Égoutter", "Ajouter les tomates", "Servir"}) {
+			t.Errorf("Expected the patched steps, got %v", patched.Steps)
+		}
+		if len(patched.Ingredients) != 1 || patched.Ingredients[0].Amount != 500 {
+			t.Errorf("Expected the patched ingredients, got %v", patched.Ingredients)
+		}
+		if len(patched.Timers) != 2 || patched.Timers[1].Name != "resting time" || patched.Timers[1].Amount != 10 {
+			t.Errorf("Expected the patched timers, got %v", patched.Timers)
+		}
+		if found, _ := json.Marshal(decode(t, doRequest(e, http.MethodGet, target, "", nil))); !bytes.Contains(found, []byte("Servir")) {
+			t.Errorf("Expected the patch to be stored, got %s", found)
+		}
+	})
+
+	for _, test := range []struct {
+		name        string
+		contentType string
+		body        string
+		headers     map[string]string
+		code        int
+	}{
+		{"Unsupported content type", echo.MIMEApplicationJSON, `{"servings": 2}`, nil, http.StatusUnsupportedMediaType},
+		{"Malformed merge patch", MIMEApplicationMergePatch, `{"servings": `, nil, http.StatusBadRequest},
+		{"Merge patch not an object", MIMEApplicationMergePatch, `[]`, nil, http.StatusBadRequest},
+		{"Malformed JSON patch", MIMEApplicationJSONPatch, `{"op": "add"}`, nil, http.StatusBadRequest},
+		{"Unknown operation", MIMEApplicationJSONPatch, `[{"op": "merge", "path": "/name"}]`, nil, http.StatusBadRequest},
+		{"Failed test operation", MIMEApplicationJSONPatch, `[{"op": "test", "path": "/name", "value": "Gratin"}]`, nil, http.StatusConflict},
+		{"Missing path", MIMEApplicationJSONPatch, `[{"op": "remove", "path": "/steps/9"}]`, nil, http.StatusConflict},
+		{"Invalid patched recipe", MIMEApplicationJSONPatch, `[{"op": "remove", "path": "/steps"}]`, nil, http.StatusBadRequest},
+		{"Wrong type", MIMEApplicationMergePatch, `{"servings": "six"}`, nil, http.StatusBadRequest},
+		{"Unknown field", MIMEApplicationMergePatch, `{"calories": 400}`, nil, http.StatusBadRequest},
+		{"Read-only field", MIMEApplicationJSONPatch, `[{"op": "replace", "path": "/version", "value": 9}]`, nil, http.StatusBadRequest},
+		{"Stale version", MIMEApplicationMergePatch, `{"servings": 2}`, map[string]string{HeaderIfMatch: `"1"`}, http.StatusPreconditionFailed},
+		{"Too large", MIMEApplicationMergePatch, `{"description": "` + strings.Repeat("a", maxPatchBytes) + `"}`, nil, http.StatusRequestEntityTooLarge},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			rec := patch(test.contentType, test.body, test.headers)
+			if rec.Code != test.code {
+				t.Errorf("Expected %v, got %v: %v", test.code, rec.Code, rec.Body.String())
+			}
+			if test.code == http.StatusUnsupportedMediaType && rec.Header().Get(HeaderAcceptPatch) == "" {
+				t.Errorf("Expected the accepted patch formats")
+			}
+		})
+	}
+
+	target = "/recipe/" + primitive.NewObjectID().Hex()
+	if rec := patch(MIMEApplicationMergePatch, `{"servings": 2}`, nil); rec.Code != http.StatusNotFound {
+		t.Errorf("Expected 404 for an unknown recipe, got %v: %v", rec.Code, rec.Body.String())
+	}
+}
+
+func TestTrashAndRestore(t *testing.T) {
+	e, _ := newTestServer(t)
+	recipe := createTestRecipe(t, e, testRecipeJSON)
+	target := "/recipe/" + recipe.ID.Hex()
+
+	if rec := doRequest(e, http.MethodDelete, target, "", nil); rec.Code != http.StatusNoContent {
+		t.Fatalf("Expected 204, got %v: %v", rec.Code, rec.Body.String())
+	}
+	rec := doRequest(e, http.MethodGet, "/recipe/trash", "", nil)
+	var trash db.RecipePage
+	if err := json.Unmarshal(rec.Body.Bytes(), &trash); err != nil || rec.Code != http.StatusOK {
+		t.Fatalf("Expected 200 with a page, got %v: %v", rec.Code, rec.Body.String())
+	}
+	if trash.Total != 1 || trash.Recipes[0].ID != recipe.ID || trash.Recipes[0].DeletedAt == nil {
+		t.Errorf("Expected the deleted recipe in the trash, got %+v", trash)
+	}
+
+	rec = doRequest(e, http.MethodPost, target+"/restore", "", nil)
+	if rec.Code != http.StatusOK || rec.Header().Get(HeaderETag) != `"3"` {
+		t.Fatalf(`Expected 200 with the ETag "3", got %v %v: %v`, rec.Code, rec.Header().Get(HeaderETag), rec.Body.String())
+	}
+	if rec := doRequest(e, http.MethodGet, target, "", nil); rec.Code != http.StatusOK {
+		t.Errorf("Expected 200 for the restored recipe, got %v: %v", rec.Code, rec.Body.String())
+	}
+	if rec := doRequest(e, http.MethodPost, target+"/restore", "", nil); rec.Code != http.StatusNotFound {
+		t.Errorf("Expected 404 for a recipe out of the trash, got %v: %v", rec.Code, rec.Body.String())
+	}
+}
+
+func TestTenants(t *testing.T) {
+	newTenantServer := func(secret string) *echo.Echo {
+		conf := &configuration.Configuration{
+			OtelServiceName: "recipes-test",
+			TenancyMode:     configuration.SharedTenancy,
+			TenantHeader:    HeaderTenantID,
+			StatsCacheTTL:   time.Hour,
+			TenantClaim:     "tenant_id",
+			JWTSecret:       secret,
+		}
+		e := New(validation.New(conf))
+		NewApiHandler(db.NewMemoryStore(), conf).Register(e.Group(""), conf)
+		return e
+	}
+
+	t.Run("The tenant header scopes the requests", func(t *testing.T) {
+		e := newTenantServer("")
+		bistro := map[string]string{HeaderTenantID: "bistro"}
+		brasserie := map[string]string{HeaderTenantID: "brasserie"}
+		rec := doRequest(e, http.MethodPost, "/recipe", testRecipeJSON, bistro)
+		var recipe db.Recipe
+		if err := json.Unmarshal(rec.Body.Bytes(), &recipe); err != nil || rec.Code != http.StatusCreated {
+			t.Fatalf("Expected 201, got %v: %v", rec.Code, rec.Body.String())
+		}
+		target := "/recipe/" + recipe.ID.Hex()
+
+		for _, request := range []struct{ method, target, body string }{
+			{http.MethodGet, target, ""},
+			{http.MethodPut, target, testRecipeJSON},
+			{http.MethodDelete, target, ""},
+			{http.MethodGet, target + "/revisions", ""},
+		} {
+			if rec := doRequest(e, request.method, request.target, request.body, brasserie); rec.Code != http.StatusNotFound {
+				t.Errorf("Expected 404 for %v %v of another tenant, got %v: %v", request.method, request.target, rec.Code, rec.Body.String())
+			}
+		}
+		rec = doRequest(e, http.MethodGet, "/recipe", "", brasserie)
+		var page db.RecipePage
+		json.Unmarshal(rec.Body.Bytes(), &page)
+		if rec.Code != http.StatusOK || page.Total != 0 {
+			t.Errorf("Expected an empty listing for another tenant, got %v: %v", rec.Code, rec.Body.String())
+		}
+		if rec := doRequest(e, http.MethodGet, target, "", bistro); rec.Code != http.StatusOK || !strings.Contains(rec.Body.String(), `"version":1`) {
+			t.Errorf("Expected the recipe untouched for its tenant, got %v: %v", rec.Code, rec.Body.String())
+		}
+
+		for _, headers := range []map[string]string{nil, {HeaderTenantID: "Bistro!"}} {
+			if rec := doRequest(e, http.MethodGet, "/recipe", "", headers); rec.Code != http.StatusBadRequest {
+				t.Errorf("Expected 400 for the tenant %v, got %v: %v", headers, rec.Code, rec.Body.String())
+			}
+		}
+		rec = doRequest(e, http.MethodGet, "/recipe/stats", "", bistro)
+		if rec.Code != http.StatusOK || !strings.Contains(rec.Body.String(), `"recipes":1`) {
+			t.Errorf("Expected the statistics of the tenant, got %v: %v", rec.Code, rec.Body.String())
+		}
+		rec = doRequest(e, http.MethodGet, "/recipe/stats", "", brasserie)
+		if rec.Code != http.StatusOK || !strings.Contains(rec.Body.String(), `"recipes":0`) {
+			t.Errorf("Expected the statistics cached by tenant, got %v: %v", rec.Code, rec.Body.String())
+		}
+		if rec := doRequest(e, http.MethodGet, "/health/alive", "", nil); rec.Code != http.StatusOK {
+			t.Errorf("Expected the health checks without tenant, got %v", rec.Code)
+		}
+	})
+
+	t.Run("The tenant comes from the token when a JWT secret is set", func(t *testing.T) {
+		e := newTenantServer("secret")
+		sign := func(secret string, tenant string) string {
+			token, _ := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{"tenant_id": tenant}).SignedString([]byte(secret))
+			return "Bearer " + token
+		}
+		for name, test := range map[string]struct {
+			headers  map[string]string
+			expected int
+		}{
+			"valid token":          {map[string]string{echo.HeaderAuthorization: sign("secret", "bistro")}, http.StatusOK},
+			"matching header":      {map[string]string{echo.HeaderAuthorization: sign("secret", "bistro"), HeaderTenantID: "bistro"}, http.StatusOK},
+			"header without token": {map[string]string{HeaderTenantID: "bistro"}, http.StatusUnauthorized},
+			"forged token":         {map[string]string{echo.HeaderAuthorization: sign("guess", "bistro")}, http.StatusUnauthorized},
+			"other tenant header":  {map[string]string{echo.HeaderAuthorization: sign("secret", "bistro"), HeaderTenantID: "brasserie"}, http.StatusForbidden},
+		} {
+			if rec := doRequest(e, http.MethodGet, "/recipe", "", test.headers); rec.Code != test.expected {
+				t.Errorf("Expected %v with a %v, got %v: %v", test.expected, name, rec.Code, rec.Body.String())
+			}
+		}
+	})
+}
+
+func TestRevisions(t *testing.T) {
+	e, _ := newTestServer(t)
+	recipe := createTestRecipe(t, e, testRecipeJSON)
+	target := "/recipe/" + recipe.ID.Hex()
+	body := strings.Replace(testRecipeJSON, `"Cuire les pâtes"`, `"Cuire les pâtes al dente"`, 1)
+	if rec := doRequest(e, http.MethodPut, target, body, map[string]string{HeaderUserID: "marie"}); rec.Code != http.StatusOK {
+		t.Fatalf("Expected 200, got %v: %v", rec.Code, rec.Body.String())
+	}
+
+	rec := doRequest(e, http.MethodGet, target+"/revisions", "", nil)
+	var revisions []db.Revision
+	if err := json.Unmarshal(rec.Body.Bytes(), &revisions); err != nil || len(revisions) != 2 {
+		t.Fatalf("Expected 2 revisions, got %v: %v", rec.Code, rec.Body.String())
+	}
+	if revisions[0].Author != db.AnonymousActor || revisions[1].Author != "marie" {
+		t.Errorf("Expected the revisions by %v then marie, got %+v", db.AnonymousActor, revisions)
+	}
+
+	rec = doRequest(e, http.MethodGet, target+"/revisions/1", "", nil)
+	var revision db.Revision
+	if err := json.Unmarshal(rec.Body.Bytes(), &revision); err != nil || revision.Recipe == nil || revision.Recipe.Steps[0] != "Cuire les pâtes" {
+		t.Errorf("Expected the first revision, got %v: %v", rec.Code, rec.Body.String())
+	}
+	if rec := doRequest(e, http.MethodGet, target+"/revisions/3", "", nil); rec.Code != http.StatusNotFound {
+		t.Errorf("Expected 404 for an unknown revision, got %v: %v", rec.Code, rec.Body.String())
+	}
+
+	rec = doRequest(e, http.MethodGet, target+"/diff?from=1&to=2", "", nil)
+	var diff db.RecipeDiff
+	if err := json.Unmarshal(rec.Body.Bytes(), &diff); err != nil || len(diff.Steps) != 1 || diff.Steps[0].Op != db.StepChanged {
+		t.Errorf("Expected the first step to change, got %v: %v", rec.Code, rec.Body.String())
+	}
+	if rec := doRequest(e, http.MethodGet, target+"/diff?from=1", "", nil); rec.Code != http.StatusBadRequest {
+		t.Errorf("Expected 400 without the revision to compare to, got %v: %v", rec.Code, rec.Body.String())
+	}
+
+	if rec := doRequest(e, http.MethodPost, target+"/revert/1", "", map[string]string{HeaderIfMatch: `"1"`}); rec.Code != http.StatusPreconditionFailed {
+		t.Errorf("Expected 412 for a stale version, got %v: %v", rec.Code, rec.Body.String())
+	}
+	rec = doRequest(e, http.MethodPost, target+"/revert/1", "", map[string]string{HeaderIfMatch: `"2"`})
+	var reverted db.Recipe
+	if err := json.Unmarshal(rec.Body.Bytes(), &reverted); err != nil || rec.Code != http.StatusOK {
+		t.Fatalf("Expected 200, got %v: %v", rec.Code, rec.Body.String())
+	}
+	if reverted.Version != 3 || reverted.Steps[0] != "Cuire les pâtes" || rec.Header().Get(HeaderETag) != `"3"` {
+		t.Errorf("Expected the content of the first revision at version 3, got %+v", reverted)
+	}
+}
+
+func TestImportExport(t *testing.T) {
+	e, _ := newTestServer(t)
+	stored := createTestRecipe(t, e, testRecipeJSON)
+	compact := func(recipe string) string {
+		var buffer bytes.Buffer
+		json.Compact(&buffer, []byte(recipe))
+		return buffer.String()
+	}
+	withID := func(id string) string {
+		return strings.Replace(compact(testRecipeJSON), `{"name"`, `{"id":"`+id+`","name"`, 1)
+	}
+	newID := primitive.NewObjectID().Hex()
+	body := strings.Join([]string{
+		withID(stored.ID.Hex()),
+		compact(strings.Replace(testRecipeJSON, `"servings": 4`, `"servings": 0`, 1)),
+		"",
+		"{not json",
+		withID(newID),
+		withID(newID),
+	}, "\n")
+
+	importRecipes := func(query string) ImportReport {
+		t.Helper()
+		rec := doRequest(e, http.MethodPost, "/recipe/import"+query, body, map[string]string{echo.HeaderContentType: MIMEApplicationNDJSON})
+		var report ImportReport
+		if err := json.Unmarshal(rec.Body.Bytes(), &report); err != nil || rec.Code != http.StatusOK {
+			t.Fatalf("Expected 200 with a report, got %v: %v", rec.Code, rec.Body.String())
+		}
+		return report
+	}
+
+	report := importRecipes("?dry_run=true&allow_duplicate=true")
+	if !report.DryRun || report.Created != 1 || report.Skipped != 1 || report.Failed != 3 {
+		t.Errorf("Expected 1 creation, 1 skip and 3 failures planned, got %+v", report)
+	}
+	statuses := make(map[int]db.ImportStatus)
+	for _, line := range report.Lines {
+		statuses[line.Line] = line.Status
+	}
+	expected := map[int]db.ImportStatus{1: db.ImportSkipped, 2: db.ImportFailed, 4: db.ImportFailed, 5: db.ImportCreated, 6: db.ImportFailed}
+	if !reflect.DeepEqual(statuses, expected) {
+		t.Errorf("Expected the statuses %v, got %v", expected, statuses)
+	}
+	if rec := doRequest(e, http.MethodGet, "/recipe/"+newID, "", nil); rec.Code != http.StatusNotFound {
+		t.Errorf("Expected the dry run to import nothing, got %v", rec.Code)
+	}
+
+	report = importRecipes("?mode=upsert&allow_duplicate=true")
+	if report.DryRun || report.Created != 1 || report.Updated != 1 || report.Failed != 3 {
+		t.Errorf("Expected 1 creation, 1 update and 3 failures, got %+v", report)
+	}
+	if rec := doRequest(e, http.MethodPost, "/recipe/import?mode=replace", body, nil); rec.Code != http.StatusBadRequest {
+		t.Errorf("Expected 400 for an unknown mode, got %v", rec.Code)
+	}
+
+	rec := doRequest(e, http.MethodGet, "/recipe/export", "", nil)
+	if rec.Code != http.StatusOK || rec.Header().Get(echo.HeaderContentType) != MIMEApplicationNDJSON {
+		t.Fatalf("Expected 200 with NDJSON, got %v %v", rec.Code, rec.Header())
+	}
+	lines := strings.Split(strings.TrimSpace(rec.Body.String()), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("Expected 2 exported recipes, got %v", lines)
+	}
+	for _, line := range lines {
+		var recipe db.Recipe
+		if err := json.Unmarshal([]byte(line), &recipe); err != nil || recipe.ID.IsZero() {
+			t.Errorf("Expected a recipe by line, got %v (%v)", line, err)
+		}
+	}
+}
+
+func TestImportDuplicates(t *testing.T) {
+	e, _ := newTestServer(t)
+	createTestRecipe(t, e, testRecipeJSON)
+	var buffer bytes.Buffer
+	json.Compact(&buffer, []byte(testRecipeJSON))
+	copied := strings.Replace(buffer.String(), "Pate tomates basilic", "Pâte tomates, basilic!", 1)
+	gratin := strings.Replace(buffer.String(), "Pate tomates basilic", "Gratin", 1)
+	body := strings.Join([]string{copied, gratin, gratin}, "\n")
+
+	importRecipes := func(query string) map[int]ImportLine {
+		t.Helper()
+		rec := doRequest(e, http.MethodPost, "/recipe/import"+query, body, map[string]string{echo.HeaderContentType: MIMEApplicationNDJSON})
+		var report ImportReport
+		if err := json.Unmarshal(rec.Body.Bytes(), &report); err != nil || rec.Code != http.StatusOK {
+			t.Fatalf("Expected 200 with a report, got %v: %v", rec.Code, rec.Body.String())
+		}
+		lines := make(map[int]ImportLine)
+		for _, line := range report.Lines {
+			lines[line.Line] = line
+		}
+		return lines
+	}
+
+	// The copy of the stored recipe and the second gratin of the import are refused, even by a dry run
+	for _, query := range []string{"?dry_run=true", ""} {
+		lines := importRecipes(query)
+		for number, code := range map[int]int{1: http.StatusConflict, 2: 0, 3: http.StatusConflict} {
+			if line := lines[number]; line.Code != code || (code == 0) != (line.Status == db.ImportCreated) {
+				t.Errorf("Expected line %v to answer %v with %q, got %+v", number, code, query, line)
+			}
+		}
+		if line := lines[1]; len(line.Errors) != 1 || !strings.Contains(line.Errors[0], db.ErrDuplicateRecipe.Error()) {
+			t.Errorf("Expected the duplicate to be reported, got %+v", line)
+		}
+	}
+
+	for number, line := range importRecipes("?allow_duplicate=true") {
+		if line.Status != db.ImportCreated {
+			t.Errorf("Expected line %v to be created with allow_duplicate, got %+v", number, line)
+		}
+	}
+}
+
+func TestImportLongLine(t *testing.T) {
+	e, _ := newTestServer(t)
+	var buffer bytes.Buffer
+	json.Compact(&buffer, []byte(testRecipeJSON))
+	long := strings.Replace(buffer.String(), `"servings"`, `"comment":"`+strings.Repeat("a", maxImportLineBytes)+`","servings"`, 1)
+	body := strings.Join([]string{long, buffer.String(), long}, "\n")
+
+	rec := doRequest(e, http.MethodPost, "/recipe/import", body, map[string]string{echo.HeaderContentType: MIMEApplicationNDJSON})
+	var report ImportReport
+	if err := json.Unmarshal(rec.Body.Bytes(), &report); err != nil || rec.Code != http.StatusOK {
+		t.Fatalf("Expected 200 with a report, got %v: %v", rec.Code, rec.Body.String())
+	}
+	if report.Created != 1 || report.Failed != 2 {
+		t.Errorf("Expected 1 creation and 2 failures, got %+v", report)
+	}
+	for _, line := range report.Lines {
+		if line.Status == db.ImportFailed && (line.Line == 2 || !reflect.DeepEqual(line.Errors, []string{errImportLineTooLong.Error()})) {
+			t.Errorf("Expected only the long lines to fail as too long, got %+v", line)
+		}
+	}
+}
+
+// slowStore never answers before the request deadline
+type slowStore struct {
+	*db.MemoryStore
+}
+
+func (s slowStore) FindRecipeByID(ctx context.Context, l *logrus.Entry, id string) (*db.Recipe, error) {
+	<-ctx.Done()
+	return nil, fmt.Errorf("%w: %w", db.ErrTimeout, ctx.Err())
+}
+
+func TestDbTimeoutsAreGatewayTimeouts(t *testing.T) {
+	conf := &configuration.Configuration{OtelServiceName: "recipes-test"}
+	e := New(validation.New(conf))
+	NewApiHandler(slowStore{db.NewMemoryStore()}, conf).Register(e.Group(""), conf)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	req := httptest.NewRequest(http.MethodGet, "/recipe/"+primitive.NewObjectID().Hex(), nil).WithContext(ctx)
+	rec := httptest.NewRecorder()
+	e.ServeHTTP(rec, req)
+	if rec.Code != http.StatusGatewayTimeout {
+		t.Errorf("Expected 504, got %v: %v", rec.Code, rec.Body.String())
+	}
+}
+
+// unavailableStore answers like a DbHandler started in grace mode before it reached MongoDB
+type unavailableStore struct {
+	*db.MemoryStore
+}
+
+func (s unavailableStore) Ping(ctx context.Context) error {
+	return db.ErrNotReady
+}
+
+func (s unavailableStore) FindAllRecipes(ctx context.Context, l *logrus.Entry, opts db.ListOptions) (*db.RecipePage, error) {
+	return nil, db.ErrNotReady
+}
+
+func TestDbUnavailable(t *testing.T) {
+	conf := &configuration.Configuration{OtelServiceName: "recipes-test"}
+	e := New(validation.New(conf))
+	NewApiHandler(unavailableStore{db.NewMemoryStore()}, conf).Register(e.Group(""), conf)
+
+	if rec := doRequest(e, http.MethodGet, "/health/ready", "", nil); rec.Code != http.StatusServiceUnavailable || !strings.Contains(rec.Body.String(), NotReadyStatus) {
+		t.Errorf("Expected not ready, got %v: %v", rec.Code, rec.Body.String())
+	}
+	if rec := doRequest(e, http.MethodGet, "/health/alive", "", nil); rec.Code != http.StatusOK {
+		t.Errorf("Expected alive, got %v", rec.Code)
+	}
+	if rec := doRequest(e, http.MethodGet, "/recipe", "", nil); rec.Code != http.StatusServiceUnavailable {
+		t.Errorf("Expected 503, got %v: %v", rec.Code, rec.Body.String())
+	}
+}
+
+func TestRecipeListing(t *testing.T) {
+	e, _ := newTestServer(t)
+	for _, name := range []string{"Crepes", "Aioli", "Bouillabaisse"} {
+		createTestRecipe(t, e, strings.Replace(testRecipeJSON, "Pate tomates basilic", name, 1))
+	}
+
+	rec := doRequest(e, http.MethodGet, "/recipe?limit=2&sort=name", "", nil)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Expected 200, got %v: %v", rec.Code, rec.Body.String())
+	}
+	var page db.RecipePage
+	json.Unmarshal(rec.Body.Bytes(), &page)
+	if page.Total != 3 || len(page.Recipes) != 2 || page.Recipes[0].Name != "Aioli" || page.NextCursor == "" {
+		t.Fatalf("Unexpected first page: %+v", page)
+	}
+	link := rec.Header().Get("Link")
+	if !strings.Contains(link, `rel="next"`) || !strings.Contains(link, "cursor="+page.NextCursor) {
+		t.Errorf("Expected a next link, got %v", link)
+	}
+
+	rec = doRequest(e, http.MethodGet, "/recipe?limit=2&sort=name&after="+page.NextCursor, "", nil)
+	page = db.RecipePage{}
+	json.Unmarshal(rec.Body.Bytes(), &page)
+	if len(page.Recipes) != 1 || page.Recipes[0].Name != "Crepes" || page.NextCursor != "" {
+		t.Errorf("Unexpected last page: %+v", page)
+	}
+	if strings.Contains(rec.Header().Get("Link"), `rel="next"`) {
+		t.Errorf("Expected no next link on the last page, got %v", rec.Header().Get("Link"))
+	}
+
+	for _, query := range []string{"sort=author", "limit=1000", "cursor=garbage"} {
+		rec = doRequest(e, http.MethodGet, "/recipe?"+query, "", nil)
+		if rec.Code != http.StatusBadRequest {
+			t.Errorf("Expected 400 for %v, got %v: %v", query, rec.Code, rec.Body.String())
+		}
+	}
+
+	rec = doRequest(e, http.MethodGet, "/recipe/user/arsene?limit=1", "", nil)
+	page = db.RecipePage{}
+	json.Unmarshal(rec.Body.Bytes(), &page)
+	if rec.Code != http.StatusOK || page.Total != 3 || len(page.Recipes) != 1 {
+		t.Errorf("Unexpected author page: %v %+v", rec.Code, page)
+	}
+}
+
+func TestRecipeFilters(t *testing.T) {
+	e, _ := newTestServer(t)
+	createTestRecipe(t, e, testRecipeJSON)
+	crepes := strings.NewReplacer(
+		"Pate tomates basilic", "Crepes",
+		`"dish": "main"`, `"dish": "dessert"`,
+		`"cook time": "30"`, `"origin": "Bretagne"`,
+	).Replace(testRecipeJSON)
+	createTestRecipe(t, e, crepes)
+	createTestRecipe(t, e, strings.NewReplacer(`"author": "arsene"`, `"author": "marius"`, "Crepes", "Crepes de marius").Replace(crepes))
+
+	rec := doRequest(e, http.MethodGet, "/recipe?dish=dessert,starter&author=arsene&metadata.origin=Bretagne&max_total_time=15", "", nil)
+	var page db.RecipePage
+	json.Unmarshal(rec.Body.Bytes(), &page)
+	if rec.Code != http.StatusOK || page.Total != 1 || page.Recipes[0].Name != "Crepes" || page.Recipes[0].Author != "arsene" {
+		t.Fatalf("Expected the crepes of arsene, got %v %v", rec.Code, rec.Body.String())
+	}
+	if page.Facets == nil || len(page.Facets.Dish) != 1 || page.Facets.Dish[0] != (db.FacetCount{Value: "dessert", Count: 1}) {
+		t.Errorf("Expected the facets of the filtered recipes, got %+v", page.Facets)
+	}
+
+	rec = doRequest(e, http.MethodGet, "/recipe?with_ingredients=59b40d78cc5d6a001237265e&with_ingredients=598b5ebefd078b0011140a17", "", nil)
+	page = db.RecipePage{}
+	json.Unmarshal(rec.Body.Bytes(), &page)
+	expectedAuthors := []db.FacetCount{{Value: "arsene", Count: 2}, {Value: "marius", Count: 1}}
+	if page.Total != 3 || !reflect.DeepEqual(page.Facets.Author, expectedAuthors) {
+		t.Errorf("Expected the 3 recipes counted by author %v, got %v", expectedAuthors, rec.Body.String())
+	}
+	rec = doRequest(e, http.MethodGet, "/recipe?without_ingredients=59b40d78cc5d6a001237265e&servings_min=1", "", nil)
+	page = db.RecipePage{}
+	json.Unmarshal(rec.Body.Bytes(), &page)
+	if page.Total != 0 || len(page.Facets.Dish) != 0 {
+		t.Errorf("Expected no recipe without the ingredient, got %v", rec.Body.String())
+	}
+
+	for _, query := range []string{"dish=brunch", "servings_min=4&servings_max=2", "max_total_time=-5", "metadata.$where=1"} {
+		rec = doRequest(e, http.MethodGet, "/recipe?"+query, "", nil)
+		if rec.Code != http.StatusBadRequest {
+			t.Errorf("Expected 400 for %v, got %v: %v", query, rec.Code, rec.Body.String())
+		}
+	}
+}
+
+func TestRecipeFields(t *testing.T) {
+	e, _ := newTestServer(t)
+	recipe := createTestRecipe(t, e, testRecipeJSON)
+	createTestRecipe(t, e, strings.Replace(testRecipeJSON, "Pate tomates basilic", "Gratin de pates", 1))
+	keys := func(object map[string]json.RawMessage) []string {
+		names := make([]string, 0, len(object))
+		for name := range object {
+			names = append(names, name)
+		}
+		slices.Sort(names)
+		return names
+	}
+
+	for _, test := range []struct {
+		target   string
+		expected []string
+	}{
+		{"/recipe?fields=name,dish&sort=servings&limit=1", []string{"dish", "id", "name"}},
+		{"/recipe/ingredient/59b40d78cc5d6a001237265e?fields=name&fields=servings", []string{"id", "name", "servings"}},
+		{"/recipe/user/arsene?exclude=steps,ingredients,timers,metadata,description", []string{"author", "created_at", "dish", "id", "name", "servings", "version"}},
+	} {
+		rec := doRequest(e, http.MethodGet, test.target, "", nil)
+		var page struct {
+			Recipes    []map[string]json.RawMessage `json:"recipes"`
+			NextCursor string                       `json:"next_cursor"`
+			Total      int64                        `json:"total"`
+		}
+		if err := json.Unmarshal(rec.Body.Bytes(), &page); err != nil || rec.Code != http.StatusOK || page.Total != 2 {
+			t.Fatalf("Expected the 2 recipes of %v, got %v: %v", test.target, rec.Code, rec.Body.String())
+		}
+		for _, recipe := range page.Recipes {
+			if names := keys(recipe); !slices.Equal(names, test.expected) {
+				t.Errorf("Expected the fields %v of %v, got %v", test.expected, test.target, names)
+			}
+		}
+	}
+
+	rec := doRequest(e, http.MethodGet, "/recipe/"+recipe.ID.Hex()+"?fields=name,steps", "", nil)
+	var found map[string]json.RawMessage
+	json.Unmarshal(rec.Body.Bytes(), &found)
+	if names := keys(found); !slices.Equal(names, []string{"id", "name", "steps"}) || !strings.HasPrefix(rec.Header().Get(HeaderETag), `W/"1-`) {
+		t.Errorf("Expected the ID, name and steps with a weak ETag, got %v %v", names, rec.Header().Get(HeaderETag))
+	}
+	rec = doRequest(e, http.MethodGet, "/recipe/title/gratin?exclude=steps", "", nil)
+	found = nil
+	json.Unmarshal(rec.Body.Bytes(), &found)
+	if _, ok := found["steps"]; ok || found["name"] == nil {
+		t.Errorf("Expected the recipe without its steps, got %v", rec.Body.String())
+	}
+	rec = doRequest(e, http.MethodGet, "/recipe/search?q=gratin&fields=name", "", nil)
+	var results struct {
+		Results []map[string]json.RawMessage `json:"results"`
+	}
+	json.Unmarshal(rec.Body.Bytes(), &results)
+	if len(results.Results) != 1 || !slices.Equal(keys(results.Results[0]), []string{"highlights", "id", "name", "score"}) {
+		t.Errorf("Expected the name with the score and highlights, got %v", rec.Body.String())
+	}
+
+	for _, target := range []string{"/recipe?fields=calories", "/recipe?exclude=tenant_id", "/recipe/" + recipe.ID.Hex() + "?fields=name_key", "/recipe/search?q=gratin&fields=score"} {
+		if rec := doRequest(e, http.MethodGet, target, "", nil); rec.Code != http.StatusBadRequest {
+			t.Errorf("Expected 400 for %v, got %v: %v", target, rec.Code, rec.Body.String())
+		}
+	}
+}
+
+func TestRecipeStats(t *testing.T) {
+	conf := &configuration.Configuration{OtelServiceName: "recipes-test", StatsCacheTTL: time.Hour}
+	e := New(validation.New(conf))
+	NewApiHandler(db.NewMemoryStore(), conf).Register(e.Group(""), conf)
+	createTestRecipe(t, e, testRecipeJSON)
+
+	rec := doRequest(e, http.MethodGet, "/recipe/stats", "", nil)
+	var stats db.CatalogStats
+	json.Unmarshal(rec.Body.Bytes(), &stats)
+	if rec.Code != http.StatusOK || stats.Recipes != 1 || stats.AverageServings != 4 || len(stats.TopIngredients) != 2 {
+		t.Fatalf("Expected the statistics of the recipe, got %v %v", rec.Code, rec.Body.String())
+	}
+	if cacheControl := rec.Header().Get(echo.HeaderCacheControl); cacheControl != "private, max-age=3600" {
+		t.Errorf("Expected the statistics privately cacheable for an hour, got %v", cacheControl)
+	}
+
+	createTestRecipe(t, e, strings.Replace(testRecipeJSON, "Pate tomates basilic", "Pates au pesto", 1))
+	rec = doRequest(e, http.MethodGet, "/recipe/stats", "", nil)
+	stats = db.CatalogStats{}
+	json.Unmarshal(rec.Body.Bytes(), &stats)
+	if stats.Recipes != 1 {
+		t.Errorf("Expected the cached statistics, got %v recipes", stats.Recipes)
+	}
+
+	e, _ = newTestServer(t)
+	createTestRecipe(t, e, testRecipeJSON)
+	createTestRecipe(t, e, strings.Replace(testRecipeJSON, "Pate tomates basilic", "Pates au pesto", 1))
+	rec = doRequest(e, http.MethodGet, "/recipe/stats", "", nil)
+	stats = db.CatalogStats{}
+	json.Unmarshal(rec.Body.Bytes(), &stats)
+	if stats.Recipes != 2 {
+		t.Errorf("Expected the statistics computed without cache, got %v recipes", stats.Recipes)
+	}
+}
+
+func TestRecipeDuplicates(t *testing.T) {
+	e, _ := newTestServer(t)
+	original := createTestRecipe(t, e, testRecipeJSON)
+	copied := strings.Replace(testRecipeJSON, "Pate tomates basilic", "Pâte, tomates & BASILIC", 1)
+
+	rec := doRequest(e, http.MethodPost, "/recipe", copied, nil)
+	if rec.Code != http.StatusConflict || !strings.Contains(rec.Body.String(), original.ID.Hex()) {
+		t.Fatalf("Expected 409 referencing %v, got %v: %v", original.ID.Hex(), rec.Code, rec.Body.String())
+	}
+	if rec := doRequest(e, http.MethodPost, "/recipe?allow_duplicate=maybe", copied, nil); rec.Code != http.StatusBadRequest {
+		t.Errorf("Expected 400 for an invalid allow_duplicate, got %v", rec.Code)
+	}
+	rec = doRequest(e, http.MethodPost, "/recipe?allow_duplicate=true", copied, nil)
+	if rec.Code != http.StatusCreated {
+		t.Fatalf("Expected the duplicate allowed, got %v: %v", rec.Code, rec.Body.String())
+	}
+	var duplicate db.Recipe
+	json.Unmarshal(rec.Body.Bytes(), &duplicate)
+	// Another name or other ingredients are no duplicate
+	createTestRecipe(t, e, strings.Replace(testRecipeJSON, "Pate tomates basilic", "Pates au pesto", 1))
+	createTestRecipe(t, e, strings.Replace(testRecipeJSON, "598b5ebefd078b0011140a17", "5a3b5ebefd078b0011140a99", 1))
+
+	rec = doRequest(e, http.MethodGet, "/recipe/duplicates", "", nil)
+	var clusters []db.DuplicateCluster
+	json.Unmarshal(rec.Body.Bytes(), &clusters)
+	if rec.Code != http.StatusOK || len(clusters) != 1 || len(clusters[0].Recipes) != 2 ||
+		clusters[0].Recipes[0].ID != original.ID || clusters[0].Recipes[1].ID != duplicate.ID {
+		t.Errorf("Expected the recipe and its duplicate, got %v: %v", rec.Code, rec.Body.String())
+	}
+}
+
+func TestRecipeSearch(t *testing.T) {
+	e, _ := newTestServer(t)
+	createTestRecipe(t, e, testRecipeJSON)
+	createTestRecipe(t, e, strings.Replace(testRecipeJSON, "Pate tomates basilic", "Crepes", 1))
+
+	rec := doRequest(e, http.MethodGet, "/recipe/search?q=basilic", "", nil)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Expected 200, got %v: %v", rec.Code, rec.Body.String())
+	}
+	var page db.SearchPage
+	json.Unmarshal(rec.Body.Bytes(), &page)
+	if page.Total != 1 || page.Results[0].Name != "Pate tomates basilic" || len(page.Results[0].Highlights["name"]) != 1 {
+		t.Errorf("Unexpected search results: %+v", page)
+	}
+
+	rec = doRequest(e, http.MethodGet, "/recipe/search", "", nil)
+	if rec.Code != http.StatusBadRequest {
+		t.Errorf("Expected 400 without a query, got %v: %v", rec.Code, rec.Body.String())
+	}
+}
+
+// Build a multipart body holding the image and the form fields
+func multipartImage(t *testing.T, data []byte, fields map[string]string) (string, string) {
+	t.Helper()
+	var body bytes.Buffer
+	writer := multipart.NewWriter(&body)
+	part, err := writer.CreateFormFile(imageFormField, "photo")
+	if err != nil {
+		t.Fatalf("Error when trying to create the multipart body: %v", err)
+	}
+	part.Write(data)
+	for name, value := range fields {
+		writer.WriteField(name, value)
+	}
+	writer.Close()
+	return body.String(), writer.FormDataContentType()
+}
+
+func TestRecipeImages(t *testing.T) {
+	conf := &configuration.Configuration{OtelServiceName: "recipes-test", ImageMaxBytes: 1 << 20}
+	e := New(validation.New(conf))
+	NewApiHandler(db.NewMemoryStore(), conf).Register(e.Group(""), conf)
+	recipe := createTestRecipe(t, e, testRecipeJSON)
+	target := "/recipe/" + recipe.ID.Hex() + "/images"
+
+	photo := image.NewRGBA(image.Rect(0, 0, 1200, 600))
+	draw.Draw(photo, photo.Bounds(), image.NewUniform(color.RGBA{R: 200, A: 255}), image.Point{}, draw.Src)
+	var pngData bytes.Buffer
+	png.Encode(&pngData, photo)
+	upload := func(target string, data []byte, fields map[string]string) *httptest.ResponseRecorder {
+		body, contentType := multipartImage(t, data, fields)
+		return doRequest(e, http.MethodPost, target, body, map[string]string{echo.HeaderContentType: contentType})
+	}
+
+	rec := upload(target, pngData.Bytes(), nil)
+	var first db.Image
+	if err := json.Unmarshal(rec.Body.Bytes(), &first); err != nil || rec.Code != http.StatusCreated {
+		t.Fatalf("Expected 201, got %v: %v", rec.Code, rec.Body.String())
+	}
+	if first.ContentType != "image/png" || first.Width != 1200 || first.Height != 600 || !first.Cover || len(first.Thumbnails) != 3 {
+		t.Errorf("Expected a PNG cover with 3 thumbnails, got %+v", first)
+	}
+	rec = upload(target, pngData.Bytes(), map[string]string{"cover": "true"})
+	var second db.Image
+	json.Unmarshal(rec.Body.Bytes(), &second)
+	if rec.Code != http.StatusCreated || !second.Cover {
+		t.Errorf("Expected the second image to take the cover, got %v: %v", rec.Code, rec.Body.String())
+	}
+	rec = doRequest(e, http.MethodGet, "/recipe/"+recipe.ID.Hex(), "", nil)
+	var found db.Recipe
+	json.Unmarshal(rec.Body.Bytes(), &found)
+	if len(found.Images) != 2 || found.Images[0].Cover || !found.Images[1].Cover {
+		t.Errorf("Expected the recipe to reference both images, got %+v", found.Images)
+	}
+
+	rec = doRequest(e, http.MethodGet, target+"/"+first.ID.Hex(), "", nil)
+	if rec.Code != http.StatusOK || rec.Header().Get(echo.HeaderContentType) != "image/png" || !bytes.Equal(rec.Body.Bytes(), pngData.Bytes()) {
+		t.Errorf("Expected the original image, got %v %v", rec.Code, rec.Header().Get(echo.HeaderContentType))
+	}
+	if !strings.Contains(rec.Header().Get(echo.HeaderCacheControl), "immutable") {
+		t.Errorf("Expected an immutable image, got %v", rec.Header().Get(echo.HeaderCacheControl))
+	}
+	rec = doRequest(e, http.MethodGet, target+"/"+first.ID.Hex()+"?size=medium", "", nil)
+	if rec.Code != http.StatusOK || rec.Header().Get(echo.HeaderContentType) != "image/jpeg" {
+		t.Fatalf("Expected the medium thumbnail, got %v: %v", rec.Code, rec.Body.String())
+	}
+	if thumbnail, err := jpeg.DecodeConfig(rec.Body); err != nil || thumbnail.Width != 480 || thumbnail.Height != 240 {
+		t.Errorf("Expected a thumbnail of 480x240, got %+v (%v)", thumbnail, err)
+	}
+
+	for _, test := range []struct {
+		name   string
+		code   int
+		target string
+		data   []byte
+		fields map[string]string
+	}{
+		{"A type other than an image", http.StatusUnsupportedMediaType, target, []byte("%PDF-1.4 not an image"), nil},
+		{"A file larger than the limit", http.StatusRequestEntityTooLarge, target, bytes.Repeat(pngData.Bytes(), 200), nil},
+		{"A truncated image", http.StatusUnprocessableEntity, target, pngData.Bytes()[:pngData.Len()/2], nil},
+		{"A cover other than a boolean", http.StatusBadRequest, target, pngData.Bytes(), map[string]string{"cover": "maybe"}},
+		{"An unknown recipe", http.StatusNotFound, "/recipe/" + primitive.NewObjectID().Hex() + "/images", pngData.Bytes(), nil},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			if rec := upload(test.target, test.data, test.fields); rec.Code != test.code {
+				t.Errorf("Expected %v, got %v: %v", test.code, rec.Code, rec.Body.String())
+			}
+		})
+	}
+	if rec := doRequest(e, http.MethodPost, target, testRecipeJSON, nil); rec.Code != http.StatusBadRequest {
+		t.Errorf("Expected 400 without a multipart body, got %v: %v", rec.Code, rec.Body.String())
+	}
+	if rec := doRequest(e, http.MethodGet, target+"/"+first.ID.Hex()+"?size=huge", "", nil); rec.Code != http.StatusBadRequest {
+		t.Errorf("Expected 400 for an unknown size, got %v", rec.Code)
+	}
+	if rec := doRequest(e, http.MethodGet, target+"/"+primitive.NewObjectID().Hex(), "", nil); rec.Code != http.StatusNotFound {
+		t.Errorf("Expected 404 for an unknown image, got %v", rec.Code)
+	}
+}
+
+func TestRecipeCache(t *testing.T) {
+	conf := &configuration.Configuration{OtelServiceName: "recipes-test"}
+	e := New(validation.New(conf))
+	NewApiHandler(db.NewCachedStore(db.NewMemoryStore(), cache.NewLRU(100, time.Minute)), conf).Register(e.Group(""), conf)
+	recipe := createTestRecipe(t, e, testRecipeJSON)
+	target := "/recipe/" + recipe.ID.Hex()
+
+	doRequest(e, http.MethodGet, target, "", nil)
+	doRequest(e, http.MethodGet, target, "", nil)
+	update := strings.Replace(testRecipeJSON, "Pate tomates basilic", "Pate au pistou", 1)
+	if rec := doRequest(e, http.MethodPut, target, update, nil); rec.Code != http.StatusOK {
+		t.Fatalf("Expected 200, got %v: %v", rec.Code, rec.Body.String())
+	}
+	rec := doRequest(e, http.MethodGet, target, "", nil)
+	if !strings.Contains(rec.Body.String(), "Pate au pistou") || rec.Header().Get(HeaderETag) != `"2"` {
+		t.Errorf("Expected the updated recipe once invalidated, got %v %v", rec.Header().Get(HeaderETag), rec.Body.String())
+	}
+
+	rec = doRequest(e, http.MethodGet, "/cache/stats", "", nil)
+	var stats db.CacheStats
+	json.Unmarshal(rec.Body.Bytes(), &stats)
+	if rec.Code != http.StatusOK || stats.Hits < 1 || stats.Misses < 2 {
+		t.Errorf("Expected the hits and misses of the cache, got %v: %v", rec.Code, rec.Body.String())
+	}
+
+	e, _ = newTestServer(t)
+	if rec := doRequest(e, http.MethodGet, "/cache/stats", "", nil); rec.Code != http.StatusNotFound {
+		t.Errorf("Expected 404 without cache, got %v", rec.Code)
+	}
+}
+
+func TestDensities(t *testing.T) {
+	conf := &configuration.Configuration{OtelServiceName: "recipes-test", AdminToken: "s3cret"}
+	e := New(validation.New(conf))
+	NewApiHandler(db.NewMemoryStore(), conf).Register(e.Group(""), conf)
+	admin := map[string]string{echo.HeaderAuthorization: "Bearer s3cret"}
+
+	if rec := doRequest(e, http.MethodGet, "/admin/densities", "", nil); rec.Code != http.StatusUnauthorized {
+		t.Errorf("Expected 401 without token, got %v", rec.Code)
+	}
+	if rec := doRequest(e, http.MethodGet, "/admin/densities", "", map[string]string{echo.HeaderAuthorization: "Bearer wrong"}); rec.Code != http.StatusUnauthorized {
+		t.Errorf("Expected 401 with another token, got %v", rec.Code)
+	}
+
+	rec := doRequest(e, http.MethodGet, "/units/convert?amount=2&from=cs&to=g&ingredient=flour", "", nil)
+	if rec.Code != http.StatusUnprocessableEntity || !strings.Contains(rec.Body.String(), "no density for ingredient") {
+		t.Errorf("Expected 422 without density, got %v: %v", rec.Code, rec.Body.String())
+	}
+
+	if rec := doRequest(e, http.MethodPut, "/admin/densities/flour", `{"grams_per_ml": 0.5}`, admin); rec.Code != http.StatusCreated {
+		t.Fatalf("Expected 201, got %v: %v", rec.Code, rec.Body.String())
+	}
+	if rec := doRequest(e, http.MethodPut, "/admin/densities/flour", `{"grams_per_ml": 0.53, "note": "sifted"}`, admin); rec.Code != http.StatusOK {
+		t.Errorf("Expected 200 for an update, got %v: %v", rec.Code, rec.Body.String())
+	}
+	if rec := doRequest(e, http.MethodPut, "/admin/densities/sugar", `{"grams_per_ml": -1}`, admin); rec.Code != http.StatusBadRequest {
+		t.Errorf("Expected 400 for a negative density, got %v", rec.Code)
+	}
+	rec = doRequest(e, http.MethodGet, "/admin/densities", "", admin)
+	if rec.Code != http.StatusOK || !strings.Contains(rec.Body.String(), `"ingredient_id":"flour","grams_per_ml":0.53,"note":"sifted"`) {
+		t.Errorf("Expected the density of the flour, got %v: %v", rec.Code, rec.Body.String())
+	}
+
+	rec = doRequest(e, http.MethodGet, "/units/convert?amount=2&from=cs&to=g&ingredient=flour", "", nil)
+	var conversion ConversionResponse
+	json.Unmarshal(rec.Body.Bytes(), &conversion)
+	if rec.Code != http.StatusOK || conversion.Unit != "g" || conversion.Amount < 250.78 || conversion.Amount > 250.79 || conversion.Density == nil {
+		t.Errorf("Expected 2 cups of flour to weigh 250.8 g, got %v: %v", rec.Code, rec.Body.String())
+	}
+	for _, query := range []string{"amount=1&from=i&to=g&ingredient=flour", "amount=1&from=cs&to=pinch", "from=g&to=kg"} {
+		if rec := doRequest(e, http.MethodGet, "/units/convert?"+query, "", nil); rec.Code != http.StatusBadRequest {
+			t.Errorf("Expected 400 for %v, got %v", query, rec.Code)
+		}
+	}
+
+	if rec := doRequest(e, http.MethodDelete, "/admin/densities/flour", "", admin); rec.Code != http.StatusNoContent {
+		t.Errorf("Expected 204, got %v", rec.Code)
+	}
+	if rec := doRequest(e, http.MethodGet, "/admin/densities/flour", "", admin); rec.Code != http.StatusNotFound {
+		t.Errorf("Expected 404 once deleted, got %v", rec.Code)
+	}
+
+	e, _ = newTestServer(t)
+	if rec := doRequest(e, http.MethodGet, "/admin/densities", "", admin); rec.Code != http.StatusForbidden {
+		t.Errorf("Expected 403 without admin token configured, got %v", rec.Code)
+	}
+}
+
+func TestScaledRecipe(t *testing.T) {
+	e, store := newTestServer(t)
+	body := strings.Replace(testRecipeJSON, `{"id": "598b5ebefd078b0011140a17", "amount": 1, "unit": "i"}`,
+		`{"id": "598b5ebefd078b0011140a17", "amount": 1, "unit": "i"}, {"id": "salt", "amount": 1, "unit": "tsp", "scaling": "spice"}`, 1)
+	recipe := createTestRecipe(t, e, body)
+	target := "/recipe/" + recipe.ID.Hex() + "/scaled"
+
+	rec := doRequest(e, http.MethodGet, target+"?servings=10", "", nil)
+	var scaled db.Recipe
+	json.Unmarshal(rec.Body.Bytes(), &scaled)
+	expected := []db.Ingredient{
+		{ID: "59b40d78cc5d6a001237265e", Amount: 1.2, Unit: "kg"},
+		{ID: "598b5ebefd078b0011140a17", Amount: 3, Unit: "is"},
+		{ID: "salt", Amount: 2, Unit: "tsp", Scaling: db.SpiceScaling},
+	}
+	if rec.Code != http.StatusOK || scaled.Servings != 10 || !reflect.DeepEqual(scaled.Ingredients, expected) {
+		t.Errorf("Expected 10 servings of %+v, got %v: %v", expected, rec.Code, rec.Body.String())
+	}
+	etag := rec.Header().Get(HeaderETag)
+	if !strings.HasPrefix(etag, `W/"1-`) {
+		t.Errorf("Expected a weak entity tag for the scaled recipe, got %v", etag)
+	}
+	if other := doRequest(e, http.MethodGet, target+"?servings=6", "", nil).Header().Get(HeaderETag); other == etag {
+		t.Errorf("Expected another entity tag for other servings, got %v", other)
+	}
+	update := strings.Replace(body, `"servings": 4`, `"servings": 5`, 1)
+	if rec := doRequest(e, http.MethodPut, "/recipe/"+recipe.ID.Hex(), update, map[string]string{HeaderIfMatch: etag}); rec.Code != http.StatusPreconditionFailed {
+		t.Errorf("Expected a write conditioned on the scaled recipe to fail with 412, got %v", rec.Code)
+	}
+
+	rec = doRequest(e, http.MethodGet, target+"?factor=0.5&fields=servings,ingredients", "", nil)
+	var halved map[string]any
+	json.Unmarshal(rec.Body.Bytes(), &halved)
+	if rec.Code != http.StatusOK || halved["servings"] != 2.0 || halved["name"] != nil {
+		t.Errorf("Expected the 2 servings only, got %v: %v", rec.Code, rec.Body.String())
+	}
+
+	if stored := doRequest(e, http.MethodGet, "/recipe/"+recipe.ID.Hex(), "", nil); !strings.Contains(stored.Body.String(), `"amount":480,"unit":"g"`) {
+		t.Errorf("Expected the stored recipe untouched, got %v", stored.Body.String())
+	}
+	for _, query := range []string{"", "?servings=2&factor=2", "?servings=0", "?factor=-1", "?servings=many"} {
+		if rec := doRequest(e, http.MethodGet, target+query, "", nil); rec.Code != http.StatusBadRequest {
+			t.Errorf("Expected 400 for %q, got %v", query, rec.Code)
+		}
+	}
+	if rec := doRequest(e, http.MethodGet, "/recipe/"+primitive.NewObjectID().Hex()+"/scaled?servings=2", "", nil); rec.Code != http.StatusNotFound {
+		t.Errorf("Expected 404 for an unknown recipe, got %v", rec.Code)
+	}
+
+	legacy := &db.Recipe{ID: primitive.NewObjectID(), Name: "Legacy", Ingredients: []db.Ingredient{{ID: "flour", Amount: 100, Unit: "g"}}}
+	store.SaveRecipe(context.Background(), logrus.WithField("test", t.Name()), legacy)
+	if rec := doRequest(e, http.MethodGet, "/recipe/"+legacy.ID.Hex()+"/scaled?servings=2", "", nil); rec.Code != http.StatusUnprocessableEntity {
+		t.Errorf("Expected 422 for a recipe without servings, got %v: %v", rec.Code, rec.Body.String())
+	}
+}
+
+func TestUnitsSystem(t *testing.T) {
+	e, _ := newTestServer(t)
+	recipe := createTestRecipe(t, e, testRecipeJSON)
+	target := "/recipe/" + recipe.ID.Hex()
+	ingredients := func(rec *httptest.ResponseRecorder) []db.Ingredient {
+		var found db.Recipe
+		json.Unmarshal(rec.Body.Bytes(), &found)
+		return found.Ingredients
+	}
+	imperial := []db.Ingredient{
+		{ID: "59b40d78cc5d6a001237265e", Amount: 1, Unit: "lb", Approximate: true},
+		{ID: "598b5ebefd078b0011140a17", Amount: 1, Unit: "i"},
+	}
+
+	rec := doRequest(e, http.MethodGet, target+"?system=imperial", "", nil)
+	if rec.Code != http.StatusOK || !reflect.DeepEqual(ingredients(rec), imperial) {
+		t.Errorf("Expected %+v, got %v: %v", imperial, rec.Code, rec.Body.String())
+	}
+	etag := rec.Header().Get(HeaderETag)
+	if !strings.HasPrefix(etag, `W/"1-`) || !slices.Contains(rec.Header().Values(echo.HeaderVary), HeaderUserID) {
+		t.Errorf("Expected a weak ETag varying with the user, got %v %v", etag, rec.Header())
+	}
+	converted := rec.Body.String()
+	for _, write := range []struct {
+		method, target string
+		headers        map[string]string
+	}{
+		{http.MethodPut, target, map[string]string{HeaderIfMatch: etag}},
+		{http.MethodPut, target, nil},
+		{http.MethodPost, "/recipe?allow_duplicate=true", nil},
+	} {
+		if rec := doRequest(e, write.method, write.target, converted, write.headers); rec.Code != http.StatusBadRequest || !strings.Contains(rec.Body.String(), "approximate conversions") {
+			t.Errorf("Expected %v of a converted recipe to fail with 400, got %v: %v", write.method, rec.Code, rec.Body.String())
+		}
+	}
+	if rec := doRequest(e, http.MethodGet, target+"?system=original", "", nil); strings.Contains(rec.Body.String(), "approximate") {
+		t.Errorf("Expected the original units, got %v", rec.Body.String())
+	}
+	if rec := doRequest(e, http.MethodGet, target+"?system=nautical", "", nil); rec.Code != http.StatusBadRequest {
+		t.Errorf("Expected 400 for an unknown system, got %v", rec.Code)
+	}
+
+	user := map[string]string{HeaderUserID: "partner"}
+	if rec := doRequest(e, http.MethodPut, "/preferences", `{"system": "imperial"}`, nil); rec.Code != http.StatusBadRequest {
+		t.Errorf("Expected 400 without user, got %v", rec.Code)
+	}
+	if rec := doRequest(e, http.MethodPut, "/preferences", `{"system": "nautical"}`, user); rec.Code != http.StatusBadRequest {
+		t.Errorf("Expected 400 for an unknown system, got %v", rec.Code)
+	}
+	if rec := doRequest(e, http.MethodPut, "/preferences", `{"system": "imperial"}`, user); rec.Code != http.StatusOK {
+		t.Fatalf("Expected 200, got %v: %v", rec.Code, rec.Body.String())
+	}
+	if rec := doRequest(e, http.MethodGet, "/preferences", "", user); !strings.Contains(rec.Body.String(), `"system":"imperial"`) {
+		t.Errorf("Expected the imperial preference, got %v", rec.Body.String())
+	}
+
+	rec = doRequest(e, http.MethodGet, "/recipe", "", user)
+	var page db.RecipePage
+	json.Unmarshal(rec.Body.Bytes(), &page)
+	if len(page.Recipes) != 1 || !reflect.DeepEqual(page.Recipes[0].Ingredients, imperial) {
+		t.Errorf("Expected the preferred units in the listing, got %v", rec.Body.String())
+	}
+	if rec := doRequest(e, http.MethodGet, target+"?system=original", "", user); !reflect.DeepEqual(ingredients(rec), recipe.Ingredients) {
+		t.Errorf("Expected the query parameter to override the preference, got %v", rec.Body.String())
+	}
+	rec = doRequest(e, http.MethodGet, target+"/scaled?servings=8", "", user)
+	if found := ingredients(rec); len(found) != 2 || found[0].Unit != "lb" || found[0].Amount != 2 || !found[0].Approximate {
+		t.Errorf("Expected the scaled recipe in the preferred units, got %v", rec.Body.String())
+	}
+
+	// The stored recipe and its export keep the original units
+	if rec := doRequest(e, http.MethodGet, "/recipe/export", "", user); strings.Contains(rec.Body.String(), "approximate") {
+		t.Errorf("Expected the export in the original units, got %v", rec.Body.String())
+	}
+	if rec := doRequest(e, http.MethodGet, target, "", nil); !reflect.DeepEqual(ingredients(rec), recipe.Ingredients) {
+		t.Errorf("Expected the stored recipe untouched, got %v", rec.Body.String())
+	}
+}
@@ -0,0 +1,46 @@
+package api
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+
+	"github.com/labstack/echo/v4"
+)
+
+// Return the recipe scaled to the servings, or by the factor, its stored version is left untouched
+func (api *ApiHandler) getScaledRecipe(c echo.Context) error {
+	l := logger.WithField("request", "getScaledRecipe")
+	params := new(ScaleParams)
+	if err := c.Bind(params); err != nil {
+		return NewBadRequestError(err)
+	}
+	if err := c.Validate(params); err != nil {
+		return err
+	}
+	if (params.Servings == 0) == (params.Factor == 0) {
+		return NewBadRequestError(errors.New("expected either servings or factor"))
+	}
+	fields, err := bindFieldSet(c)
+	if err != nil {
+		return err
+	}
+
+	recipe, err := api.dbh.FindRecipeByID(c.Request().Context(), l, params.ID)
+	if err != nil {
+		return NewDbError(err, NewNotFoundError)
+	}
+	// The recipes stored before the servings were required have none to scale from
+	if recipe.Servings < 1 {
+		return NewUnprocessableEntityError(fmt.Errorf("the recipe %v has no servings to scale from", params.ID))
+	}
+	factor := params.Factor
+	if params.Servings > 0 {
+		factor = float64(params.Servings) / float64(recipe.Servings)
+	}
+	scaled := recipe.Scale(factor)
+	scaled.Servings = max(int(math.Round(float64(recipe.Servings)*factor)), 1)
+	setDerivedETag(c, recipe.Version, "scaled="+strconv.FormatFloat(factor, 'g', -1, 64), fieldsVariant(fields), systemVariant(c))
+	return sendSparse(c, scaled, fields)
+}
@@ -0,0 +1,81 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"recipes/db"
+	"sync"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"github.com/sirupsen/logrus"
+	"golang.org/x/sync/singleflight"
+)
+
+// statsCache keeps the catalog statistics of every tenant for ttl, a zero ttl computes them on every request.
+// The first request of a tenant after the expiry computes them while the concurrent ones of the tenant wait for it,
+// the other tenants are not held up.
+type statsCache struct {
+	mu      sync.Mutex
+	ttl     time.Duration
+	entries map[string]statsEntry
+	group   singleflight.Group
+}
+
+type statsEntry struct {
+	stats   *db.CatalogStats
+	expires time.Time
+}
+
+func (sc *statsCache) get(ctx context.Context, l *logrus.Entry, store db.RecipeStore) (*db.CatalogStats, error) {
+	tenant := db.TenantFrom(ctx)
+	sc.mu.Lock()
+	entry, ok := sc.entries[tenant]
+	sc.mu.Unlock()
+	if ok && time.Now().Before(entry.expires) {
+		return entry.stats, nil
+	}
+	// The computation is shared by the waiting requests, the one which started it going away does not cancel it
+	stats, err, _ := sc.group.Do(tenant, func() (any, error) {
+		stats, err := store.RecipeStats(context.WithoutCancel(ctx), l)
+		if err != nil {
+			return nil, err
+		}
+		if sc.ttl > 0 {
+			sc.mu.Lock()
+			if sc.entries == nil {
+				sc.entries = make(map[string]statsEntry)
+			}
+			sc.entries[tenant] = statsEntry{stats: stats, expires: time.Now().Add(sc.ttl)}
+			sc.mu.Unlock()
+		}
+		return stats, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return stats.(*db.CatalogStats), nil
+}
+
+func (api *ApiHandler) getRecipeStats(c echo.Context) error {
+	l := logger.WithField("request", "getRecipeStats")
+	stats, err := api.stats.get(c.Request().Context(), l, api.dbh)
+	if err != nil {
+		return NewDbError(err, NewInternalServerError)
+	}
+	// The statistics are the ones of the tenant of the request, a shared cache must not serve them to another one
+	c.Response().Header().Set(echo.HeaderCacheControl, fmt.Sprintf("private, max-age=%d", int(api.stats.ttl/time.Second)))
+	c.Response().Header().Add(echo.HeaderVary, api.conf.TenantHeader)
+	return c.JSON(http.StatusOK, stats)
+}
+
+// Report the hits and misses of the recipe cache, 404 when it is disabled
+func (api *ApiHandler) getCacheStats(c echo.Context) error {
+	store, ok := api.dbh.(*db.CachedStore)
+	if !ok {
+		return NewNotFoundError(errors.New("the recipe cache is disabled"))
+	}
+	return c.JSON(http.StatusOK, store.Stats())
+}
@@ -0,0 +1,156 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"recipes/db"
+	"recipes/preferences"
+	"recipes/units"
+	"strings"
+
+	"github.com/labstack/echo/v4"
+)
+
+type systemKey struct{}
+
+// SystemParams choose the units of the returned recipes, the preference of the user when it is not given
+type SystemParams struct {
+	System string `query:"system" validate:"omitempty,oneof=original metric imperial"`
+}
+
+// UsePreferences replaces the store of the user preferences, by default an empty one kept in memory
+func (api *ApiHandler) UsePreferences(store *preferences.Store) {
+	api.preferences = store
+}
+
+// The user of the request, empty for an anonymous one
+func requestUser(c echo.Context) string {
+	return strings.TrimSpace(c.Request().Header.Get(HeaderUserID))
+}
+
+// Put the units system of the returned recipes in the context of the request:
+// the system query parameter, else the preference of the user, else the original units
+func (api *ApiHandler) systemMiddleware(next echo.HandlerFunc) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		params := new(SystemParams)
+		if err := (&echo.DefaultBinder{}).BindQueryParams(c, params); err != nil {
+			return NewBadRequestError(err)
+		}
+		if err := c.Validate(params); err != nil {
+			return err
+		}
+		req := c.Request()
+		system := params.System
+		if user := requestUser(c); system == "" && user != "" {
+			preferred, _ := api.preferences.Get(db.TenantFrom(req.Context()), user)
+			system = preferred.System
+		}
+		// The preference of the user changes the body of the reads
+		c.Response().Header().Add(echo.HeaderVary, HeaderUserID)
+		if system != "" && system != preferences.OriginalSystem {
+			c.SetRequest(req.WithContext(context.WithValue(req.Context(), systemKey{}, units.System(system))))
+		}
+		return next(c)
+	}
+}
+
+// The units system of the request, none for the original units
+func requestSystem(c echo.Context) (units.System, bool) {
+	system, ok := c.Request().Context().Value(systemKey{}).(units.System)
+	return system, ok
+}
+
+// The variant of the representation of a recipe in the units system of the request, empty for the original units
+func systemVariant(c echo.Context) string {
+	if system, ok := requestSystem(c); ok {
+		return "system=" + string(system)
+	}
+	return ""
+}
+
+// A recipe read in another units system holds approximate amounts, writing them back would lose the original ones
+func checkExactAmounts(recipe *db.Recipe) error {
+	var approximate []string
+	for _, ingredient := range recipe.Ingredients {
+		if ingredient.Approximate {
+			approximate = append(approximate, ingredient.ID)
+		}
+	}
+	if len(approximate) > 0 {
+		return fmt.Errorf("the amounts of the ingredients %v are approximate conversions, write the recipe in its original units read with ?system=original",
+			strings.Join(approximate, ", "))
+	}
+	return nil
+}
+
+// The recipe in the units system of the request
+func presentRecipe(c echo.Context, recipe *db.Recipe) *db.Recipe {
+	if system, ok := requestSystem(c); ok {
+		return recipe.InSystem(system)
+	}
+	return recipe
+}
+
+// The page with its recipes in the units system of the request, the page of the store is left untouched
+func presentPage(c echo.Context, page *db.RecipePage) *db.RecipePage {
+	if _, ok := requestSystem(c); !ok {
+		return page
+	}
+	presented := *page
+	presented.Recipes = make([]db.Recipe, len(page.Recipes))
+	for i := range page.Recipes {
+		presented.Recipes[i] = *presentRecipe(c, &page.Recipes[i])
+	}
+	return &presented
+}
+
+// The search results in the units system of the request
+func presentSearchPage(c echo.Context, page *db.SearchPage) *db.SearchPage {
+	if _, ok := requestSystem(c); !ok {
+		return page
+	}
+	presented := *page
+	presented.Results = make([]db.SearchResult, len(page.Results))
+	for i, result := range page.Results {
+		result.Recipe = *presentRecipe(c, &result.Recipe)
+		presented.Results[i] = result
+	}
+	return &presented
+}
+
+func (api *ApiHandler) getPreferences(c echo.Context) error {
+	user := requestUser(c)
+	if user == "" {
+		return NewBadRequestError(fmt.Errorf("missing user, set the %v header", HeaderUserID))
+	}
+	preferred, _ := api.preferences.Get(db.TenantFrom(c.Request().Context()), user)
+	if preferred.System == "" {
+		preferred.System = preferences.OriginalSystem
+	}
+	return c.JSON(http.StatusOK, preferred)
+}
+
+func (api *ApiHandler) putPreferences(c echo.Context) error {
+	l := logger.WithField("request", "putPreferences")
+	user := requestUser(c)
+	if user == "" {
+		return NewBadRequestError(fmt.Errorf("missing user, set the %v header", HeaderUserID))
+	}
+	preferred := new(preferences.Preferences)
+	if err := c.Bind(preferred); err != nil {
+		return NewBadRequestError(err)
+	}
+	if err := c.Validate(preferred); err != nil {
+		return err
+	}
+	if preferred.System == "" {
+		return NewBadRequestError(errors.New("expected a system, one of original, metric, imperial"))
+	}
+	if err := api.preferences.Set(db.TenantFrom(c.Request().Context()), user, *preferred); err != nil {
+		FailOnError(l, err, "Error when trying to save the preferences")
+		return NewInternalServerError(err)
+	}
+	return c.JSON(http.StatusOK, preferred)
+}
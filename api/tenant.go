@@ -0,0 +1,83 @@
+package api
+
+import (
+	"errors"
+	"fmt"
+	"recipes/configuration"
+	"recipes/db"
+	"strings"
+
+	"github.com/golang-jwt/jwt"
+	"github.com/labstack/echo/v4"
+)
+
+// HeaderTenantID is the default header holding the tenant of the request, see TENANT_HEADER
+const HeaderTenantID = "X-Tenant-ID"
+
+// Read the tenant claim of the bearer token, signed with HMAC and the secret
+func tenantClaim(authorization string, secret string, claim string) (string, error) {
+	raw, ok := strings.CutPrefix(authorization, "Bearer ")
+	if !ok {
+		return "", errors.New("expected a bearer token")
+	}
+	claims := jwt.MapClaims{}
+	_, err := jwt.ParseWithClaims(raw, claims, func(token *jwt.Token) (any, error) {
+		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
+			return nil, fmt.Errorf("unexpected signing method %v", token.Header["alg"])
+		}
+		return []byte(secret), nil
+	})
+	if err != nil {
+		return "", err
+	}
+	tenant, _ := claims[claim].(string)
+	if tenant == "" {
+		return "", fmt.Errorf("the token has no %v claim", claim)
+	}
+	return tenant, nil
+}
+
+// Resolve the tenant of the request. With a JWT secret the tenant comes from the claim of the bearer token,
+// the tenant header can only repeat it. Without secret it comes from the tenant header.
+func (api *ApiHandler) resolveTenant(c echo.Context) (string, error) {
+	header := strings.TrimSpace(c.Request().Header.Get(api.conf.TenantHeader))
+	tenant := header
+	if api.conf.JWTSecret != "" {
+		authorization := c.Request().Header.Get(echo.HeaderAuthorization)
+		if authorization == "" {
+			return "", NewUnauthorizedError(errors.New("missing bearer token"))
+		}
+		claimed, err := tenantClaim(authorization, api.conf.JWTSecret, api.conf.TenantClaim)
+		if err != nil {
+			return "", NewUnauthorizedError(err)
+		}
+		if header != "" && header != claimed {
+			return "", NewForbiddenError(fmt.Errorf("the %v header does not match the tenant of the token", api.conf.TenantHeader))
+		}
+		tenant = claimed
+	}
+	if tenant == "" {
+		return "", NewBadRequestError(fmt.Errorf("missing tenant, set the %v header", api.conf.TenantHeader))
+	}
+	if !db.ValidTenant(tenant) {
+		return "", NewBadRequestError(fmt.Errorf("invalid tenant %q, expected lowercase letters, digits or dashes", tenant))
+	}
+	return tenant, nil
+}
+
+// Put the tenant of the request in its context for the db package, the requests without tenant are refused
+func (api *ApiHandler) tenantMiddleware(next echo.HandlerFunc) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		if api.conf.TenancyMode == "" || api.conf.TenancyMode == configuration.NoTenancy {
+			return next(c)
+		}
+		tenant, err := api.resolveTenant(c)
+		if err != nil {
+			logger.WithField("request", "tenant").WithError(err).Warn("Request refused without a valid tenant")
+			return err
+		}
+		req := c.Request()
+		c.SetRequest(req.WithContext(db.WithTenant(req.Context(), tenant)))
+		return next(c)
+	}
+}
@@ -0,0 +1,105 @@
+package cache
+
+import (
+	"container/list"
+	"context"
+	"sync"
+	"time"
+)
+
+// Cache holds encoded values by key. The in-process LRU is the default, a cache shared by the replicas can implement it too.
+// A cache never fails: an unreachable cache behaves as an empty one.
+type Cache interface {
+	// Get returns the value of the key, the caller must not modify it
+	Get(ctx context.Context, key string) ([]byte, bool)
+	Set(ctx context.Context, key string, value []byte)
+	Delete(ctx context.Context, keys ...string)
+}
+
+// LRU is an in-process Cache holding up to size entries for ttl, the least recently used entry is evicted first.
+// A zero ttl keeps the entries until they are evicted.
+type LRU struct {
+	mu      sync.Mutex
+	size    int
+	ttl     time.Duration
+	entries map[string]*list.Element
+	// The most recently used entry is at the front
+	order *list.List
+	// Clock of the expiries, replaced by the tests
+	now func() time.Time
+}
+
+type entry struct {
+	key     string
+	value   []byte
+	expires time.Time
+}
+
+var _ Cache = (*LRU)(nil)
+
+func NewLRU(size int, ttl time.Duration) *LRU {
+	return &LRU{
+		size:    size,
+		ttl:     ttl,
+		entries: make(map[string]*list.Element, size),
+		order:   list.New(),
+		now:     time.Now,
+	}
+}
+
+func (c *LRU) Get(ctx context.Context, key string) ([]byte, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	element, ok := c.entries[key]
+	if !ok {
+		return nil, false
+	}
+	e := element.Value.(*entry)
+	if c.ttl > 0 && !c.now().Before(e.expires) {
+		c.remove(element)
+		return nil, false
+	}
+	c.order.MoveToFront(element)
+	return e.value, true
+}
+
+func (c *LRU) Set(ctx context.Context, key string, value []byte) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.size <= 0 {
+		return
+	}
+	e := &entry{key: key, value: append([]byte(nil), value...), expires: c.now().Add(c.ttl)}
+	if element, ok := c.entries[key]; ok {
+		element.Value = e
+		c.order.MoveToFront(element)
+		return
+	}
+	c.entries[key] = c.order.PushFront(e)
+	for c.order.Len() > c.size {
+		c.remove(c.order.Back())
+	}
+}
+
+func (c *LRU) Delete(ctx context.Context, keys ...string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, key := range keys {
+		if element, ok := c.entries[key]; ok {
+			c.remove(element)
+		}
+	}
+}
+
+// Len returns the number of entries, the expired ones included until they are read or evicted
+func (c *LRU) Len() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.order.Len()
+}
+
+// Remove the entry, must be called under the lock
+func (c *LRU) remove(element *list.Element) {
+	c.order.Remove(element)
+	delete(c.entries, element.Value.(*entry).key)
+}
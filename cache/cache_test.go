@@ -0,0 +1,95 @@
+package cache
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestLRU(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("The least recently used entry is evicted first", func(t *testing.T) {
+		c := NewLRU(2, 0)
+		c.Set(ctx, "a", []byte("1"))
+		c.Set(ctx, "b", []byte("2"))
+		c.Get(ctx, "a")
+		c.Set(ctx, "c", []byte("3"))
+		if _, ok := c.Get(ctx, "b"); ok {
+			t.Errorf("Expected b evicted")
+		}
+		for _, key := range []string{"a", "c"} {
+			if _, ok := c.Get(ctx, key); !ok {
+				t.Errorf("Expected %v kept", key)
+			}
+		}
+		if c.Len() != 2 {
+			t.Errorf("Expected 2 entries, got %v", c.Len())
+		}
+	})
+
+	t.Run("Entries expire after the TTL", func(t *testing.T) {
+		clock := time.Date(2024, time.March, 4, 12, 0, 0, 0, time.UTC)
+		c := NewLRU(10, time.Minute)
+		c.now = func() time.Time { return clock }
+		c.Set(ctx, "a", []byte("1"))
+		clock = clock.Add(59 * time.Second)
+		if value, ok := c.Get(ctx, "a"); !ok || string(value) != "1" {
+			t.Errorf("Expected a before its expiry, got %q %v", value, ok)
+		}
+		clock = clock.Add(time.Second)
+		if _, ok := c.Get(ctx, "a"); ok || c.Len() != 0 {
+			t.Errorf("Expected a expired and removed, got %v entries", c.Len())
+		}
+	})
+
+	t.Run("Set replaces and Delete removes", func(t *testing.T) {
+		c := NewLRU(10, 0)
+		value := []byte("1")
+		c.Set(ctx, "a", value)
+		value[0] = 'x'
+		if stored, _ := c.Get(ctx, "a"); string(stored) != "1" {
+			t.Errorf("Expected the stored value copied, got %q", stored)
+		}
+		c.Set(ctx, "a", []byte("2"))
+		if stored, _ := c.Get(ctx, "a"); string(stored) != "2" || c.Len() != 1 {
+			t.Errorf("Expected the value replaced, got %q with %v entries", stored, c.Len())
+		}
+		c.Set(ctx, "b", []byte("3"))
+		c.Delete(ctx, "a", "b", "unknown")
+		if c.Len() != 0 {
+			t.Errorf("Expected no entry left, got %v", c.Len())
+		}
+	})
+
+	t.Run("A zero size caches nothing", func(t *testing.T) {
+		c := NewLRU(0, time.Minute)
+		c.Set(ctx, "a", []byte("1"))
+		if _, ok := c.Get(ctx, "a"); ok {
+			t.Errorf("Expected nothing cached")
+		}
+	})
+
+	t.Run("Concurrent use is safe", func(t *testing.T) {
+		c := NewLRU(16, time.Minute)
+		done := make(chan bool)
+		for i := 0; i < 8; i++ {
+			go func(i int) {
+				for j := 0; j < 100; j++ {
+					key := fmt.Sprint((i + j) % 32)
+					c.Set(ctx, key, []byte(key))
+					c.Get(ctx, key)
+					c.Delete(ctx, fmt.Sprint(j%32))
+				}
+				done <- true
+			}(i)
+		}
+		for i := 0; i < 8; i++ {
+			<-done
+		}
+		if c.Len() > 16 {
+			t.Errorf("Expected at most 16 entries, got %v", c.Len())
+		}
+	})
+}
@@ -4,6 +4,7 @@ import (
 	"os"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/sirupsen/logrus"
 )
@@ -12,14 +13,62 @@ var logger = logrus.WithFields(logrus.Fields{
 	"context": "configuration/configuration",
 })
 
+// Storage backends selectable with STORAGE_BACKEND
+const (
+	MongoBackend  = "mongo"
+	MemoryBackend = "memory"
+	BoltBackend   = "bolt"
+)
+
+// Event publishers selectable with EVENTS_PUBLISHER
+const (
+	NoPublisher   = "none"
+	LogPublisher  = "log"
+	HTTPPublisher = "http"
+)
+
+// Tenancy modes selectable with TENANCY_MODE
+const (
+	NoTenancy         = "none"
+	SharedTenancy     = "shared"
+	CollectionTenancy = "collection"
+)
+
 type Configuration struct {
 	ListenPort            string
 	ListenAddress         string
 	ListenRoute           string
 	LogLevel              logrus.Level
+	StorageBackend        string
+	BoltPath              string
 	DBURI                 string
 	DBName                string
 	RecipesCollectionName string
+	DBConnectTimeout      time.Duration
+	DBConnectRetries      int
+	DBConnectBackoff      time.Duration
+	DBConnectMaxBackoff   time.Duration
+	DBStartupGrace        bool
+	DBReadTimeout         time.Duration
+	DBWriteTimeout        time.Duration
+	DBMigrateOnStart      bool
+	DBAllowNoTransactions bool
+	TrashRetention        time.Duration
+	TrashPurgeInterval    time.Duration
+	EventsPublisher       string
+	EventsHTTPURL         string
+	EventsHTTPTimeout     time.Duration
+	EventsRelayInterval   time.Duration
+	StatsCacheTTL         time.Duration
+	ImageMaxBytes         int64
+	CacheSize             int
+	CacheTTL              time.Duration
+	TenancyMode           string
+	TenantHeader          string
+	TenantClaim           string
+	DensitiesPath         string
+	AdminToken            string
+	PreferencesPath       string
 	TranslateValidation   bool
 	OtelServiceName       string
 	JWTSecret             string
@@ -54,30 +103,149 @@ func New() *Configuration {
 	conf.ListenAddress = os.Getenv("API_ADDRESS")
 	conf.ListenRoute = os.Getenv("API_ROUTE")
 
-	conf.DBURI = os.Getenv("MONGODB_URI")
+	conf.StorageBackend = os.Getenv("STORAGE_BACKEND")
+	if len(conf.StorageBackend) < 1 {
+		conf.StorageBackend = MongoBackend
+	}
+	if conf.StorageBackend != MongoBackend && conf.StorageBackend != MemoryBackend && conf.StorageBackend != BoltBackend {
+		logger.WithField("storageBackend", conf.StorageBackend).Error("STORAGE_BACKEND must be one of mongo, memory, bolt")
+		os.Exit(1)
+	}
+
+	if conf.StorageBackend == BoltBackend {
+		conf.BoltPath = os.Getenv("BOLT_PATH")
+		if len(conf.BoltPath) < 1 {
+			conf.BoltPath = "recipes.db"
+		}
+	}
+
+	if conf.StorageBackend == MongoBackend {
+		conf.DBURI = os.Getenv("MONGODB_URI")
+
+		if len(conf.DBURI) < 1 {
+			logger.Error("MONGODB_URI is not set")
+			os.Exit(1)
+		}
+
+		// Extract the dbName from the DBURI
+		// Try to split the DBURI by "/" and get the 4th element
+		splitedUri := strings.Split(conf.DBURI, "/")
+		if len(splitedUri) < 4 {
+			logger.Error("Failed to extract the DBName from the DBURI")
+			os.Exit(1)
+		}
+		conf.DBName = splitedUri[3]
+		logger.Debug("DBName: ", conf.DBName)
+
+		conf.RecipesCollectionName = os.Getenv("MONGODB_RECIPES_COLLECTION")
+
+		if len(conf.RecipesCollectionName) < 1 {
+			logger.Error("MONGODB_RECIPES_COLLECTION is not set")
+			os.Exit(1)
+		}
+	}
+
+	conf.DBConnectTimeout = getDuration("DB_CONNECT_TIMEOUT", 3*time.Second)
+	conf.DBReadTimeout = getDuration("DB_READ_TIMEOUT", 5*time.Second)
+	conf.DBWriteTimeout = getDuration("DB_WRITE_TIMEOUT", 10*time.Second)
+
+	conf.DBConnectRetries = int(getInt64("DB_CONNECT_RETRIES", 5))
+	conf.DBConnectBackoff = getDuration("DB_CONNECT_BACKOFF", 500*time.Millisecond)
+	conf.DBConnectMaxBackoff = getDuration("DB_CONNECT_MAX_BACKOFF", 10*time.Second)
+	// In grace mode the API starts before MongoDB answers and reports not ready until then
+	if startupGrace := os.Getenv("DB_STARTUP_GRACE"); len(startupGrace) > 0 {
+		conf.DBStartupGrace, err = strconv.ParseBool(startupGrace)
+		if err != nil {
+			logger.Error("Failed to parse bool for DB_STARTUP_GRACE")
+			os.Exit(1)
+		}
+	}
+
+	conf.DBMigrateOnStart = true
+	if migrateOnStart := os.Getenv("DB_MIGRATE_ON_START"); len(migrateOnStart) > 0 {
+		conf.DBMigrateOnStart, err = strconv.ParseBool(migrateOnStart)
+		if err != nil {
+			logger.Error("Failed to parse bool for DB_MIGRATE_ON_START")
+			os.Exit(1)
+		}
+	}
+
+	// Without transactions a failed write can leave a recipe without its revision or event, it must be accepted explicitly
+	if allowNoTransactions := os.Getenv("DB_ALLOW_NO_TRANSACTIONS"); len(allowNoTransactions) > 0 {
+		conf.DBAllowNoTransactions, err = strconv.ParseBool(allowNoTransactions)
+		if err != nil {
+			logger.Error("Failed to parse bool for DB_ALLOW_NO_TRANSACTIONS")
+			os.Exit(1)
+		}
+	}
+
+	// A zero retention keeps the trashed recipes until they are restored
+	conf.TrashRetention = getDuration("TRASH_RETENTION", 30*24*time.Hour)
+	conf.TrashPurgeInterval = getDuration("TRASH_PURGE_INTERVAL", time.Hour)
+	if conf.TrashPurgeInterval == 0 {
+		logger.Error("TRASH_PURGE_INTERVAL must be positive")
+		os.Exit(1)
+	}
 
-	if len(conf.DBURI) < 1 {
-		logger.Error("MONGODB_URI is not set")
+	conf.EventsPublisher = os.Getenv("EVENTS_PUBLISHER")
+	if len(conf.EventsPublisher) < 1 {
+		conf.EventsPublisher = LogPublisher
+	}
+	if conf.EventsPublisher != NoPublisher && conf.EventsPublisher != LogPublisher && conf.EventsPublisher != HTTPPublisher {
+		logger.WithField("eventsPublisher", conf.EventsPublisher).Error("EVENTS_PUBLISHER must be one of none, log, http")
+		os.Exit(1)
+	}
+	if conf.EventsPublisher == HTTPPublisher {
+		conf.EventsHTTPURL = os.Getenv("EVENTS_HTTP_URL")
+		if len(conf.EventsHTTPURL) < 1 {
+			logger.Error("EVENTS_HTTP_URL is not set")
+			os.Exit(1)
+		}
+	}
+	conf.EventsHTTPTimeout = getDuration("EVENTS_HTTP_TIMEOUT", 5*time.Second)
+	conf.EventsRelayInterval = getDuration("EVENTS_RELAY_INTERVAL", time.Second)
+	if conf.EventsRelayInterval == 0 {
+		logger.Error("EVENTS_RELAY_INTERVAL must be positive")
 		os.Exit(1)
 	}
 
-	// Extract the dbName from the DBURI
-	// Try to split the DBURI by "/" and get the 4th element
-	splitedUri := strings.Split(conf.DBURI, "/")
-	if len(splitedUri) < 4 {
-		logger.Error("Failed to extract the DBName from the DBURI")
+	conf.TenancyMode = os.Getenv("TENANCY_MODE")
+	if len(conf.TenancyMode) < 1 {
+		conf.TenancyMode = NoTenancy
+	}
+	if conf.TenancyMode != NoTenancy && conf.TenancyMode != SharedTenancy && conf.TenancyMode != CollectionTenancy {
+		logger.WithField("tenancyMode", conf.TenancyMode).Error("TENANCY_MODE must be one of none, shared, collection")
 		os.Exit(1)
 	}
-	conf.DBName = splitedUri[3]
-	logger.Debug("DBName: ", conf.DBName)
+	conf.TenantHeader = os.Getenv("TENANT_HEADER")
+	if len(conf.TenantHeader) < 1 {
+		conf.TenantHeader = "X-Tenant-ID"
+	}
+	conf.TenantClaim = os.Getenv("TENANT_JWT_CLAIM")
+	if len(conf.TenantClaim) < 1 {
+		conf.TenantClaim = "tenant_id"
+	}
+
+	// A zero TTL computes the statistics on every request
+	conf.StatsCacheTTL = getDuration("STATS_CACHE_TTL", time.Minute)
 
-	conf.RecipesCollectionName = os.Getenv("MONGODB_RECIPES_COLLECTION")
+	// A zero size disables the cache of the recipe lookups
+	conf.CacheSize = int(getInt64("CACHE_SIZE", 1000))
+	conf.CacheTTL = getDuration("CACHE_TTL", 30*time.Second)
 
-	if len(conf.RecipesCollectionName) < 1 {
-		logger.Error("MONGODB_RECIPES_COLLECTION is not set")
+	conf.ImageMaxBytes = getInt64("IMAGE_MAX_BYTES", 5<<20)
+	if conf.ImageMaxBytes == 0 {
+		logger.Error("IMAGE_MAX_BYTES must be positive")
 		os.Exit(1)
 	}
 
+	// Without path the densities are only kept in memory
+	conf.DensitiesPath = os.Getenv("DENSITIES_PATH")
+	// Without token the admin endpoints are disabled
+	conf.AdminToken = os.Getenv("ADMIN_TOKEN")
+	// Without path the preferences of the users are only kept in memory
+	conf.PreferencesPath = os.Getenv("PREFERENCES_PATH")
+
 	conf.TranslateValidation, err = strconv.ParseBool(os.Getenv("TRANSLATE_VALIDATION"))
 
 	if err != nil {
@@ -96,3 +264,31 @@ func New() *Configuration {
 
 	return &conf
 }
+
+// Parse the duration held by the env variable (e.g. 500ms, 5s), or return the fallback if it is not set
+func getDuration(env string, fallback time.Duration) time.Duration {
+	value := os.Getenv(env)
+	if len(value) < 1 {
+		return fallback
+	}
+	duration, err := time.ParseDuration(value)
+	if err != nil || duration < 0 {
+		logger.WithField(env, value).Error("Failed to parse duration for " + env)
+		os.Exit(1)
+	}
+	return duration
+}
+
+// Parse the positive integer held by the env variable, or return the fallback if it is not set
+func getInt64(env string, fallback int64) int64 {
+	value := os.Getenv(env)
+	if len(value) < 1 {
+		return fallback
+	}
+	n, err := strconv.ParseInt(value, 10, 64)
+	if err != nil || n < 0 {
+		logger.WithField(env, value).Error("Failed to parse integer for " + env)
+		os.Exit(1)
+	}
+	return n
+}
@@ -0,0 +1,807 @@
+package db
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"regexp"
+	"slices"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	bolt "go.etcd.io/bbolt"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Buckets of the BoltStore, the index keys are <tenant>\x00<value>\x00<recipe ID>
+var (
+	recipesBucket    = []byte("recipes")
+	revisionsBucket  = []byte("revisions")
+	eventsBucket     = []byte("events")
+	filesBucket      = []byte("files")
+	authorIndex      = []byte("recipes_by_author")
+	ingredientIndex  = []byte("recipes_by_ingredient")
+	boltStoreBuckets = [][]byte{recipesBucket, revisionsBucket, eventsBucket, filesBucket, authorIndex, ingredientIndex}
+	errStopIteration = errors.New("stop the iteration")
+)
+
+// BoltStore is a RecipeStore keeping the recipes in a bbolt file, for the single-node deployments without MongoDB.
+// The recipes, revisions, events and image files are encoded in BSON, each kind in its own bucket.
+// The recipes are indexed by author and by ingredient ID, the other reads scan the recipes of the tenant.
+// The tenants are kept apart like in the MemoryStore.
+type BoltStore struct {
+	db *bolt.DB
+}
+
+// OpenBoltStore opens the bbolt file at path, creating it if needed.
+// The file is locked until Close, timeout bounds the wait for the lock held by another process.
+func OpenBoltStore(path string, timeout time.Duration) (*BoltStore, error) {
+	boltDB, err := bolt.Open(path, 0o600, &bolt.Options{Timeout: timeout})
+	if err != nil {
+		loger.WithError(err).WithField("path", path).Error("Failed to open the bbolt file")
+		return nil, err
+	}
+	err = boltDB.Update(func(tx *bolt.Tx) error {
+		for _, name := range boltStoreBuckets {
+			if _, err := tx.CreateBucketIfNotExists(name); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		loger.WithError(err).Error("Failed to create the buckets")
+		boltDB.Close()
+		return nil, err
+	}
+	return &BoltStore{db: boltDB}, nil
+}
+
+// Close releases the file of the store
+func (bs *BoltStore) Close() error {
+	return bs.db.Close()
+}
+
+func (bs *BoltStore) NewID() primitive.ObjectID {
+	return primitive.NewObjectID()
+}
+
+func (bs *BoltStore) Ping(ctx context.Context) error {
+	if err := ctx.Err(); err != nil {
+		return wrapError(err)
+	}
+	// Fails once the store is closed
+	return bs.db.View(func(*bolt.Tx) error { return nil })
+}
+
+func indexKey(tenant string, value string, id primitive.ObjectID) []byte {
+	return append(indexPrefix(tenant, value), id[:]...)
+}
+
+func indexPrefix(tenant string, value string) []byte {
+	return []byte(tenant + "\x00" + value + "\x00")
+}
+
+// Key of a revision, the revisions of a recipe follow each other by number
+func revisionKey(recipeID primitive.ObjectID, number int64) []byte {
+	return binary.BigEndian.AppendUint64(slices.Clone(recipeID[:]), uint64(number))
+}
+
+// Call fn with the recipe IDs of the tenant indexed under the value, in order
+func scanIndex(tx *bolt.Tx, index []byte, tenant string, value string, fn func(id primitive.ObjectID) error) error {
+	prefix := indexPrefix(tenant, value)
+	cursor := tx.Bucket(index).Cursor()
+	for key, _ := cursor.Seek(prefix); key != nil && bytes.HasPrefix(key, prefix); key, _ = cursor.Next() {
+		// A longer key belongs to a value starting with this one and a separator
+		if len(key) != len(prefix)+len(primitive.NilObjectID) {
+			continue
+		}
+		if err := fn(primitive.ObjectID(key[len(prefix):])); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+type indexEntry struct {
+	index []byte
+	key   []byte
+}
+
+// Entries of the recipe in the author and ingredient indexes
+func indexEntries(recipe *Recipe) []indexEntry {
+	entries := []indexEntry{{authorIndex, indexKey(recipe.TenantID, recipe.Author, recipe.ID)}}
+	for _, ingredient := range recipe.Ingredients {
+		entries = append(entries, indexEntry{ingredientIndex, indexKey(recipe.TenantID, ingredient.ID, recipe.ID)})
+	}
+	return entries
+}
+
+// Replace the index entries of the previous content of the recipe by the ones of the recipe, either can be nil
+func updateIndexes(tx *bolt.Tx, previous *Recipe, recipe *Recipe) error {
+	if previous != nil {
+		for _, entry := range indexEntries(previous) {
+			if err := tx.Bucket(entry.index).Delete(entry.key); err != nil {
+				return err
+			}
+		}
+	}
+	if recipe != nil {
+		for _, entry := range indexEntries(recipe) {
+			if err := tx.Bucket(entry.index).Put(entry.key, nil); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// Store the recipe and its indexes, previous is its stored content or nil for a new recipe
+func putRecipe(tx *bolt.Tx, previous *Recipe, recipe *Recipe) error {
+	data, err := bson.Marshal(recipe)
+	if err != nil {
+		return err
+	}
+	if err := tx.Bucket(recipesBucket).Put(recipe.ID[:], data); err != nil {
+		return err
+	}
+	return updateIndexes(tx, previous, recipe)
+}
+
+func putRevision(tx *bolt.Tx, revision Revision) error {
+	data, err := bson.Marshal(revision)
+	if err != nil {
+		return err
+	}
+	return tx.Bucket(revisionsBucket).Put(revisionKey(revision.RecipeID, revision.Number), data)
+}
+
+func putEvent(tx *bolt.Tx, event Event) error {
+	data, err := bson.Marshal(event)
+	if err != nil {
+		return err
+	}
+	return tx.Bucket(eventsBucket).Put(event.ID[:], data)
+}
+
+func putFile(tx *bolt.Tx, id primitive.ObjectID, file ImageFile) error {
+	data, err := bson.Marshal(file)
+	if err != nil {
+		return err
+	}
+	return tx.Bucket(filesBucket).Put(id[:], data)
+}
+
+// Delete the recipe with its indexes, revisions and image files
+func removeRecipe(tx *bolt.Tx, recipe *Recipe) error {
+	if err := updateIndexes(tx, recipe, nil); err != nil {
+		return err
+	}
+	for _, image := range recipe.Images {
+		for _, fileID := range image.fileIDs() {
+			if err := tx.Bucket(filesBucket).Delete(fileID[:]); err != nil {
+				return err
+			}
+		}
+	}
+	revisions := tx.Bucket(revisionsBucket)
+	var keys [][]byte
+	cursor := revisions.Cursor()
+	for key, _ := cursor.Seek(recipe.ID[:]); key != nil && bytes.HasPrefix(key, recipe.ID[:]); key, _ = cursor.Next() {
+		keys = append(keys, slices.Clone(key))
+	}
+	for _, key := range keys {
+		if err := revisions.Delete(key); err != nil {
+			return err
+		}
+	}
+	return tx.Bucket(recipesBucket).Delete(recipe.ID[:])
+}
+
+// Decode the recipe stored under the ID if it belongs to the tenant of the context, trashed or not, nil otherwise
+func (bs *BoltStore) lookup(ctx context.Context, tx *bolt.Tx, id primitive.ObjectID) (*Recipe, error) {
+	data := tx.Bucket(recipesBucket).Get(id[:])
+	if data == nil {
+		return nil, nil
+	}
+	recipe := new(Recipe)
+	if err := bson.Unmarshal(data, recipe); err != nil {
+		return nil, err
+	}
+	if recipe.TenantID != TenantFrom(ctx) {
+		return nil, nil
+	}
+	return recipe, nil
+}
+
+// Return the stored recipe if it is out of the trash and at the expected version
+func (bs *BoltStore) checkVersion(ctx context.Context, tx *bolt.Tx, id primitive.ObjectID, version int64) (*Recipe, error) {
+	stored, err := bs.lookup(ctx, tx, id)
+	if err != nil {
+		return nil, err
+	}
+	if stored == nil || stored.DeletedAt != nil {
+		return nil, ErrRecipeNotFound
+	}
+	if version != AnyVersion && version != stored.Version {
+		return nil, ErrVersionMismatch
+	}
+	return stored, nil
+}
+
+// Decode the recipes of the tenant matching the predicate, trashed or not, ordered by ID
+func (bs *BoltStore) collect(ctx context.Context, match func(*Recipe) bool) ([]Recipe, error) {
+	tenant := TenantFrom(ctx)
+	recipes := make([]Recipe, 0)
+	err := bs.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(recipesBucket).ForEach(func(_, data []byte) error {
+			var recipe Recipe
+			if err := bson.Unmarshal(data, &recipe); err != nil {
+				return err
+			}
+			if recipe.TenantID == tenant && match(&recipe) {
+				recipes = append(recipes, recipe)
+			}
+			return nil
+		})
+	})
+	return recipes, err
+}
+
+// Decode the recipes of the tenant out of the trash matching the predicate, ordered by ID
+func (bs *BoltStore) findAll(ctx context.Context, match func(*Recipe) bool) ([]Recipe, error) {
+	return bs.collect(ctx, func(r *Recipe) bool { return r.DeletedAt == nil && match(r) })
+}
+
+// Decode the recipes of the tenant out of the trash indexed under the value, ordered by ID
+func (bs *BoltStore) findIndexed(ctx context.Context, index []byte, value string) ([]Recipe, error) {
+	recipes := make([]Recipe, 0)
+	err := bs.db.View(func(tx *bolt.Tx) error {
+		return scanIndex(tx, index, TenantFrom(ctx), value, func(id primitive.ObjectID) error {
+			recipe, err := bs.lookup(ctx, tx, id)
+			if err == nil && recipe != nil && recipe.DeletedAt == nil {
+				recipes = append(recipes, *recipe)
+			}
+			return err
+		})
+	})
+	return recipes, err
+}
+
+// Return the page of the recipes once read, name tells the read in the logs
+func (bs *BoltStore) findPage(ctx context.Context, l *logrus.Entry, name string, recipes []Recipe, err error, opts ListOptions) (*RecipePage, error) {
+	if err != nil {
+		l.WithError(err).Error("Error when trying to " + name)
+		return nil, wrapError(err)
+	}
+	return filterPage(ctx, l, recipes, opts)
+}
+
+func (bs *BoltStore) FindAllRecipes(ctx context.Context, l *logrus.Entry, opts ListOptions) (*RecipePage, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, wrapError(err)
+	}
+	recipes, err := bs.findAll(ctx, func(*Recipe) bool { return true })
+	return bs.findPage(ctx, l, "find all recipes", recipes, err, opts)
+}
+
+func (bs *BoltStore) FindRecipesByIngredientID(ctx context.Context, l *logrus.Entry, id string, opts ListOptions) (*RecipePage, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, wrapError(err)
+	}
+	recipes, err := bs.findIndexed(ctx, ingredientIndex, id)
+	return bs.findPage(ctx, l, "find recipes by ingredient", recipes, err, opts)
+}
+
+func (bs *BoltStore) FindRecipesByAuthorID(ctx context.Context, l *logrus.Entry, author string, opts ListOptions) (*RecipePage, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, wrapError(err)
+	}
+	recipes, err := bs.findIndexed(ctx, authorIndex, author)
+	return bs.findPage(ctx, l, "find recipes by author", recipes, err, opts)
+}
+
+func (bs *BoltStore) FindRecipeByTitle(ctx context.Context, l *logrus.Entry, title string) (*Recipe, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, wrapError(err)
+	}
+	re, err := regexp.Compile("(?i)" + regexp.QuoteMeta(title))
+	if err != nil {
+		l.WithError(err).Error("Error when trying to find recipe by title")
+		return nil, err
+	}
+	recipes, err := bs.findAll(ctx, func(r *Recipe) bool { return re.MatchString(r.Name) })
+	if err != nil {
+		l.WithError(err).Error("Error when trying to find recipe by title")
+		return nil, wrapError(err)
+	}
+	if len(recipes) == 0 {
+		l.WithError(ErrRecipeNotFound).Error("Error when trying to find recipe by title")
+		return nil, ErrRecipeNotFound
+	}
+	return &recipes[0], nil
+}
+
+func (bs *BoltStore) FindRecipeByID(ctx context.Context, l *logrus.Entry, id string) (*Recipe, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, wrapError(err)
+	}
+	objectID, _ := primitive.ObjectIDFromHex(id)
+	var recipe *Recipe
+	err := bs.db.View(func(tx *bolt.Tx) error {
+		var err error
+		recipe, err = bs.checkVersion(ctx, tx, objectID, AnyVersion)
+		return err
+	})
+	if err != nil {
+		l.WithError(err).Error("Error when trying to find recipe by id")
+		return nil, wrapError(err)
+	}
+	return recipe, nil
+}
+
+func (bs *BoltStore) SearchRecipes(ctx context.Context, l *logrus.Entry, query string, opts ListOptions) (*SearchPage, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, wrapError(err)
+	}
+	recipes, err := bs.findAll(ctx, func(*Recipe) bool { return true })
+	if err != nil {
+		l.WithError(err).Error("Error when trying to search recipes")
+		return nil, wrapError(err)
+	}
+	page, err := searchInMemory(recipes, query, opts)
+	if err != nil {
+		l.WithError(err).Warn("Error when trying to decode the cursor")
+		return nil, err
+	}
+	return page, nil
+}
+
+// Store a new recipe prepared for its insertion, with its revision and event
+func insertRecipe(ctx context.Context, tx *bolt.Tx, recipe *Recipe) error {
+	if err := putRecipe(tx, nil, recipe); err != nil {
+		return err
+	}
+	if err := putRevision(tx, newRevision(ctx, recipe)); err != nil {
+		return err
+	}
+	return putEvent(tx, newEvent(ctx, RecipeCreated, recipe))
+}
+
+func (bs *BoltStore) SaveRecipe(ctx context.Context, l *logrus.Entry, recipe *Recipe) error {
+	if err := ctx.Err(); err != nil {
+		return wrapError(err)
+	}
+	err := bs.db.Update(func(tx *bolt.Tx) error {
+		if tx.Bucket(recipesBucket).Get(recipe.ID[:]) != nil {
+			return ErrDuplicateID
+		}
+		recipe.prepareInsert(TenantFrom(ctx))
+		return insertRecipe(ctx, tx, recipe)
+	})
+	if err != nil {
+		l.WithError(err).Error("Error when trying to save recipe")
+		return wrapError(err)
+	}
+	return nil
+}
+
+func (bs *BoltStore) DeleteRecipeByID(ctx context.Context, l *logrus.Entry, id string, version int64) error {
+	if err := ctx.Err(); err != nil {
+		return wrapError(err)
+	}
+	objectID, _ := primitive.ObjectIDFromHex(id)
+	err := bs.db.Update(func(tx *bolt.Tx) error {
+		stored, err := bs.checkVersion(ctx, tx, objectID, version)
+		if err != nil {
+			return err
+		}
+		deleted := *stored
+		deletedAt := now()
+		deleted.DeletedAt = &deletedAt
+		deleted.Version++
+		if err := putRecipe(tx, stored, &deleted); err != nil {
+			return err
+		}
+		return putEvent(tx, newEvent(ctx, RecipeDeleted, &deleted))
+	})
+	if err != nil {
+		l.WithError(err).Error("Error when trying to delete recipe by id")
+		return wrapError(err)
+	}
+	return nil
+}
+
+// Replace the content of a stored recipe, with its revision and event
+func (bs *BoltStore) upsert(ctx context.Context, tx *bolt.Tx, recipe *Recipe) error {
+	stored, err := bs.checkVersion(ctx, tx, recipe.ID, recipe.Version)
+	if err != nil {
+		return err
+	}
+	// Like the mongo $set, an unset creation date keeps the stored one
+	if recipe.CreatedAt.IsZero() {
+		recipe.CreatedAt = stored.CreatedAt
+	}
+	recipe.Version = stored.Version + 1
+	recipe.DeletedAt = nil
+	recipe.TenantID = stored.TenantID
+	recipe.Images = stored.Images
+	recipe.computeDerivedFields()
+	if err := putRecipe(tx, stored, recipe); err != nil {
+		return err
+	}
+	if err := putRevision(tx, newRevision(ctx, recipe)); err != nil {
+		return err
+	}
+	return putEvent(tx, newEvent(ctx, RecipeUpdated, recipe))
+}
+
+func (bs *BoltStore) UpsertOne(ctx context.Context, l *logrus.Entry, recipe *Recipe) error {
+	if err := ctx.Err(); err != nil {
+		return wrapError(err)
+	}
+	err := bs.db.Update(func(tx *bolt.Tx) error {
+		return bs.upsert(ctx, tx, recipe)
+	})
+	if err != nil {
+		l.WithError(err).Error("Error when trying to upsert recipe")
+		return wrapError(err)
+	}
+	return nil
+}
+
+func (bs *BoltStore) FindTrashedRecipes(ctx context.Context, l *logrus.Entry, opts ListOptions) (*RecipePage, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, wrapError(err)
+	}
+	recipes, err := bs.collect(ctx, func(r *Recipe) bool { return r.DeletedAt != nil })
+	return bs.findPage(ctx, l, "find trashed recipes", recipes, err, opts)
+}
+
+func (bs *BoltStore) RestoreRecipeByID(ctx context.Context, l *logrus.Entry, id string) (*Recipe, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, wrapError(err)
+	}
+	objectID, _ := primitive.ObjectIDFromHex(id)
+	var restored Recipe
+	err := bs.db.Update(func(tx *bolt.Tx) error {
+		stored, err := bs.lookup(ctx, tx, objectID)
+		if err != nil {
+			return err
+		}
+		if stored == nil || stored.DeletedAt == nil {
+			return ErrRecipeNotFound
+		}
+		restored = *stored
+		restored.DeletedAt = nil
+		restored.Version++
+		if err := putRecipe(tx, stored, &restored); err != nil {
+			return err
+		}
+		return putEvent(tx, newEvent(ctx, RecipeUpdated, &restored))
+	})
+	if err != nil {
+		l.WithError(err).Error("Error when trying to restore recipe by id")
+		return nil, wrapError(err)
+	}
+	return &restored, nil
+}
+
+// PurgeDeletedRecipes purges the trash of every tenant
+func (bs *BoltStore) PurgeDeletedRecipes(ctx context.Context, l *logrus.Entry, before time.Time) (int64, error) {
+	if err := ctx.Err(); err != nil {
+		return 0, wrapError(err)
+	}
+	purged := int64(0)
+	err := bs.db.Update(func(tx *bolt.Tx) error {
+		var expired []Recipe
+		err := tx.Bucket(recipesBucket).ForEach(func(_, data []byte) error {
+			var recipe Recipe
+			if err := bson.Unmarshal(data, &recipe); err != nil {
+				return err
+			}
+			if recipe.DeletedAt != nil && recipe.DeletedAt.Before(before) {
+				expired = append(expired, recipe)
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		// The bucket cannot change while it is iterated
+		for i := range expired {
+			if err := removeRecipe(tx, &expired[i]); err != nil {
+				return err
+			}
+		}
+		purged = int64(len(expired))
+		return nil
+	})
+	if err != nil {
+		l.WithError(err).Error("Error when trying to purge the deleted recipes")
+		return 0, wrapError(err)
+	}
+	return purged, nil
+}
+
+func (bs *BoltStore) FindRevisions(ctx context.Context, l *logrus.Entry, recipeID string) ([]Revision, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, wrapError(err)
+	}
+	objectID, _ := primitive.ObjectIDFromHex(recipeID)
+	revisions := make([]Revision, 0)
+	err := bs.db.View(func(tx *bolt.Tx) error {
+		if stored, err := bs.lookup(ctx, tx, objectID); err != nil || stored == nil {
+			return err
+		}
+		cursor := tx.Bucket(revisionsBucket).Cursor()
+		for key, data := cursor.Seek(objectID[:]); key != nil && bytes.HasPrefix(key, objectID[:]); key, data = cursor.Next() {
+			var revision Revision
+			if err := bson.Unmarshal(data, &revision); err != nil {
+				return err
+			}
+			revision.Recipe = nil
+			revisions = append(revisions, revision)
+		}
+		return nil
+	})
+	if err != nil {
+		l.WithError(err).Error("Error when trying to find revisions")
+		return nil, wrapError(err)
+	}
+	return revisions, nil
+}
+
+func (bs *BoltStore) FindRevision(ctx context.Context, l *logrus.Entry, recipeID string, number int64) (*Revision, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, wrapError(err)
+	}
+	objectID, _ := primitive.ObjectIDFromHex(recipeID)
+	revision := new(Revision)
+	err := bs.db.View(func(tx *bolt.Tx) error {
+		stored, err := bs.lookup(ctx, tx, objectID)
+		if err != nil {
+			return err
+		}
+		data := tx.Bucket(revisionsBucket).Get(revisionKey(objectID, number))
+		if stored == nil || data == nil {
+			return ErrRevisionNotFound
+		}
+		return bson.Unmarshal(data, revision)
+	})
+	if err != nil {
+		l.WithError(err).Error("Error when trying to find revision")
+		return nil, wrapError(err)
+	}
+	return revision, nil
+}
+
+func (bs *BoltStore) ImportRecipes(ctx context.Context, l *logrus.Entry, recipes []Recipe, opts ImportOptions) ([]ImportOutcome, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, wrapError(err)
+	}
+	var outcomes []ImportOutcome
+	err := bs.db.Update(func(tx *bolt.Tx) error {
+		var lookupErr error
+		outcomes = planImport(recipes, func(id primitive.ObjectID) bool {
+			stored, err := bs.lookup(ctx, tx, id)
+			if lookupErr == nil {
+				lookupErr = err
+			}
+			return stored != nil
+		}, opts)
+		if lookupErr != nil {
+			return lookupErr
+		}
+		// The ID of a recipe of another tenant cannot be reused, like the unique _id of a shared collection
+		for i := range recipes {
+			if taken := tx.Bucket(recipesBucket).Get(recipes[i].ID[:]) != nil; taken && outcomes[i].Status == ImportCreated {
+				outcomes[i] = ImportOutcome{Status: ImportFailed, Err: ErrDuplicateID}
+			}
+		}
+		if opts.DryRun {
+			return nil
+		}
+		for i := range recipes {
+			switch outcomes[i].Status {
+			case ImportCreated:
+				recipes[i].prepareInsert(TenantFrom(ctx))
+				if err := insertRecipe(ctx, tx, &recipes[i]); err != nil {
+					return err
+				}
+			case ImportUpdated:
+				recipes[i].Version = AnyVersion
+				err := bs.upsert(ctx, tx, &recipes[i])
+				if errors.Is(err, ErrRecipeNotFound) {
+					outcomes[i] = ImportOutcome{Status: ImportFailed, Err: err}
+				} else if err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		l.WithError(err).Error("Error when trying to import recipes")
+		return nil, wrapError(err)
+	}
+	return outcomes, nil
+}
+
+func (bs *BoltStore) ExportRecipes(ctx context.Context, l *logrus.Entry, fn func(recipe *Recipe) error) error {
+	recipes, err := bs.findAll(ctx, func(*Recipe) bool { return true })
+	if err != nil {
+		l.WithError(err).Error("Error when trying to export recipes")
+		return wrapError(err)
+	}
+	for _, recipe := range recipes {
+		if err := ctx.Err(); err != nil {
+			return wrapError(err)
+		}
+		if err := fn(&recipe); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (bs *BoltStore) PendingEvents(ctx context.Context, l *logrus.Entry, limit int) ([]Event, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, wrapError(err)
+	}
+	events := make([]Event, 0)
+	err := bs.db.View(func(tx *bolt.Tx) error {
+		err := tx.Bucket(eventsBucket).ForEach(func(_, data []byte) error {
+			if len(events) >= limit {
+				return errStopIteration
+			}
+			var event Event
+			if err := bson.Unmarshal(data, &event); err != nil {
+				return err
+			}
+			events = append(events, event)
+			return nil
+		})
+		if errors.Is(err, errStopIteration) {
+			return nil
+		}
+		return err
+	})
+	if err != nil {
+		l.WithError(err).Error("Error when trying to find the pending events")
+		return nil, wrapError(err)
+	}
+	return events, nil
+}
+
+// ClaimEvents returns the pending events, the file is locked by one process whose relay is the only one
+func (bs *BoltStore) ClaimEvents(ctx context.Context, l *logrus.Entry, owner string, lease time.Duration, limit int) ([]Event, error) {
+	return bs.PendingEvents(ctx, l, limit)
+}
+
+// MarkEventsPublished removes the published events, the outbox only keeps the pending ones
+func (bs *BoltStore) MarkEventsPublished(ctx context.Context, l *logrus.Entry, ids []primitive.ObjectID) error {
+	if err := ctx.Err(); err != nil {
+		return wrapError(err)
+	}
+	err := bs.db.Update(func(tx *bolt.Tx) error {
+		for _, id := range ids {
+			if err := tx.Bucket(eventsBucket).Delete(id[:]); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		l.WithError(err).Error("Error when trying to mark the events published")
+		return wrapError(err)
+	}
+	return nil
+}
+
+func (bs *BoltStore) RecipeStats(ctx context.Context, l *logrus.Entry) (*CatalogStats, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, wrapError(err)
+	}
+	recipes, err := bs.findAll(ctx, func(*Recipe) bool { return true })
+	if err != nil {
+		l.WithError(err).Error("Error when trying to compute the statistics")
+		return nil, wrapError(err)
+	}
+	return computeStats(recipes), nil
+}
+
+func (bs *BoltStore) FindDuplicates(ctx context.Context, l *logrus.Entry, recipe *Recipe) ([]Duplicate, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, wrapError(err)
+	}
+	nameKey := NormalizeName(recipe.Name)
+	recipes, err := bs.findAll(ctx, func(r *Recipe) bool { return r.NameKey == nameKey })
+	if err != nil {
+		l.WithError(err).Error("Error when trying to find duplicates")
+		return nil, wrapError(err)
+	}
+	return duplicatesIn(recipes, recipe), nil
+}
+
+func (bs *BoltStore) FindDuplicateClusters(ctx context.Context, l *logrus.Entry) ([]DuplicateCluster, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, wrapError(err)
+	}
+	recipes, err := bs.findAll(ctx, func(*Recipe) bool { return true })
+	if err != nil {
+		l.WithError(err).Error("Error when trying to find duplicates")
+		return nil, wrapError(err)
+	}
+	return clustersIn(recipes), nil
+}
+
+func (bs *BoltStore) AddRecipeImage(ctx context.Context, l *logrus.Entry, recipeID string, upload *ImageUpload, cover bool) (*Image, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, wrapError(err)
+	}
+	objectID, _ := primitive.ObjectIDFromHex(recipeID)
+	var image Image
+	err := bs.db.Update(func(tx *bolt.Tx) error {
+		stored, err := bs.checkVersion(ctx, tx, objectID, AnyVersion)
+		if err != nil {
+			return err
+		}
+		image = upload.Image
+		image.UploadedAt = now()
+		if err := putFile(tx, image.ID, upload.Original); err != nil {
+			return err
+		}
+		for _, thumbnail := range image.Thumbnails {
+			if err := putFile(tx, thumbnail.FileID, upload.Thumbnails[thumbnail.Name]); err != nil {
+				return err
+			}
+		}
+		updated := *stored
+		updated.Images = addImage(slices.Clone(stored.Images), image, cover)
+		image = updated.Images[len(updated.Images)-1]
+		return putRecipe(tx, stored, &updated)
+	})
+	if err != nil {
+		l.WithError(err).Error("Error when trying to add the image")
+		return nil, wrapError(err)
+	}
+	return &image, nil
+}
+
+func (bs *BoltStore) OpenRecipeImage(ctx context.Context, l *logrus.Entry, recipeID string, imageID string, size string) (*ImageFile, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, wrapError(err)
+	}
+	objectID, _ := primitive.ObjectIDFromHex(recipeID)
+	imageObjectID, _ := primitive.ObjectIDFromHex(imageID)
+	file := new(ImageFile)
+	err := bs.db.View(func(tx *bolt.Tx) error {
+		stored, err := bs.checkVersion(ctx, tx, objectID, AnyVersion)
+		if err != nil {
+			return err
+		}
+		index := slices.IndexFunc(stored.Images, func(image Image) bool { return image.ID == imageObjectID })
+		if index < 0 {
+			return ErrImageNotFound
+		}
+		fileID, ok := stored.Images[index].fileID(size)
+		data := tx.Bucket(filesBucket).Get(fileID[:])
+		if !ok || data == nil {
+			return ErrImageNotFound
+		}
+		if err := bson.Unmarshal(data, file); err != nil {
+			return err
+		}
+		// The data of the file is only valid during the transaction
+		file.Data = bytes.Clone(file.Data)
+		return nil
+	})
+	if err != nil {
+		l.WithError(err).Error("Error when trying to find the image")
+		return nil, wrapError(err)
+	}
+	return file, nil
+}
@@ -0,0 +1,104 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	bolt "go.etcd.io/bbolt"
+)
+
+// Open a store in a file of its own, closed at the end of the test
+func newTestBoltStore(t *testing.T) *BoltStore {
+	store, err := OpenBoltStore(filepath.Join(t.TempDir(), "recipes.db"), time.Second)
+	if err != nil {
+		t.Fatalf("Error when trying to open the store: %v", err)
+	}
+	t.Cleanup(func() { store.Close() })
+	return store
+}
+
+func TestBoltStore(t *testing.T) {
+	testRecipeStore(t, func(t *testing.T) RecipeStore {
+		return newTestBoltStore(t)
+	})
+
+	ctx := context.Background()
+
+	t.Run("The indexes follow the updates", func(t *testing.T) {
+		l := logrus.WithField("test", t.Name())
+		store := newTestBoltStore(t)
+		recipe := newTestRecipe(store, "Crepes", "arsene", "flour", "egg")
+		store.SaveRecipe(ctx, l, &recipe)
+		other := newTestRecipe(store, "Flan", "arsene2", "flo")
+		store.SaveRecipe(ctx, l, &other)
+
+		recipe.Author = "marius"
+		recipe.Ingredients = recipe.Ingredients[:1]
+		if err := store.UpsertOne(ctx, l, &recipe); err != nil {
+			t.Fatalf("Error when trying to update recipe: %v", err)
+		}
+		for _, find := range []struct {
+			name     string
+			find     func() (*RecipePage, error)
+			expected int64
+		}{
+			{"previous author", func() (*RecipePage, error) { return store.FindRecipesByAuthorID(ctx, l, "arsene", ListOptions{}) }, 0},
+			{"new author", func() (*RecipePage, error) { return store.FindRecipesByAuthorID(ctx, l, "marius", ListOptions{}) }, 1},
+			{"kept ingredient", func() (*RecipePage, error) { return store.FindRecipesByIngredientID(ctx, l, "flour", ListOptions{}) }, 1},
+			{"removed ingredient", func() (*RecipePage, error) { return store.FindRecipesByIngredientID(ctx, l, "egg", ListOptions{}) }, 0},
+			// A value prefixing another one only finds its own recipes
+			{"prefix of an ingredient", func() (*RecipePage, error) { return store.FindRecipesByIngredientID(ctx, l, "flo", ListOptions{}) }, 1},
+		} {
+			page, err := find.find()
+			if err != nil {
+				t.Fatalf("Error when trying to find by %v: %v", find.name, err)
+			}
+			if page.Total != find.expected {
+				t.Errorf("Expected %v recipes by %v, got %v", find.expected, find.name, page.Total)
+			}
+		}
+
+		// The purge removes the index entries
+		store.DeleteRecipeByID(ctx, l, recipe.ID.Hex(), AnyVersion)
+		store.PurgeDeletedRecipes(ctx, l, time.Now().Add(time.Second))
+		store.db.View(func(tx *bolt.Tx) error {
+			if entries := tx.Bucket(ingredientIndex).Stats().KeyN; entries != 1 {
+				t.Errorf("Expected the ingredient entry of the other recipe only, got %v", entries)
+			}
+			return nil
+		})
+	})
+
+	t.Run("The recipes persist in the file", func(t *testing.T) {
+		l := logrus.WithField("test", t.Name())
+		path := filepath.Join(t.TempDir(), "recipes.db")
+		store, err := OpenBoltStore(path, time.Second)
+		if err != nil {
+			t.Fatalf("Error when trying to open the store: %v", err)
+		}
+		recipe := newTestRecipe(store, "Crepes", "arsene", "flour")
+		store.SaveRecipe(ctx, l, &recipe)
+		store.Close()
+		if err := store.Ping(ctx); err == nil {
+			t.Errorf("Expected a closed store to fail the ping")
+		}
+
+		store, err = OpenBoltStore(path, time.Second)
+		if err != nil {
+			t.Fatalf("Error when trying to reopen the store: %v", err)
+		}
+		defer store.Close()
+		found, err := store.FindRecipeByID(ctx, l, recipe.ID.Hex())
+		if err != nil || found.Name != "Crepes" {
+			t.Errorf("Expected the recipe to be found after reopening, got %v (%v)", found, err)
+		}
+		// The file is locked by the open store
+		if _, err := OpenBoltStore(path, 10*time.Millisecond); !errors.Is(err, bolt.ErrTimeout) {
+			t.Errorf("Expected the lock of the file to time out, got %v", err)
+		}
+	})
+}
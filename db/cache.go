@@ -0,0 +1,205 @@
+package db
+
+import (
+	"context"
+	"encoding/json"
+	"recipes/cache"
+	"sync/atomic"
+
+	"github.com/sirupsen/logrus"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/metric"
+)
+
+var meter = otel.Meter("recipes/db")
+
+// Lookups counted by the cache metrics
+const (
+	recipeLookup = "recipe"
+	listLookup   = "list"
+)
+
+// CacheStats count the lookups of a CachedStore answered by its cache, or not
+type CacheStats struct {
+	Hits     int64   `json:"hits"`
+	Misses   int64   `json:"misses"`
+	HitRatio float64 `json:"hit_ratio"`
+}
+
+// CachedStore is a read-through cache of the recipes by ID and of the listings in front of a RecipeStore.
+// Every write invalidates the recipe it changes and the listings of its tenant, the other calls go to the store.
+// The purge of the trash needs no invalidation, the trashed recipes are neither found by ID nor listed.
+//
+// The listings are cached under the generation of their tenant, a write moves the tenant to a new generation
+// so the listings computed before it are never read again. A lookup racing with a write may still cache
+// the recipe it read before the write, until the TTL of the cache.
+type CachedStore struct {
+	RecipeStore
+	cache  cache.Cache
+	hits   atomic.Int64
+	misses atomic.Int64
+	// The same counts exported as metrics, by lookup
+	hitCounter  metric.Int64Counter
+	missCounter metric.Int64Counter
+}
+
+var _ RecipeStore = (*CachedStore)(nil)
+
+func NewCachedStore(store RecipeStore, c cache.Cache) *CachedStore {
+	hitCounter, _ := meter.Int64Counter("recipes.cache.hits", metric.WithDescription("Recipe lookups answered by the cache"))
+	missCounter, _ := meter.Int64Counter("recipes.cache.misses", metric.WithDescription("Recipe lookups sent to the store"))
+	return &CachedStore{RecipeStore: store, cache: c, hitCounter: hitCounter, missCounter: missCounter}
+}
+
+// Stats returns the hits and misses of the lookups since the start
+func (cs *CachedStore) Stats() CacheStats {
+	stats := CacheStats{Hits: cs.hits.Load(), Misses: cs.misses.Load()}
+	if total := stats.Hits + stats.Misses; total > 0 {
+		stats.HitRatio = float64(stats.Hits) / float64(total)
+	}
+	return stats
+}
+
+func (cs *CachedStore) count(ctx context.Context, lookup string, hit bool) {
+	option := metric.WithAttributes(attribute.String("lookup", lookup))
+	if hit {
+		cs.hits.Add(1)
+		cs.hitCounter.Add(ctx, 1, option)
+	} else {
+		cs.misses.Add(1)
+		cs.missCounter.Add(ctx, 1, option)
+	}
+}
+
+// The key of a recipe, the IDs are canonicalized so a lookup in uppercase is invalidated with the others
+func recipeKey(ctx context.Context, id string) string {
+	if objectID, err := primitive.ObjectIDFromHex(id); err == nil {
+		id = objectID.Hex()
+	}
+	return "recipe:" + TenantFrom(ctx) + ":" + id
+}
+
+func generationKey(ctx context.Context) string {
+	return "generation:" + TenantFrom(ctx)
+}
+
+// The current generation of the listings of the tenant, a new one is started if the cache lost it
+func (cs *CachedStore) generation(ctx context.Context) string {
+	if generation, ok := cs.cache.Get(ctx, generationKey(ctx)); ok {
+		return string(generation)
+	}
+	generation := primitive.NewObjectID().Hex()
+	cs.cache.Set(ctx, generationKey(ctx), []byte(generation))
+	return generation
+}
+
+// Forget the recipes and start a new generation of the listings of the tenant
+func (cs *CachedStore) invalidate(ctx context.Context, ids ...string) {
+	keys := []string{generationKey(ctx)}
+	for _, id := range ids {
+		keys = append(keys, recipeKey(ctx, id))
+	}
+	cs.cache.Delete(ctx, keys...)
+}
+
+// Decode the cached value of the key into value, or load and cache it.
+// The values are encoded in BSON, like in the store, so they keep the fields the JSON leaves out.
+func (cs *CachedStore) readThrough(ctx context.Context, l *logrus.Entry, lookup string, key string, value any, load func() error) error {
+	if data, ok := cs.cache.Get(ctx, key); ok {
+		err := bson.Unmarshal(data, value)
+		if err == nil {
+			cs.count(ctx, lookup, true)
+			return nil
+		}
+		l.WithError(err).Warn("Error when trying to decode the cached value")
+	}
+	cs.count(ctx, lookup, false)
+	if err := load(); err != nil {
+		return err
+	}
+	data, err := bson.Marshal(value)
+	if err != nil {
+		l.WithError(err).Warn("Error when trying to encode the value to cache")
+		return nil
+	}
+	cs.cache.Set(ctx, key, data)
+	return nil
+}
+
+func (cs *CachedStore) FindRecipeByID(ctx context.Context, l *logrus.Entry, id string) (*Recipe, error) {
+	recipe := new(Recipe)
+	err := cs.readThrough(ctx, l, recipeLookup, recipeKey(ctx, id), recipe, func() error {
+		found, err := cs.RecipeStore.FindRecipeByID(ctx, l, id)
+		if err == nil {
+			*recipe = *found
+		}
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return recipe, nil
+}
+
+func (cs *CachedStore) FindAllRecipes(ctx context.Context, l *logrus.Entry, opts ListOptions) (*RecipePage, error) {
+	encoded, err := json.Marshal(opts)
+	if err != nil {
+		return cs.RecipeStore.FindAllRecipes(ctx, l, opts)
+	}
+	page := new(RecipePage)
+	key := "recipes:" + TenantFrom(ctx) + ":" + cs.generation(ctx) + ":" + string(encoded)
+	err = cs.readThrough(ctx, l, listLookup, key, page, func() error {
+		found, err := cs.RecipeStore.FindAllRecipes(ctx, l, opts)
+		if err == nil {
+			*page = *found
+		}
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return page, nil
+}
+
+func (cs *CachedStore) SaveRecipe(ctx context.Context, l *logrus.Entry, recipe *Recipe) error {
+	err := cs.RecipeStore.SaveRecipe(ctx, l, recipe)
+	cs.invalidate(ctx, recipe.ID.Hex())
+	return err
+}
+
+func (cs *CachedStore) UpsertOne(ctx context.Context, l *logrus.Entry, recipe *Recipe) error {
+	err := cs.RecipeStore.UpsertOne(ctx, l, recipe)
+	cs.invalidate(ctx, recipe.ID.Hex())
+	return err
+}
+
+func (cs *CachedStore) DeleteRecipeByID(ctx context.Context, l *logrus.Entry, id string, version int64) error {
+	err := cs.RecipeStore.DeleteRecipeByID(ctx, l, id, version)
+	cs.invalidate(ctx, id)
+	return err
+}
+
+func (cs *CachedStore) RestoreRecipeByID(ctx context.Context, l *logrus.Entry, id string) (*Recipe, error) {
+	recipe, err := cs.RecipeStore.RestoreRecipeByID(ctx, l, id)
+	cs.invalidate(ctx, id)
+	return recipe, err
+}
+
+func (cs *CachedStore) ImportRecipes(ctx context.Context, l *logrus.Entry, recipes []Recipe, opts ImportOptions) ([]ImportOutcome, error) {
+	outcomes, err := cs.RecipeStore.ImportRecipes(ctx, l, recipes, opts)
+	ids := make([]string, len(recipes))
+	for i := range recipes {
+		ids[i] = recipes[i].ID.Hex()
+	}
+	cs.invalidate(ctx, ids...)
+	return outcomes, err
+}
+
+func (cs *CachedStore) AddRecipeImage(ctx context.Context, l *logrus.Entry, recipeID string, upload *ImageUpload, cover bool) (*Image, error) {
+	image, err := cs.RecipeStore.AddRecipeImage(ctx, l, recipeID, upload, cover)
+	cs.invalidate(ctx, recipeID)
+	return image, err
+}
@@ -0,0 +1,95 @@
+package db
+
+import (
+	"context"
+	"recipes/cache"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+func TestCachedStore(t *testing.T) {
+	testRecipeStore(t, func(t *testing.T) RecipeStore {
+		return NewCachedStore(NewMemoryStore(), cache.NewLRU(100, time.Minute))
+	})
+
+	ctx := context.Background()
+	l := logrus.WithField("test", t.Name())
+
+	t.Run("Lookups are answered by the cache until a write", func(t *testing.T) {
+		store := NewCachedStore(NewMemoryStore(), cache.NewLRU(100, time.Minute))
+		recipe := newTestRecipe(store, "Crepes", "arsene", "flour")
+		if err := store.SaveRecipe(ctx, l, &recipe); err != nil {
+			t.Fatalf("Error when trying to save recipe: %v", err)
+		}
+		id := recipe.ID.Hex()
+		for i := 0; i < 3; i++ {
+			found, err := store.FindRecipeByID(ctx, l, id)
+			if err != nil || found.Name != "Crepes" || found.TotalTime != recipe.TotalTime {
+				t.Fatalf("Expected the recipe, got %+v (%v)", found, err)
+			}
+			found.Name = "Altered by the caller"
+			if page, err := store.FindAllRecipes(ctx, l, ListOptions{Limit: 10}); err != nil || page.Total != 1 {
+				t.Fatalf("Expected a page of 1 recipe, got %+v (%v)", page, err)
+			}
+		}
+		if stats := store.Stats(); stats.Hits != 4 || stats.Misses != 2 || stats.HitRatio != 4.0/6 {
+			t.Errorf("Expected 4 hits and 2 misses, got %+v", stats)
+		}
+
+		recipe.Name, recipe.Version = "Crepes Suzette", AnyVersion
+		if err := store.UpsertOne(ctx, l, &recipe); err != nil {
+			t.Fatalf("Error when trying to update recipe: %v", err)
+		}
+		if found, _ := store.FindRecipeByID(ctx, l, strings.ToUpper(id)); found.Name != "Crepes Suzette" {
+			t.Errorf("Expected the updated recipe, got %v", found.Name)
+		}
+		if page, _ := store.FindAllRecipes(ctx, l, ListOptions{Limit: 10}); page.Recipes[0].Name != "Crepes Suzette" {
+			t.Errorf("Expected the updated listing, got %v", page.Recipes[0].Name)
+		}
+		if err := store.DeleteRecipeByID(ctx, l, id, AnyVersion); err != nil {
+			t.Fatalf("Error when trying to delete recipe: %v", err)
+		}
+		if _, err := store.FindRecipeByID(ctx, l, id); err == nil {
+			t.Errorf("Expected the deleted recipe not found")
+		}
+		if page, _ := store.FindAllRecipes(ctx, l, ListOptions{Limit: 10}); page.Total != 0 || page.Recipes == nil {
+			t.Errorf("Expected an empty listing, got %+v", page)
+		}
+	})
+
+	t.Run("The tenants never share the cached recipes", func(t *testing.T) {
+		store := NewCachedStore(NewMemoryStore(), cache.NewLRU(100, time.Minute))
+		bistro := WithTenant(ctx, "bistro")
+		recipe := newTestRecipe(store, "Crepes", "arsene", "flour")
+		store.SaveRecipe(bistro, l, &recipe)
+		store.FindRecipeByID(bistro, l, recipe.ID.Hex())
+		store.FindAllRecipes(bistro, l, ListOptions{})
+		if _, err := store.FindRecipeByID(WithTenant(ctx, "brasserie"), l, recipe.ID.Hex()); err == nil {
+			t.Errorf("Expected the recipe of another tenant not found")
+		}
+		if page, _ := store.FindAllRecipes(WithTenant(ctx, "brasserie"), l, ListOptions{}); page.Total != 0 {
+			t.Errorf("Expected an empty listing for another tenant, got %v", page.Total)
+		}
+	})
+
+	t.Run("Entries expire after the TTL", func(t *testing.T) {
+		memory := NewMemoryStore()
+		store := NewCachedStore(memory, cache.NewLRU(100, 20*time.Millisecond))
+		recipe := newTestRecipe(store, "Crepes", "arsene", "flour")
+		store.SaveRecipe(ctx, l, &recipe)
+		store.FindRecipeByID(ctx, l, recipe.ID.Hex())
+		// A write behind the cache is only seen once the entry expired
+		recipe.Name, recipe.Version = "Crepes Suzette", AnyVersion
+		memory.UpsertOne(ctx, l, &recipe)
+		if found, _ := store.FindRecipeByID(ctx, l, recipe.ID.Hex()); found.Name != "Crepes" {
+			t.Errorf("Expected the cached recipe, got %v", found.Name)
+		}
+		time.Sleep(30 * time.Millisecond)
+		if found, _ := store.FindRecipeByID(ctx, l, recipe.ID.Hex()); found.Name != "Crepes Suzette" {
+			t.Errorf("Expected the recipe read again after the TTL, got %v", found.Name)
+		}
+	})
+}
@@ -0,0 +1,183 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync/atomic"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"go.mongodb.org/mongo-driver/event"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+var (
+	// ErrUnavailable is returned when no MongoDB server could be reached
+	ErrUnavailable = errors.New("database unavailable")
+	// ErrNotReady is returned until a handler started in grace mode reached the database and prepared it
+	ErrNotReady = fmt.Errorf("%w: not connected yet", ErrUnavailable)
+	// ErrNoTransactions is returned by Connect when the server is not a replica set and AllowNoTransactions is not set
+	ErrNoTransactions = errors.New("MongoDB does not support transactions, run it as a replica set")
+)
+
+// ConnectOptions tell how Connect reaches the database and prepares it
+type ConnectOptions struct {
+	Timeouts Timeouts
+	Tenancy  TenancyMode
+	// Retries bounds the pings made after the first failed one
+	Retries int
+	// Backoff is the delay before the first retry, doubled at every retry up to MaxBackoff
+	Backoff    time.Duration
+	MaxBackoff time.Duration
+	// Grace returns the handler at once and prepares it in the background, retrying until the context is done.
+	// The handler fails with ErrNotReady until then.
+	Grace bool
+	// Setup runs once the database is reached, before the handler is ready (e.g. the migrations)
+	Setup func(ctx context.Context, dbh *DbHandler) error
+	// AllowNoTransactions accepts a server without transactions, the recipes are then written
+	// one after the other with their revision and event and a failure can leave them apart
+	AllowNoTransactions bool
+}
+
+// Delay before the retry following the given attempt, starting at 0
+func (opts *ConnectOptions) delay(attempt int) time.Duration {
+	delay := opts.Backoff
+	for i := 0; i < attempt && (opts.MaxBackoff <= 0 || delay < opts.MaxBackoff); i++ {
+		delay *= 2
+	}
+	if opts.MaxBackoff > 0 && delay > opts.MaxBackoff {
+		delay = opts.MaxBackoff
+	}
+	return delay
+}
+
+// Connect returns the handler of the database once it answered a ping, its indexes created and the setup done.
+// The ping is retried with an exponential backoff. In grace mode the handler is returned at once, see ConnectOptions.Grace.
+func Connect(ctx context.Context, dbUri string, dbName string, recipesCollectionName string, opts ConnectOptions) (*DbHandler, error) {
+	clientOptions := options.Client().ApplyURI(dbUri).SetServerMonitor(newConnectionMonitor())
+	if opts.Timeouts.Connect > 0 {
+		// The operations fail with ErrUnavailable instead of waiting for a server until their own timeout
+		clientOptions.SetServerSelectionTimeout(opts.Timeouts.Connect)
+	}
+	// The client connects in the background, only an invalid URI fails here
+	client, err := mongo.Connect(ctx, clientOptions)
+	if err != nil {
+		loger.WithError(err).Error("Failed to create the MongoDB client")
+		return nil, err
+	}
+	handler := NewDbHandler(client, dbName, recipesCollectionName)
+	handler.Timeouts = opts.Timeouts
+	if opts.Tenancy != "" {
+		handler.Tenancy = opts.Tenancy
+	}
+	handler.ready.Store(false)
+
+	if opts.Grace {
+		go func() {
+			for attempt := 0; ; attempt++ {
+				err := handler.prepare(ctx, &opts)
+				if err == nil || ctx.Err() != nil {
+					return
+				}
+				delay := opts.delay(attempt)
+				loger.WithError(err).WithField("retryIn", delay).Error("Failed to prepare MongoDB, the API stays not ready")
+				select {
+				case <-ctx.Done():
+					return
+				case <-time.After(delay):
+				}
+			}
+		}()
+		return handler, nil
+	}
+	if err := handler.prepare(ctx, &opts); err != nil {
+		client.Disconnect(context.WithoutCancel(ctx))
+		return nil, err
+	}
+	return handler, nil
+}
+
+// Wait for the database, detect the transactions, create the indexes and run the setup, then mark the handler ready
+func (dbh *DbHandler) prepare(ctx context.Context, opts *ConnectOptions) error {
+	if err := dbh.waitReachable(ctx, opts); err != nil {
+		return err
+	}
+	loger.Info("Connected to MongoDB!")
+	dbh.Transactions = supportsTransactions(ctx, dbh.Client)
+	if !dbh.Transactions {
+		if !opts.AllowNoTransactions {
+			loger.WithError(ErrNoTransactions).Error("Failed to prepare MongoDB, set DB_ALLOW_NO_TRANSACTIONS to run without transactions")
+			return ErrNoTransactions
+		}
+		loger.Warn("MongoDB is not a replica set, the recipes are written without transaction along their revision and event")
+	}
+	if err := dbh.EnsureIndexes(ctx); err != nil {
+		loger.WithError(err).Error("Failed to create the indexes")
+		return wrapError(err)
+	}
+	if opts.Setup != nil {
+		if err := opts.Setup(ctx, dbh); err != nil {
+			loger.WithError(err).Error("Failed to set up the database")
+			return err
+		}
+	}
+	dbh.ready.Store(true)
+	return nil
+}
+
+// Ping the database until it answers, at most 1+opts.Retries times out of grace mode
+func (dbh *DbHandler) waitReachable(ctx context.Context, opts *ConnectOptions) error {
+	for attempt := 0; ; attempt++ {
+		pingCtx, cancel := ctx, context.CancelFunc(func() {})
+		if opts.Timeouts.Connect > 0 {
+			pingCtx, cancel = context.WithTimeout(ctx, opts.Timeouts.Connect)
+		}
+		err := dbh.Client.Ping(pingCtx, nil)
+		cancel()
+		if err == nil {
+			return nil
+		}
+		if ctx.Err() != nil {
+			return wrapError(ctx.Err())
+		}
+		if !opts.Grace && attempt >= opts.Retries {
+			loger.WithError(err).WithField("attempts", attempt+1).Error("Failed to reach MongoDB")
+			return fmt.Errorf("%w: %w", ErrUnavailable, err)
+		}
+		delay := opts.delay(attempt)
+		loger.WithError(err).WithFields(logrus.Fields{"attempt": attempt + 1, "retryIn": delay}).Warn("MongoDB is not reachable yet")
+		select {
+		case <-ctx.Done():
+			return wrapError(ctx.Err())
+		case <-time.After(delay):
+		}
+	}
+}
+
+// Fail fast while the handler is not ready
+func (dbh *DbHandler) checkReady() error {
+	if !dbh.ready.Load() {
+		return ErrNotReady
+	}
+	return nil
+}
+
+// Log when the server stops answering the heartbeats of the driver and when it answers again, once per change.
+// The driver reconnects by itself, meanwhile the operations fail with ErrUnavailable.
+func newConnectionMonitor() *event.ServerMonitor {
+	var lost atomic.Bool
+	return &event.ServerMonitor{
+		ServerHeartbeatFailed: func(e *event.ServerHeartbeatFailedEvent) {
+			if !lost.Swap(true) {
+				loger.WithError(e.Failure).WithField("server", e.ConnectionID).Error("Lost the connection to MongoDB")
+			}
+		},
+		ServerHeartbeatSucceeded: func(e *event.ServerHeartbeatSucceededEvent) {
+			if lost.Swap(false) {
+				loger.WithField("server", e.ConnectionID).Info("Reconnected to MongoDB")
+			}
+		},
+	}
+}
@@ -0,0 +1,142 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// startUnreachableServer accepts the connections and closes them at once, like a MongoDB restarting
+func startUnreachableServer(t *testing.T) string {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Error when trying to listen: %v", err)
+	}
+	t.Cleanup(func() { listener.Close() })
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			conn.Close()
+		}
+	}()
+	return "mongodb://" + listener.Addr().String() + "/?directConnection=true"
+}
+
+func TestConnectOptionsDelay(t *testing.T) {
+	for _, test := range []struct {
+		name     string
+		opts     ConnectOptions
+		expected []time.Duration
+	}{
+		{"Doubled at every retry", ConnectOptions{Backoff: 100 * time.Millisecond},
+			[]time.Duration{100 * time.Millisecond, 200 * time.Millisecond, 400 * time.Millisecond, 800 * time.Millisecond}},
+		{"Bounded by the max backoff", ConnectOptions{Backoff: time.Second, MaxBackoff: 3 * time.Second},
+			[]time.Duration{time.Second, 2 * time.Second, 3 * time.Second, 3 * time.Second}},
+		{"No backoff retries at once", ConnectOptions{MaxBackoff: time.Second},
+			[]time.Duration{0, 0, 0, 0}},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			for attempt, expected := range test.expected {
+				if delay := test.opts.delay(attempt); delay != expected {
+					t.Errorf("Expected %v before the retry %v, got %v", expected, attempt+1, delay)
+				}
+			}
+		})
+	}
+}
+
+func TestConnectUnreachable(t *testing.T) {
+	l := logrus.WithField("test", t.Name())
+	timeouts := Timeouts{Connect: 100 * time.Millisecond, Read: time.Second, Write: time.Second}
+
+	t.Run("Connect retries with backoff then fails", func(t *testing.T) {
+		uri := startUnreachableServer(t)
+		setup := false
+		start := time.Now()
+		_, err := Connect(context.Background(), uri, "recipes", "recipe", ConnectOptions{
+			Timeouts: timeouts,
+			Retries:  2,
+			Backoff:  50 * time.Millisecond,
+			Setup:    func(context.Context, *DbHandler) error { setup = true; return nil },
+		})
+		if !errors.Is(err, ErrUnavailable) {
+			t.Fatalf("Expected ErrUnavailable, got %v", err)
+		}
+		// 3 pings of 100ms and 2 delays of 50ms and 100ms
+		if elapsed := time.Since(start); elapsed < 450*time.Millisecond {
+			t.Errorf("Expected the pings retried with backoff, gave up after %v", elapsed)
+		}
+		if setup {
+			t.Errorf("Expected the setup skipped")
+		}
+	})
+
+	t.Run("Connect gives up when the context is done", func(t *testing.T) {
+		uri := startUnreachableServer(t)
+		ctx, cancel := context.WithTimeout(context.Background(), 150*time.Millisecond)
+		defer cancel()
+		start := time.Now()
+		_, err := Connect(ctx, uri, "recipes", "recipe", ConnectOptions{Timeouts: timeouts, Retries: 100, Backoff: time.Second})
+		if err == nil || time.Since(start) > time.Second {
+			t.Errorf("Expected to give up with the context, got %v after %v", err, time.Since(start))
+		}
+	})
+
+	t.Run("A handler in grace mode starts not ready", func(t *testing.T) {
+		uri := startUnreachableServer(t)
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		start := time.Now()
+		dbh, err := Connect(ctx, uri, "recipes", "recipe", ConnectOptions{Timeouts: timeouts, Backoff: 10 * time.Millisecond, Grace: true})
+		if err != nil || time.Since(start) > 50*time.Millisecond {
+			t.Fatalf("Expected the handler at once, got %v after %v", err, time.Since(start))
+		}
+		if err := dbh.Ping(ctx); !errors.Is(err, ErrNotReady) || !errors.Is(err, ErrUnavailable) {
+			t.Errorf("Expected ErrNotReady, got %v", err)
+		}
+		if _, err := dbh.FindAllRecipes(ctx, l, ListOptions{}); !errors.Is(err, ErrNotReady) {
+			t.Errorf("Expected the operations to fail fast with ErrNotReady, got %v", err)
+		}
+		if _, err := dbh.PendingEvents(ctx, l, 10); !errors.Is(err, ErrNotReady) {
+			t.Errorf("Expected ErrNotReady for the outbox, got %v", err)
+		}
+		// The pings go on in the background beyond the retries, the handler stays not ready
+		time.Sleep(300 * time.Millisecond)
+		if err := dbh.Ping(ctx); !errors.Is(err, ErrNotReady) {
+			t.Errorf("Expected ErrNotReady while the server is unreachable, got %v", err)
+		}
+	})
+
+	t.Run("Operations fail with ErrUnavailable once the server is gone", func(t *testing.T) {
+		uri := startUnreachableServer(t)
+		client, err := mongo.Connect(context.Background(), options.Client().ApplyURI(uri).SetServerSelectionTimeout(100*time.Millisecond))
+		if err != nil {
+			t.Fatalf("Error when trying to create the client: %v", err)
+		}
+		defer client.Disconnect(context.Background())
+		dbh := NewDbHandler(client, "recipes", "recipe")
+		dbh.Timeouts = timeouts
+		if _, err := dbh.FindRecipeByID(context.Background(), l, NewMemoryStore().NewID().Hex()); !errors.Is(err, ErrUnavailable) {
+			t.Errorf("Expected ErrUnavailable, got %v", err)
+		}
+		if err := dbh.Ping(context.Background()); !errors.Is(err, ErrUnavailable) {
+			t.Errorf("Expected the ping to fail with ErrUnavailable, got %v", err)
+		}
+		// A deadline of the caller stays a timeout
+		expired, cancel := context.WithDeadline(context.Background(), time.Now().Add(-time.Second))
+		defer cancel()
+		if _, err := dbh.FindRecipeByID(expired, l, NewMemoryStore().NewID().Hex()); !errors.Is(err, ErrTimeout) {
+			t.Errorf("Expected ErrTimeout, got %v", err)
+		}
+	})
+}
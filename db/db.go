@@ -2,16 +2,41 @@ package db
 
 import (
 	"context"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"go.mongodb.org/mongo-driver/mongo"
-	"go.mongodb.org/mongo-driver/mongo/options"
 )
 
+// Timeouts bounds the duration of each kind of database operation.
+// A zero duration means the operation only stops when its context is done.
+type Timeouts struct {
+	Connect time.Duration
+	Read    time.Duration
+	Write   time.Duration
+}
+
+var DefaultTimeouts = Timeouts{
+	Connect: 3 * time.Second,
+	Read:    5 * time.Second,
+	Write:   10 * time.Second,
+}
+
 type DbHandler struct {
 	Client                *mongo.Client
 	DBName                string
 	RecipesCollectionName string
+	Timeouts              Timeouts
+	// Transactions tells if the writes, their revision and their event are written in a transaction
+	Transactions bool
+	Tenancy      TenancyMode
+	// The tenant the handler is bound to, see forTenant
+	tenant string
+	// The tenants registered by this process
+	tenants *sync.Map
+	// Set once the database is reached and prepared, shared by the bound copies of the handler
+	ready *atomic.Bool
 }
 
 func NewDbHandler(client *mongo.Client, dbName string, recipesCollectionName string) *DbHandler {
@@ -19,27 +44,25 @@ func NewDbHandler(client *mongo.Client, dbName string, recipesCollectionName str
 		Client:                client,
 		DBName:                dbName,
 		RecipesCollectionName: recipesCollectionName,
+		Timeouts:              DefaultTimeouts,
+		Tenancy:               SingleTenant,
+		tenants:               &sync.Map{},
+		ready:                 &atomic.Bool{},
 	}
+	handler.ready.Store(true)
 	return &handler
 }
 
-func New(dbUri string, dbName string, recipesCollectionName string) (*DbHandler, error) {
-
-	// Database connexion
-
-	loger.Info("Connecting to MongoDB..." + dbUri)
-	client, err := mongo.Connect(context.TODO(), options.Client().
-		ApplyURI(dbUri))
-	if err != nil {
-		panic(err)
-	}
+// New connects to the database without retry, see Connect
+func New(dbUri string, dbName string, recipesCollectionName string, timeouts Timeouts) (*DbHandler, error) {
+	return Connect(context.Background(), dbUri, dbName, recipesCollectionName, ConnectOptions{Timeouts: timeouts})
+}
 
-	ctx, cancel := context.WithTimeout(context.Background(), time.Second*3)
-	defer cancel()
-	err = client.Ping(ctx, nil)
-	if err != nil {
-		panic(err)
+func (dbh *DbHandler) Ping(ctx context.Context) error {
+	if err := dbh.checkReady(); err != nil {
+		return err
 	}
-	loger.Info("Connected to MongoDB!")
-	return NewDbHandler(client, dbName, recipesCollectionName), nil
+	ctx, end := startOperation(ctx, "Ping", dbh.Timeouts.Connect)
+	defer end()
+	return wrapError(dbh.Client.Ping(ctx, nil))
 }
@@ -34,6 +34,10 @@ func setupTest(tb testing.TB) (*DbHandler, func(tb testing.TB)) {
 	// 	log.Println("teardown test")
 	// }
 
+	if !DockerAvailable() {
+		tb.Skip("Docker is not available, use the MemoryStore tests instead")
+	}
+
 	// Get a random port for the test, between 1024 and 65535
 	exposedPort := fmt.Sprint(rand.Intn(65525-1024) + 1024)
 	dbh, pool, resource := InitTestDocker(exposedPort)
@@ -115,7 +119,7 @@ func TestDoubleMe(t *testing.T) {
 		if err != nil {
 			t.Errorf("Error when trying to unmarshal recipe: %v", err)
 		}
-		err = dbh.SaveRecipe(l, Recipe1)
+		err = dbh.SaveRecipe(context.Background(), l, &Recipe1)
 
 		if err != nil {
 			t.Errorf("Error when trying to save recipe: %v", err)
@@ -130,7 +134,7 @@ func TestDoubleMe(t *testing.T) {
 			t.Errorf("Expected 1 recipes, got %v", nb)
 		}
 
-		r, err := dbh.FindRecipeByTitle(l, "Pate tomates basilic")
+		r, err := dbh.FindRecipeByTitle(context.Background(), l, "Pate tomates basilic")
 
 		if err != nil {
 			t.Errorf("Error when trying to find recipe by title: %v", err)
@@ -0,0 +1,169 @@
+package db
+
+import "slices"
+
+// FieldChange is the change of a recipe field between two revisions, metadata entries are named metadata.<key>.
+// From is unset for an added field and To for a removed one.
+type FieldChange struct {
+	Field string `json:"field"`
+	From  any    `json:"from,omitempty"`
+	To    any    `json:"to,omitempty"`
+}
+
+// IngredientChange is an ingredient whose amount or unit changed
+type IngredientChange struct {
+	ID   string     `json:"id"`
+	From Ingredient `json:"from"`
+	To   Ingredient `json:"to"`
+}
+
+// Kinds of step changes
+const (
+	StepAdded   = "added"
+	StepRemoved = "removed"
+	StepChanged = "changed"
+)
+
+// StepChange is a step added, removed or changed.
+// Index is the position of the step in the new revision, or in the old one for a removed step.
+type StepChange struct {
+	Op    string `json:"op"`
+	Index int    `json:"index"`
+	From  string `json:"from,omitempty"`
+	To    string `json:"to,omitempty"`
+}
+
+// RecipeDiff holds the field-level changes between two revisions of a recipe
+type RecipeDiff struct {
+	From               int64              `json:"from"`
+	To                 int64              `json:"to"`
+	Fields             []FieldChange      `json:"fields"`
+	IngredientsAdded   []Ingredient       `json:"ingredients_added"`
+	IngredientsRemoved []Ingredient       `json:"ingredients_removed"`
+	IngredientsChanged []IngredientChange `json:"ingredients_changed"`
+	Steps              []StepChange       `json:"steps"`
+}
+
+// DiffRevisions compares the snapshots of two revisions
+func DiffRevisions(from *Revision, to *Revision) *RecipeDiff {
+	diff := DiffRecipes(from.Recipe, to.Recipe)
+	diff.From, diff.To = from.Number, to.Number
+	return diff
+}
+
+// DiffRecipes compares the content of two recipes
+func DiffRecipes(from *Recipe, to *Recipe) *RecipeDiff {
+	diff := &RecipeDiff{
+		From:               from.Version,
+		To:                 to.Version,
+		Fields:             make([]FieldChange, 0),
+		IngredientsAdded:   make([]Ingredient, 0),
+		IngredientsRemoved: make([]Ingredient, 0),
+		IngredientsChanged: make([]IngredientChange, 0),
+	}
+	field := func(name string, a, b any) {
+		if a != b {
+			diff.Fields = append(diff.Fields, FieldChange{Field: name, From: a, To: b})
+		}
+	}
+	field("name", from.Name, to.Name)
+	field("author", from.Author, to.Author)
+	field("description", from.Description, to.Description)
+	field("dish", from.Dish, to.Dish)
+	field("servings", from.Servings, to.Servings)
+	if !slices.Equal(from.Timers, to.Timers) {
+		diff.Fields = append(diff.Fields, FieldChange{Field: "timers", From: from.Timers, To: to.Timers})
+	}
+
+	keys := make([]string, 0, len(from.Metadata)+len(to.Metadata))
+	for key := range from.Metadata {
+		keys = append(keys, key)
+	}
+	for key := range to.Metadata {
+		keys = append(keys, key)
+	}
+	slices.Sort(keys)
+	keys = slices.Compact(keys)
+	for _, key := range keys {
+		a, inFrom := from.Metadata[key]
+		b, inTo := to.Metadata[key]
+		switch {
+		case !inFrom:
+			diff.Fields = append(diff.Fields, FieldChange{Field: "metadata." + key, To: b})
+		case !inTo:
+			diff.Fields = append(diff.Fields, FieldChange{Field: "metadata." + key, From: a})
+		default:
+			field("metadata."+key, a, b)
+		}
+	}
+
+	diffIngredients(diff, from.Ingredients, to.Ingredients)
+	diff.Steps = diffSteps(from.Steps, to.Steps)
+	return diff
+}
+
+// Ingredients are matched by their catalog ID
+func diffIngredients(diff *RecipeDiff, from []Ingredient, to []Ingredient) {
+	find := func(ingredients []Ingredient, id string) (Ingredient, bool) {
+		i := slices.IndexFunc(ingredients, func(ingredient Ingredient) bool { return ingredient.ID == id })
+		if i < 0 {
+			return Ingredient{}, false
+		}
+		return ingredients[i], true
+	}
+	for _, a := range from {
+		b, ok := find(to, a.ID)
+		switch {
+		case !ok:
+			diff.IngredientsRemoved = append(diff.IngredientsRemoved, a)
+		case a != b:
+			diff.IngredientsChanged = append(diff.IngredientsChanged, IngredientChange{ID: a.ID, From: a, To: b})
+		}
+	}
+	for _, b := range to {
+		if _, ok := find(from, b.ID); !ok {
+			diff.IngredientsAdded = append(diff.IngredientsAdded, b)
+		}
+	}
+}
+
+// Diff the steps along their longest common subsequence.
+// A step removed where another is added is reported as changed.
+func diffSteps(from []string, to []string) []StepChange {
+	// lcs[i][j] is the length of the longest common subsequence of from[i:] and to[j:]
+	lcs := make([][]int, len(from)+1)
+	for i := range lcs {
+		lcs[i] = make([]int, len(to)+1)
+	}
+	for i := len(from) - 1; i >= 0; i-- {
+		for j := len(to) - 1; j >= 0; j-- {
+			if from[i] == to[j] {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else {
+				lcs[i][j] = max(lcs[i+1][j], lcs[i][j+1])
+			}
+		}
+	}
+
+	changes := make([]StepChange, 0)
+	i, j := 0, 0
+	for i < len(from) || j < len(to) {
+		switch {
+		case i < len(from) && j < len(to) && from[i] == to[j]:
+			i++
+			j++
+		case i < len(from) && j < len(to) && lcs[i+1][j] == lcs[i][j+1]:
+			// Dropping either step keeps the common subsequence, the step was rewritten
+			changes = append(changes, StepChange{Op: StepChanged, Index: j, From: from[i], To: to[j]})
+			i++
+			j++
+		case j == len(to) || (i < len(from) && lcs[i+1][j] >= lcs[i][j+1]):
+			changes = append(changes, StepChange{Op: StepRemoved, Index: i, From: from[i]})
+			i++
+		default:
+			changes = append(changes, StepChange{Op: StepAdded, Index: j, To: to[j]})
+			j++
+		}
+	}
+	return changes
+}
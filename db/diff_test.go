@@ -0,0 +1,92 @@
+package db
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestDiffSteps(t *testing.T) {
+	tests := []struct {
+		name     string
+		from, to []string
+		expected []StepChange
+	}{
+		{"Same steps", []string{"Cook", "Eat"}, []string{"Cook", "Eat"}, []StepChange{}},
+		{"Step added", []string{"Cook", "Eat"}, []string{"Cook", "Rest", "Eat"}, []StepChange{
+			{Op: StepAdded, Index: 1, To: "Rest"},
+		}},
+		{"Step removed", []string{"Cook", "Rest", "Eat"}, []string{"Cook", "Eat"}, []StepChange{
+			{Op: StepRemoved, Index: 1, From: "Rest"},
+		}},
+		{"Step changed", []string{"Cook", "Eat"}, []string{"Cook slowly", "Eat"}, []StepChange{
+			{Op: StepChanged, Index: 0, From: "Cook", To: "Cook slowly"},
+		}},
+		{"Steps appended", []string{}, []string{"Cook", "Eat"}, []StepChange{
+			{Op: StepAdded, Index: 0, To: "Cook"},
+			{Op: StepAdded, Index: 1, To: "Eat"},
+		}},
+		{"Last steps dropped", []string{"Cook", "Eat", "Wash"}, []string{"Cook"}, []StepChange{
+			{Op: StepRemoved, Index: 1, From: "Eat"},
+			{Op: StepRemoved, Index: 2, From: "Wash"},
+		}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if changes := diffSteps(test.from, test.to); !reflect.DeepEqual(changes, test.expected) {
+				t.Errorf("Expected %+v, got %+v", test.expected, changes)
+			}
+		})
+	}
+}
+
+func TestDiffRecipes(t *testing.T) {
+	from := &Recipe{
+		Name:     "Crepes",
+		Servings: 4,
+		Metadata: map[string]string{"cook time": "30", "origin": "Bretagne"},
+		Steps:    []string{"Mix", "Cook"},
+		Ingredients: []Ingredient{
+			{ID: "flour", Amount: 250, Unit: "g"},
+			{ID: "milk", Amount: 50, Unit: "cs"},
+			{ID: "salt", Amount: 1, Unit: "tsp"},
+		},
+	}
+	to := &Recipe{
+		Name:     "Crepes au sucre",
+		Servings: 4,
+		Metadata: map[string]string{"cook time": "20", "difficulty": "easy"},
+		Steps:    []string{"Mix", "Cook", "Sprinkle sugar"},
+		Ingredients: []Ingredient{
+			{ID: "flour", Amount: 300, Unit: "g"},
+			{ID: "milk", Amount: 50, Unit: "cs"},
+			{ID: "sugar", Amount: 2, Unit: "tbsp"},
+		},
+	}
+
+	diff := DiffRecipes(from, to)
+	expectedFields := []FieldChange{
+		{Field: "name", From: "Crepes", To: "Crepes au sucre"},
+		{Field: "metadata.cook time", From: "30", To: "20"},
+		{Field: "metadata.difficulty", To: "easy"},
+		{Field: "metadata.origin", From: "Bretagne"},
+	}
+	if !reflect.DeepEqual(diff.Fields, expectedFields) {
+		t.Errorf("Expected the fields %+v, got %+v", expectedFields, diff.Fields)
+	}
+	if len(diff.IngredientsAdded) != 1 || diff.IngredientsAdded[0].ID != "sugar" {
+		t.Errorf("Expected sugar to be added, got %+v", diff.IngredientsAdded)
+	}
+	if len(diff.IngredientsRemoved) != 1 || diff.IngredientsRemoved[0].ID != "salt" {
+		t.Errorf("Expected salt to be removed, got %+v", diff.IngredientsRemoved)
+	}
+	if len(diff.IngredientsChanged) != 1 || diff.IngredientsChanged[0].To.Amount != 300 {
+		t.Errorf("Expected the flour amount to change, got %+v", diff.IngredientsChanged)
+	}
+	if expected := []StepChange{{Op: StepAdded, Index: 2, To: "Sprinkle sugar"}}; !reflect.DeepEqual(diff.Steps, expected) {
+		t.Errorf("Expected the steps %+v, got %+v", expected, diff.Steps)
+	}
+
+	if diff := DiffRecipes(from, from); len(diff.Fields)+len(diff.Steps)+len(diff.IngredientsChanged) != 0 {
+		t.Errorf("Expected no change, got %+v", diff)
+	}
+}
@@ -0,0 +1,265 @@
+package db
+
+import (
+	"bytes"
+	"cmp"
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/sirupsen/logrus"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// DuplicateThreshold is the lowest similarity of the ingredients of two recipes of the same name for them to be duplicates
+const DuplicateThreshold = 0.8
+
+// ErrDuplicateRecipe is wrapped by the DuplicateError of a recipe looking like a stored one
+var ErrDuplicateRecipe = errors.New("likely duplicate recipe")
+
+// Duplicate is a stored recipe looking like another one, Score is the similarity of their ingredients
+type Duplicate struct {
+	ID        primitive.ObjectID `json:"id" bson:"_id"`
+	Name      string             `json:"name" bson:"name"`
+	Author    string             `json:"author" bson:"author"`
+	CreatedAt time.Time          `json:"created_at" bson:"created_at,omitempty"`
+	Score     float64            `json:"score,omitempty" bson:"-"`
+	// Only read to compute the score
+	Ingredients []Ingredient `json:"-" bson:"ingredients"`
+}
+
+// DuplicateError tells the stored recipes a new recipe duplicates, the most similar first
+type DuplicateError struct {
+	Duplicates []Duplicate
+}
+
+func (e *DuplicateError) Error() string {
+	first := e.Duplicates[0]
+	return fmt.Sprintf("%v: the recipe %v %q has %.0f%% of its ingredients in common",
+		ErrDuplicateRecipe, first.ID.Hex(), first.Name, first.Score*100)
+}
+
+func (e *DuplicateError) Unwrap() error {
+	return ErrDuplicateRecipe
+}
+
+// DuplicateCluster is a group of recipes of the same normalized name linked by similar ingredients.
+// Similarity is the lowest similarity between two recipes of the cluster.
+type DuplicateCluster struct {
+	NameKey    string      `json:"name_key"`
+	Similarity float64     `json:"similarity"`
+	Recipes    []Duplicate `json:"recipes"`
+}
+
+// NormalizeName folds the case and the diacritics of a recipe name and keeps its words separated by a space,
+// so "Crêpes  au sucre!" and "crepes au Sucre" have the same key
+func NormalizeName(name string) string {
+	return strings.Join(strings.FieldsFunc(fold(name), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsNumber(r)
+	}), " ")
+}
+
+// IngredientSimilarity is the Jaccard index of the ingredient IDs of two recipes: the shared IDs over all the IDs.
+// An ingredient without catalog ID cannot be compared, it counts as one the other recipe does not have.
+func IngredientSimilarity(a, b []Ingredient) float64 {
+	ids := make(map[string]int, len(a)+len(b))
+	unknown := 0
+	for i, ingredient := range slices.Concat(a, b) {
+		switch {
+		case ingredient.ID == "":
+			unknown++
+		case i < len(a):
+			ids[ingredient.ID] |= 1
+		default:
+			ids[ingredient.ID] |= 2
+		}
+	}
+	if len(ids)+unknown == 0 {
+		return 1
+	}
+	shared := 0
+	for _, sides := range ids {
+		if sides == 3 {
+			shared++
+		}
+	}
+	return float64(shared) / float64(len(ids)+unknown)
+}
+
+// Keep the candidates similar enough to the recipe, the most similar first
+func scoreDuplicates(recipe *Recipe, candidates []Duplicate) []Duplicate {
+	duplicates := make([]Duplicate, 0)
+	for _, candidate := range candidates {
+		if candidate.ID == recipe.ID {
+			continue
+		}
+		candidate.Score = IngredientSimilarity(recipe.Ingredients, candidate.Ingredients)
+		if candidate.Score >= DuplicateThreshold {
+			duplicates = append(duplicates, candidate)
+		}
+	}
+	slices.SortStableFunc(duplicates, func(a, b Duplicate) int { return cmp.Compare(b.Score, a.Score) })
+	return duplicates
+}
+
+// Split recipes of the same name key into the clusters of recipes linked by similar ingredients.
+// The recipes are linked transitively, a recipe alone is no cluster.
+func clusterDuplicates(nameKey string, recipes []Duplicate) []DuplicateCluster {
+	parent := make([]int, len(recipes))
+	for i := range parent {
+		parent[i] = i
+	}
+	var root func(i int) int
+	root = func(i int) int {
+		if parent[i] != i {
+			parent[i] = root(parent[i])
+		}
+		return parent[i]
+	}
+	for i := range recipes {
+		for j := i + 1; j < len(recipes); j++ {
+			if IngredientSimilarity(recipes[i].Ingredients, recipes[j].Ingredients) >= DuplicateThreshold {
+				parent[root(j)] = root(i)
+			}
+		}
+	}
+	members := make(map[int][]Duplicate)
+	roots := make([]int, 0)
+	for i := range recipes {
+		r := root(i)
+		if _, ok := members[r]; !ok {
+			roots = append(roots, r)
+		}
+		members[r] = append(members[r], recipes[i])
+	}
+	clusters := make([]DuplicateCluster, 0)
+	for _, r := range roots {
+		if len(members[r]) < 2 {
+			continue
+		}
+		cluster := DuplicateCluster{NameKey: nameKey, Similarity: 1, Recipes: members[r]}
+		for i := range cluster.Recipes {
+			for j := i + 1; j < len(cluster.Recipes); j++ {
+				cluster.Similarity = min(cluster.Similarity, IngredientSimilarity(cluster.Recipes[i].Ingredients, cluster.Recipes[j].Ingredients))
+			}
+		}
+		clusters = append(clusters, cluster)
+	}
+	return clusters
+}
+
+// Order the clusters by name key, then by the ID of their first recipe
+func sortClusters(clusters []DuplicateCluster) {
+	slices.SortFunc(clusters, func(a, b DuplicateCluster) int {
+		return cmp.Or(strings.Compare(a.NameKey, b.NameKey), bytes.Compare(a.Recipes[0].ID[:], b.Recipes[0].ID[:]))
+	})
+}
+
+// Find the duplicates of the recipe among the recipes, for the stores computing them in memory
+func duplicatesIn(recipes []Recipe, recipe *Recipe) []Duplicate {
+	candidates := make([]Duplicate, 0, len(recipes))
+	for i := range recipes {
+		candidates = append(candidates, toDuplicate(&recipes[i]))
+	}
+	return scoreDuplicates(recipe, candidates)
+}
+
+// Group the recipes by name key into clusters, for the stores computing them in memory
+func clustersIn(recipes []Recipe) []DuplicateCluster {
+	groups := make(map[string][]Duplicate)
+	for i := range recipes {
+		if recipes[i].NameKey != "" {
+			groups[recipes[i].NameKey] = append(groups[recipes[i].NameKey], toDuplicate(&recipes[i]))
+		}
+	}
+	clusters := make([]DuplicateCluster, 0)
+	for nameKey, group := range groups {
+		clusters = append(clusters, clusterDuplicates(nameKey, group)...)
+	}
+	sortClusters(clusters)
+	return clusters
+}
+
+func toDuplicate(recipe *Recipe) Duplicate {
+	return Duplicate{
+		ID:          recipe.ID,
+		Name:        recipe.Name,
+		Author:      recipe.Author,
+		CreatedAt:   recipe.CreatedAt,
+		Ingredients: recipe.Ingredients,
+	}
+}
+
+func (dbh *DbHandler) FindDuplicates(ctx context.Context, l *logrus.Entry, recipe *Recipe) ([]Duplicate, error) {
+	ctx, end := startOperation(ctx, "FindDuplicates", dbh.Timeouts.Read)
+	defer end()
+	l = l.WithContext(ctx)
+	dbh, err := dbh.forTenant(ctx)
+	if err != nil {
+		l.WithError(err).Error("Error when trying to resolve the tenant")
+		return nil, wrapError(err)
+	}
+	cursor, err := dbh.GetRecipeCollection().Find(ctx, dbh.live(bson.M{"name_key": NormalizeName(recipe.Name)}),
+		options.Find().SetProjection(bson.M{"name": 1, "author": 1, "created_at": 1, "ingredients": 1}))
+	if err != nil {
+		l.WithError(err).Error("Error when trying to find duplicates")
+		return nil, wrapError(err)
+	}
+	candidates := make([]Duplicate, 0)
+	if err := cursor.All(ctx, &candidates); err != nil {
+		l.WithError(err).Error("Error when trying to decode duplicates")
+		return nil, wrapError(err)
+	}
+	return scoreDuplicates(recipe, candidates), nil
+}
+
+func (dbh *DbHandler) FindDuplicateClusters(ctx context.Context, l *logrus.Entry) ([]DuplicateCluster, error) {
+	ctx, end := startOperation(ctx, "FindDuplicateClusters", dbh.Timeouts.Read)
+	defer end()
+	l = l.WithContext(ctx)
+	dbh, err := dbh.forTenant(ctx)
+	if err != nil {
+		l.WithError(err).Error("Error when trying to resolve the tenant")
+		return nil, wrapError(err)
+	}
+	// Only the names shared by several recipes can hold duplicates
+	cursor, err := dbh.GetRecipeCollection().Aggregate(ctx, bson.A{
+		bson.M{"$match": dbh.live(bson.M{"name_key": bson.M{"$type": "string", "$ne": ""}})},
+		bson.M{"$sort": bson.M{"_id": 1}},
+		bson.M{"$group": bson.M{
+			"_id": "$name_key",
+			"recipes": bson.M{"$push": bson.M{
+				"_id":         "$_id",
+				"name":        "$name",
+				"author":      "$author",
+				"created_at":  "$created_at",
+				"ingredients": "$ingredients",
+			}},
+		}},
+		bson.M{"$match": bson.M{"recipes.1": bson.M{"$exists": true}}},
+	})
+	if err != nil {
+		l.WithError(err).Error("Error when trying to group the recipes by name")
+		return nil, wrapError(err)
+	}
+	var groups []struct {
+		NameKey string      `bson:"_id"`
+		Recipes []Duplicate `bson:"recipes"`
+	}
+	if err := cursor.All(ctx, &groups); err != nil {
+		l.WithError(err).Error("Error when trying to decode the recipes grouped by name")
+		return nil, wrapError(err)
+	}
+	clusters := make([]DuplicateCluster, 0)
+	for _, group := range groups {
+		clusters = append(clusters, clusterDuplicates(group.NameKey, group.Recipes)...)
+	}
+	sortClusters(clusters)
+	return clusters, nil
+}
@@ -0,0 +1,77 @@
+package db
+
+import (
+	"testing"
+)
+
+func TestNormalizeName(t *testing.T) {
+	for name, expected := range map[string]string{
+		"Crêpes  au sucre!":         "crepes au sucre",
+		"crepes au Sucre":           "crepes au sucre",
+		"  Pâte, tomates & basilic": "pate tomates basilic",
+		"Bœuf 2.0":                  "bœuf 2 0",
+		"!!!":                       "",
+	} {
+		if key := NormalizeName(name); key != expected {
+			t.Errorf("Expected %q for %q, got %q", expected, name, key)
+		}
+	}
+}
+
+func TestIngredientSimilarity(t *testing.T) {
+	ingredients := func(ids ...string) []Ingredient {
+		list := make([]Ingredient, len(ids))
+		for i, id := range ids {
+			list[i] = Ingredient{ID: id, Amount: 1, Unit: "g"}
+		}
+		return list
+	}
+	tests := []struct {
+		name     string
+		a, b     []Ingredient
+		expected float64
+	}{
+		{"Same ingredients in another order", ingredients("flour", "egg", "milk"), ingredients("milk", "flour", "egg"), 1},
+		{"An ingredient listed twice counts once", ingredients("flour", "egg", "egg"), ingredients("flour", "egg"), 1},
+		{"One ingredient more", ingredients("flour", "egg", "milk", "sugar"), ingredients("flour", "egg", "milk", "sugar", "salt"), 0.8},
+		{"Half of the ingredients shared", ingredients("flour", "egg"), ingredients("egg", "milk", "flour", "sugar"), 0.5},
+		{"Nothing shared", ingredients("flour"), ingredients("beef"), 0},
+		{"No ingredient", nil, nil, 1},
+		{"Ingredients without catalog ID are never shared", ingredients("", ""), ingredients(""), 0},
+		{"An ingredient without catalog ID among shared ones", ingredients("flour", "egg", ""), ingredients("flour", "egg"), 2.0 / 3},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if similarity := IngredientSimilarity(test.a, test.b); similarity != test.expected {
+				t.Errorf("Expected %v, got %v", test.expected, similarity)
+			}
+		})
+	}
+}
+
+func TestClusterDuplicates(t *testing.T) {
+	store := NewMemoryStore()
+	recipes := make([]Duplicate, 0)
+	for _, ids := range [][]string{
+		{"flour", "egg", "milk", "sugar"},
+		{"beef", "carrot", "wine"},
+		{"flour", "egg", "milk", "sugar", "salt"},
+		{"flour", "egg", "milk", "sugar", "salt", "butter"},
+		{"tomato"},
+	} {
+		recipe := newTestRecipe(store, "Crepes", "arsene", ids...)
+		recipes = append(recipes, toDuplicate(&recipe))
+	}
+	clusters := clusterDuplicates("crepes", recipes)
+	// The first and the last are only linked through the third
+	if len(clusters) != 1 || len(clusters[0].Recipes) != 3 {
+		t.Fatalf("Expected a cluster of 3 recipes, got %+v", clusters)
+	}
+	cluster := clusters[0]
+	if cluster.Recipes[0].ID != recipes[0].ID || cluster.Recipes[1].ID != recipes[2].ID || cluster.Recipes[2].ID != recipes[3].ID {
+		t.Errorf("Expected the recipes 0, 2 and 3 in order, got %+v", cluster.Recipes)
+	}
+	if expected := 4.0 / 6; cluster.NameKey != "crepes" || cluster.Similarity != expected {
+		t.Errorf("Expected the lowest similarity %v, got %+v", expected, cluster)
+	}
+}
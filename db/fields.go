@@ -0,0 +1,125 @@
+package db
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"slices"
+	"strings"
+
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// ErrInvalidFields is returned when a field set names a field the recipes do not have
+var ErrInvalidFields = errors.New("invalid fields")
+
+// FieldSet selects the fields of the recipes returned by a listing, by their JSON names.
+// Include lists the only fields to return and Exclude the fields to leave out, the ID is always returned.
+// The zero value returns every field.
+type FieldSet struct {
+	Include []string `json:",omitempty"`
+	Exclude []string `json:",omitempty"`
+}
+
+type recipeField struct {
+	stored string
+	index  int
+}
+
+// The fields of the recipes a field set can select by their JSON name
+var recipeFields = func() map[string]recipeField {
+	fields := make(map[string]recipeField)
+	recipeType := reflect.TypeOf(Recipe{})
+	for i := 0; i < recipeType.NumField(); i++ {
+		field := recipeType.Field(i)
+		name, _, _ := strings.Cut(field.Tag.Get("json"), ",")
+		stored, _, _ := strings.Cut(field.Tag.Get("bson"), ",")
+		if name != "" && name != "-" {
+			fields[name] = recipeField{stored: stored, index: i}
+		}
+	}
+	return fields
+}()
+
+// RecipeFields returns the names a field set accepts, sorted
+func RecipeFields() []string {
+	names := make([]string, 0, len(recipeFields))
+	for name := range recipeFields {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+	return names
+}
+
+// NewFieldSet checks the names of the fields, when both are given the excluded fields are removed from the included ones
+func NewFieldSet(include []string, exclude []string) (FieldSet, error) {
+	fs := FieldSet{Include: include, Exclude: exclude}
+	if err := fs.validate(); err != nil {
+		return FieldSet{}, err
+	}
+	return fs, nil
+}
+
+func (fs FieldSet) validate() error {
+	for _, name := range slices.Concat(fs.Include, fs.Exclude) {
+		if _, ok := recipeFields[name]; !ok {
+			return fmt.Errorf("%w: unknown field %v, expected some of %v", ErrInvalidFields, name, strings.Join(RecipeFields(), ", "))
+		}
+	}
+	return nil
+}
+
+// IsZero tells if the field set returns every field
+func (fs FieldSet) IsZero() bool {
+	return len(fs.Include) == 0 && len(fs.Exclude) == 0
+}
+
+// Selected tells if the field of the JSON name is returned, the names which are not recipe fields always are
+func (fs FieldSet) Selected(name string) bool {
+	if _, ok := recipeFields[name]; !ok || name == "id" {
+		return true
+	}
+	if len(fs.Include) > 0 && !slices.Contains(fs.Include, name) {
+		return false
+	}
+	return !slices.Contains(fs.Exclude, name)
+}
+
+// The mongo projection of the field set, nil for every field.
+// The stored fields in needed are read even when they are not selected, e.g. the sort field for the cursor.
+func (fs FieldSet) mongoProjection(needed ...string) bson.M {
+	if fs.IsZero() {
+		return nil
+	}
+	projection := bson.M{}
+	if len(fs.Include) > 0 {
+		for name, field := range recipeFields {
+			if fs.Selected(name) {
+				projection[field.stored] = 1
+			}
+		}
+		for _, stored := range needed {
+			projection[stored] = 1
+		}
+		return projection
+	}
+	for _, name := range fs.Exclude {
+		if stored := recipeFields[name].stored; name != "id" && !slices.Contains(needed, stored) {
+			projection[stored] = 0
+		}
+	}
+	return projection
+}
+
+// Clear the fields the field set leaves out
+func (fs FieldSet) apply(recipe *Recipe) {
+	if fs.IsZero() {
+		return
+	}
+	value := reflect.ValueOf(recipe).Elem()
+	for name, field := range recipeFields {
+		if !fs.Selected(name) {
+			value.Field(field.index).SetZero()
+		}
+	}
+}
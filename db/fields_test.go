@@ -0,0 +1,49 @@
+package db
+
+import (
+	"errors"
+	"reflect"
+	"slices"
+	"testing"
+
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+func TestFieldSet(t *testing.T) {
+	for _, test := range []struct {
+		name       string
+		include    []string
+		exclude    []string
+		left       []string
+		projection bson.M
+	}{
+		{"Every field", nil, nil, nil, nil},
+		{"Included fields", []string{"name", "created_at"}, nil,
+			[]string{"author", "description", "dish", "servings", "metadata", "timers", "steps", "ingredients", "version", "deleted_at", "images"},
+			bson.M{"_id": 1, "name": 1, "created_at": 1, "servings": 1}},
+		{"Excluded fields", nil, []string{"steps", "servings", "id"}, []string{"steps", "servings"}, bson.M{"steps": 0}},
+		{"Included fields less the excluded ones", []string{"name", "steps"}, []string{"steps"},
+			[]string{"author", "description", "dish", "servings", "metadata", "timers", "steps", "ingredients", "created_at", "version", "deleted_at", "images"},
+			bson.M{"_id": 1, "name": 1, "servings": 1}},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			fields, err := NewFieldSet(test.include, test.exclude)
+			if err != nil {
+				t.Fatalf("Error when trying to create the field set: %v", err)
+			}
+			for _, name := range append(RecipeFields(), "score") {
+				if fields.Selected(name) == slices.Contains(test.left, name) {
+					t.Errorf("Expected %v to be selected: %v", name, !slices.Contains(test.left, name))
+				}
+			}
+			// The servings are the sort field the cursor needs
+			if projection := fields.mongoProjection("servings"); !reflect.DeepEqual(projection, test.projection) {
+				t.Errorf("Expected the projection %v, got %v", test.projection, projection)
+			}
+		})
+	}
+
+	if _, err := NewFieldSet([]string{"name"}, []string{"tenant_id"}); !errors.Is(err, ErrInvalidFields) {
+		t.Errorf("Expected a stored-only field to be invalid, got %v", err)
+	}
+}
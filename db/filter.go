@@ -0,0 +1,197 @@
+package db
+
+import (
+	"cmp"
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// ErrInvalidFilter is returned when a filter cannot be compiled into a query
+var ErrInvalidFilter = errors.New("invalid filter")
+
+// RecipeFilter narrows a recipe listing, every set criterion must match.
+// The zero value matches every recipe.
+type RecipeFilter struct {
+	// Dishes matches the recipes of any of the dishes
+	Dishes      []Dish
+	ServingsMin int
+	ServingsMax int
+	// MaxTotalTime bounds the sum of the timers of the recipes
+	MaxTotalTime time.Duration
+	// WithIngredients are all required, WithoutIngredients all excluded
+	WithIngredients    []string
+	WithoutIngredients []string
+	Author             string
+	// Metadata matches the recipes having all these metadata values
+	Metadata map[string]string
+}
+
+// FacetCount is the number of recipes sharing a value
+type FacetCount struct {
+	Value string `json:"value" bson:"_id"`
+	Count int64  `json:"count" bson:"count"`
+}
+
+// Facets count the recipes matching a filter per dish and per author, most frequent first
+type Facets struct {
+	Dish   []FacetCount `json:"dish" bson:"dish"`
+	Author []FacetCount `json:"author" bson:"author"`
+}
+
+// The metadata keys become field paths of the query, a dot or a $ would reach other fields or operators
+func validMetadataKey(key string) bool {
+	return key != "" && !strings.ContainsAny(key, ".$")
+}
+
+func (f RecipeFilter) validate() error {
+	if f.ServingsMin < 0 || f.ServingsMax < 0 || f.MaxTotalTime < 0 {
+		return fmt.Errorf("%w: negative bound", ErrInvalidFilter)
+	}
+	if f.ServingsMax > 0 && f.ServingsMax < f.ServingsMin {
+		return fmt.Errorf("%w: servings_max is lower than servings_min", ErrInvalidFilter)
+	}
+	for key := range f.Metadata {
+		if !validMetadataKey(key) {
+			return fmt.Errorf("%w: metadata key %q", ErrInvalidFilter, key)
+		}
+	}
+	return nil
+}
+
+// Compile the filter into a mongo query. Every value is compared as is, none is interpreted as an operator.
+func (f RecipeFilter) mongoFilter() (bson.M, error) {
+	if err := f.validate(); err != nil {
+		return nil, err
+	}
+	filter := bson.M{}
+	if len(f.Dishes) > 0 {
+		filter["dish"] = bson.M{"$in": f.Dishes}
+	}
+	servings := bson.M{}
+	if f.ServingsMin > 0 {
+		servings["$gte"] = f.ServingsMin
+	}
+	if f.ServingsMax > 0 {
+		servings["$lte"] = f.ServingsMax
+	}
+	if len(servings) > 0 {
+		filter["servings"] = servings
+	}
+	if f.MaxTotalTime > 0 {
+		filter["total_time"] = bson.M{"$lte": int64(f.MaxTotalTime / time.Second)}
+	}
+	ingredients := bson.M{}
+	if len(f.WithIngredients) > 0 {
+		ingredients["$all"] = f.WithIngredients
+	}
+	if len(f.WithoutIngredients) > 0 {
+		ingredients["$nin"] = f.WithoutIngredients
+	}
+	if len(ingredients) > 0 {
+		filter["ingredients._id"] = ingredients
+	}
+	if f.Author != "" {
+		filter["author"] = f.Author
+	}
+	for key, value := range f.Metadata {
+		// $eq keeps a value looking like an operator a plain string
+		filter["metadata."+key] = bson.M{"$eq": value}
+	}
+	return filter, nil
+}
+
+// Tell if the recipe matches the filter, the in-memory twin of mongoFilter
+func (f RecipeFilter) match(r *Recipe) bool {
+	if len(f.Dishes) > 0 && !slices.Contains(f.Dishes, r.Dish) {
+		return false
+	}
+	if f.ServingsMin > 0 && r.Servings < f.ServingsMin || f.ServingsMax > 0 && r.Servings > f.ServingsMax {
+		return false
+	}
+	if f.MaxTotalTime > 0 && r.TotalTime > int64(f.MaxTotalTime/time.Second) {
+		return false
+	}
+	hasIngredient := func(id string) bool {
+		return slices.ContainsFunc(r.Ingredients, func(i Ingredient) bool { return i.ID == id })
+	}
+	for _, id := range f.WithIngredients {
+		if !hasIngredient(id) {
+			return false
+		}
+	}
+	for _, id := range f.WithoutIngredients {
+		if hasIngredient(id) {
+			return false
+		}
+	}
+	if f.Author != "" && r.Author != f.Author {
+		return false
+	}
+	for key, value := range f.Metadata {
+		if stored, ok := r.Metadata[key]; !ok || stored != value {
+			return false
+		}
+	}
+	return true
+}
+
+func sortFacet(counts []FacetCount) {
+	slices.SortFunc(counts, func(a, b FacetCount) int {
+		if c := cmp.Compare(b.Count, a.Count); c != 0 {
+			return c
+		}
+		return strings.Compare(a.Value, b.Value)
+	})
+}
+
+// Count the facets of the recipes in memory
+func countFacets(recipes []Recipe) *Facets {
+	count := func(value func(r *Recipe) string) []FacetCount {
+		counts := make(map[string]int64)
+		for i := range recipes {
+			counts[value(&recipes[i])]++
+		}
+		facet := make([]FacetCount, 0, len(counts))
+		for value, n := range counts {
+			facet = append(facet, FacetCount{Value: value, Count: n})
+		}
+		sortFacet(facet)
+		return facet
+	}
+	return &Facets{
+		Dish:   count(func(r *Recipe) string { return string(r.Dish) }),
+		Author: count(func(r *Recipe) string { return r.Author }),
+	}
+}
+
+// Count the facets of the recipes matching the query in one aggregation
+func (dbh *DbHandler) countFacets(ctx context.Context, query bson.M) (*Facets, error) {
+	group := func(field string) bson.A {
+		return bson.A{bson.M{"$group": bson.M{"_id": "$" + field, "count": bson.M{"$sum": 1}}}}
+	}
+	cursor, err := dbh.GetRecipeCollection().Aggregate(ctx, bson.A{
+		bson.M{"$match": query},
+		bson.M{"$facet": bson.M{"dish": group("dish"), "author": group("author")}},
+	})
+	if err != nil {
+		return nil, err
+	}
+	results := make([]Facets, 0, 1)
+	if err := cursor.All(ctx, &results); err != nil {
+		return nil, err
+	}
+	facets := &Facets{Dish: make([]FacetCount, 0), Author: make([]FacetCount, 0)}
+	if len(results) > 0 {
+		facets.Dish = append(facets.Dish, results[0].Dish...)
+		facets.Author = append(facets.Author, results[0].Author...)
+	}
+	sortFacet(facets.Dish)
+	sortFacet(facets.Author)
+	return facets, nil
+}
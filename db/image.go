@@ -0,0 +1,286 @@
+package db
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"slices"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/gridfs"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// ErrImageNotFound is returned when a recipe has no image, or no thumbnail, with the requested ID
+var ErrImageNotFound = errors.New("image not found")
+
+// OriginalSize names the uploaded image among its thumbnails
+const OriginalSize = "original"
+
+// Image is a photo of a recipe. Its original and thumbnails are stored apart from the recipe, which only references them.
+type Image struct {
+	ID          primitive.ObjectID `json:"id" bson:"_id"`
+	ContentType string             `json:"content_type" bson:"content_type"`
+	Size        int64              `json:"size" bson:"size"`
+	Width       int                `json:"width" bson:"width"`
+	Height      int                `json:"height" bson:"height"`
+	// The cover is the image shown first, a recipe with images has exactly one
+	Cover      bool        `json:"cover" bson:"cover"`
+	Thumbnails []Thumbnail `json:"thumbnails" bson:"thumbnails"`
+	UploadedAt time.Time   `json:"uploaded_at" bson:"uploaded_at"`
+}
+
+// Thumbnail is a reduced copy of an image, its name is one of the fixed thumbnail sizes
+type Thumbnail struct {
+	Name   string             `json:"name" bson:"name"`
+	FileID primitive.ObjectID `json:"-" bson:"file_id"`
+	Width  int                `json:"width" bson:"width"`
+	Height int                `json:"height" bson:"height"`
+}
+
+// ImageFile is the content of an original image or of a thumbnail
+type ImageFile struct {
+	ContentType string
+	Data        []byte
+}
+
+// ImageUpload is an image ready to be stored, with the content of its original and of its thumbnails by name
+type ImageUpload struct {
+	Image      Image
+	Original   ImageFile
+	Thumbnails map[string]ImageFile
+}
+
+// Find the file of the image in the given size, OriginalSize or the name of a thumbnail
+func (image *Image) fileID(size string) (primitive.ObjectID, bool) {
+	if size == "" || size == OriginalSize {
+		return image.ID, true
+	}
+	for _, thumbnail := range image.Thumbnails {
+		if thumbnail.Name == size {
+			return thumbnail.FileID, true
+		}
+	}
+	return primitive.NilObjectID, false
+}
+
+// The IDs of the files of the image, its original and its thumbnails
+func (image *Image) fileIDs() []primitive.ObjectID {
+	ids := []primitive.ObjectID{image.ID}
+	for _, thumbnail := range image.Thumbnails {
+		ids = append(ids, thumbnail.FileID)
+	}
+	return ids
+}
+
+// Tell if one of the images is the cover
+func hasCover(images []Image) bool {
+	return slices.ContainsFunc(images, func(image Image) bool { return image.Cover })
+}
+
+// Add the image to the images, making it the cover if asked or if there is none yet
+func addImage(images []Image, image Image, cover bool) []Image {
+	image.Cover = cover || !hasCover(images)
+	if image.Cover {
+		for i := range images {
+			images[i].Cover = false
+		}
+	}
+	return append(images, image)
+}
+
+// The GridFS bucket of the images of the tenant the handler is bound to, its deadlines are the ones of the context
+func (dbh *DbHandler) imageBucket(ctx context.Context) (*gridfs.Bucket, error) {
+	bucket, err := gridfs.NewBucket(dbh.Client.Database(dbh.DBName), options.GridFSBucket().SetName(dbh.recipesCollectionName()+"_images"))
+	if err != nil {
+		return nil, err
+	}
+	if deadline, ok := ctx.Deadline(); ok {
+		bucket.SetReadDeadline(deadline)
+		bucket.SetWriteDeadline(deadline)
+	}
+	return bucket, nil
+}
+
+// Remove the files of the images, the missing ones are ignored
+func (dbh *DbHandler) deleteImageFiles(ctx context.Context, ids []primitive.ObjectID) error {
+	bucket, err := dbh.imageBucket(ctx)
+	if err != nil {
+		return err
+	}
+	for _, id := range ids {
+		if err := bucket.DeleteContext(ctx, id); err != nil && !errors.Is(err, gridfs.ErrFileNotFound) {
+			return err
+		}
+	}
+	return nil
+}
+
+// AddRecipeImage stores the original and the thumbnails of the image in GridFS and references them on the recipe.
+// The image becomes the cover if cover is set or if the recipe has none. The version of the recipe is left unchanged.
+func (dbh *DbHandler) AddRecipeImage(ctx context.Context, l *logrus.Entry, recipeID string, upload *ImageUpload, cover bool) (*Image, error) {
+	ctx, end := startOperation(ctx, "AddRecipeImage", dbh.Timeouts.Write)
+	defer end()
+	l = l.WithContext(ctx)
+	dbh, err := dbh.forTenant(ctx)
+	if err != nil {
+		l.WithError(err).Error("Error when trying to resolve the tenant")
+		return nil, wrapError(err)
+	}
+	objectID, _ := primitive.ObjectIDFromHex(recipeID)
+	var recipe Recipe
+	err = dbh.GetRecipeCollection().FindOne(ctx, dbh.live(bson.M{"_id": objectID}),
+		options.FindOne().SetProjection(bson.M{"_id": 1})).Decode(&recipe)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		err = ErrRecipeNotFound
+	}
+	if err != nil {
+		l.WithError(err).Error("Error when trying to find the recipe of the image")
+		return nil, wrapError(err)
+	}
+
+	image := upload.Image
+	image.UploadedAt = now()
+	bucket, err := dbh.imageBucket(ctx)
+	if err == nil {
+		err = uploadImageFile(bucket, image.ID, recipeID, upload.Original)
+	}
+	for i := 0; err == nil && i < len(image.Thumbnails); i++ {
+		err = uploadImageFile(bucket, image.Thumbnails[i].FileID, recipeID, upload.Thumbnails[image.Thumbnails[i].Name])
+	}
+	if err == nil {
+		image.Cover, err = dbh.referenceImage(ctx, objectID, image, cover)
+	}
+	if err != nil {
+		l.WithError(err).Error("Error when trying to store the image")
+		// The reference may have been written before the error, e.g. a timeout, it is removed before the files
+		cleanupCtx := context.WithoutCancel(ctx)
+		cleanupErr := dbh.unreferenceImage(cleanupCtx, objectID, image.ID)
+		if cleanupErr == nil {
+			cleanupErr = dbh.deleteImageFiles(cleanupCtx, image.fileIDs())
+		}
+		if cleanupErr != nil {
+			l.WithError(cleanupErr).Warn("Error when trying to remove the files of the failed upload")
+		}
+		return nil, wrapError(err)
+	}
+	return &image, nil
+}
+
+func uploadImageFile(bucket *gridfs.Bucket, id primitive.ObjectID, recipeID string, file ImageFile) error {
+	return bucket.UploadFromStreamWithID(id, recipeID, bytes.NewReader(file.Data),
+		options.GridFSUpload().SetMetadata(bson.M{"content_type": file.ContentType}))
+}
+
+// Append the image to the recipe and move the cover to it if asked or if the recipe has none, in one update.
+// It returns whether the image became the cover.
+func (dbh *DbHandler) referenceImage(ctx context.Context, recipeID primitive.ObjectID, image Image, cover bool) (bool, error) {
+	image.Cover = false
+	isCover := any(true)
+	if !cover {
+		isCover = bson.M{"$not": bson.A{bson.M{"$anyElementTrue": bson.A{bson.M{"$map": bson.M{
+			"input": "$$images",
+			"as":    "image",
+			"in":    bson.M{"$eq": bson.A{"$$image.cover", true}},
+		}}}}}}
+	}
+	images := bson.M{"$let": bson.M{
+		"vars": bson.M{"images": bson.M{"$ifNull": bson.A{"$images", bson.A{}}}},
+		"in": bson.M{"$let": bson.M{
+			"vars": bson.M{"cover": isCover},
+			"in": bson.M{"$concatArrays": bson.A{
+				bson.M{"$map": bson.M{
+					"input": "$$images",
+					"as":    "image",
+					"in": bson.M{"$mergeObjects": bson.A{"$$image", bson.M{"cover": bson.M{"$and": bson.A{
+						bson.M{"$not": bson.A{"$$cover"}}, bson.M{"$eq": bson.A{"$$image.cover", true}},
+					}}}}},
+				}},
+				bson.A{bson.M{"$mergeObjects": bson.A{bson.M{"$literal": image}, bson.M{"cover": "$$cover"}}}},
+			}},
+		}},
+	}}
+	var recipe Recipe
+	err := dbh.GetRecipeCollection().FindOneAndUpdate(ctx, dbh.live(bson.M{"_id": recipeID}),
+		bson.A{bson.M{"$set": bson.M{"images": images}}},
+		options.FindOneAndUpdate().SetReturnDocument(options.After).
+			SetProjection(bson.M{"images": bson.M{"$elemMatch": bson.M{"_id": image.ID}}})).Decode(&recipe)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return false, ErrRecipeNotFound
+	}
+	if err != nil {
+		return false, err
+	}
+	return len(recipe.Images) == 1 && recipe.Images[0].Cover, nil
+}
+
+// Remove the image from the recipe, whatever its tenant and its trash state
+func (dbh *DbHandler) unreferenceImage(ctx context.Context, recipeID primitive.ObjectID, imageID primitive.ObjectID) error {
+	_, err := dbh.GetRecipeCollection().UpdateOne(ctx, bson.M{"_id": recipeID}, bson.M{"$pull": bson.M{"images": bson.M{"_id": imageID}}})
+	return err
+}
+
+// OpenRecipeImage returns the content of an image of the recipe in the given size, OriginalSize or a thumbnail name
+func (dbh *DbHandler) OpenRecipeImage(ctx context.Context, l *logrus.Entry, recipeID string, imageID string, size string) (*ImageFile, error) {
+	ctx, end := startOperation(ctx, "OpenRecipeImage", dbh.Timeouts.Read)
+	defer end()
+	l = l.WithContext(ctx)
+	dbh, err := dbh.forTenant(ctx)
+	if err != nil {
+		l.WithError(err).Error("Error when trying to resolve the tenant")
+		return nil, wrapError(err)
+	}
+	objectID, _ := primitive.ObjectIDFromHex(recipeID)
+	imageObjectID, _ := primitive.ObjectIDFromHex(imageID)
+	// Only the images referenced by a recipe of the tenant are reachable
+	var recipe Recipe
+	err = dbh.GetRecipeCollection().FindOne(ctx, dbh.live(bson.M{"_id": objectID}), options.FindOne().
+		SetProjection(bson.M{"images": bson.M{"$elemMatch": bson.M{"_id": imageObjectID}}})).Decode(&recipe)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		err = ErrRecipeNotFound
+	} else if err == nil && len(recipe.Images) == 0 {
+		err = ErrImageNotFound
+	}
+	if err != nil {
+		l.WithError(err).Error("Error when trying to find the image")
+		return nil, wrapError(err)
+	}
+	image := recipe.Images[0]
+	fileID, ok := image.fileID(size)
+	if !ok {
+		l.WithError(ErrImageNotFound).Error("Error when trying to find the thumbnail")
+		return nil, ErrImageNotFound
+	}
+
+	bucket, err := dbh.imageBucket(ctx)
+	if err != nil {
+		l.WithError(err).Error("Error when trying to open the image bucket")
+		return nil, wrapError(err)
+	}
+	stream, err := bucket.OpenDownloadStream(fileID)
+	if errors.Is(err, gridfs.ErrFileNotFound) {
+		err = ErrImageNotFound
+	}
+	if err != nil {
+		l.WithError(err).Error("Error when trying to open the image")
+		return nil, wrapError(err)
+	}
+	defer stream.Close()
+	data, err := io.ReadAll(stream)
+	if err != nil {
+		l.WithError(err).Error("Error when trying to read the image")
+		return nil, wrapError(err)
+	}
+	var metadata struct {
+		ContentType string `bson:"content_type"`
+	}
+	if raw := stream.GetFile().Metadata; raw != nil {
+		bson.Unmarshal(raw, &metadata)
+	}
+	return &ImageFile{ContentType: metadata.ContentType, Data: data}, nil
+}
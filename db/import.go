@@ -0,0 +1,237 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"slices"
+
+	"github.com/sirupsen/logrus"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// ImportMode tells what an import does with the recipes whose ID is already stored
+type ImportMode string
+
+const (
+	ImportSkip   ImportMode = "skip"
+	ImportUpsert ImportMode = "upsert"
+)
+
+// ImportOptions describes how a batch of recipes is imported.
+// The zero value skips the stored IDs and writes the new recipes.
+type ImportOptions struct {
+	Mode ImportMode
+	// DryRun only reports what the import would do
+	DryRun bool
+}
+
+type ImportStatus string
+
+const (
+	ImportCreated ImportStatus = "created"
+	ImportUpdated ImportStatus = "updated"
+	ImportSkipped ImportStatus = "skipped"
+	ImportFailed  ImportStatus = "failed"
+)
+
+// ImportOutcome is what happened to one recipe of an import, Err is set when it failed
+type ImportOutcome struct {
+	Status ImportStatus
+	Err    error
+}
+
+// Decide the outcome of every recipe of the batch from the IDs already stored
+func planImport(recipes []Recipe, stored func(id primitive.ObjectID) bool, opts ImportOptions) []ImportOutcome {
+	outcomes := make([]ImportOutcome, len(recipes))
+	for i := range recipes {
+		switch {
+		case !stored(recipes[i].ID):
+			outcomes[i].Status = ImportCreated
+		case opts.Mode == ImportUpsert:
+			outcomes[i].Status = ImportUpdated
+		default:
+			outcomes[i].Status = ImportSkipped
+		}
+	}
+	return outcomes
+}
+
+// Fail the outcomes having the given status
+func failImport(outcomes []ImportOutcome, status ImportStatus, err error) {
+	for i := range outcomes {
+		if outcomes[i].Status == status {
+			outcomes[i] = ImportOutcome{Status: ImportFailed, Err: err}
+		}
+	}
+}
+
+// ImportRecipes writes a batch of recipes and returns the outcome of each of them.
+// The new recipes are inserted at once, with their revisions and RecipeCreated events,
+// the stored ones are skipped or updated one by one according to the mode.
+func (dbh *DbHandler) ImportRecipes(ctx context.Context, l *logrus.Entry, recipes []Recipe, opts ImportOptions) ([]ImportOutcome, error) {
+	ctx, end := startOperation(ctx, "ImportRecipes", dbh.Timeouts.Write)
+	defer end()
+	l = l.WithContext(ctx)
+	dbh, err := dbh.forTenant(ctx)
+	if err != nil {
+		l.WithError(err).Error("Error when trying to resolve the tenant")
+		return nil, wrapError(err)
+	}
+	ids := make([]primitive.ObjectID, len(recipes))
+	for i := range recipes {
+		ids[i] = recipes[i].ID
+	}
+	// The _id is unique in the whole collection: in SharedCollection mode the ID of a recipe of another tenant
+	// is looked up too, it cannot be reused
+	cursor, err := dbh.GetRecipeCollection().Find(ctx, bson.M{"_id": bson.M{"$in": ids}},
+		options.Find().SetProjection(bson.M{"tenant_id": 1}))
+	if err != nil {
+		l.WithError(err).Error("Error when trying to find the stored recipes of the import")
+		return nil, wrapError(err)
+	}
+	var found []struct {
+		ID       primitive.ObjectID `bson:"_id"`
+		TenantID string             `bson:"tenant_id"`
+	}
+	if err := cursor.All(ctx, &found); err != nil {
+		l.WithError(err).Error("Error when trying to find the stored recipes of the import")
+		return nil, wrapError(err)
+	}
+	stored := make(map[primitive.ObjectID]bool, len(found))
+	for _, recipe := range found {
+		stored[recipe.ID] = dbh.Tenancy != SharedCollection || recipe.TenantID == dbh.tenant
+	}
+	outcomes := planImport(recipes, func(id primitive.ObjectID) bool { return stored[id] }, opts)
+	for i := range recipes {
+		if mine, taken := stored[recipes[i].ID]; taken && !mine {
+			outcomes[i] = ImportOutcome{Status: ImportFailed, Err: ErrDuplicateID}
+		}
+	}
+	if opts.DryRun {
+		return outcomes, nil
+	}
+
+	if dbh.multiTenant() && slices.ContainsFunc(outcomes, func(o ImportOutcome) bool { return o.Status == ImportCreated }) {
+		if err := dbh.ensureTenant(ctx); err != nil {
+			l.WithError(err).Error("Error when trying to register the tenant")
+			return nil, wrapError(err)
+		}
+	}
+	if err := dbh.insertImport(ctx, recipes, outcomes); err != nil {
+		l.WithError(err).Error("Error when trying to insert the imported recipes")
+		failImport(outcomes, ImportCreated, wrapError(err))
+	}
+
+	for i := range recipes {
+		if outcomes[i].Status == ImportUpdated {
+			recipes[i].Version = AnyVersion
+			if err := dbh.UpsertOne(ctx, l, &recipes[i]); err != nil {
+				outcomes[i] = ImportOutcome{Status: ImportFailed, Err: err}
+			}
+		}
+	}
+	return outcomes, nil
+}
+
+// ExportRecipes calls fn with every recipe out of the trash, in the order of their IDs.
+// The recipes are read one by one from a cursor and the export stops at the first error of fn.
+func (dbh *DbHandler) ExportRecipes(ctx context.Context, l *logrus.Entry, fn func(recipe *Recipe) error) error {
+	// An export lasts as long as the collection is large, only the context bounds it
+	ctx, end := startOperation(ctx, "ExportRecipes", 0)
+	defer end()
+	l = l.WithContext(ctx)
+	dbh, err := dbh.forTenant(ctx)
+	if err != nil {
+		l.WithError(err).Error("Error when trying to resolve the tenant")
+		return wrapError(err)
+	}
+	cursor, err := dbh.GetRecipeCollection().Find(ctx, dbh.live(bson.M{}), options.Find().SetSort(bson.M{"_id": 1}))
+	if err != nil {
+		l.WithError(err).Error("Error when trying to export recipes")
+		return wrapError(err)
+	}
+	defer cursor.Close(context.WithoutCancel(ctx))
+	for cursor.Next(ctx) {
+		var recipe Recipe
+		if err := cursor.Decode(&recipe); err != nil {
+			l.WithError(err).Error("Error when trying to decode the exported recipe")
+			return err
+		}
+		if err := fn(&recipe); err != nil {
+			return err
+		}
+	}
+	if err := cursor.Err(); err != nil {
+		l.WithError(err).Error("Error when trying to export recipes")
+		return wrapError(err)
+	}
+	return nil
+}
+
+// Insert the new recipes of the import with their revisions and RecipeCreated events.
+// The insert is unordered, a recipe refused by the server, e.g. on an ID taken meanwhile, only fails its own line.
+// In a transaction the refused recipes abort the others, they are inserted again without them.
+func (dbh *DbHandler) insertImport(ctx context.Context, recipes []Recipe, outcomes []ImportOutcome) error {
+	for {
+		var lines []int
+		for i := range recipes {
+			if outcomes[i].Status == ImportCreated {
+				recipes[i].prepareInsert(dbh.tenant)
+				lines = append(lines, i)
+			}
+		}
+		if len(lines) == 0 {
+			return nil
+		}
+		var refused []mongo.BulkWriteError
+		err := dbh.withTransaction(ctx, func(ctx context.Context) error {
+			refused = nil
+			created := make([]any, len(lines))
+			for j, line := range lines {
+				created[j] = &recipes[line]
+			}
+			_, err := dbh.GetRecipeCollection().InsertMany(ctx, created, options.InsertMany().SetOrdered(false))
+			var bulkErr mongo.BulkWriteException
+			if errors.As(err, &bulkErr) && bulkErr.WriteConcernError == nil && len(bulkErr.WriteErrors) > 0 {
+				refused = bulkErr.WriteErrors
+				if dbh.Transactions {
+					return err
+				}
+				// Without a transaction the other recipes are inserted, they get their revisions and events
+				err = nil
+			}
+			if err != nil {
+				return err
+			}
+			revisions := make([]any, 0, len(lines))
+			events := make([]any, 0, len(lines))
+			for j, line := range lines {
+				if !slices.ContainsFunc(refused, func(we mongo.BulkWriteError) bool { return we.Index == j }) {
+					revisions = append(revisions, newRevision(ctx, &recipes[line]))
+					events = append(events, newEvent(ctx, RecipeCreated, &recipes[line]))
+				}
+			}
+			if len(revisions) == 0 {
+				return nil
+			}
+			if _, err := dbh.GetRevisionCollection().InsertMany(ctx, revisions); err != nil {
+				return err
+			}
+			_, err = dbh.GetOutboxCollection().InsertMany(ctx, events)
+			return err
+		})
+		for _, we := range refused {
+			var importErr error = we.WriteError
+			if mongo.IsDuplicateKeyError(we.WriteError) {
+				importErr = ErrDuplicateID
+			}
+			outcomes[lines[we.Index]] = ImportOutcome{Status: ImportFailed, Err: importErr}
+		}
+		if len(refused) == 0 || !dbh.Transactions {
+			return err
+		}
+	}
+}
@@ -0,0 +1,488 @@
+package db
+
+import (
+	"bytes"
+	"context"
+	"maps"
+	"regexp"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// MemoryStore is a RecipeStore keeping the recipes in memory.
+// It is safe for concurrent use and is meant for local runs and tests.
+// The tenants are kept apart like in SharedCollection mode, a context without tenant is a tenant of its own.
+type MemoryStore struct {
+	mu        sync.RWMutex
+	recipes   map[primitive.ObjectID]Recipe
+	revisions map[primitive.ObjectID][]Revision
+	// The pending events of the outbox, removed once published
+	events []Event
+	// The relay holding the lease of the outbox and its expiry
+	leaseOwner   string
+	leaseExpires time.Time
+	// The content of the images and of their thumbnails by file ID
+	files map[primitive.ObjectID]ImageFile
+}
+
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{
+		recipes:   make(map[primitive.ObjectID]Recipe),
+		revisions: make(map[primitive.ObjectID][]Revision),
+		events:    make([]Event, 0),
+		files:     make(map[primitive.ObjectID]ImageFile),
+	}
+}
+
+func (ms *MemoryStore) NewID() primitive.ObjectID {
+	return primitive.NewObjectID()
+}
+
+func (ms *MemoryStore) Ping(ctx context.Context) error {
+	return wrapError(ctx.Err())
+}
+
+// Return a copy of the recipes of the tenant out of the trash matching the predicate, ordered by ID
+func (ms *MemoryStore) findAll(ctx context.Context, match func(*Recipe) bool) []Recipe {
+	return ms.collect(ctx, func(r *Recipe) bool { return r.DeletedAt == nil && match(r) })
+}
+
+// Return a copy of the recipes of the tenant matching the predicate, trashed or not, ordered by ID
+func (ms *MemoryStore) collect(ctx context.Context, match func(*Recipe) bool) []Recipe {
+	tenant := TenantFrom(ctx)
+	ms.mu.RLock()
+	defer ms.mu.RUnlock()
+	recipes := make([]Recipe, 0)
+	for _, recipe := range ms.recipes {
+		if recipe.TenantID == tenant && match(&recipe) {
+			recipes = append(recipes, cloneRecipe(recipe))
+		}
+	}
+	slices.SortFunc(recipes, func(a, b Recipe) int {
+		return bytes.Compare(a.ID[:], b.ID[:])
+	})
+	return recipes
+}
+
+// Find the page of recipes out of the trash matching the predicate
+func (ms *MemoryStore) findPage(ctx context.Context, l *logrus.Entry, match func(*Recipe) bool, opts ListOptions) (*RecipePage, error) {
+	return filterPage(ctx, l, ms.findAll(ctx, match), opts)
+}
+
+// Return the stored recipe if it belongs to the tenant of the context, must be called under the lock
+func (ms *MemoryStore) lookup(ctx context.Context, id primitive.ObjectID) (Recipe, bool) {
+	recipe, ok := ms.recipes[id]
+	if !ok || recipe.TenantID != TenantFrom(ctx) {
+		return Recipe{}, false
+	}
+	return recipe, true
+}
+
+func (ms *MemoryStore) FindAllRecipes(ctx context.Context, l *logrus.Entry, opts ListOptions) (*RecipePage, error) {
+	return ms.findPage(ctx, l, func(*Recipe) bool { return true }, opts)
+}
+
+func (ms *MemoryStore) FindRecipesByIngredientID(ctx context.Context, l *logrus.Entry, id string, opts ListOptions) (*RecipePage, error) {
+	return ms.findPage(ctx, l, func(r *Recipe) bool {
+		return slices.ContainsFunc(r.Ingredients, func(i Ingredient) bool { return i.ID == id })
+	}, opts)
+}
+
+func (ms *MemoryStore) FindRecipeByTitle(ctx context.Context, l *logrus.Entry, title string) (*Recipe, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, wrapError(err)
+	}
+	re, err := regexp.Compile("(?i)" + regexp.QuoteMeta(title))
+	if err != nil {
+		l.WithError(err).Error("Error when trying to find recipe by title")
+		return nil, err
+	}
+	recipes := ms.findAll(ctx, func(r *Recipe) bool { return re.MatchString(r.Name) })
+	if len(recipes) == 0 {
+		l.WithError(ErrRecipeNotFound).Error("Error when trying to find recipe by title")
+		return nil, ErrRecipeNotFound
+	}
+	return &recipes[0], nil
+}
+
+func (ms *MemoryStore) FindRecipeByID(ctx context.Context, l *logrus.Entry, id string) (*Recipe, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, wrapError(err)
+	}
+	objectID, _ := primitive.ObjectIDFromHex(id)
+	ms.mu.RLock()
+	defer ms.mu.RUnlock()
+	recipe, ok := ms.lookup(ctx, objectID)
+	if !ok || recipe.DeletedAt != nil {
+		l.WithError(ErrRecipeNotFound).Error("Error when trying to find recipe by id")
+		return nil, ErrRecipeNotFound
+	}
+	recipe = cloneRecipe(recipe)
+	return &recipe, nil
+}
+
+func (ms *MemoryStore) FindRecipesByAuthorID(ctx context.Context, l *logrus.Entry, author string, opts ListOptions) (*RecipePage, error) {
+	return ms.findPage(ctx, l, func(r *Recipe) bool { return r.Author == author }, opts)
+}
+
+func (ms *MemoryStore) SearchRecipes(ctx context.Context, l *logrus.Entry, query string, opts ListOptions) (*SearchPage, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, wrapError(err)
+	}
+	page, err := searchInMemory(ms.findAll(ctx, func(*Recipe) bool { return true }), query, opts)
+	if err != nil {
+		l.WithError(err).Warn("Error when trying to decode the cursor")
+		return nil, err
+	}
+	return page, nil
+}
+
+func (ms *MemoryStore) SaveRecipe(ctx context.Context, l *logrus.Entry, recipe *Recipe) error {
+	if err := ctx.Err(); err != nil {
+		return wrapError(err)
+	}
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+	if _, ok := ms.recipes[recipe.ID]; ok {
+		l.WithError(ErrDuplicateID).Error("Error when trying to save recipe")
+		return ErrDuplicateID
+	}
+	recipe.prepareInsert(TenantFrom(ctx))
+	ms.recipes[recipe.ID] = cloneRecipe(*recipe)
+	ms.revisions[recipe.ID] = append(ms.revisions[recipe.ID], newRevision(ctx, recipe))
+	ms.events = append(ms.events, newEvent(ctx, RecipeCreated, recipe))
+	return nil
+}
+
+// Return the stored recipe if it is out of the trash and at the expected version, must be called under the lock
+func (ms *MemoryStore) checkVersion(ctx context.Context, id primitive.ObjectID, version int64) (Recipe, error) {
+	stored, ok := ms.lookup(ctx, id)
+	if !ok || stored.DeletedAt != nil {
+		return Recipe{}, ErrRecipeNotFound
+	}
+	if version != AnyVersion && version != stored.Version {
+		return Recipe{}, ErrVersionMismatch
+	}
+	return stored, nil
+}
+
+func (ms *MemoryStore) DeleteRecipeByID(ctx context.Context, l *logrus.Entry, id string, version int64) error {
+	if err := ctx.Err(); err != nil {
+		return wrapError(err)
+	}
+	objectID, _ := primitive.ObjectIDFromHex(id)
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+	stored, err := ms.checkVersion(ctx, objectID, version)
+	if err != nil {
+		l.WithError(err).Error("Error when trying to delete recipe by id")
+		return err
+	}
+	deletedAt := now()
+	stored.DeletedAt = &deletedAt
+	stored.Version++
+	ms.recipes[objectID] = stored
+	ms.events = append(ms.events, newEvent(ctx, RecipeDeleted, &stored))
+	return nil
+}
+
+func (ms *MemoryStore) UpsertOne(ctx context.Context, l *logrus.Entry, recipe *Recipe) error {
+	if err := ctx.Err(); err != nil {
+		return wrapError(err)
+	}
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+	if err := ms.upsert(ctx, recipe); err != nil {
+		l.WithError(err).Error("Error when trying to upsert recipe")
+		return err
+	}
+	return nil
+}
+
+// Replace the stored recipe, the caller holds the lock
+func (ms *MemoryStore) upsert(ctx context.Context, recipe *Recipe) error {
+	stored, err := ms.checkVersion(ctx, recipe.ID, recipe.Version)
+	if err != nil {
+		return err
+	}
+	// Like the mongo $set, an unset creation date keeps the stored one
+	if recipe.CreatedAt.IsZero() {
+		recipe.CreatedAt = stored.CreatedAt
+	}
+	recipe.Version = stored.Version + 1
+	recipe.DeletedAt = nil
+	recipe.TenantID = stored.TenantID
+	recipe.Images = stored.Images
+	recipe.computeDerivedFields()
+	ms.recipes[recipe.ID] = cloneRecipe(*recipe)
+	ms.revisions[recipe.ID] = append(ms.revisions[recipe.ID], newRevision(ctx, recipe))
+	ms.events = append(ms.events, newEvent(ctx, RecipeUpdated, recipe))
+	return nil
+}
+
+func (ms *MemoryStore) FindTrashedRecipes(ctx context.Context, l *logrus.Entry, opts ListOptions) (*RecipePage, error) {
+	return filterPage(ctx, l, ms.collect(ctx, func(r *Recipe) bool { return r.DeletedAt != nil }), opts)
+}
+
+func (ms *MemoryStore) RestoreRecipeByID(ctx context.Context, l *logrus.Entry, id string) (*Recipe, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, wrapError(err)
+	}
+	objectID, _ := primitive.ObjectIDFromHex(id)
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+	stored, ok := ms.lookup(ctx, objectID)
+	if !ok || stored.DeletedAt == nil {
+		l.WithError(ErrRecipeNotFound).Error("Error when trying to restore recipe by id")
+		return nil, ErrRecipeNotFound
+	}
+	stored.DeletedAt = nil
+	stored.Version++
+	ms.recipes[objectID] = stored
+	ms.events = append(ms.events, newEvent(ctx, RecipeUpdated, &stored))
+	recipe := cloneRecipe(stored)
+	return &recipe, nil
+}
+
+// PurgeDeletedRecipes purges the trash of every tenant
+func (ms *MemoryStore) PurgeDeletedRecipes(ctx context.Context, l *logrus.Entry, before time.Time) (int64, error) {
+	if err := ctx.Err(); err != nil {
+		return 0, wrapError(err)
+	}
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+	purged := int64(0)
+	for id, recipe := range ms.recipes {
+		if recipe.DeletedAt != nil && recipe.DeletedAt.Before(before) {
+			for _, image := range recipe.Images {
+				for _, fileID := range image.fileIDs() {
+					delete(ms.files, fileID)
+				}
+			}
+			delete(ms.recipes, id)
+			delete(ms.revisions, id)
+			purged++
+		}
+	}
+	return purged, nil
+}
+
+func (ms *MemoryStore) FindRevisions(ctx context.Context, l *logrus.Entry, recipeID string) ([]Revision, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, wrapError(err)
+	}
+	objectID, _ := primitive.ObjectIDFromHex(recipeID)
+	ms.mu.RLock()
+	defer ms.mu.RUnlock()
+	var revisions []Revision
+	if _, ok := ms.lookup(ctx, objectID); ok {
+		revisions = slices.Clone(ms.revisions[objectID])
+	}
+	if revisions == nil {
+		revisions = make([]Revision, 0)
+	}
+	for i := range revisions {
+		revisions[i].Recipe = nil
+	}
+	return revisions, nil
+}
+
+func (ms *MemoryStore) FindRevision(ctx context.Context, l *logrus.Entry, recipeID string, number int64) (*Revision, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, wrapError(err)
+	}
+	objectID, _ := primitive.ObjectIDFromHex(recipeID)
+	ms.mu.RLock()
+	defer ms.mu.RUnlock()
+	var revisions []Revision
+	if _, ok := ms.lookup(ctx, objectID); ok {
+		revisions = ms.revisions[objectID]
+	}
+	i := slices.IndexFunc(revisions, func(r Revision) bool { return r.Number == number })
+	if i < 0 {
+		l.WithError(ErrRevisionNotFound).Error("Error when trying to find revision")
+		return nil, ErrRevisionNotFound
+	}
+	revision := revisions[i]
+	snapshot := cloneRecipe(*revision.Recipe)
+	revision.Recipe = &snapshot
+	return &revision, nil
+}
+
+func (ms *MemoryStore) ImportRecipes(ctx context.Context, l *logrus.Entry, recipes []Recipe, opts ImportOptions) ([]ImportOutcome, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, wrapError(err)
+	}
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+	outcomes := planImport(recipes, func(id primitive.ObjectID) bool {
+		_, ok := ms.lookup(ctx, id)
+		return ok
+	}, opts)
+	// The ID of a recipe of another tenant cannot be reused, like the unique _id of a shared collection
+	for i := range recipes {
+		if _, taken := ms.recipes[recipes[i].ID]; taken && outcomes[i].Status == ImportCreated {
+			outcomes[i] = ImportOutcome{Status: ImportFailed, Err: ErrDuplicateID}
+		}
+	}
+	if opts.DryRun {
+		return outcomes, nil
+	}
+	for i := range recipes {
+		if outcomes[i].Status == ImportCreated {
+			recipe := &recipes[i]
+			recipe.prepareInsert(TenantFrom(ctx))
+			ms.recipes[recipe.ID] = cloneRecipe(*recipe)
+			ms.revisions[recipe.ID] = append(ms.revisions[recipe.ID], newRevision(ctx, recipe))
+			ms.events = append(ms.events, newEvent(ctx, RecipeCreated, recipe))
+		}
+	}
+	// The updates are applied under the same lock, a recipe deleted in between cannot fail the import
+	for i := range recipes {
+		if outcomes[i].Status == ImportUpdated {
+			recipes[i].Version = AnyVersion
+			if err := ms.upsert(ctx, &recipes[i]); err != nil {
+				l.WithError(err).Error("Error when trying to upsert the imported recipe")
+				outcomes[i] = ImportOutcome{Status: ImportFailed, Err: err}
+			}
+		}
+	}
+	return outcomes, nil
+}
+
+func (ms *MemoryStore) ExportRecipes(ctx context.Context, l *logrus.Entry, fn func(recipe *Recipe) error) error {
+	for _, recipe := range ms.findAll(ctx, func(*Recipe) bool { return true }) {
+		if err := ctx.Err(); err != nil {
+			return wrapError(err)
+		}
+		if err := fn(&recipe); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (ms *MemoryStore) PendingEvents(ctx context.Context, l *logrus.Entry, limit int) ([]Event, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, wrapError(err)
+	}
+	ms.mu.RLock()
+	defer ms.mu.RUnlock()
+	return slices.Clone(ms.events[:min(limit, len(ms.events))]), nil
+}
+
+func (ms *MemoryStore) ClaimEvents(ctx context.Context, l *logrus.Entry, owner string, lease time.Duration, limit int) ([]Event, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, wrapError(err)
+	}
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+	claimedAt := now()
+	if ms.leaseOwner != owner && !ms.leaseExpires.Before(claimedAt) {
+		return nil, nil
+	}
+	ms.leaseOwner, ms.leaseExpires = owner, claimedAt.Add(lease)
+	return slices.Clone(ms.events[:min(limit, len(ms.events))]), nil
+}
+
+func (ms *MemoryStore) MarkEventsPublished(ctx context.Context, l *logrus.Entry, ids []primitive.ObjectID) error {
+	if err := ctx.Err(); err != nil {
+		return wrapError(err)
+	}
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+	ms.events = slices.DeleteFunc(ms.events, func(e Event) bool { return slices.Contains(ids, e.ID) })
+	return nil
+}
+
+func (ms *MemoryStore) RecipeStats(ctx context.Context, l *logrus.Entry) (*CatalogStats, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, wrapError(err)
+	}
+	return computeStats(ms.findAll(ctx, func(*Recipe) bool { return true })), nil
+}
+
+func (ms *MemoryStore) FindDuplicates(ctx context.Context, l *logrus.Entry, recipe *Recipe) ([]Duplicate, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, wrapError(err)
+	}
+	nameKey := NormalizeName(recipe.Name)
+	return duplicatesIn(ms.findAll(ctx, func(r *Recipe) bool { return r.NameKey == nameKey }), recipe), nil
+}
+
+func (ms *MemoryStore) FindDuplicateClusters(ctx context.Context, l *logrus.Entry) ([]DuplicateCluster, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, wrapError(err)
+	}
+	return clustersIn(ms.findAll(ctx, func(*Recipe) bool { return true })), nil
+}
+
+// Deep copy a recipe so callers never share slices or maps with the store
+func cloneRecipe(recipe Recipe) Recipe {
+	recipe.Metadata = maps.Clone(recipe.Metadata)
+	recipe.Timers = slices.Clone(recipe.Timers)
+	recipe.Steps = slices.Clone(recipe.Steps)
+	recipe.Ingredients = slices.Clone(recipe.Ingredients)
+	recipe.MetadataValues = slices.Clone(recipe.MetadataValues)
+	recipe.Images = slices.Clone(recipe.Images)
+	if recipe.DeletedAt != nil {
+		deletedAt := *recipe.DeletedAt
+		recipe.DeletedAt = &deletedAt
+	}
+	return recipe
+}
+
+func (ms *MemoryStore) AddRecipeImage(ctx context.Context, l *logrus.Entry, recipeID string, upload *ImageUpload, cover bool) (*Image, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, wrapError(err)
+	}
+	objectID, _ := primitive.ObjectIDFromHex(recipeID)
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+	stored, err := ms.checkVersion(ctx, objectID, AnyVersion)
+	if err != nil {
+		l.WithError(err).Error("Error when trying to find the recipe of the image")
+		return nil, err
+	}
+	image := upload.Image
+	image.UploadedAt = now()
+	ms.files[image.ID] = upload.Original
+	for _, thumbnail := range image.Thumbnails {
+		ms.files[thumbnail.FileID] = upload.Thumbnails[thumbnail.Name]
+	}
+	stored.Images = addImage(slices.Clone(stored.Images), image, cover)
+	ms.recipes[objectID] = stored
+	image = stored.Images[len(stored.Images)-1]
+	return &image, nil
+}
+
+func (ms *MemoryStore) OpenRecipeImage(ctx context.Context, l *logrus.Entry, recipeID string, imageID string, size string) (*ImageFile, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, wrapError(err)
+	}
+	objectID, _ := primitive.ObjectIDFromHex(recipeID)
+	imageObjectID, _ := primitive.ObjectIDFromHex(imageID)
+	ms.mu.RLock()
+	defer ms.mu.RUnlock()
+	stored, err := ms.checkVersion(ctx, objectID, AnyVersion)
+	if err != nil {
+		l.WithError(err).Error("Error when trying to find the image")
+		return nil, err
+	}
+	index := slices.IndexFunc(stored.Images, func(image Image) bool { return image.ID == imageObjectID })
+	if index < 0 {
+		l.WithError(ErrImageNotFound).Error("Error when trying to find the image")
+		return nil, ErrImageNotFound
+	}
+	fileID, ok := stored.Images[index].fileID(size)
+	file, found := ms.files[fileID]
+	if !ok || !found {
+		l.WithError(ErrImageNotFound).Error("Error when trying to find the thumbnail")
+		return nil, ErrImageNotFound
+	}
+	return &file, nil
+}
@@ -1,6 +1,9 @@
 package db
 
 import (
+	"slices"
+	"time"
+
 	"go.mongodb.org/mongo-driver/bson/primitive"
 )
 
@@ -14,6 +17,13 @@ const (
 	Dessert Dish = "dessert"
 )
 
+const (
+	// InitialVersion is the version of a newly saved recipe, every update increments it
+	InitialVersion int64 = 1
+	// AnyVersion skips the version check of a write
+	AnyVersion int64 = -1
+)
+
 // Metadata is a key value pair to store metadata
 type Metadata struct {
 	Key   string `json:"key" bson:"key"`
@@ -27,11 +37,26 @@ type Timer struct {
 	Unit   string `json:"unit" bson:"units" validate:"oneof=seconds minutes hours"`
 }
 
+func (t Timer) Duration() time.Duration {
+	switch t.Unit {
+	case "hours":
+		return time.Duration(t.Amount) * time.Hour
+	case "minutes":
+		return time.Duration(t.Amount) * time.Minute
+	default:
+		return time.Duration(t.Amount) * time.Second
+	}
+}
+
 // Reference the ingredient in the catalog MS
 type Ingredient struct {
 	ID     string  `json:"id" bson:"_id" validate:"omitempty"`
 	Amount float64 `json:"amount" bson:"quantity" validate:"required,min=0.1"`
 	Unit   string  `json:"unit" bson:"units" validate:"oneof=i is cs tbsp tsp g kg"`
+	// How the amount follows the servings when the recipe is scaled, linear when empty
+	Scaling Scaling `json:"scaling,omitempty" bson:"scaling,omitempty" validate:"omitempty,oneof=linear spice leavening fixed"`
+	// Set on the amounts converted into another measurement system for a read, never stored
+	Approximate bool `json:"approximate,omitempty" bson:"-"`
 }
 
 type Recipe struct {
@@ -45,4 +70,49 @@ type Recipe struct {
 	Timers      []Timer            `json:"timers" bson:"timers" validate:"omitempty,dive,required"`
 	Steps       []string           `json:"steps" bson:"steps" validate:"required"`
 	Ingredients []Ingredient       `json:"ingredients" bson:"ingredients" validate:"required,dive,required"`
+	CreatedAt   time.Time          `json:"created_at" bson:"created_at,omitempty"`
+	Version     int64              `json:"version" bson:"version"`
+	// Set while the recipe is in the trash
+	DeletedAt *time.Time `json:"deleted_at,omitempty" bson:"deleted_at,omitempty"`
+	// Copy of the metadata values, the text index cannot reach the values of a map
+	MetadataValues []string `json:"-" bson:"metadata_values"`
+	// Sum of the timers in seconds, for the filter on the total time
+	TotalTime int64 `json:"-" bson:"total_time"`
+	// The normalized name, for the detection of the duplicates
+	NameKey string `json:"-" bson:"name_key"`
+	// The tenant owning the recipe, empty without tenancy
+	TenantID string `json:"-" bson:"tenant_id,omitempty"`
+	// Managed by the image endpoints, the content of the recipe never changes them
+	Images []Image `json:"images,omitempty" bson:"images,omitempty"`
+}
+
+// Compute the fields derived from the content of the recipe before storing it
+func (r *Recipe) computeDerivedFields() {
+	r.MetadataValues = make([]string, 0, len(r.Metadata))
+	for _, value := range r.Metadata {
+		r.MetadataValues = append(r.MetadataValues, value)
+	}
+	slices.Sort(r.MetadataValues)
+	var total time.Duration
+	for _, timer := range r.Timers {
+		total += timer.Duration()
+	}
+	r.TotalTime = int64(total / time.Second)
+	for i := range r.Ingredients {
+		r.Ingredients[i].Approximate = false
+	}
+	r.NameKey = NormalizeName(r.Name)
+}
+
+// Prepare a new recipe of the tenant before its insertion: the first version, created now
+func (r *Recipe) prepareInsert(tenant string) {
+	if r.CreatedAt.IsZero() {
+		r.CreatedAt = now()
+	}
+	r.Version = InitialVersion
+	r.DeletedAt = nil
+	r.TenantID = tenant
+	// The files of the images are not part of the recipe, a new recipe has none
+	r.Images = nil
+	r.computeDerivedFields()
 }
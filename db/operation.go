@@ -0,0 +1,55 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/x/mongo/driver/topology"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// ErrTimeout is returned when a database operation did not complete before its deadline
+var ErrTimeout = errors.New("database operation timed out")
+
+var tracer = otel.Tracer("recipes/db")
+
+// Start the span of a database operation and bound it with the given timeout.
+// The returned function must be called once the operation is over.
+func startOperation(ctx context.Context, name string, timeout time.Duration) (context.Context, func()) {
+	ctx, span := tracer.Start(ctx, "db."+name, trace.WithSpanKind(trace.SpanKindClient))
+	cancel := context.CancelFunc(func() {})
+	if timeout > 0 {
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+	}
+	return ctx, func() {
+		if err := ctx.Err(); err != nil {
+			span.RecordError(err)
+			span.SetStatus(codes.Error, err.Error())
+		}
+		cancel()
+		span.End()
+	}
+}
+
+// Wrap the deadline errors into ErrTimeout and the unreachable servers into ErrUnavailable so the callers can tell them apart
+func wrapError(err error) error {
+	if err == nil || errors.Is(err, ErrTimeout) || errors.Is(err, ErrUnavailable) {
+		return err
+	}
+	if errors.Is(err, context.DeadlineExceeded) {
+		return fmt.Errorf("%w: %w", ErrTimeout, err)
+	}
+	// The driver reports a server selection timeout as a timeout, it means no server answered
+	if errors.As(err, &topology.ServerSelectionError{}) || mongo.IsNetworkError(err) {
+		return fmt.Errorf("%w: %w", ErrUnavailable, err)
+	}
+	if mongo.IsTimeout(err) {
+		return fmt.Errorf("%w: %w", ErrTimeout, err)
+	}
+	return err
+}
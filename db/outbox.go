@@ -0,0 +1,176 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// EventType is the kind of change an event reports
+type EventType string
+
+const (
+	RecipeCreated EventType = "RecipeCreated"
+	RecipeUpdated EventType = "RecipeUpdated"
+	RecipeDeleted EventType = "RecipeDeleted"
+)
+
+// Event is a change of a recipe written to the outbox along with the change itself.
+// The snapshot of the recipe is left out of the RecipeDeleted events.
+// The outbox is shared by the tenants, every event names the tenant of its recipe.
+type Event struct {
+	ID          primitive.ObjectID `json:"id" bson:"_id"`
+	TenantID    string             `json:"tenant_id,omitempty" bson:"tenant_id,omitempty"`
+	Type        EventType          `json:"type" bson:"type"`
+	RecipeID    primitive.ObjectID `json:"recipe_id" bson:"recipe_id"`
+	Version     int64              `json:"version" bson:"version"`
+	Actor       string             `json:"actor" bson:"actor"`
+	OccurredAt  time.Time          `json:"occurred_at" bson:"occurred_at"`
+	Recipe      *Recipe            `json:"recipe,omitempty" bson:"recipe,omitempty"`
+	PublishedAt *time.Time         `json:"-" bson:"published_at"`
+}
+
+// Build the event of a write made by the actor of the context
+func newEvent(ctx context.Context, eventType EventType, recipe *Recipe) Event {
+	event := Event{
+		ID:         primitive.NewObjectID(),
+		TenantID:   recipe.TenantID,
+		Type:       eventType,
+		RecipeID:   recipe.ID,
+		Version:    recipe.Version,
+		Actor:      ActorFrom(ctx),
+		OccurredAt: now(),
+	}
+	if eventType != RecipeDeleted {
+		snapshot := cloneRecipe(*recipe)
+		event.Recipe = &snapshot
+	}
+	return event
+}
+
+func (dbh *DbHandler) GetOutboxCollection() *mongo.Collection {
+	return dbh.Client.Database(dbh.DBName).Collection(dbh.RecipesCollectionName + "_outbox")
+}
+
+// Write the event of a change, in the transaction of the change when there is one
+func (dbh *DbHandler) recordEvent(ctx context.Context, eventType EventType, recipe *Recipe) error {
+	_, err := dbh.GetOutboxCollection().InsertOne(ctx, newEvent(ctx, eventType, recipe))
+	return err
+}
+
+// PendingEvents returns the oldest events of the outbox which have not been published yet
+func (dbh *DbHandler) PendingEvents(ctx context.Context, l *logrus.Entry, limit int) ([]Event, error) {
+	if err := dbh.checkReady(); err != nil {
+		return nil, err
+	}
+	ctx, end := startOperation(ctx, "PendingEvents", dbh.Timeouts.Read)
+	defer end()
+	l = l.WithContext(ctx)
+	cursor, err := dbh.GetOutboxCollection().Find(ctx, bson.M{"published_at": nil}, options.Find().
+		SetSort(bson.D{{Key: "_id", Value: 1}}).
+		SetLimit(int64(limit)))
+	if err != nil {
+		l.WithError(err).Error("Error when trying to find the pending events")
+		return nil, wrapError(err)
+	}
+	events := make([]Event, 0, limit)
+	if err := cursor.All(ctx, &events); err != nil {
+		l.WithError(err).Error("Error when trying to decode the pending events")
+		return nil, wrapError(err)
+	}
+	return events, nil
+}
+
+// GetOutboxLeaseCollection holds the lease of the relay publishing the outbox
+func (dbh *DbHandler) GetOutboxLeaseCollection() *mongo.Collection {
+	return dbh.Client.Database(dbh.DBName).Collection(dbh.RecipesCollectionName + "_outbox_lease")
+}
+
+// ClaimEvents returns the oldest pending events once the owner holds the lease of the outbox until now+lease.
+// The relays of the replicas share the outbox: only the one holding the lease publishes, in the order of the events.
+// It returns no event while another owner holds the lease.
+func (dbh *DbHandler) ClaimEvents(ctx context.Context, l *logrus.Entry, owner string, lease time.Duration, limit int) ([]Event, error) {
+	claimed, err := dbh.claimOutbox(ctx, l, owner, lease)
+	if err != nil || !claimed {
+		return nil, err
+	}
+	return dbh.PendingEvents(ctx, l, limit)
+}
+
+// Take the lease of the outbox if it is free, expired or already held by the owner.
+// When another owner holds it the filter does not match and the upsert fails on the duplicate _id.
+func (dbh *DbHandler) claimOutbox(ctx context.Context, l *logrus.Entry, owner string, lease time.Duration) (bool, error) {
+	if err := dbh.checkReady(); err != nil {
+		return false, err
+	}
+	ctx, end := startOperation(ctx, "ClaimEvents", dbh.Timeouts.Write)
+	defer end()
+	l = l.WithContext(ctx)
+	claimedAt := now()
+	filter := bson.M{
+		"_id": "relay",
+		"$or": bson.A{
+			bson.M{"owner": owner},
+			bson.M{"expires_at": bson.M{"$lt": claimedAt}},
+		},
+	}
+	update := bson.M{"$set": bson.M{"owner": owner, "expires_at": claimedAt.Add(lease)}}
+	err := dbh.GetOutboxLeaseCollection().FindOneAndUpdate(ctx, filter, update, options.FindOneAndUpdate().SetUpsert(true)).Err()
+	if mongo.IsDuplicateKeyError(err) {
+		return false, nil
+	}
+	// The document is only returned when it existed before the update
+	if err != nil && !errors.Is(err, mongo.ErrNoDocuments) {
+		l.WithError(err).Error("Error when trying to claim the outbox")
+		return false, wrapError(err)
+	}
+	return true, nil
+}
+
+// MarkEventsPublished takes the events out of the pending ones.
+// The published events expire from the outbox after a while, see the migrations.
+func (dbh *DbHandler) MarkEventsPublished(ctx context.Context, l *logrus.Entry, ids []primitive.ObjectID) error {
+	ctx, end := startOperation(ctx, "MarkEventsPublished", dbh.Timeouts.Write)
+	defer end()
+	l = l.WithContext(ctx)
+	_, err := dbh.GetOutboxCollection().UpdateMany(ctx, bson.M{"_id": bson.M{"$in": ids}}, bson.M{"$set": bson.M{"published_at": now()}})
+	if err != nil {
+		l.WithError(err).Error("Error when trying to mark the events as published")
+		return wrapError(err)
+	}
+	return nil
+}
+
+// Tell if the server is a replica set member or a mongos, the deployments supporting transactions
+func supportsTransactions(ctx context.Context, client *mongo.Client) bool {
+	var hello struct {
+		SetName string `bson:"setName"`
+		Msg     string `bson:"msg"`
+	}
+	err := client.Database("admin").RunCommand(ctx, bson.D{{Key: "hello", Value: 1}}).Decode(&hello)
+	return err == nil && (hello.SetName != "" || hello.Msg == "isdbgrid")
+}
+
+// Run fn in a transaction if the server supports them.
+// Otherwise, only when ConnectOptions.AllowNoTransactions accepted it, the writes of fn are not atomic:
+// a failure may leave the recipe written without its revision or event.
+func (dbh *DbHandler) withTransaction(ctx context.Context, fn func(ctx context.Context) error) error {
+	if !dbh.Transactions {
+		return fn(ctx)
+	}
+	session, err := dbh.Client.StartSession()
+	if err != nil {
+		return err
+	}
+	defer session.EndSession(context.WithoutCancel(ctx))
+	_, err = session.WithTransaction(ctx, func(ctx mongo.SessionContext) (any, error) {
+		return nil, fn(ctx)
+	})
+	return err
+}
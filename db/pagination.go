@@ -0,0 +1,224 @@
+package db
+
+import (
+	"bytes"
+	"cmp"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+const (
+	DefaultPageLimit = 20
+	MaxPageLimit     = 100
+)
+
+// ErrInvalidCursor is returned when a cursor was not issued for the requested listing
+var ErrInvalidCursor = errors.New("invalid cursor")
+
+// SortField is a field the recipes can be listed by
+type SortField string
+
+const (
+	SortByName      SortField = "name"
+	SortByServings  SortField = "servings"
+	SortByCreatedAt SortField = "created_at"
+)
+
+// ListOptions describes the page of recipes to return.
+// The zero value returns the first DefaultPageLimit recipes by creation date, without filter.
+type ListOptions struct {
+	Limit      int
+	Cursor     string // NextCursor of the previous page
+	Sort       SortField
+	Descending bool
+	Filter     RecipeFilter
+	// WithFacets counts the recipes matching the filter per dish and per author
+	WithFacets bool
+	// Fields selects the fields of the returned recipes
+	Fields FieldSet
+}
+
+// RecipePage is one page of a recipe listing
+type RecipePage struct {
+	Recipes    []Recipe `json:"recipes"`
+	NextCursor string   `json:"next_cursor,omitempty"`
+	Total      int64    `json:"total"`
+	Facets     *Facets  `json:"facets,omitempty"`
+}
+
+// The cursor holds the sort key of the last recipe of a page.
+// The ID breaks the ties between recipes having the same sort value.
+type cursor struct {
+	Sort       SortField          `json:"s"`
+	Descending bool               `json:"d,omitempty"`
+	Value      json.RawMessage    `json:"v"`
+	ID         primitive.ObjectID `json:"id"`
+}
+
+func (opts ListOptions) normalize() ListOptions {
+	if opts.Limit <= 0 {
+		opts.Limit = DefaultPageLimit
+	}
+	opts.Limit = min(opts.Limit, MaxPageLimit)
+	if opts.Sort == "" {
+		opts.Sort = SortByCreatedAt
+	}
+	return opts
+}
+
+func sortValue(recipe *Recipe, field SortField) any {
+	switch field {
+	case SortByName:
+		return recipe.Name
+	case SortByServings:
+		return recipe.Servings
+	default:
+		return recipe.CreatedAt
+	}
+}
+
+func compareRecipes(a, b *Recipe, field SortField) int {
+	var c int
+	switch field {
+	case SortByName:
+		c = strings.Compare(a.Name, b.Name)
+	case SortByServings:
+		c = cmp.Compare(a.Servings, b.Servings)
+	default:
+		c = a.CreatedAt.Compare(b.CreatedAt)
+	}
+	if c == 0 {
+		c = bytes.Compare(a.ID[:], b.ID[:])
+	}
+	return c
+}
+
+func encodeCursor(recipe *Recipe, opts ListOptions) string {
+	value, _ := json.Marshal(sortValue(recipe, opts.Sort))
+	raw, _ := json.Marshal(cursor{Sort: opts.Sort, Descending: opts.Descending, Value: value, ID: recipe.ID})
+	return base64.RawURLEncoding.EncodeToString(raw)
+}
+
+// Decode the cursor of the listing into the recipe it points after
+func decodeCursor(opts ListOptions) (*Recipe, error) {
+	raw, err := base64.RawURLEncoding.DecodeString(opts.Cursor)
+	if err != nil {
+		return nil, ErrInvalidCursor
+	}
+	var c cursor
+	if err := json.Unmarshal(raw, &c); err != nil || c.Sort != opts.Sort || c.Descending != opts.Descending {
+		return nil, ErrInvalidCursor
+	}
+	after := Recipe{ID: c.ID}
+	switch c.Sort {
+	case SortByName:
+		err = json.Unmarshal(c.Value, &after.Name)
+	case SortByServings:
+		err = json.Unmarshal(c.Value, &after.Servings)
+	case SortByCreatedAt:
+		err = json.Unmarshal(c.Value, &after.CreatedAt)
+	default:
+		err = ErrInvalidCursor
+	}
+	if err != nil {
+		return nil, ErrInvalidCursor
+	}
+	return &after, nil
+}
+
+// Build the mongo sort and the condition selecting the recipes after the cursor
+func mongoPage(opts ListOptions) (bson.D, bson.M, error) {
+	direction := 1
+	operator := "$gt"
+	if opts.Descending {
+		direction, operator = -1, "$lt"
+	}
+	sort := bson.D{{Key: string(opts.Sort), Value: direction}, {Key: "_id", Value: direction}}
+	if opts.Cursor == "" {
+		return sort, nil, nil
+	}
+	after, err := decodeCursor(opts)
+	if err != nil {
+		return nil, nil, err
+	}
+	value := sortValue(after, opts.Sort)
+	if t, ok := value.(time.Time); ok {
+		value = primitive.NewDateTimeFromTime(t)
+	}
+	return sort, bson.M{"$or": bson.A{
+		bson.M{string(opts.Sort): bson.M{operator: value}},
+		bson.M{string(opts.Sort): value, "_id": bson.M{operator: after.ID}},
+	}}, nil
+}
+
+// Sort the recipes in memory and cut the requested page
+func paginate(recipes []Recipe, opts ListOptions) (*RecipePage, error) {
+	opts = opts.normalize()
+	compare := func(a, b *Recipe) int {
+		if opts.Descending {
+			return compareRecipes(b, a, opts.Sort)
+		}
+		return compareRecipes(a, b, opts.Sort)
+	}
+	slices.SortFunc(recipes, func(a, b Recipe) int { return compare(&a, &b) })
+
+	start := 0
+	if opts.Cursor != "" {
+		after, err := decodeCursor(opts)
+		if err != nil {
+			return nil, err
+		}
+		start, _ = slices.BinarySearchFunc(recipes, after, func(r Recipe, after *Recipe) int {
+			if compare(&r, after) <= 0 {
+				return -1
+			}
+			return 1
+		})
+	}
+	end := min(start+opts.Limit, len(recipes))
+	page := &RecipePage{
+		Recipes: recipes[start:end],
+		Total:   int64(len(recipes)),
+	}
+	if end < len(recipes) {
+		page.NextCursor = encodeCursor(&recipes[end-1], opts)
+	}
+	return page, nil
+}
+
+// Filter the recipes and return their page, for the stores filtering in memory
+func filterPage(ctx context.Context, l *logrus.Entry, recipes []Recipe, opts ListOptions) (*RecipePage, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, wrapError(err)
+	}
+	if err := opts.Filter.validate(); err != nil {
+		l.WithError(err).Warn("Error when trying to compile the filter")
+		return nil, err
+	}
+	if err := opts.Fields.validate(); err != nil {
+		l.WithError(err).Warn("Error when trying to select the fields")
+		return nil, err
+	}
+	recipes = slices.DeleteFunc(recipes, func(r Recipe) bool { return !opts.Filter.match(&r) })
+	page, err := paginate(recipes, opts)
+	if err != nil {
+		l.WithError(err).Warn("Error when trying to decode the cursor")
+		return nil, err
+	}
+	if opts.WithFacets {
+		page.Facets = countFacets(recipes)
+	}
+	for i := range page.Recipes {
+		opts.Fields.apply(&page.Recipes[i])
+	}
+	return page, nil
+}
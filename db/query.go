@@ -3,135 +3,325 @@ package db
 import (
 	"context"
 	"errors"
+	"regexp"
+	"time"
 
 	"github.com/sirupsen/logrus"
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
 	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
 )
 
 var loger = logrus.WithFields(logrus.Fields{
 	"context": "db/query",
 })
 
+var (
+	ErrRecipeNotFound  = errors.New("ID not found")
+	ErrDuplicateID     = errors.New("a recipe with this ID already exists")
+	ErrVersionMismatch = errors.New("the recipe has been modified since the expected version")
+)
+
+// The current time at the millisecond precision stored by MongoDB
+func now() time.Time {
+	return time.Now().UTC().Truncate(time.Millisecond)
+}
+
 func (dbh *DbHandler) NewID() primitive.ObjectID {
 	return primitive.NewObjectID()
 }
 
 func (dbh *DbHandler) GetRecipeCollection() *mongo.Collection {
-	return dbh.Client.Database(dbh.DBName).Collection(dbh.RecipesCollectionName)
+	return dbh.Client.Database(dbh.DBName).Collection(dbh.recipesCollectionName())
 }
 
-func (dbh *DbHandler) FindAllRecipes(l *logrus.Entry) (*[]Recipe, error) {
-	recipes := make([]Recipe, 0)
-	cursor, err := dbh.GetRecipeCollection().Find(context.Background(), bson.M{})
+// Restrict the filter to the recipes of the tenant which are not in the trash
+func (dbh *DbHandler) live(filter bson.M) bson.M {
+	filter["deleted_at"] = nil
+	return dbh.scope(filter)
+}
+
+// Find the page of recipes matching the filter restricted by scope to the tenant, name is used for tracing
+func (dbh *DbHandler) findPage(ctx context.Context, l *logrus.Entry, name string, filter bson.M, scope func(*DbHandler, bson.M) bson.M, opts ListOptions) (*RecipePage, error) {
+	ctx, end := startOperation(ctx, name, dbh.Timeouts.Read)
+	defer end()
+	l = l.WithContext(ctx)
+	dbh, err := dbh.forTenant(ctx)
+	if err != nil {
+		l.WithError(err).Error("Error when trying to resolve the tenant")
+		return nil, wrapError(err)
+	}
+	filter = scope(dbh, filter)
+	opts = opts.normalize()
+	sort, after, err := mongoPage(opts)
 	if err != nil {
-		l.WithError(err).Error("Error when trying to find all recipes")
+		l.WithError(err).Warn("Error when trying to decode the cursor")
 		return nil, err
 	}
-
-	err = cursor.All(context.Background(), &recipes)
+	criteria, err := opts.Filter.mongoFilter()
 	if err != nil {
-		l.WithError(err).Error("Error when trying to decode all recipes")
+		l.WithError(err).Warn("Error when trying to compile the filter")
+		return nil, err
+	}
+	if len(criteria) > 0 {
+		filter = bson.M{"$and": bson.A{filter, criteria}}
+	}
+	if err := opts.Fields.validate(); err != nil {
+		l.WithError(err).Warn("Error when trying to select the fields")
 		return nil, err
 	}
-	return &recipes, nil
-}
 
-func (dbh *DbHandler) FindRecipesByIngredientID(l *logrus.Entry, id string) (*[]Recipe, error) {
-	cursor, err := dbh.GetRecipeCollection().Find(context.Background(), bson.M{"ingredients._id": id})
+	total, err := dbh.GetRecipeCollection().CountDocuments(ctx, filter)
 	if err != nil {
-		l.WithError(err).Error("Error when trying to find recipe by ingredient id")
-		return nil, err
+		l.WithError(err).Error("Error when trying to count recipes")
+		return nil, wrapError(err)
+	}
+
+	query := filter
+	if after != nil {
+		query = bson.M{"$and": bson.A{filter, after}}
+	}
+	// Fetch one more recipe than the limit to know if there is a next page
+	findOptions := options.Find().SetSort(sort).SetLimit(int64(opts.Limit + 1))
+	// The sort field is read for the cursor, then cleared if it is not selected
+	if projection := opts.Fields.mongoProjection(string(opts.Sort)); projection != nil {
+		findOptions.SetProjection(projection)
 	}
-	var recipes []Recipe
-	err = cursor.All(context.Background(), &recipes)
+	cursor, err := dbh.GetRecipeCollection().Find(ctx, query, findOptions)
+	if err != nil {
+		l.WithError(err).Error("Error when trying to find recipes")
+		return nil, wrapError(err)
+	}
+	recipes := make([]Recipe, 0, opts.Limit+1)
+	err = cursor.All(ctx, &recipes)
 	if err != nil {
 		l.WithError(err).Error("Error when trying to decode all recipes")
-		return nil, err
+		return nil, wrapError(err)
+	}
+
+	page := &RecipePage{Recipes: recipes, Total: total}
+	if len(recipes) > opts.Limit {
+		page.Recipes = recipes[:opts.Limit]
+		page.NextCursor = encodeCursor(&page.Recipes[opts.Limit-1], opts)
 	}
-	return &recipes, nil
+	for i := range page.Recipes {
+		opts.Fields.apply(&page.Recipes[i])
+	}
+	if opts.WithFacets {
+		page.Facets, err = dbh.countFacets(ctx, filter)
+		if err != nil {
+			l.WithError(err).Error("Error when trying to count the facets")
+			return nil, wrapError(err)
+		}
+	}
+	return page, nil
+}
+
+func (dbh *DbHandler) FindAllRecipes(ctx context.Context, l *logrus.Entry, opts ListOptions) (*RecipePage, error) {
+	return dbh.findPage(ctx, l, "FindAllRecipes", bson.M{}, (*DbHandler).live, opts)
 }
 
-func (dbh *DbHandler) FindRecipeByTitle(l *logrus.Entry, title string) (*Recipe, error) {
+func (dbh *DbHandler) FindRecipesByIngredientID(ctx context.Context, l *logrus.Entry, id string, opts ListOptions) (*RecipePage, error) {
+	return dbh.findPage(ctx, l, "FindRecipesByIngredientID", bson.M{"ingredients._id": id}, (*DbHandler).live, opts)
+}
+
+func (dbh *DbHandler) FindRecipeByTitle(ctx context.Context, l *logrus.Entry, title string) (*Recipe, error) {
+	ctx, end := startOperation(ctx, "FindRecipeByTitle", dbh.Timeouts.Read)
+	defer end()
+	l = l.WithContext(ctx)
+	dbh, err := dbh.forTenant(ctx)
+	if err != nil {
+		l.WithError(err).Error("Error when trying to resolve the tenant")
+		return nil, wrapError(err)
+	}
 	var recipe Recipe
 	// Search if the name is in the title
-	err := dbh.GetRecipeCollection().FindOne(context.Background(), bson.M{"name": bson.M{"$regex": title, "$options": "i"}}).Decode(&recipe)
+	err = dbh.GetRecipeCollection().FindOne(ctx, dbh.live(bson.M{"name": bson.M{"$regex": regexp.QuoteMeta(title), "$options": "i"}})).Decode(&recipe)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		err = ErrRecipeNotFound
+	}
 	if err != nil {
 		l.WithError(err).Error("Error when trying to find recipe by title")
-		return nil, err
+		return nil, wrapError(err)
 	}
 	return &recipe, nil
 }
 
-func (dbh *DbHandler) FindRecipeByID(l *logrus.Entry, id string) (*Recipe, error) {
+func (dbh *DbHandler) FindRecipeByID(ctx context.Context, l *logrus.Entry, id string) (*Recipe, error) {
+	ctx, end := startOperation(ctx, "FindRecipeByID", dbh.Timeouts.Read)
+	defer end()
+	l = l.WithContext(ctx)
+	dbh, err := dbh.forTenant(ctx)
+	if err != nil {
+		l.WithError(err).Error("Error when trying to resolve the tenant")
+		return nil, wrapError(err)
+	}
 	// Convert id to ObjectID
 	objectID, err := primitive.ObjectIDFromHex(id)
-	filter := map[string]primitive.ObjectID{"_id": objectID}
+	filter := dbh.live(bson.M{"_id": objectID})
 	var recipe Recipe
-	err = dbh.GetRecipeCollection().FindOne(context.Background(), filter).Decode(&recipe)
+	err = dbh.GetRecipeCollection().FindOne(ctx, filter).Decode(&recipe)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		err = ErrRecipeNotFound
+	}
 	if err != nil {
 		l.WithError(err).Error("Error when trying to find recipe by id")
-		return nil, err
+		return nil, wrapError(err)
 	}
 	return &recipe, nil
 }
 
-func (dbh *DbHandler) FindRecipesByAuthorID(l *logrus.Entry, author string) (*[]Recipe, error) {
-	cursor, err := dbh.GetRecipeCollection().Find(context.Background(), bson.M{"author": author})
+func (dbh *DbHandler) FindRecipesByAuthorID(ctx context.Context, l *logrus.Entry, author string, opts ListOptions) (*RecipePage, error) {
+	return dbh.findPage(ctx, l, "FindRecipesByAuthorID", bson.M{"author": author}, (*DbHandler).live, opts)
+}
+
+// SaveRecipe inserts the recipe, sets its version and creation date,
+// and records its first revision and its RecipeCreated event
+func (dbh *DbHandler) SaveRecipe(ctx context.Context, l *logrus.Entry, recipe *Recipe) error {
+	ctx, end := startOperation(ctx, "SaveRecipe", dbh.Timeouts.Write)
+	defer end()
+	l = l.WithContext(ctx)
+	dbh, err := dbh.forNewRecipes(ctx)
 	if err != nil {
-		l.WithError(err).Error("Error when trying to find recipe by author")
-		return nil, err
+		l.WithError(err).Error("Error when trying to resolve the tenant")
+		return wrapError(err)
 	}
-	recipes := make([]Recipe, 0)
-	err = cursor.All(context.Background(), &recipes)
-	if err != nil {
-		l.WithError(err).Error("Error when trying to decode all recipes")
-		return nil, err
+	recipe.prepareInsert(dbh.tenant)
+	err = dbh.withTransaction(ctx, func(ctx context.Context) error {
+		if _, err := dbh.GetRecipeCollection().InsertOne(ctx, recipe); err != nil {
+			return err
+		}
+		if err := dbh.recordRevision(ctx, recipe); err != nil {
+			return err
+		}
+		return dbh.recordEvent(ctx, RecipeCreated, recipe)
+	})
+	if mongo.IsDuplicateKeyError(err) {
+		err = ErrDuplicateID
 	}
-	return &recipes, nil
-}
-
-// TODO Return the saved recipe
-func (dbh *DbHandler) SaveRecipe(l *logrus.Entry, recipe Recipe) error {
-	_, err := dbh.GetRecipeCollection().InsertOne(context.Background(), recipe)
 	if err != nil {
 		l.WithError(err).Error("Error when trying to save recipe")
-		return err
+		return wrapError(err)
 	}
 	return nil
 }
 
-func (dbh *DbHandler) DeleteRecipeByID(l *logrus.Entry, id string) error {
-	objectID, _ := primitive.ObjectIDFromHex(id)
-	filter := map[string]primitive.ObjectID{"_id": objectID}
-	res, err := dbh.GetRecipeCollection().DeleteOne(context.Background(), filter)
+// Filter the recipe of the tenant out of the trash by ID and, unless AnyVersion is expected, by version
+func (dbh *DbHandler) versionFilter(id primitive.ObjectID, version int64) bson.M {
+	filter := dbh.live(bson.M{"_id": id})
+	switch {
+	case version == AnyVersion:
+	case version == 0:
+		// Recipes stored before the versioning have no version field
+		filter["version"] = bson.M{"$in": bson.A{0, nil}}
+	default:
+		filter["version"] = version
+	}
+	return filter
+}
+
+// Tell apart a missing recipe from a version mismatch once a conditional write matched nothing
+func (dbh *DbHandler) missedWriteError(ctx context.Context, id primitive.ObjectID) error {
+	count, err := dbh.GetRecipeCollection().CountDocuments(ctx, dbh.live(bson.M{"_id": id}))
 	if err != nil {
-		l.WithError(err).Error("Error when trying to delete recipe by id")
-		return err
+		return wrapError(err)
 	}
+	if count == 0 {
+		return ErrRecipeNotFound
+	}
+	return ErrVersionMismatch
+}
 
-	if res.DeletedCount == 0 {
-		err = errors.New("ID not found")
+// DeleteRecipeByID moves the recipe to the trash if it is at the expected version, AnyVersion skips the check.
+// Trashed recipes are hidden from the Find methods until they are restored or purged.
+func (dbh *DbHandler) DeleteRecipeByID(ctx context.Context, l *logrus.Entry, id string, version int64) error {
+	ctx, end := startOperation(ctx, "DeleteRecipeByID", dbh.Timeouts.Write)
+	defer end()
+	l = l.WithContext(ctx)
+	dbh, err := dbh.forTenant(ctx)
+	if err != nil {
+		l.WithError(err).Error("Error when trying to resolve the tenant")
+		return wrapError(err)
+	}
+	objectID, _ := primitive.ObjectIDFromHex(id)
+	filter := dbh.versionFilter(objectID, version)
+	update := bson.M{"$set": bson.M{"deleted_at": now()}, "$inc": bson.M{"version": 1}}
+	err = dbh.withTransaction(ctx, func(ctx context.Context) error {
+		var deleted Recipe
+		err := dbh.GetRecipeCollection().FindOneAndUpdate(ctx, filter, update,
+			options.FindOneAndUpdate().SetReturnDocument(options.After)).Decode(&deleted)
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			return dbh.missedWriteError(ctx, objectID)
+		}
+		if err != nil {
+			return err
+		}
+		return dbh.recordEvent(ctx, RecipeDeleted, &deleted)
+	})
+	if err != nil {
 		l.WithError(err).Error("Error when trying to delete recipe by id")
-		return err
+		return wrapError(err)
 	}
 	return nil
 }
 
-func (dbh *DbHandler) UpsertOne(l *logrus.Entry, recipe *Recipe) error {
-	// Convert id to string
-	filter := map[string]primitive.ObjectID{"_id": recipe.ID}
-	update := map[string]Recipe{"$set": *recipe}
-	res, err := dbh.GetRecipeCollection().UpdateOne(context.Background(), filter, update)
+// UpsertOne replaces the content of the recipe if it is still at recipe.Version, AnyVersion skips the check.
+// On success the recipe is updated with the stored one, holding the next version,
+// and its revision and RecipeUpdated event are recorded.
+func (dbh *DbHandler) UpsertOne(ctx context.Context, l *logrus.Entry, recipe *Recipe) error {
+	ctx, end := startOperation(ctx, "UpsertOne", dbh.Timeouts.Write)
+	defer end()
+	l = l.WithContext(ctx)
+	dbh, err := dbh.forTenant(ctx)
 	if err != nil {
-		l.WithError(err).Error("Error when trying to upsert recipe")
+		l.WithError(err).Error("Error when trying to resolve the tenant")
+		return wrapError(err)
+	}
+	recipe.computeDerivedFields()
+	set, err := toSetDocument(recipe)
+	if err != nil {
+		l.WithError(err).Error("Error when trying to encode recipe")
 		return err
 	}
-	if res.MatchedCount == 0 {
-		err = errors.New("ID not found")
+	// The filter is built once, the transaction may run several times and the decoding changes the version
+	filter := dbh.versionFilter(recipe.ID, recipe.Version)
+	update := bson.M{"$set": set, "$inc": bson.M{"version": 1}}
+	err = dbh.withTransaction(ctx, func(ctx context.Context) error {
+		err := dbh.GetRecipeCollection().FindOneAndUpdate(ctx, filter, update,
+			options.FindOneAndUpdate().SetReturnDocument(options.After)).Decode(recipe)
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			return dbh.missedWriteError(ctx, recipe.ID)
+		}
+		if err != nil {
+			return err
+		}
+		if err := dbh.recordRevision(ctx, recipe); err != nil {
+			return err
+		}
+		return dbh.recordEvent(ctx, RecipeUpdated, recipe)
+	})
+	if err != nil {
 		l.WithError(err).Error("Error when trying to upsert recipe")
-		return err
+		return wrapError(err)
 	}
 	return nil
 }
+
+// Encode the recipe into a $set document leaving its identity, tenant, version, trash date and images untouched
+func toSetDocument(recipe *Recipe) (bson.M, error) {
+	raw, err := bson.Marshal(recipe)
+	if err != nil {
+		return nil, err
+	}
+	set := bson.M{}
+	if err := bson.Unmarshal(raw, &set); err != nil {
+		return nil, err
+	}
+	delete(set, "_id")
+	delete(set, "version")
+	delete(set, "deleted_at")
+	delete(set, "tenant_id")
+	delete(set, "images")
+	return set, nil
+}
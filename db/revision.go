@@ -0,0 +1,121 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// ErrRevisionNotFound is returned when a recipe has no revision with the requested number
+var ErrRevisionNotFound = errors.New("revision not found")
+
+// AnonymousActor is the author of the revisions made without a known user
+const AnonymousActor = "anonymous"
+
+// Revision is an immutable snapshot of a recipe, recorded by every accepted write of its content.
+// Its number is the version of the recipe it snapshots.
+type Revision struct {
+	ID        primitive.ObjectID `json:"-" bson:"_id"`
+	RecipeID  primitive.ObjectID `json:"recipe_id" bson:"recipe_id"`
+	Number    int64              `json:"revision" bson:"revision"`
+	Author    string             `json:"author" bson:"author"`
+	CreatedAt time.Time          `json:"created_at" bson:"created_at"`
+	// The snapshot is left out of the revision listings
+	Recipe   *Recipe `json:"recipe,omitempty" bson:"recipe,omitempty"`
+	TenantID string  `json:"-" bson:"tenant_id,omitempty"`
+}
+
+type actorKey struct{}
+
+// WithActor returns a context recording the writes made with it as done by the actor
+func WithActor(ctx context.Context, actor string) context.Context {
+	return context.WithValue(ctx, actorKey{}, actor)
+}
+
+// ActorFrom returns the actor of the context, AnonymousActor if there is none
+func ActorFrom(ctx context.Context) string {
+	if actor, ok := ctx.Value(actorKey{}).(string); ok && actor != "" {
+		return actor
+	}
+	return AnonymousActor
+}
+
+// Snapshot the recipe as the revision written by the actor of the context
+func newRevision(ctx context.Context, recipe *Recipe) Revision {
+	snapshot := cloneRecipe(*recipe)
+	// The images are not versioned, reverting a recipe leaves them untouched
+	snapshot.Images = nil
+	return Revision{
+		ID:        primitive.NewObjectID(),
+		RecipeID:  recipe.ID,
+		Number:    recipe.Version,
+		Author:    ActorFrom(ctx),
+		CreatedAt: now(),
+		Recipe:    &snapshot,
+		TenantID:  recipe.TenantID,
+	}
+}
+
+func (dbh *DbHandler) GetRevisionCollection() *mongo.Collection {
+	return dbh.Client.Database(dbh.DBName).Collection(dbh.recipesCollectionName() + "_revisions")
+}
+
+// Record the revision of a write, in the transaction of the write when there is one
+func (dbh *DbHandler) recordRevision(ctx context.Context, recipe *Recipe) error {
+	_, err := dbh.GetRevisionCollection().InsertOne(ctx, newRevision(ctx, recipe))
+	return err
+}
+
+// FindRevisions returns the revisions of the recipe without their snapshot, oldest first
+func (dbh *DbHandler) FindRevisions(ctx context.Context, l *logrus.Entry, recipeID string) ([]Revision, error) {
+	ctx, end := startOperation(ctx, "FindRevisions", dbh.Timeouts.Read)
+	defer end()
+	l = l.WithContext(ctx)
+	dbh, err := dbh.forTenant(ctx)
+	if err != nil {
+		l.WithError(err).Error("Error when trying to resolve the tenant")
+		return nil, wrapError(err)
+	}
+	objectID, _ := primitive.ObjectIDFromHex(recipeID)
+	cursor, err := dbh.GetRevisionCollection().Find(ctx, dbh.scope(bson.M{"recipe_id": objectID}), options.Find().
+		SetProjection(bson.M{"recipe": 0}).
+		SetSort(bson.M{"revision": 1}))
+	if err != nil {
+		l.WithError(err).Error("Error when trying to find revisions")
+		return nil, wrapError(err)
+	}
+	revisions := make([]Revision, 0)
+	if err := cursor.All(ctx, &revisions); err != nil {
+		l.WithError(err).Error("Error when trying to decode revisions")
+		return nil, wrapError(err)
+	}
+	return revisions, nil
+}
+
+func (dbh *DbHandler) FindRevision(ctx context.Context, l *logrus.Entry, recipeID string, number int64) (*Revision, error) {
+	ctx, end := startOperation(ctx, "FindRevision", dbh.Timeouts.Read)
+	defer end()
+	l = l.WithContext(ctx)
+	dbh, err := dbh.forTenant(ctx)
+	if err != nil {
+		l.WithError(err).Error("Error when trying to resolve the tenant")
+		return nil, wrapError(err)
+	}
+	objectID, _ := primitive.ObjectIDFromHex(recipeID)
+	var revision Revision
+	err = dbh.GetRevisionCollection().FindOne(ctx, dbh.scope(bson.M{"recipe_id": objectID, "revision": number})).Decode(&revision)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		err = ErrRevisionNotFound
+	}
+	if err != nil {
+		l.WithError(err).Error("Error when trying to find revision")
+		return nil, wrapError(err)
+	}
+	return &revision, nil
+}
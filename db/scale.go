@@ -0,0 +1,74 @@
+package db
+
+import (
+	"math"
+	"recipes/units"
+)
+
+// Scaling tells how the amount of an ingredient follows the servings of its recipe
+type Scaling string
+
+const (
+	LinearScaling Scaling = "linear"
+	// The spices and the leavening grow slower than the servings, a fixed amount never changes (e.g. a bay leaf)
+	SpiceScaling     Scaling = "spice"
+	LeaveningScaling Scaling = "leavening"
+	FixedScaling     Scaling = "fixed"
+)
+
+// The amounts are multiplied by the scaling factor raised to these exponents
+var scalingExponents = map[Scaling]float64{
+	"":               1,
+	LinearScaling:    1,
+	SpiceScaling:     0.75,
+	LeaveningScaling: 0.85,
+	FixedScaling:     0,
+}
+
+// Scale returns a copy of the recipe whose ingredient amounts are multiplied by the factor according to their scaling.
+// The amounts move to the unit they fit best, e.g. 1200 g to 1.2 kg, and are rounded to what a kitchen can measure.
+func (r *Recipe) Scale(factor float64) *Recipe {
+	scaled := *r
+	scaled.Ingredients = make([]Ingredient, len(r.Ingredients))
+	for i, ingredient := range r.Ingredients {
+		exponent, ok := scalingExponents[ingredient.Scaling]
+		if !ok {
+			exponent = 1
+		}
+		amount := ingredient.Amount * math.Pow(factor, exponent)
+		if unit, ok := units.ValueOfAbbreviation(ingredient.Unit); ok && exponent != 0 {
+			amount, unit = units.Fit(amount, unit)
+			ingredient.Amount = roundScaled(amount, unit)
+			ingredient.Unit = unit.Abbreviation
+		} else {
+			ingredient.Amount = amount
+		}
+		scaled.Ingredients[i] = ingredient
+	}
+	return &scaled
+}
+
+// The scaled amounts of the spoons and cups are measured by quarters, down to a quarter teaspoon
+func roundScaled(amount float64, unit units.Unit) float64 {
+	if unit.Fractional && unit.Dimension != units.Count {
+		return max(units.RoundStep(amount, 0.25), 0.25)
+	}
+	return units.RoundKitchen(amount, unit)
+}
+
+// InSystem returns a copy of the recipe whose ingredient amounts are converted into the units of the measurement system.
+// The converted amounts are approximate, the amounts of the units of the system or of no system are left as they are.
+func (r *Recipe) InSystem(system units.System) *Recipe {
+	converted := *r
+	converted.Ingredients = make([]Ingredient, len(r.Ingredients))
+	for i, ingredient := range r.Ingredients {
+		if unit, ok := units.ValueOfAbbreviation(ingredient.Unit); ok {
+			var approximate bool
+			ingredient.Amount, unit, approximate = units.ToSystem(ingredient.Amount, unit, system)
+			ingredient.Unit = unit.Abbreviation
+			ingredient.Approximate = ingredient.Approximate || approximate
+		}
+		converted.Ingredients[i] = ingredient
+	}
+	return &converted
+}
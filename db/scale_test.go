@@ -0,0 +1,91 @@
+package db
+
+import (
+	"recipes/units"
+	"reflect"
+	"testing"
+)
+
+func TestScaleRecipe(t *testing.T) {
+	recipe := &Recipe{
+		Name:     "Clafoutis",
+		Servings: 4,
+		Ingredients: []Ingredient{
+			{ID: "flour", Amount: 600, Unit: "g"},
+			{ID: "egg", Amount: 3, Unit: "is"},
+			{ID: "milk", Amount: 0.5, Unit: "cs"},
+			{ID: "salt", Amount: 1, Unit: "tsp", Scaling: SpiceScaling},
+			{ID: "baking powder", Amount: 2, Unit: "tsp", Scaling: LeaveningScaling},
+			{ID: "vanilla pod", Amount: 1, Unit: "i", Scaling: FixedScaling},
+		},
+	}
+	tests := []struct {
+		name     string
+		factor   float64
+		expected []Ingredient
+	}{
+		{"Scale up", 2.5, []Ingredient{
+			{ID: "flour", Amount: 1.5, Unit: "kg"},
+			{ID: "egg", Amount: 8, Unit: "is"},
+			{ID: "milk", Amount: 1.25, Unit: "cs"},
+			{ID: "salt", Amount: 2, Unit: "tsp", Scaling: SpiceScaling},
+			{ID: "baking powder", Amount: 1.5, Unit: "tbsp", Scaling: LeaveningScaling},
+			{ID: "vanilla pod", Amount: 1, Unit: "i", Scaling: FixedScaling},
+		}},
+		{"Scale down", 0.25, []Ingredient{
+			{ID: "flour", Amount: 150, Unit: "g"},
+			{ID: "egg", Amount: 1, Unit: "i"},
+			{ID: "milk", Amount: 2, Unit: "tbsp"},
+			{ID: "salt", Amount: 0.25, Unit: "tsp", Scaling: SpiceScaling},
+			{ID: "baking powder", Amount: 0.5, Unit: "tsp", Scaling: LeaveningScaling},
+			{ID: "vanilla pod", Amount: 1, Unit: "i", Scaling: FixedScaling},
+		}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			scaled := recipe.Scale(test.factor)
+			if !reflect.DeepEqual(scaled.Ingredients, test.expected) {
+				t.Errorf("Expected %+v, got %+v", test.expected, scaled.Ingredients)
+			}
+			if recipe.Ingredients[0].Amount != 600 || recipe.Ingredients[0].Unit != "g" {
+				t.Errorf("Expected the recipe left untouched, got %+v", recipe.Ingredients[0])
+			}
+		})
+	}
+}
+
+func TestRecipeInSystem(t *testing.T) {
+	recipe := &Recipe{
+		Name: "Pancakes",
+		Ingredients: []Ingredient{
+			{ID: "flour", Amount: 200, Unit: "g"},
+			{ID: "milk", Amount: 1.5, Unit: "cs"},
+			{ID: "sugar", Amount: 2, Unit: "tbsp"},
+			{ID: "egg", Amount: 2, Unit: "is"},
+		},
+	}
+	imperial := recipe.InSystem(units.Imperial)
+	expected := []Ingredient{
+		{ID: "flour", Amount: 7, Unit: "oz", Approximate: true},
+		{ID: "milk", Amount: 1.5, Unit: "cs"},
+		{ID: "sugar", Amount: 2, Unit: "tbsp"},
+		{ID: "egg", Amount: 2, Unit: "is"},
+	}
+	if !reflect.DeepEqual(imperial.Ingredients, expected) {
+		t.Errorf("Expected %+v, got %+v", expected, imperial.Ingredients)
+	}
+
+	metric := recipe.InSystem(units.Metric)
+	expected = []Ingredient{
+		{ID: "flour", Amount: 200, Unit: "g"},
+		{ID: "milk", Amount: 355, Unit: "ml", Approximate: true},
+		{ID: "sugar", Amount: 2, Unit: "tbsp"},
+		{ID: "egg", Amount: 2, Unit: "is"},
+	}
+	if !reflect.DeepEqual(metric.Ingredients, expected) {
+		t.Errorf("Expected %+v, got %+v", expected, metric.Ingredients)
+	}
+	if recipe.Ingredients[1].Unit != "cs" || recipe.Ingredients[0].Approximate {
+		t.Errorf("Expected the recipe left untouched, got %+v", recipe.Ingredients)
+	}
+}
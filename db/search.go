@@ -0,0 +1,287 @@
+package db
+
+import (
+	"cmp"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"slices"
+	"strings"
+	"unicode"
+
+	"github.com/sirupsen/logrus"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"golang.org/x/text/runes"
+	"golang.org/x/text/transform"
+	"golang.org/x/text/unicode/norm"
+)
+
+const textIndexName = "recipe_text"
+
+// Weight of each searchable field in the relevance score
+var searchWeights = map[string]int{
+	"name":            10,
+	"description":     5,
+	"steps":           2,
+	"metadata_values": 1,
+}
+
+// SearchResult is a recipe matching a search with its relevance score.
+// Highlights holds, by field, the matched texts with the terms wrapped in <em> tags.
+type SearchResult struct {
+	Recipe     `bson:",inline"`
+	Score      float64             `json:"score" bson:"score"`
+	Highlights map[string][]string `json:"highlights" bson:"-"`
+}
+
+// SearchPage is one page of search results, ordered by decreasing score
+type SearchPage struct {
+	Results    []SearchResult `json:"results"`
+	NextCursor string         `json:"next_cursor,omitempty"`
+	Total      int64          `json:"total"`
+}
+
+// The search cursor is the offset of the next page in the results of the query
+type searchCursor struct {
+	Query  string `json:"q"`
+	Offset int    `json:"o"`
+}
+
+func encodeSearchCursor(query string, offset int) string {
+	raw, _ := json.Marshal(searchCursor{Query: query, Offset: offset})
+	return base64.RawURLEncoding.EncodeToString(raw)
+}
+
+func decodeSearchCursor(query string, opts ListOptions) (int, error) {
+	if opts.Cursor == "" {
+		return 0, nil
+	}
+	raw, err := base64.RawURLEncoding.DecodeString(opts.Cursor)
+	if err != nil {
+		return 0, ErrInvalidCursor
+	}
+	var c searchCursor
+	if err := json.Unmarshal(raw, &c); err != nil || c.Query != query || c.Offset < 0 {
+		return 0, ErrInvalidCursor
+	}
+	return c.Offset, nil
+}
+
+// Lower the case and remove the diacritics of the text, as the mongo text index does
+func fold(text string) string {
+	folded, _, err := transform.String(transform.Chain(norm.NFD, runes.Remove(runes.In(unicode.Mn)), norm.NFC), text)
+	if err != nil {
+		folded = text
+	}
+	return strings.ToLower(folded)
+}
+
+// Split a search query into folded terms
+func searchTerms(query string) []string {
+	terms := strings.FieldsFunc(fold(query), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsNumber(r)
+	})
+	slices.Sort(terms)
+	return slices.Compact(terms)
+}
+
+// Call fn with the searchable fields of the recipe and their texts
+func searchableFields(recipe *Recipe, fn func(field string, weight int, text string)) {
+	fn("name", searchWeights["name"], recipe.Name)
+	fn("description", searchWeights["description"], recipe.Description)
+	for _, step := range recipe.Steps {
+		fn("steps", searchWeights["steps"], step)
+	}
+	keys := make([]string, 0, len(recipe.Metadata))
+	for key := range recipe.Metadata {
+		keys = append(keys, key)
+	}
+	slices.Sort(keys)
+	for _, key := range keys {
+		fn("metadata."+key, searchWeights["metadata_values"], recipe.Metadata[key])
+	}
+}
+
+// Wrap the words of the text matching one of the terms in <em> tags
+func highlightText(text string, terms []string) (string, int) {
+	var b strings.Builder
+	matches := 0
+	word := make([]rune, 0)
+	flush := func() {
+		if len(word) == 0 {
+			return
+		}
+		if slices.Contains(terms, fold(string(word))) {
+			matches++
+			b.WriteString("<em>" + string(word) + "</em>")
+		} else {
+			b.WriteString(string(word))
+		}
+		word = word[:0]
+	}
+	for _, r := range text {
+		if unicode.IsLetter(r) || unicode.IsNumber(r) {
+			word = append(word, r)
+			continue
+		}
+		flush()
+		b.WriteRune(r)
+	}
+	flush()
+	return b.String(), matches
+}
+
+// Highlight the terms in the recipe and compute the weighted number of matches
+func highlight(recipe *Recipe, terms []string) (map[string][]string, int) {
+	highlights := make(map[string][]string)
+	score := 0
+	searchableFields(recipe, func(field string, weight int, text string) {
+		highlighted, matches := highlightText(text, terms)
+		if matches > 0 {
+			highlights[field] = append(highlights[field], highlighted)
+			score += weight * matches
+		}
+	})
+	return highlights, score
+}
+
+// Rank the recipes matching the query in memory and cut the requested page
+func searchInMemory(recipes []Recipe, query string, opts ListOptions) (*SearchPage, error) {
+	opts = opts.normalize()
+	offset, err := decodeSearchCursor(query, opts)
+	if err != nil {
+		return nil, err
+	}
+	terms := searchTerms(query)
+	results := make([]SearchResult, 0)
+	for _, recipe := range recipes {
+		highlights, score := highlight(&recipe, terms)
+		if score > 0 {
+			results = append(results, SearchResult{Recipe: recipe, Score: float64(score), Highlights: highlights})
+		}
+	}
+	slices.SortStableFunc(results, func(a, b SearchResult) int {
+		return cmp.Compare(b.Score, a.Score)
+	})
+
+	start := min(offset, len(results))
+	end := min(start+opts.Limit, len(results))
+	page := &SearchPage{Results: results[start:end], Total: int64(len(results))}
+	if end < len(results) {
+		page.NextCursor = encodeSearchCursor(query, end)
+	}
+	return page, nil
+}
+
+// EnsureIndexes creates the indexes of the recipe collection if they do not exist yet
+func (dbh *DbHandler) EnsureIndexes(ctx context.Context) error {
+	ctx, end := startOperation(ctx, "EnsureIndexes", dbh.Timeouts.Write)
+	defer end()
+	weights := bson.D{}
+	keys := bson.D{}
+	for _, field := range []string{"name", "description", "steps", "metadata_values"} {
+		keys = append(keys, bson.E{Key: field, Value: "text"})
+		weights = append(weights, bson.E{Key: field, Value: searchWeights[field]})
+	}
+	_, err := dbh.GetRecipeCollection().Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys: keys,
+		Options: options.Index().
+			SetName(textIndexName).
+			SetWeights(weights).
+			SetDefaultLanguage("none"),
+	})
+	if err != nil {
+		return wrapError(fmt.Errorf("creating the %v index: %w", textIndexName, err))
+	}
+	// A recipe has one revision by version
+	_, err = dbh.GetRevisionCollection().Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys:    bson.D{{Key: "recipe_id", Value: 1}, {Key: "revision", Value: 1}},
+		Options: options.Index().SetName("recipe_revision").SetUnique(true),
+	})
+	if err != nil {
+		return wrapError(fmt.Errorf("creating the recipe_revision index: %w", err))
+	}
+	_, err = dbh.GetRecipeCollection().Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys:    bson.D{{Key: "name_key", Value: 1}},
+		Options: options.Index().SetName("name_key"),
+	})
+	if err != nil {
+		return wrapError(fmt.Errorf("creating the name_key index: %w", err))
+	}
+	// The migrations 1 and 4 create them in the recipes collection, the collections of the tenants get them here.
+	// Creating them again with the same name and keys does nothing.
+	_, err = dbh.GetRecipeCollection().Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{Keys: bson.D{{Key: "author", Value: 1}, {Key: "_id", Value: 1}}, Options: options.Index().SetName("author")},
+		{Keys: bson.D{{Key: "ingredients._id", Value: 1}}, Options: options.Index().SetName("ingredients_id")},
+		{Keys: bson.D{{Key: "created_at", Value: 1}, {Key: "_id", Value: 1}}, Options: options.Index().SetName("created_at")},
+		{Keys: bson.D{{Key: "deleted_at", Value: 1}}, Options: options.Index().SetName("deleted_at").SetSparse(true)},
+	})
+	if err != nil {
+		return wrapError(fmt.Errorf("creating the listing indexes: %w", err))
+	}
+	return nil
+}
+
+func (dbh *DbHandler) SearchRecipes(ctx context.Context, l *logrus.Entry, query string, opts ListOptions) (*SearchPage, error) {
+	ctx, end := startOperation(ctx, "SearchRecipes", dbh.Timeouts.Read)
+	defer end()
+	l = l.WithContext(ctx)
+	dbh, err := dbh.forTenant(ctx)
+	if err != nil {
+		l.WithError(err).Error("Error when trying to resolve the tenant")
+		return nil, wrapError(err)
+	}
+	opts = opts.normalize()
+	offset, err := decodeSearchCursor(query, opts)
+	if err != nil {
+		l.WithError(err).Warn("Error when trying to decode the cursor")
+		return nil, err
+	}
+	// The collection of an unknown tenant has no text index to search
+	if dbh.Tenancy == CollectionPerTenant {
+		registered, err := dbh.registered(ctx)
+		if err != nil {
+			l.WithError(err).Error("Error when trying to find the tenant")
+			return nil, wrapError(err)
+		}
+		if !registered {
+			return &SearchPage{Results: []SearchResult{}}, nil
+		}
+	}
+
+	filter := dbh.live(bson.M{"$text": bson.M{"$search": query}})
+	total, err := dbh.GetRecipeCollection().CountDocuments(ctx, filter)
+	if err != nil {
+		l.WithError(err).Error("Error when trying to count the search results")
+		return nil, wrapError(err)
+	}
+
+	score := bson.M{"$meta": "textScore"}
+	cursor, err := dbh.GetRecipeCollection().Find(ctx, filter, options.Find().
+		SetProjection(bson.M{"score": score}).
+		SetSort(bson.D{{Key: "score", Value: score}, {Key: "_id", Value: 1}}).
+		SetSkip(int64(offset)).
+		SetLimit(int64(opts.Limit)))
+	if err != nil {
+		l.WithError(err).Error("Error when trying to search recipes")
+		return nil, wrapError(err)
+	}
+	results := make([]SearchResult, 0, opts.Limit)
+	if err := cursor.All(ctx, &results); err != nil {
+		l.WithError(err).Error("Error when trying to decode the search results")
+		return nil, wrapError(err)
+	}
+
+	terms := searchTerms(query)
+	for i := range results {
+		results[i].Highlights, _ = highlight(&results[i].Recipe, terms)
+	}
+	page := &SearchPage{Results: results, Total: total}
+	if next := offset + len(results); int64(next) < total {
+		page.NextCursor = encodeSearchCursor(query, next)
+	}
+	return page, nil
+}
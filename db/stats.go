@@ -0,0 +1,229 @@
+package db
+
+import (
+	"context"
+	"slices"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// Number of ingredients reported by the catalog statistics
+const TopIngredientsLimit = 10
+
+// Lower bounds of the total time buckets, the last bucket has no upper bound
+var totalTimeBounds = []time.Duration{0, 15 * time.Minute, 30 * time.Minute, time.Hour, 2 * time.Hour}
+
+// TimeBucket counts the recipes whose total time is in [Min, Max), Max is zero for the last bucket
+type TimeBucket struct {
+	MinMinutes int64 `json:"min_minutes"`
+	MaxMinutes int64 `json:"max_minutes,omitempty"`
+	Count      int64 `json:"count"`
+}
+
+// WeekCount is the number of recipes created in the week starting on Monday at 00:00 UTC
+type WeekCount struct {
+	Week  time.Time `json:"week" bson:"_id"`
+	Count int64     `json:"count" bson:"count"`
+}
+
+// CatalogStats describe the recipes out of the trash
+type CatalogStats struct {
+	Recipes         int64        `json:"recipes"`
+	Dishes          []FacetCount `json:"dishes"`
+	Authors         []FacetCount `json:"authors"`
+	TopIngredients  []FacetCount `json:"top_ingredients"`
+	AverageServings float64      `json:"average_servings"`
+	TotalTime       []TimeBucket `json:"total_time"`
+	CreatedPerWeek  []WeekCount  `json:"created_per_week"`
+	ComputedAt      time.Time    `json:"computed_at"`
+}
+
+// Empty buckets for every bound of the total time distribution
+func newTimeBuckets() []TimeBucket {
+	buckets := make([]TimeBucket, len(totalTimeBounds))
+	for i, bound := range totalTimeBounds {
+		buckets[i].MinMinutes = int64(bound / time.Minute)
+		if i+1 < len(totalTimeBounds) {
+			buckets[i].MaxMinutes = int64(totalTimeBounds[i+1] / time.Minute)
+		}
+	}
+	return buckets
+}
+
+// Index of the bucket of a total time in seconds
+func timeBucketIndex(seconds int64) int {
+	index := 0
+	for i, bound := range totalTimeBounds {
+		if seconds >= int64(bound/time.Second) {
+			index = i
+		}
+	}
+	return index
+}
+
+// Start of the week of the date, on Monday at 00:00 UTC
+func weekOf(date time.Time) time.Time {
+	date = date.UTC()
+	daysSinceMonday := (int(date.Weekday()) + 6) % 7
+	return time.Date(date.Year(), date.Month(), date.Day()-daysSinceMonday, 0, 0, 0, 0, time.UTC)
+}
+
+// Limit the counts to the first n
+func topCounts(counts []FacetCount, n int) []FacetCount {
+	if len(counts) > n {
+		return counts[:n]
+	}
+	return counts
+}
+
+// RecipeStats computes the catalog statistics in one aggregation
+func (dbh *DbHandler) RecipeStats(ctx context.Context, l *logrus.Entry) (*CatalogStats, error) {
+	ctx, end := startOperation(ctx, "RecipeStats", dbh.Timeouts.Read)
+	defer end()
+	l = l.WithContext(ctx)
+	dbh, err := dbh.forTenant(ctx)
+	if err != nil {
+		l.WithError(err).Error("Error when trying to resolve the tenant")
+		return nil, wrapError(err)
+	}
+
+	group := func(field string) bson.A {
+		return bson.A{bson.M{"$group": bson.M{"_id": "$" + field, "count": bson.M{"$sum": 1}}}}
+	}
+	boundaries := make(bson.A, len(totalTimeBounds))
+	for i, bound := range totalTimeBounds {
+		boundaries[i] = int64(bound / time.Second)
+	}
+	cursor, err := dbh.GetRecipeCollection().Aggregate(ctx, bson.A{
+		bson.M{"$match": dbh.live(bson.M{})},
+		bson.M{"$facet": bson.M{
+			"summary": bson.A{bson.M{"$group": bson.M{
+				"_id":              nil,
+				"recipes":          bson.M{"$sum": 1},
+				"average_servings": bson.M{"$avg": "$servings"},
+			}}},
+			"dishes":  group("dish"),
+			"authors": group("author"),
+			"ingredients": bson.A{
+				bson.M{"$unwind": "$ingredients"},
+				// An ingredient listed twice in a recipe counts once
+				bson.M{"$group": bson.M{"_id": bson.M{"recipe": "$_id", "ingredient": "$ingredients._id"}}},
+				bson.M{"$group": bson.M{"_id": "$_id.ingredient", "count": bson.M{"$sum": 1}}},
+				bson.M{"$sort": bson.D{{Key: "count", Value: -1}, {Key: "_id", Value: 1}}},
+				bson.M{"$limit": TopIngredientsLimit},
+			},
+			"total_time": bson.A{bson.M{"$bucket": bson.M{
+				"groupBy":    bson.M{"$ifNull": bson.A{"$total_time", 0}},
+				"boundaries": boundaries,
+				// The times beyond the last bound fall in the last bucket
+				"default": boundaries[len(boundaries)-1],
+				"output":  bson.M{"count": bson.M{"$sum": 1}},
+			}}},
+			"weeks": bson.A{
+				bson.M{"$match": bson.M{"created_at": bson.M{"$type": "date"}}},
+				bson.M{"$group": bson.M{
+					"_id":   bson.M{"$dateTrunc": bson.M{"date": "$created_at", "unit": "week", "startOfWeek": "monday"}},
+					"count": bson.M{"$sum": 1},
+				}},
+				bson.M{"$sort": bson.M{"_id": 1}},
+			},
+		}},
+	})
+	if err != nil {
+		l.WithError(err).Error("Error when trying to aggregate the catalog statistics")
+		return nil, wrapError(err)
+	}
+	var results []struct {
+		Summary []struct {
+			Recipes         int64   `bson:"recipes"`
+			AverageServings float64 `bson:"average_servings"`
+		} `bson:"summary"`
+		Dishes      []FacetCount `bson:"dishes"`
+		Authors     []FacetCount `bson:"authors"`
+		Ingredients []FacetCount `bson:"ingredients"`
+		TotalTime   []struct {
+			Bound int64 `bson:"_id"`
+			Count int64 `bson:"count"`
+		} `bson:"total_time"`
+		Weeks []WeekCount `bson:"weeks"`
+	}
+	if err := cursor.All(ctx, &results); err != nil {
+		l.WithError(err).Error("Error when trying to decode the catalog statistics")
+		return nil, wrapError(err)
+	}
+
+	stats := &CatalogStats{
+		Dishes:         make([]FacetCount, 0),
+		Authors:        make([]FacetCount, 0),
+		TopIngredients: make([]FacetCount, 0),
+		TotalTime:      newTimeBuckets(),
+		CreatedPerWeek: make([]WeekCount, 0),
+		ComputedAt:     now(),
+	}
+	if len(results) == 0 {
+		return stats, nil
+	}
+	result := results[0]
+	if len(result.Summary) > 0 {
+		stats.Recipes = result.Summary[0].Recipes
+		stats.AverageServings = result.Summary[0].AverageServings
+	}
+	stats.Dishes = append(stats.Dishes, result.Dishes...)
+	stats.Authors = append(stats.Authors, result.Authors...)
+	stats.TopIngredients = append(stats.TopIngredients, result.Ingredients...)
+	sortFacet(stats.Dishes)
+	sortFacet(stats.Authors)
+	for _, bucket := range result.TotalTime {
+		stats.TotalTime[timeBucketIndex(bucket.Bound)].Count += bucket.Count
+	}
+	for _, week := range result.Weeks {
+		stats.CreatedPerWeek = append(stats.CreatedPerWeek, WeekCount{Week: week.Week.UTC(), Count: week.Count})
+	}
+	return stats, nil
+}
+
+// Compute the statistics of the recipes out of the trash, for the stores computing them in memory
+func computeStats(recipes []Recipe) *CatalogStats {
+	facets := countFacets(recipes)
+	stats := &CatalogStats{
+		Recipes:        int64(len(recipes)),
+		Dishes:         facets.Dish,
+		Authors:        facets.Author,
+		TotalTime:      newTimeBuckets(),
+		CreatedPerWeek: make([]WeekCount, 0),
+		ComputedAt:     now(),
+	}
+	ingredients := make(map[string]int64)
+	weeks := make(map[time.Time]int64)
+	servings := 0
+	for i := range recipes {
+		servings += recipes[i].Servings
+		seen := make(map[string]bool)
+		for _, ingredient := range recipes[i].Ingredients {
+			if !seen[ingredient.ID] {
+				seen[ingredient.ID] = true
+				ingredients[ingredient.ID]++
+			}
+		}
+		stats.TotalTime[timeBucketIndex(recipes[i].TotalTime)].Count++
+		if !recipes[i].CreatedAt.IsZero() {
+			weeks[weekOf(recipes[i].CreatedAt)]++
+		}
+	}
+	if len(recipes) > 0 {
+		stats.AverageServings = float64(servings) / float64(len(recipes))
+	}
+	stats.TopIngredients = make([]FacetCount, 0, len(ingredients))
+	for id, count := range ingredients {
+		stats.TopIngredients = append(stats.TopIngredients, FacetCount{Value: id, Count: count})
+	}
+	sortFacet(stats.TopIngredients)
+	stats.TopIngredients = topCounts(stats.TopIngredients, TopIngredientsLimit)
+	for week, count := range weeks {
+		stats.CreatedPerWeek = append(stats.CreatedPerWeek, WeekCount{Week: week, Count: count})
+	}
+	slices.SortFunc(stats.CreatedPerWeek, func(a, b WeekCount) int { return a.Week.Compare(b.Week) })
+	return stats
+}
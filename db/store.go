@@ -0,0 +1,45 @@
+package db
+
+import (
+	"context"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// RecipeStore is the set of recipe operations the API relies on.
+// DbHandler implements it on top of MongoDB, MemoryStore keeps everything in memory.
+type RecipeStore interface {
+	NewID() primitive.ObjectID
+	Ping(ctx context.Context) error
+	FindAllRecipes(ctx context.Context, l *logrus.Entry, opts ListOptions) (*RecipePage, error)
+	FindRecipeByID(ctx context.Context, l *logrus.Entry, id string) (*Recipe, error)
+	FindRecipesByAuthorID(ctx context.Context, l *logrus.Entry, author string, opts ListOptions) (*RecipePage, error)
+	FindRecipesByIngredientID(ctx context.Context, l *logrus.Entry, id string, opts ListOptions) (*RecipePage, error)
+	FindRecipeByTitle(ctx context.Context, l *logrus.Entry, title string) (*Recipe, error)
+	SearchRecipes(ctx context.Context, l *logrus.Entry, query string, opts ListOptions) (*SearchPage, error)
+	SaveRecipe(ctx context.Context, l *logrus.Entry, recipe *Recipe) error
+	UpsertOne(ctx context.Context, l *logrus.Entry, recipe *Recipe) error
+	DeleteRecipeByID(ctx context.Context, l *logrus.Entry, id string, version int64) error
+	FindTrashedRecipes(ctx context.Context, l *logrus.Entry, opts ListOptions) (*RecipePage, error)
+	RestoreRecipeByID(ctx context.Context, l *logrus.Entry, id string) (*Recipe, error)
+	PurgeDeletedRecipes(ctx context.Context, l *logrus.Entry, before time.Time) (int64, error)
+	FindRevisions(ctx context.Context, l *logrus.Entry, recipeID string) ([]Revision, error)
+	FindRevision(ctx context.Context, l *logrus.Entry, recipeID string, number int64) (*Revision, error)
+	PendingEvents(ctx context.Context, l *logrus.Entry, limit int) ([]Event, error)
+	ClaimEvents(ctx context.Context, l *logrus.Entry, owner string, lease time.Duration, limit int) ([]Event, error)
+	MarkEventsPublished(ctx context.Context, l *logrus.Entry, ids []primitive.ObjectID) error
+	ImportRecipes(ctx context.Context, l *logrus.Entry, recipes []Recipe, opts ImportOptions) ([]ImportOutcome, error)
+	ExportRecipes(ctx context.Context, l *logrus.Entry, fn func(recipe *Recipe) error) error
+	RecipeStats(ctx context.Context, l *logrus.Entry) (*CatalogStats, error)
+	FindDuplicates(ctx context.Context, l *logrus.Entry, recipe *Recipe) ([]Duplicate, error)
+	FindDuplicateClusters(ctx context.Context, l *logrus.Entry) ([]DuplicateCluster, error)
+	AddRecipeImage(ctx context.Context, l *logrus.Entry, recipeID string, upload *ImageUpload, cover bool) (*Image, error)
+	OpenRecipeImage(ctx context.Context, l *logrus.Entry, recipeID string, imageID string, size string) (*ImageFile, error)
+}
+
+var (
+	_ RecipeStore = (*DbHandler)(nil)
+	_ RecipeStore = (*MemoryStore)(nil)
+)
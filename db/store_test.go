@@ -0,0 +1,911 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	bolt "go.etcd.io/bbolt"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func newTestRecipe(store RecipeStore, name string, author string, ingredientIDs ...string) Recipe {
+	ingredients := make([]Ingredient, len(ingredientIDs))
+	for i, id := range ingredientIDs {
+		ingredients[i] = Ingredient{ID: id, Amount: 100, Unit: "g"}
+	}
+	return Recipe{
+		ID:          store.NewID(),
+		Name:        name,
+		Author:      author,
+		Description: "A test recipe",
+		Dish:        Main,
+		Servings:    4,
+		Metadata:    map[string]string{"cook time": "30"},
+		Timers:      []Timer{{Name: "cooking time", Amount: 10, Unit: "minutes"}},
+		Steps:       []string{"Cook", "Eat"},
+		Ingredients: ingredients,
+	}
+}
+
+// testRecipeStore runs the behaviour every RecipeStore implementation must share
+func testRecipeStore(t *testing.T, newStore func(t *testing.T) RecipeStore) {
+	l := logrus.WithField("test", t.Name())
+	ctx := context.Background()
+
+	t.Run("Save and find a recipe by ID", func(t *testing.T) {
+		store := newStore(t)
+		recipe := newTestRecipe(store, "Pate tomates basilic", "arsene", "tomato", "basil")
+		if err := store.SaveRecipe(ctx, l, &recipe); err != nil {
+			t.Fatalf("Error when trying to save recipe: %v", err)
+		}
+		found, err := store.FindRecipeByID(ctx, l, recipe.ID.Hex())
+		if err != nil {
+			t.Fatalf("Error when trying to find recipe by id: %v", err)
+		}
+		if found.Name != recipe.Name || len(found.Ingredients) != 2 || found.Metadata["cook time"] != "30" {
+			t.Errorf("Expected %+v, got %+v", recipe, found)
+		}
+		if _, err := store.FindRecipeByID(ctx, l, primitive.NewObjectID().Hex()); err == nil {
+			t.Errorf("Expected an error for an unknown ID")
+		}
+	})
+
+	t.Run("Saving twice the same ID fails", func(t *testing.T) {
+		store := newStore(t)
+		recipe := newTestRecipe(store, "Crepes", "arsene", "flour")
+		if err := store.SaveRecipe(ctx, l, &recipe); err != nil {
+			t.Fatalf("Error when trying to save recipe: %v", err)
+		}
+		if err := store.SaveRecipe(ctx, l, &recipe); !errors.Is(err, ErrDuplicateID) {
+			t.Errorf("Expected ErrDuplicateID, got %v", err)
+		}
+	})
+
+	t.Run("Find by author, ingredient and title", func(t *testing.T) {
+		store := newStore(t)
+		for _, recipe := range []Recipe{
+			newTestRecipe(store, "Pate tomates basilic", "arsene", "tomato", "basil"),
+			newTestRecipe(store, "Salade de tomates", "arsene", "tomato"),
+			newTestRecipe(store, "Tarte aux pommes", "louise", "apple", "flour"),
+		} {
+			if err := store.SaveRecipe(ctx, l, &recipe); err != nil {
+				t.Fatalf("Error when trying to save recipe: %v", err)
+			}
+		}
+
+		all, err := store.FindAllRecipes(ctx, l, ListOptions{})
+		if err != nil || len(all.Recipes) != 3 || all.Total != 3 {
+			t.Errorf("Expected 3 recipes, got %v (%v)", all, err)
+		}
+		byAuthor, err := store.FindRecipesByAuthorID(ctx, l, "arsene", ListOptions{})
+		if err != nil || len(byAuthor.Recipes) != 2 {
+			t.Errorf("Expected 2 recipes for arsene, got %v (%v)", byAuthor, err)
+		}
+		byIngredient, err := store.FindRecipesByIngredientID(ctx, l, "flour", ListOptions{})
+		if err != nil || len(byIngredient.Recipes) != 1 || byIngredient.Recipes[0].Name != "Tarte aux pommes" {
+			t.Errorf("Expected the apple pie for flour, got %v (%v)", byIngredient, err)
+		}
+		byTitle, err := store.FindRecipeByTitle(ctx, l, "SALADE")
+		if err != nil || byTitle.Name != "Salade de tomates" {
+			t.Errorf("Expected the tomato salad, got %v (%v)", byTitle, err)
+		}
+	})
+
+	t.Run("Writes compare and swap the version", func(t *testing.T) {
+		store := newStore(t)
+		recipe := newTestRecipe(store, "Crepes", "arsene", "flour")
+		if err := store.SaveRecipe(ctx, l, &recipe); err != nil {
+			t.Fatalf("Error when trying to save recipe: %v", err)
+		}
+		if recipe.Version != InitialVersion || recipe.CreatedAt.IsZero() {
+			t.Fatalf("Expected the first version and a creation date, got %v and %v", recipe.Version, recipe.CreatedAt)
+		}
+		createdAt := recipe.CreatedAt
+
+		first, second := recipe, recipe
+		first.Servings = 2
+		first.CreatedAt = time.Time{}
+		if err := store.UpsertOne(ctx, l, &first); err != nil {
+			t.Fatalf("Error when trying to update recipe: %v", err)
+		}
+		if first.Version != 2 || !first.CreatedAt.Equal(createdAt) {
+			t.Errorf("Expected version 2 created at %v, got %v created at %v", createdAt, first.Version, first.CreatedAt)
+		}
+		second.Servings = 12
+		if err := store.UpsertOne(ctx, l, &second); !errors.Is(err, ErrVersionMismatch) {
+			t.Errorf("Expected ErrVersionMismatch for a stale version, got %v", err)
+		}
+		found, _ := store.FindRecipeByID(ctx, l, recipe.ID.Hex())
+		if found.Servings != 2 || found.Version != 2 {
+			t.Errorf("Expected the first update to be kept, got %v servings at version %v", found.Servings, found.Version)
+		}
+
+		second.Version = AnyVersion
+		if err := store.UpsertOne(ctx, l, &second); err != nil || second.Version != 3 {
+			t.Errorf("Expected version 3 without check, got %v (%v)", second.Version, err)
+		}
+		if err := store.DeleteRecipeByID(ctx, l, recipe.ID.Hex(), 2); !errors.Is(err, ErrVersionMismatch) {
+			t.Errorf("Expected ErrVersionMismatch for a stale version, got %v", err)
+		}
+		if err := store.DeleteRecipeByID(ctx, l, recipe.ID.Hex(), 3); err != nil {
+			t.Errorf("Error when trying to delete recipe: %v", err)
+		}
+		if err := store.DeleteRecipeByID(ctx, l, recipe.ID.Hex(), 3); !errors.Is(err, ErrRecipeNotFound) {
+			t.Errorf("Expected ErrRecipeNotFound, got %v", err)
+		}
+	})
+
+	t.Run("Paginate and sort the recipes", func(t *testing.T) {
+		store := newStore(t)
+		for i, name := range []string{"Crepes", "Aioli", "Bouillabaisse", "Daube", "Entrecote"} {
+			recipe := newTestRecipe(store, name, "arsene")
+			recipe.Servings = 5 - i
+			if err := store.SaveRecipe(ctx, l, &recipe); err != nil {
+				t.Fatalf("Error when trying to save recipe: %v", err)
+			}
+		}
+
+		for _, test := range []struct {
+			opts     ListOptions
+			expected []string
+		}{
+			{ListOptions{Limit: 2, Sort: SortByName}, []string{"Aioli", "Bouillabaisse", "Crepes", "Daube", "Entrecote"}},
+			{ListOptions{Limit: 3, Sort: SortByName, Descending: true}, []string{"Entrecote", "Daube", "Crepes", "Bouillabaisse", "Aioli"}},
+			{ListOptions{Limit: 2, Sort: SortByServings}, []string{"Entrecote", "Daube", "Bouillabaisse", "Aioli", "Crepes"}},
+			{ListOptions{Limit: 4}, []string{"Crepes", "Aioli", "Bouillabaisse", "Daube", "Entrecote"}},
+		} {
+			names := make([]string, 0)
+			opts := test.opts
+			for {
+				page, err := store.FindAllRecipes(ctx, l, opts)
+				if err != nil {
+					t.Fatalf("Error when trying to list recipes with %+v: %v", opts, err)
+				}
+				if page.Total != 5 || len(page.Recipes) > test.opts.Limit {
+					t.Errorf("Expected at most %v of 5 recipes, got %v of %v", test.opts.Limit, len(page.Recipes), page.Total)
+				}
+				for _, recipe := range page.Recipes {
+					names = append(names, recipe.Name)
+				}
+				if page.NextCursor == "" {
+					break
+				}
+				opts.Cursor = page.NextCursor
+			}
+			if !slices.Equal(names, test.expected) {
+				t.Errorf("Expected %v with %+v, got %v", test.expected, test.opts, names)
+			}
+		}
+
+		page, _ := store.FindAllRecipes(ctx, l, ListOptions{Limit: 1, Sort: SortByName})
+		if _, err := store.FindAllRecipes(ctx, l, ListOptions{Cursor: page.NextCursor, Sort: SortByServings}); !errors.Is(err, ErrInvalidCursor) {
+			t.Errorf("Expected ErrInvalidCursor for a cursor of another sort, got %v", err)
+		}
+		if _, err := store.FindAllRecipes(ctx, l, ListOptions{Cursor: "not a cursor"}); !errors.Is(err, ErrInvalidCursor) {
+			t.Errorf("Expected ErrInvalidCursor, got %v", err)
+		}
+	})
+
+	t.Run("Select the fields of the listed recipes", func(t *testing.T) {
+		store := newStore(t)
+		for i, name := range []string{"Crepes", "Aioli", "Bouillabaisse"} {
+			recipe := newTestRecipe(store, name, "arsene", "flour")
+			recipe.Servings = 3 - i
+			store.SaveRecipe(ctx, l, &recipe)
+		}
+
+		// The cursor still follows the servings when they are not selected
+		opts := ListOptions{Limit: 2, Sort: SortByServings, Fields: FieldSet{Include: []string{"name", "dish"}}}
+		var names []string
+		for {
+			page, err := store.FindAllRecipes(ctx, l, opts)
+			if err != nil {
+				t.Fatalf("Error when trying to list recipes: %v", err)
+			}
+			for _, recipe := range page.Recipes {
+				names = append(names, recipe.Name)
+				if recipe.ID.IsZero() || recipe.Dish != Main || recipe.Servings != 0 || recipe.Steps != nil || recipe.Ingredients != nil {
+					t.Errorf("Expected the ID, name and dish only, got %+v", recipe)
+				}
+			}
+			if page.NextCursor == "" {
+				break
+			}
+			opts.Cursor = page.NextCursor
+		}
+		if !slices.Equal(names, []string{"Bouillabaisse", "Aioli", "Crepes"}) {
+			t.Errorf("Expected the recipes by servings, got %v", names)
+		}
+
+		page, err := store.FindRecipesByIngredientID(ctx, l, "flour", ListOptions{Fields: FieldSet{Exclude: []string{"steps", "id"}}})
+		if err != nil || len(page.Recipes) != 3 {
+			t.Fatalf("Expected 3 recipes, got %v (%v)", page, err)
+		}
+		if recipe := page.Recipes[0]; recipe.ID.IsZero() || recipe.Steps != nil || recipe.Name != "Crepes" || len(recipe.Ingredients) != 1 {
+			t.Errorf("Expected every field but the steps, got %+v", recipe)
+		}
+
+		if _, err := store.FindAllRecipes(ctx, l, ListOptions{Fields: FieldSet{Include: []string{"calories"}}}); !errors.Is(err, ErrInvalidFields) {
+			t.Errorf("Expected ErrInvalidFields, got %v", err)
+		}
+	})
+
+	t.Run("Search recipes by relevance", func(t *testing.T) {
+		store := newStore(t)
+		pie := newTestRecipe(store, "Tarte aux pommes", "louise")
+		crepes := newTestRecipe(store, "Crepes", "arsene")
+		crepes.Description = "Des crêpes aux pommes"
+		daube := newTestRecipe(store, "Daube", "arsene")
+		daube.Steps = []string{"Ajouter les pommes de terre"}
+		pasta := newTestRecipe(store, "Pâtes au pesto", "arsene")
+		for _, recipe := range []Recipe{daube, pasta, crepes, pie} {
+			if err := store.SaveRecipe(ctx, l, &recipe); err != nil {
+				t.Fatalf("Error when trying to save recipe: %v", err)
+			}
+		}
+
+		page, err := store.SearchRecipes(ctx, l, "Pommes", ListOptions{Limit: 2})
+		if err != nil {
+			t.Fatalf("Error when trying to search recipes: %v", err)
+		}
+		if page.Total != 3 || len(page.Results) != 2 || page.NextCursor == "" {
+			t.Fatalf("Expected 2 of 3 results and a next page, got %+v", page)
+		}
+		if page.Results[0].Name != pie.Name || page.Results[1].Name != crepes.Name {
+			t.Errorf("Expected the pie then the crepes, got %v then %v", page.Results[0].Name, page.Results[1].Name)
+		}
+		if highlights := page.Results[0].Highlights["name"]; len(highlights) != 1 || highlights[0] != "Tarte aux <em>pommes</em>" {
+			t.Errorf("Expected the name to be highlighted, got %v", page.Results[0].Highlights)
+		}
+		if page.Results[0].Score <= page.Results[1].Score {
+			t.Errorf("Expected decreasing scores, got %v then %v", page.Results[0].Score, page.Results[1].Score)
+		}
+
+		next, err := store.SearchRecipes(ctx, l, "Pommes", ListOptions{Limit: 2, Cursor: page.NextCursor})
+		if err != nil || len(next.Results) != 1 || next.Results[0].Name != daube.Name || next.NextCursor != "" {
+			t.Errorf("Expected the daube on the last page, got %+v (%v)", next, err)
+		}
+		if highlights := next.Results[0].Highlights["steps"]; len(highlights) != 1 || highlights[0] != "Ajouter les <em>pommes</em> de terre" {
+			t.Errorf("Expected the step to be highlighted, got %v", next.Results[0].Highlights)
+		}
+		if _, err := store.SearchRecipes(ctx, l, "crepes", ListOptions{Cursor: page.NextCursor}); !errors.Is(err, ErrInvalidCursor) {
+			t.Errorf("Expected ErrInvalidCursor for a cursor of another query, got %v", err)
+		}
+
+		accents, err := store.SearchRecipes(ctx, l, "pates", ListOptions{})
+		if err != nil || len(accents.Results) != 1 || accents.Results[0].Highlights["name"][0] != "<em>Pâtes</em> au pesto" {
+			t.Errorf("Expected the diacritics to be ignored, got %+v (%v)", accents, err)
+		}
+	})
+
+	t.Run("Expired contexts end with ErrTimeout", func(t *testing.T) {
+		store := newStore(t)
+		expired, cancel := context.WithDeadline(ctx, time.Now().Add(-time.Second))
+		defer cancel()
+		if _, err := store.FindAllRecipes(expired, l, ListOptions{}); !errors.Is(err, ErrTimeout) {
+			t.Errorf("Expected ErrTimeout, got %v", err)
+		}
+		if err := store.SaveRecipe(expired, l, &Recipe{ID: store.NewID()}); !errors.Is(err, ErrTimeout) {
+			t.Errorf("Expected ErrTimeout, got %v", err)
+		}
+	})
+
+	t.Run("Update and delete a recipe", func(t *testing.T) {
+		store := newStore(t)
+		recipe := newTestRecipe(store, "Crepes", "arsene", "flour", "milk")
+		if err := store.SaveRecipe(ctx, l, &recipe); err != nil {
+			t.Fatalf("Error when trying to save recipe: %v", err)
+		}
+		recipe.Servings = 8
+		recipe.Version = AnyVersion
+		if err := store.UpsertOne(ctx, l, &recipe); err != nil {
+			t.Fatalf("Error when trying to update recipe: %v", err)
+		}
+		found, err := store.FindRecipeByID(ctx, l, recipe.ID.Hex())
+		if err != nil || found.Servings != 8 {
+			t.Errorf("Expected 8 servings, got %v (%v)", found, err)
+		}
+
+		if err := store.DeleteRecipeByID(ctx, l, recipe.ID.Hex(), AnyVersion); err != nil {
+			t.Fatalf("Error when trying to delete recipe: %v", err)
+		}
+		if err := store.DeleteRecipeByID(ctx, l, recipe.ID.Hex(), AnyVersion); !errors.Is(err, ErrRecipeNotFound) {
+			t.Errorf("Expected ErrRecipeNotFound, got %v", err)
+		}
+		unknown := newTestRecipe(store, "Unknown", "arsene")
+		if err := store.UpsertOne(ctx, l, &unknown); !errors.Is(err, ErrRecipeNotFound) {
+			t.Errorf("Expected ErrRecipeNotFound, got %v", err)
+		}
+	})
+
+	t.Run("Writes record revisions", func(t *testing.T) {
+		store := newStore(t)
+		recipe := newTestRecipe(store, "Crepes", "arsene", "flour")
+		if err := store.SaveRecipe(WithActor(ctx, "arsene"), l, &recipe); err != nil {
+			t.Fatalf("Error when trying to save recipe: %v", err)
+		}
+		recipe.Servings = 6
+		if err := store.UpsertOne(WithActor(ctx, "marie"), l, &recipe); err != nil {
+			t.Fatalf("Error when trying to update recipe: %v", err)
+		}
+		recipe.Servings = 8
+		if err := store.UpsertOne(ctx, l, &recipe); err != nil {
+			t.Fatalf("Error when trying to update recipe: %v", err)
+		}
+
+		revisions, err := store.FindRevisions(ctx, l, recipe.ID.Hex())
+		if err != nil || len(revisions) != 3 {
+			t.Fatalf("Expected 3 revisions, got %v (%v)", revisions, err)
+		}
+		for i, author := range []string{"arsene", "marie", AnonymousActor} {
+			if revisions[i].Number != int64(i+1) || revisions[i].Author != author || revisions[i].Recipe != nil {
+				t.Errorf("Expected revision %v by %v without snapshot, got %+v", i+1, author, revisions[i])
+			}
+		}
+		revision, err := store.FindRevision(ctx, l, recipe.ID.Hex(), 2)
+		if err != nil || revision.Recipe == nil || revision.Recipe.Servings != 6 || revision.Recipe.Version != 2 {
+			t.Errorf("Expected the snapshot of the second revision, got %+v (%v)", revision, err)
+		}
+		if _, err := store.FindRevision(ctx, l, recipe.ID.Hex(), 4); !errors.Is(err, ErrRevisionNotFound) {
+			t.Errorf("Expected ErrRevisionNotFound, got %v", err)
+		}
+		if revisions, _ := store.FindRevisions(ctx, l, primitive.NewObjectID().Hex()); len(revisions) != 0 {
+			t.Errorf("Expected no revision for an unknown recipe, got %v", revisions)
+		}
+
+		store.DeleteRecipeByID(ctx, l, recipe.ID.Hex(), AnyVersion)
+		store.PurgeDeletedRecipes(ctx, l, time.Now().Add(time.Second))
+		if revisions, _ := store.FindRevisions(ctx, l, recipe.ID.Hex()); len(revisions) != 0 {
+			t.Errorf("Expected the revisions to be purged with the recipe, got %v", revisions)
+		}
+	})
+
+	t.Run("Writes publish their events in the outbox", func(t *testing.T) {
+		store := newStore(t)
+		recipe := newTestRecipe(store, "Crepes", "arsene", "flour")
+		writer := WithActor(ctx, "arsene")
+		if err := store.SaveRecipe(writer, l, &recipe); err != nil {
+			t.Fatalf("Error when trying to save recipe: %v", err)
+		}
+		recipe.Servings = 6
+		if err := store.UpsertOne(writer, l, &recipe); err != nil {
+			t.Fatalf("Error when trying to update recipe: %v", err)
+		}
+		if err := store.DeleteRecipeByID(writer, l, recipe.ID.Hex(), AnyVersion); err != nil {
+			t.Fatalf("Error when trying to delete recipe: %v", err)
+		}
+		stale := recipe
+		if err := store.UpsertOne(writer, l, &stale); err == nil {
+			t.Fatalf("Expected the update of a trashed recipe to fail")
+		}
+
+		events, err := store.PendingEvents(ctx, l, 10)
+		if err != nil || len(events) != 3 {
+			t.Fatalf("Expected 3 pending events, got %v (%v)", events, err)
+		}
+		for i, eventType := range []EventType{RecipeCreated, RecipeUpdated, RecipeDeleted} {
+			event := events[i]
+			if event.Type != eventType || event.RecipeID != recipe.ID || event.Version != int64(i+1) || event.Actor != "arsene" {
+				t.Errorf("Expected %v at version %v, got %+v", eventType, i+1, event)
+			}
+			if (event.Recipe == nil) != (eventType == RecipeDeleted) {
+				t.Errorf("Expected a snapshot in every event but the deletion, got %+v", event)
+			}
+		}
+		if events[1].Recipe != nil && events[1].Recipe.Servings != 6 {
+			t.Errorf("Expected the snapshot of the update, got %+v", events[1].Recipe)
+		}
+
+		if err := store.MarkEventsPublished(ctx, l, []primitive.ObjectID{events[0].ID, events[1].ID}); err != nil {
+			t.Fatalf("Error when trying to mark the events as published: %v", err)
+		}
+		pending, err := store.PendingEvents(ctx, l, 10)
+		if err != nil || len(pending) != 1 || pending[0].ID != events[2].ID {
+			t.Errorf("Expected only the deletion to be pending, got %v (%v)", pending, err)
+		}
+	})
+
+	t.Run("Import recipes by batch and export them", func(t *testing.T) {
+		store := newStore(t)
+		stored := newTestRecipe(store, "Crepes", "arsene", "flour")
+		if err := store.SaveRecipe(ctx, l, &stored); err != nil {
+			t.Fatalf("Error when trying to save recipe: %v", err)
+		}
+		batch := func() []Recipe {
+			updated := stored
+			updated.Servings = 12
+			return []Recipe{updated, newTestRecipe(store, "Aioli", "marie", "garlic")}
+		}
+
+		dryRun, err := store.ImportRecipes(ctx, l, batch(), ImportOptions{Mode: ImportUpsert, DryRun: true})
+		if err != nil || dryRun[0].Status != ImportUpdated || dryRun[1].Status != ImportCreated {
+			t.Fatalf("Expected an update and a creation planned, got %+v (%v)", dryRun, err)
+		}
+		if page, _ := store.FindAllRecipes(ctx, l, ListOptions{}); page.Total != 1 {
+			t.Errorf("Expected the dry run to write nothing, got %v recipes", page.Total)
+		}
+
+		skipped := batch()
+		outcomes, err := store.ImportRecipes(ctx, l, skipped, ImportOptions{})
+		if err != nil || outcomes[0].Status != ImportSkipped || outcomes[1].Status != ImportCreated {
+			t.Fatalf("Expected a skip and a creation, got %+v (%v)", outcomes, err)
+		}
+		if found, _ := store.FindRecipeByID(ctx, l, stored.ID.Hex()); found.Servings != 4 {
+			t.Errorf("Expected the stored recipe to be skipped, got %v servings", found.Servings)
+		}
+		if found, err := store.FindRecipeByID(ctx, l, skipped[1].ID.Hex()); err != nil || found.Version != InitialVersion {
+			t.Errorf("Expected the imported recipe at its first version, got %v (%v)", found, err)
+		}
+
+		outcomes, err = store.ImportRecipes(ctx, l, batch(), ImportOptions{Mode: ImportUpsert})
+		if err != nil || outcomes[0].Status != ImportUpdated || outcomes[1].Status != ImportCreated {
+			t.Fatalf("Expected an update and a creation, got %+v (%v)", outcomes, err)
+		}
+		if found, _ := store.FindRecipeByID(ctx, l, stored.ID.Hex()); found.Servings != 12 || found.Version != 2 {
+			t.Errorf("Expected the stored recipe to be updated, got %v servings at version %v", found.Servings, found.Version)
+		}
+
+		exported := make([]string, 0)
+		err = store.ExportRecipes(ctx, l, func(recipe *Recipe) error {
+			exported = append(exported, recipe.Name)
+			return nil
+		})
+		if err != nil || len(exported) != 3 {
+			t.Errorf("Expected 3 recipes exported, got %v (%v)", exported, err)
+		}
+		stop := errors.New("stop")
+		calls := 0
+		err = store.ExportRecipes(ctx, l, func(recipe *Recipe) error {
+			calls++
+			return stop
+		})
+		if !errors.Is(err, stop) || calls != 1 {
+			t.Errorf("Expected the export to stop at the first error, got %v after %v calls", err, calls)
+		}
+	})
+
+	t.Run("Deleted recipes go to the trash until restored or purged", func(t *testing.T) {
+		store := newStore(t)
+		kept := newTestRecipe(store, "Crepes", "arsene", "flour")
+		trashed := newTestRecipe(store, "Crepes au sucre", "arsene", "flour")
+		for _, recipe := range []*Recipe{&kept, &trashed} {
+			if err := store.SaveRecipe(ctx, l, recipe); err != nil {
+				t.Fatalf("Error when trying to save recipe: %v", err)
+			}
+		}
+		if err := store.DeleteRecipeByID(ctx, l, trashed.ID.Hex(), InitialVersion); err != nil {
+			t.Fatalf("Error when trying to delete recipe: %v", err)
+		}
+
+		if _, err := store.FindRecipeByID(ctx, l, trashed.ID.Hex()); !errors.Is(err, ErrRecipeNotFound) {
+			t.Errorf("Expected ErrRecipeNotFound for a trashed recipe, got %v", err)
+		}
+		for name, find := range map[string]func() (*RecipePage, error){
+			"all":        func() (*RecipePage, error) { return store.FindAllRecipes(ctx, l, ListOptions{}) },
+			"author":     func() (*RecipePage, error) { return store.FindRecipesByAuthorID(ctx, l, "arsene", ListOptions{}) },
+			"ingredient": func() (*RecipePage, error) { return store.FindRecipesByIngredientID(ctx, l, "flour", ListOptions{}) },
+		} {
+			page, err := find()
+			if err != nil || page.Total != 1 || page.Recipes[0].ID != kept.ID {
+				t.Errorf("Expected only the kept recipe by %v, got %v (%v)", name, page, err)
+			}
+		}
+		if results, err := store.SearchRecipes(ctx, l, "sucre", ListOptions{}); err != nil || results.Total != 0 {
+			t.Errorf("Expected no search result for a trashed recipe, got %v (%v)", results, err)
+		}
+		recipe := trashed
+		recipe.Version = AnyVersion
+		if err := store.UpsertOne(ctx, l, &recipe); !errors.Is(err, ErrRecipeNotFound) {
+			t.Errorf("Expected ErrRecipeNotFound when updating a trashed recipe, got %v", err)
+		}
+
+		trash, err := store.FindTrashedRecipes(ctx, l, ListOptions{})
+		if err != nil || trash.Total != 1 || trash.Recipes[0].ID != trashed.ID || trash.Recipes[0].DeletedAt == nil {
+			t.Fatalf("Expected the trashed recipe in the trash, got %v (%v)", trash, err)
+		}
+		if _, err := store.RestoreRecipeByID(ctx, l, kept.ID.Hex()); !errors.Is(err, ErrRecipeNotFound) {
+			t.Errorf("Expected ErrRecipeNotFound when restoring a recipe out of the trash, got %v", err)
+		}
+		restored, err := store.RestoreRecipeByID(ctx, l, trashed.ID.Hex())
+		if err != nil || restored.DeletedAt != nil || restored.Version != 3 {
+			t.Fatalf("Expected the restored recipe at version 3, got %v (%v)", restored, err)
+		}
+		if _, err := store.FindRecipeByID(ctx, l, trashed.ID.Hex()); err != nil {
+			t.Errorf("Error when trying to find the restored recipe: %v", err)
+		}
+
+		store.DeleteRecipeByID(ctx, l, trashed.ID.Hex(), AnyVersion)
+		if purged, err := store.PurgeDeletedRecipes(ctx, l, time.Now().Add(-time.Hour)); err != nil || purged != 0 {
+			t.Errorf("Expected the recently trashed recipe to be kept, got %v purged (%v)", purged, err)
+		}
+		if purged, err := store.PurgeDeletedRecipes(ctx, l, time.Now().Add(time.Second)); err != nil || purged != 1 {
+			t.Errorf("Expected 1 recipe purged, got %v (%v)", purged, err)
+		}
+		if _, err := store.RestoreRecipeByID(ctx, l, trashed.ID.Hex()); !errors.Is(err, ErrRecipeNotFound) {
+			t.Errorf("Expected ErrRecipeNotFound for a purged recipe, got %v", err)
+		}
+		if page, _ := store.FindAllRecipes(ctx, l, ListOptions{}); page.Total != 1 {
+			t.Errorf("Expected the kept recipe to survive the purge, got %v", page)
+		}
+	})
+
+	t.Run("Filter recipes and count their facets", func(t *testing.T) {
+		store := newStore(t)
+		crepes := newTestRecipe(store, "Crepes", "arsene", "flour", "egg", "milk")
+		crepes.Dish, crepes.Servings = Dessert, 6
+		crepes.Metadata = map[string]string{"origin": "Bretagne"}
+		aioli := newTestRecipe(store, "Aioli", "marius", "garlic", "egg", "oil")
+		aioli.Dish, aioli.Servings = Starter, 2
+		aioli.Metadata = map[string]string{"origin": "Provence"}
+		daube := newTestRecipe(store, "Daube", "marius", "beef", "wine")
+		daube.Timers = []Timer{{Name: "simmer", Amount: 3, Unit: "hours"}}
+		for _, recipe := range []*Recipe{&crepes, &aioli, &daube} {
+			if err := store.SaveRecipe(ctx, l, recipe); err != nil {
+				t.Fatalf("Error when trying to save recipe: %v", err)
+			}
+		}
+
+		for name, test := range map[string]struct {
+			filter   RecipeFilter
+			expected []string
+		}{
+			"dishes":              {RecipeFilter{Dishes: []Dish{Starter, Dessert}}, []string{"Aioli", "Crepes"}},
+			"servings":            {RecipeFilter{ServingsMin: 3, ServingsMax: 4}, []string{"Daube"}},
+			"total time":          {RecipeFilter{MaxTotalTime: time.Hour}, []string{"Aioli", "Crepes"}},
+			"with ingredients":    {RecipeFilter{WithIngredients: []string{"egg", "oil"}}, []string{"Aioli"}},
+			"without ingredients": {RecipeFilter{WithoutIngredients: []string{"egg"}}, []string{"Daube"}},
+			"author and metadata": {RecipeFilter{Author: "marius", Metadata: map[string]string{"origin": "Provence"}}, []string{"Aioli"}},
+			"operator as value":   {RecipeFilter{Author: "$ne"}, []string{}},
+		} {
+			page, err := store.FindAllRecipes(ctx, l, ListOptions{Filter: test.filter, Sort: SortByName})
+			if err != nil {
+				t.Errorf("Error when trying to filter by %v: %v", name, err)
+				continue
+			}
+			names := make([]string, len(page.Recipes))
+			for i := range page.Recipes {
+				names[i] = page.Recipes[i].Name
+			}
+			if !slices.Equal(names, test.expected) || page.Total != int64(len(test.expected)) {
+				t.Errorf("Expected %v filtered by %v, got %v", test.expected, name, names)
+			}
+		}
+
+		page, err := store.FindAllRecipes(ctx, l, ListOptions{Filter: RecipeFilter{WithIngredients: []string{"egg"}}, WithFacets: true})
+		if err != nil || page.Facets == nil {
+			t.Fatalf("Expected the facets, got %v (%v)", page, err)
+		}
+		expectedDishes := []FacetCount{{Value: "dessert", Count: 1}, {Value: "starter", Count: 1}}
+		expectedAuthors := []FacetCount{{Value: "arsene", Count: 1}, {Value: "marius", Count: 1}}
+		if !slices.Equal(page.Facets.Dish, expectedDishes) || !slices.Equal(page.Facets.Author, expectedAuthors) {
+			t.Errorf("Expected the facets %v and %v, got %+v", expectedDishes, expectedAuthors, page.Facets)
+		}
+		if page, _ := store.FindAllRecipes(ctx, l, ListOptions{WithFacets: true}); page.Facets.Author[0] != (FacetCount{Value: "marius", Count: 2}) {
+			t.Errorf("Expected the most frequent author first, got %+v", page.Facets.Author)
+		}
+
+		invalid := RecipeFilter{Metadata: map[string]string{"origin.$where": "true"}}
+		if _, err := store.FindAllRecipes(ctx, l, ListOptions{Filter: invalid}); !errors.Is(err, ErrInvalidFilter) {
+			t.Errorf("Expected ErrInvalidFilter for a metadata key with an operator, got %v", err)
+		}
+	})
+
+	t.Run("Compute the catalog statistics", func(t *testing.T) {
+		store := newStore(t)
+		if stats, err := store.RecipeStats(ctx, l); err != nil || stats.Recipes != 0 || len(stats.TotalTime) != 5 {
+			t.Fatalf("Expected empty statistics, got %+v (%v)", stats, err)
+		}
+		monday := time.Date(2024, time.March, 4, 0, 0, 0, 0, time.UTC)
+		crepes := newTestRecipe(store, "Crepes", "arsene", "flour", "egg", "egg")
+		crepes.Dish, crepes.Servings, crepes.CreatedAt = Dessert, 6, monday.Add(30*time.Hour)
+		aioli := newTestRecipe(store, "Aioli", "marius", "garlic", "egg")
+		aioli.Servings, aioli.CreatedAt = 2, monday.Add(-time.Minute)
+		daube := newTestRecipe(store, "Daube", "marius", "beef", "garlic")
+		daube.Timers = []Timer{{Name: "simmer", Amount: 3, Unit: "hours"}}
+		daube.CreatedAt = monday.Add(6 * 24 * time.Hour)
+		trashed := newTestRecipe(store, "Gratin", "arsene", "potato")
+		for _, recipe := range []*Recipe{&crepes, &aioli, &daube, &trashed} {
+			if err := store.SaveRecipe(ctx, l, recipe); err != nil {
+				t.Fatalf("Error when trying to save recipe: %v", err)
+			}
+		}
+		store.DeleteRecipeByID(ctx, l, trashed.ID.Hex(), AnyVersion)
+
+		stats, err := store.RecipeStats(ctx, l)
+		if err != nil {
+			t.Fatalf("Error when trying to compute the statistics: %v", err)
+		}
+		if stats.Recipes != 3 || stats.AverageServings != 4 {
+			t.Errorf("Expected 3 recipes of 4 servings on average, got %v and %v", stats.Recipes, stats.AverageServings)
+		}
+		if expected := []FacetCount{{Value: "main", Count: 2}, {Value: "dessert", Count: 1}}; !slices.Equal(stats.Dishes, expected) {
+			t.Errorf("Expected the dishes %v, got %v", expected, stats.Dishes)
+		}
+		if expected := []FacetCount{{Value: "marius", Count: 2}, {Value: "arsene", Count: 1}}; !slices.Equal(stats.Authors, expected) {
+			t.Errorf("Expected the authors %v, got %v", expected, stats.Authors)
+		}
+		expectedIngredients := []FacetCount{{Value: "egg", Count: 2}, {Value: "garlic", Count: 2}, {Value: "beef", Count: 1}, {Value: "flour", Count: 1}}
+		if !slices.Equal(stats.TopIngredients, expectedIngredients) {
+			t.Errorf("Expected the ingredients %v, got %v", expectedIngredients, stats.TopIngredients)
+		}
+		expectedTimes := []TimeBucket{{0, 15, 2}, {15, 30, 0}, {30, 60, 0}, {60, 120, 0}, {120, 0, 1}}
+		if !slices.Equal(stats.TotalTime, expectedTimes) {
+			t.Errorf("Expected the total times %v, got %v", expectedTimes, stats.TotalTime)
+		}
+		expectedWeeks := []WeekCount{{Week: monday.AddDate(0, 0, -7), Count: 1}, {Week: monday, Count: 2}}
+		if len(stats.CreatedPerWeek) != 2 || !stats.CreatedPerWeek[0].Week.Equal(expectedWeeks[0].Week) ||
+			!stats.CreatedPerWeek[1].Week.Equal(expectedWeeks[1].Week) || stats.CreatedPerWeek[1].Count != 2 {
+			t.Errorf("Expected the weeks %v, got %v", expectedWeeks, stats.CreatedPerWeek)
+		}
+	})
+
+	t.Run("Detect the duplicates of a recipe", func(t *testing.T) {
+		store := newStore(t)
+		crepes := newTestRecipe(store, "Crêpes au sucre", "arsene", "flour", "egg", "milk", "sugar", "salt")
+		copied := newTestRecipe(store, "crepes  AU SUCRE!", "marius", "flour", "egg", "milk", "sugar", "salt", "butter")
+		other := newTestRecipe(store, "Crepes au sucre", "marius", "buckwheat", "egg", "salt")
+		daube := newTestRecipe(store, "Daube", "marius", "beef", "wine")
+		trashed := newTestRecipe(store, "Daube", "arsene", "beef", "wine")
+		for _, recipe := range []*Recipe{&crepes, &copied, &other, &daube, &trashed} {
+			if err := store.SaveRecipe(ctx, l, recipe); err != nil {
+				t.Fatalf("Error when trying to save recipe: %v", err)
+			}
+		}
+		store.DeleteRecipeByID(ctx, l, trashed.ID.Hex(), AnyVersion)
+
+		candidate := newTestRecipe(store, "Crepes au sucre", "cesar", "salt", "sugar", "milk", "egg", "flour")
+		duplicates, err := store.FindDuplicates(ctx, l, &candidate)
+		if err != nil {
+			t.Fatalf("Error when trying to find duplicates: %v", err)
+		}
+		if len(duplicates) != 2 || duplicates[0].ID != crepes.ID || duplicates[0].Score != 1 ||
+			duplicates[1].ID != copied.ID || duplicates[1].Score != 5.0/6 || duplicates[0].Name != crepes.Name {
+			t.Errorf("Expected the crepes then their copy, got %+v", duplicates)
+		}
+		// A stored recipe is not its own duplicate
+		if duplicates, _ := store.FindDuplicates(ctx, l, &daube); len(duplicates) != 0 {
+			t.Errorf("Expected no duplicate of the daube, the other one is trashed, got %+v", duplicates)
+		}
+
+		clusters, err := store.FindDuplicateClusters(ctx, l)
+		if err != nil {
+			t.Fatalf("Error when trying to find the duplicate clusters: %v", err)
+		}
+		if len(clusters) != 1 || clusters[0].NameKey != "crepes au sucre" || clusters[0].Similarity != 5.0/6 ||
+			len(clusters[0].Recipes) != 2 || clusters[0].Recipes[0].ID != crepes.ID || clusters[0].Recipes[1].ID != copied.ID {
+			t.Errorf("Expected the crepes and their copy, got %+v", clusters)
+		}
+	})
+
+	t.Run("Store images and their thumbnails", func(t *testing.T) {
+		store := newStore(t)
+		recipe := newTestRecipe(store, "Crepes", "arsene", "flour")
+		if err := store.SaveRecipe(ctx, l, &recipe); err != nil {
+			t.Fatalf("Error when trying to save recipe: %v", err)
+		}
+		id := recipe.ID.Hex()
+		newUpload := func(content string) *ImageUpload {
+			return &ImageUpload{
+				Image: Image{
+					ID:          store.NewID(),
+					ContentType: "image/png",
+					Thumbnails:  []Thumbnail{{Name: "small", FileID: store.NewID(), Width: 16, Height: 8}},
+				},
+				Original:   ImageFile{ContentType: "image/png", Data: []byte(content)},
+				Thumbnails: map[string]ImageFile{"small": {ContentType: "image/jpeg", Data: []byte(content + " small")}},
+			}
+		}
+
+		first, err := store.AddRecipeImage(ctx, l, id, newUpload("first"), false)
+		if err != nil || !first.Cover {
+			t.Fatalf("Expected the first image to become the cover, got %+v (%v)", first, err)
+		}
+		second, err := store.AddRecipeImage(ctx, l, id, newUpload("second"), false)
+		if err != nil || second.Cover {
+			t.Fatalf("Expected the second image not to take the cover, got %+v (%v)", second, err)
+		}
+		third, err := store.AddRecipeImage(ctx, l, id, newUpload("third"), true)
+		if err != nil || !third.Cover {
+			t.Fatalf("Expected the third image to take the cover, got %+v (%v)", third, err)
+		}
+		found, _ := store.FindRecipeByID(ctx, l, id)
+		covers := []bool{}
+		for _, image := range found.Images {
+			covers = append(covers, image.Cover)
+		}
+		if !slices.Equal(covers, []bool{false, false, true}) || found.Version != InitialVersion {
+			t.Errorf("Expected the cover on the third image at the initial version, got %v at %v", covers, found.Version)
+		}
+
+		for size, expected := range map[string]ImageFile{
+			"":           {ContentType: "image/png", Data: []byte("second")},
+			OriginalSize: {ContentType: "image/png", Data: []byte("second")},
+			"small":      {ContentType: "image/jpeg", Data: []byte("second small")},
+		} {
+			file, err := store.OpenRecipeImage(ctx, l, id, second.ID.Hex(), size)
+			if err != nil || file.ContentType != expected.ContentType || string(file.Data) != string(expected.Data) {
+				t.Errorf("Expected %v in size %q, got %+v (%v)", expected, size, file, err)
+			}
+		}
+		if _, err := store.OpenRecipeImage(ctx, l, id, second.ID.Hex(), "huge"); !errors.Is(err, ErrImageNotFound) {
+			t.Errorf("Expected ErrImageNotFound for an unknown size, got %v", err)
+		}
+		if _, err := store.OpenRecipeImage(ctx, l, id, store.NewID().Hex(), ""); !errors.Is(err, ErrImageNotFound) {
+			t.Errorf("Expected ErrImageNotFound for an unknown image, got %v", err)
+		}
+		if _, err := store.AddRecipeImage(ctx, l, store.NewID().Hex(), newUpload("lost"), false); !errors.Is(err, ErrRecipeNotFound) {
+			t.Errorf("Expected ErrRecipeNotFound for an unknown recipe, got %v", err)
+		}
+
+		// The content of the recipe leaves its images untouched
+		found.Name, found.Images = "Crepes Suzette", nil
+		if err := store.UpsertOne(ctx, l, found); err != nil {
+			t.Fatalf("Error when trying to update recipe: %v", err)
+		}
+		if found, _ := store.FindRecipeByID(ctx, l, id); len(found.Images) != 3 {
+			t.Errorf("Expected the images kept by the update, got %v", found.Images)
+		}
+
+		// The files go with the purged recipe
+		store.DeleteRecipeByID(ctx, l, id, AnyVersion)
+		if _, err := store.PurgeDeletedRecipes(ctx, l, time.Now().Add(time.Second)); err != nil {
+			t.Fatalf("Error when trying to purge the trash: %v", err)
+		}
+		switch store := store.(type) {
+		case *MemoryStore:
+			if len(store.files) != 0 {
+				t.Errorf("Expected the files purged with the recipe, got %v", len(store.files))
+			}
+		case *BoltStore:
+			store.db.View(func(tx *bolt.Tx) error {
+				if files := tx.Bucket(filesBucket).Stats().KeyN; files != 0 {
+					t.Errorf("Expected the files purged with the recipe, got %v", files)
+				}
+				return nil
+			})
+		case *DbHandler:
+			bucket, _ := store.imageBucket(ctx)
+			if cursor, err := bucket.FindContext(ctx, bson.M{}); err != nil || cursor.Next(ctx) {
+				t.Errorf("Expected the files purged with the recipe (%v)", err)
+			}
+		}
+	})
+}
+
+func TestDbHandlerStore(t *testing.T) {
+	dbh, teardownTest := setupTest(t)
+	defer teardownTest(t)
+
+	testRecipeStore(t, func(t *testing.T) RecipeStore {
+		// Every test gets its own collection
+		handler := NewDbHandler(dbh.Client, dbh.DBName, "recipe_"+primitive.NewObjectID().Hex())
+		// The writes run in the transactions of the replica set
+		handler.Transactions = dbh.Transactions
+		if err := handler.EnsureIndexes(context.Background()); err != nil {
+			t.Fatalf("Error when trying to create the indexes: %v", err)
+		}
+		return handler
+	})
+
+	t.Run("A failed write leaves nothing behind", func(t *testing.T) {
+		l := logrus.WithField("test", t.Name())
+		handler := NewDbHandler(dbh.Client, dbh.DBName, "recipe_"+primitive.NewObjectID().Hex())
+		handler.Transactions = dbh.Transactions
+		if err := handler.EnsureIndexes(context.Background()); err != nil {
+			t.Fatalf("Error when trying to create the indexes: %v", err)
+		}
+		recipe := newTestRecipe(handler, "Crepes", "arsene", "flour")
+		// The revision of the recipe is already taken, its insert fails after the one of the recipe
+		_, err := handler.GetRevisionCollection().InsertOne(context.Background(), bson.M{"recipe_id": recipe.ID, "revision": InitialVersion})
+		if err != nil {
+			t.Fatalf("Error when trying to insert the revision: %v", err)
+		}
+		if err := handler.SaveRecipe(context.Background(), l, &recipe); err == nil {
+			t.Fatalf("Expected the save to fail on the revision")
+		}
+		if _, err := handler.FindRecipeByID(context.Background(), l, recipe.ID.Hex()); !errors.Is(err, ErrRecipeNotFound) {
+			t.Errorf("Expected the recipe rolled back with its revision, got %v", err)
+		}
+	})
+
+	t.Run("One relay at a time holds the lease of the outbox", func(t *testing.T) {
+		l := logrus.WithField("test", t.Name())
+		handler := NewDbHandler(dbh.Client, dbh.DBName, "recipe_"+primitive.NewObjectID().Hex())
+		for _, claim := range []struct {
+			owner    string
+			lease    time.Duration
+			expected bool
+		}{
+			{"first", 50 * time.Millisecond, true},
+			{"second", time.Minute, false},
+			{"first", 50 * time.Millisecond, true},
+		} {
+			if claimed, err := handler.claimOutbox(context.Background(), l, claim.owner, claim.lease); err != nil || claimed != claim.expected {
+				t.Errorf("Expected the claim of %v to be %v, got %v (%v)", claim.owner, claim.expected, claimed, err)
+			}
+		}
+		time.Sleep(60 * time.Millisecond)
+		if claimed, err := handler.claimOutbox(context.Background(), l, "second", time.Minute); err != nil || !claimed {
+			t.Errorf("Expected the expired lease to be taken over, got %v (%v)", claimed, err)
+		}
+	})
+}
+
+func TestMemoryStore(t *testing.T) {
+	testRecipeStore(t, func(t *testing.T) RecipeStore {
+		return NewMemoryStore()
+	})
+
+	ctx := context.Background()
+
+	t.Run("Concurrent writes are safe", func(t *testing.T) {
+		l := logrus.WithField("test", t.Name())
+		store := NewMemoryStore()
+		var wg sync.WaitGroup
+		for i := 0; i < 50; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				recipe := newTestRecipe(store, "Crepes", "arsene", "flour")
+				if err := store.SaveRecipe(ctx, l, &recipe); err != nil {
+					t.Errorf("Error when trying to save recipe: %v", err)
+				}
+				if _, err := store.FindAllRecipes(ctx, l, ListOptions{}); err != nil {
+					t.Errorf("Error when trying to find all recipes: %v", err)
+				}
+			}()
+		}
+		wg.Wait()
+		all, _ := store.FindAllRecipes(ctx, l, ListOptions{})
+		if all.Total != 50 {
+			t.Errorf("Expected 50 recipes, got %v", all.Total)
+		}
+	})
+
+	t.Run("The trash is purged periodically", func(t *testing.T) {
+		l := logrus.WithField("test", t.Name())
+		store := NewMemoryStore()
+		recipe := newTestRecipe(store, "Crepes", "arsene", "flour")
+		store.SaveRecipe(ctx, l, &recipe)
+		store.DeleteRecipeByID(ctx, l, recipe.ID.Hex(), AnyVersion)
+
+		purgeCtx, cancel := context.WithCancel(ctx)
+		done := make(chan struct{})
+		go func() {
+			PurgeTrash(purgeCtx, store, time.Millisecond, time.Millisecond)
+			close(done)
+		}()
+		deadline := time.Now().Add(time.Second)
+		for time.Now().Before(deadline) {
+			if trash, _ := store.FindTrashedRecipes(ctx, l, ListOptions{}); trash.Total == 0 {
+				break
+			}
+			time.Sleep(time.Millisecond)
+		}
+		cancel()
+		<-done
+		if trash, _ := store.FindTrashedRecipes(ctx, l, ListOptions{}); trash.Total != 0 {
+			t.Errorf("Expected the trash to be purged, got %v", trash)
+		}
+	})
+
+	t.Run("Returned recipes do not alias the stored ones", func(t *testing.T) {
+		l := logrus.WithField("test", t.Name())
+		store := NewMemoryStore()
+		recipe := newTestRecipe(store, "Crepes", "arsene", "flour")
+		store.SaveRecipe(ctx, l, &recipe)
+		found, _ := store.FindRecipeByID(ctx, l, recipe.ID.Hex())
+		found.Steps[0] = "Burn"
+		found.Metadata["cook time"] = "0"
+		again, _ := store.FindRecipeByID(ctx, l, recipe.ID.Hex())
+		if again.Steps[0] != "Cook" || again.Metadata["cook time"] != "30" {
+			t.Errorf("Stored recipe was modified through a returned copy: %+v", again)
+		}
+	})
+}
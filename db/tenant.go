@@ -0,0 +1,160 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"regexp"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// ErrMissingTenant is returned when a multi-tenant handler is used without a valid tenant in the context
+var ErrMissingTenant = errors.New("missing tenant")
+
+// TenancyMode tells how a DbHandler keeps the recipes of the tenants apart
+type TenancyMode string
+
+const (
+	// SingleTenant ignores the tenants, every recipe is in the recipes collection
+	SingleTenant TenancyMode = "none"
+	// SharedCollection keeps the tenants in the recipes collection, told apart by their tenant_id
+	SharedCollection TenancyMode = "shared"
+	// CollectionPerTenant keeps every tenant in its own recipes and revisions collections
+	CollectionPerTenant TenancyMode = "collection"
+)
+
+// A tenant becomes part of a collection name, an underscore would collide with the suffixes of the collections
+var tenantPattern = regexp.MustCompile(`^[a-z0-9][a-z0-9-]{0,62}$`)
+
+// ValidTenant tells if the tenant is made of 1 to 63 lowercase letters, digits or dashes, not starting with a dash
+func ValidTenant(tenant string) bool {
+	return tenantPattern.MatchString(tenant)
+}
+
+type tenantKey struct{}
+
+// WithTenant returns a context restricting the recipe operations made with it to the tenant
+func WithTenant(ctx context.Context, tenant string) context.Context {
+	return context.WithValue(ctx, tenantKey{}, tenant)
+}
+
+// TenantFrom returns the tenant of the context, empty if there is none
+func TenantFrom(ctx context.Context) string {
+	tenant, _ := ctx.Value(tenantKey{}).(string)
+	return tenant
+}
+
+func (dbh *DbHandler) multiTenant() bool {
+	return dbh.Tenancy == SharedCollection || dbh.Tenancy == CollectionPerTenant
+}
+
+// Return a copy of the handler bound to the tenant
+func (dbh *DbHandler) bind(tenant string) *DbHandler {
+	bound := *dbh
+	bound.tenant = tenant
+	return &bound
+}
+
+// Bind the handler to the tenant of the context, its collections and filters then only reach the recipes of the tenant.
+// Nothing is created for an unknown tenant, its reads find no recipe: the tenant is only registered by forNewRecipes.
+// In SingleTenant mode the handler is returned as is. Fails with ErrNotReady until the handler is ready.
+func (dbh *DbHandler) forTenant(ctx context.Context) (*DbHandler, error) {
+	if err := dbh.checkReady(); err != nil {
+		return nil, err
+	}
+	if !dbh.multiTenant() {
+		return dbh, nil
+	}
+	tenant := TenantFrom(ctx)
+	if !ValidTenant(tenant) {
+		return nil, ErrMissingTenant
+	}
+	return dbh.bind(tenant), nil
+}
+
+// Bind the handler to the tenant of the context like forTenant, registering the tenant before the first recipe is created
+func (dbh *DbHandler) forNewRecipes(ctx context.Context) (*DbHandler, error) {
+	bound, err := dbh.forTenant(ctx)
+	if err != nil || !bound.multiTenant() {
+		return bound, err
+	}
+	if err := bound.ensureTenant(ctx); err != nil {
+		return nil, err
+	}
+	return bound, nil
+}
+
+// Tell if the tenant the handler is bound to has been registered, once by process when it is
+func (dbh *DbHandler) registered(ctx context.Context) (bool, error) {
+	if !dbh.multiTenant() {
+		return true, nil
+	}
+	if _, ok := dbh.tenants.Load(dbh.tenant); ok {
+		return true, nil
+	}
+	count, err := dbh.GetTenantCollection().CountDocuments(ctx, bson.M{"_id": dbh.tenant}, options.Count().SetLimit(1))
+	if err != nil || count == 0 {
+		return false, err
+	}
+	dbh.tenants.Store(dbh.tenant, true)
+	return true, nil
+}
+
+// Register the tenant and create the indexes of its collections, once by process
+func (dbh *DbHandler) ensureTenant(ctx context.Context) error {
+	if _, ok := dbh.tenants.Load(dbh.tenant); ok {
+		return nil
+	}
+	// The indexes come first, a registered tenant has them
+	if dbh.Tenancy == CollectionPerTenant {
+		if err := dbh.EnsureIndexes(ctx); err != nil {
+			return err
+		}
+	}
+	_, err := dbh.GetTenantCollection().UpdateOne(ctx, bson.M{"_id": dbh.tenant},
+		bson.M{"$setOnInsert": bson.M{"created_at": now()}}, options.Update().SetUpsert(true))
+	if err != nil {
+		return err
+	}
+	dbh.tenants.Store(dbh.tenant, true)
+	return nil
+}
+
+// GetTenantCollection holds the tenants met so far, shared by all of them
+func (dbh *DbHandler) GetTenantCollection() *mongo.Collection {
+	return dbh.Client.Database(dbh.DBName).Collection(dbh.RecipesCollectionName + "_tenants")
+}
+
+// Tenants returns the registered tenants
+func (dbh *DbHandler) Tenants(ctx context.Context) ([]string, error) {
+	ids, err := dbh.GetTenantCollection().Distinct(ctx, "_id", bson.M{})
+	if err != nil {
+		return nil, wrapError(err)
+	}
+	tenants := make([]string, 0, len(ids))
+	for _, id := range ids {
+		if tenant, ok := id.(string); ok {
+			tenants = append(tenants, tenant)
+		}
+	}
+	return tenants, nil
+}
+
+// Name of the recipes collection of the tenant the handler is bound to
+func (dbh *DbHandler) recipesCollectionName() string {
+	if dbh.Tenancy == CollectionPerTenant && dbh.tenant != "" {
+		return dbh.RecipesCollectionName + "_tenant_" + dbh.tenant
+	}
+	return dbh.RecipesCollectionName
+}
+
+// Restrict the filter to the documents of the tenant the handler is bound to.
+// The collections already do it in CollectionPerTenant mode, an unbound handler reaches every tenant.
+func (dbh *DbHandler) scope(filter bson.M) bson.M {
+	if dbh.Tenancy == SharedCollection && dbh.tenant != "" {
+		filter["tenant_id"] = dbh.tenant
+	}
+	return filter
+}
@@ -0,0 +1,218 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// testTenantIsolation checks that a tenant never reads nor writes the recipes of another one
+func testTenantIsolation(t *testing.T, store RecipeStore) {
+	l := logrus.WithField("test", t.Name())
+	bistro := WithTenant(context.Background(), "bistro")
+	brasserie := WithTenant(context.Background(), "brasserie")
+
+	recipe := newTestRecipe(store, "Crepes", "arsene", "flour")
+	if err := store.SaveRecipe(bistro, l, &recipe); err != nil {
+		t.Fatalf("Error when trying to save recipe: %v", err)
+	}
+	other := newTestRecipe(store, "Aioli", "arsene", "garlic")
+	if err := store.SaveRecipe(brasserie, l, &other); err != nil {
+		t.Fatalf("Error when trying to save recipe: %v", err)
+	}
+	id := recipe.ID.Hex()
+
+	t.Run("Reads only reach the recipes of the tenant", func(t *testing.T) {
+		if _, err := store.FindRecipeByID(brasserie, l, id); !errors.Is(err, ErrRecipeNotFound) {
+			t.Errorf("Expected ErrRecipeNotFound by ID, got %v", err)
+		}
+		if _, err := store.FindRecipeByTitle(brasserie, l, "Crepes"); !errors.Is(err, ErrRecipeNotFound) {
+			t.Errorf("Expected ErrRecipeNotFound by title, got %v", err)
+		}
+		for name, find := range map[string]func() (*RecipePage, error){
+			"all":    func() (*RecipePage, error) { return store.FindAllRecipes(brasserie, l, ListOptions{}) },
+			"author": func() (*RecipePage, error) { return store.FindRecipesByAuthorID(brasserie, l, "arsene", ListOptions{}) },
+			"ingredient": func() (*RecipePage, error) {
+				return store.FindRecipesByIngredientID(brasserie, l, "flour", ListOptions{})
+			},
+		} {
+			if page, err := find(); err != nil || page.Total > 1 || (page.Total == 1 && page.Recipes[0].ID != other.ID) {
+				t.Errorf("Expected only the recipes of the tenant by %v, got %v (%v)", name, page, err)
+			}
+		}
+		if results, err := store.SearchRecipes(brasserie, l, "Crepes", ListOptions{}); err != nil || results.Total != 0 {
+			t.Errorf("Expected no search result, got %v (%v)", results, err)
+		}
+		if revisions, err := store.FindRevisions(brasserie, l, id); err != nil || len(revisions) != 0 {
+			t.Errorf("Expected no revision, got %v (%v)", revisions, err)
+		}
+		if _, err := store.FindRevision(brasserie, l, id, InitialVersion); !errors.Is(err, ErrRevisionNotFound) {
+			t.Errorf("Expected ErrRevisionNotFound, got %v", err)
+		}
+		if stats, err := store.RecipeStats(brasserie, l); err != nil || stats.Recipes != 1 {
+			t.Errorf("Expected the statistics of the tenant only, got %v (%v)", stats, err)
+		}
+		copied := newTestRecipe(store, "Crepes", "marius", "flour")
+		if duplicates, err := store.FindDuplicates(brasserie, l, &copied); err != nil || len(duplicates) != 0 {
+			t.Errorf("Expected no duplicate in another tenant, got %v (%v)", duplicates, err)
+		}
+		if clusters, err := store.FindDuplicateClusters(brasserie, l); err != nil || len(clusters) != 0 {
+			t.Errorf("Expected no duplicate cluster, got %v (%v)", clusters, err)
+		}
+		exported := 0
+		store.ExportRecipes(brasserie, l, func(r *Recipe) error {
+			if r.ID == recipe.ID {
+				t.Errorf("Expected the recipe of another tenant to be left out of the export")
+			}
+			exported++
+			return nil
+		})
+		if exported != 1 {
+			t.Errorf("Expected 1 recipe exported, got %v", exported)
+		}
+	})
+
+	t.Run("Writes only reach the recipes of the tenant", func(t *testing.T) {
+		update := recipe
+		update.Name = "Stolen crepes"
+		update.Version = AnyVersion
+		if err := store.UpsertOne(brasserie, l, &update); !errors.Is(err, ErrRecipeNotFound) {
+			t.Errorf("Expected ErrRecipeNotFound on update, got %v", err)
+		}
+		if err := store.DeleteRecipeByID(brasserie, l, id, AnyVersion); !errors.Is(err, ErrRecipeNotFound) {
+			t.Errorf("Expected ErrRecipeNotFound on delete, got %v", err)
+		}
+		upload := &ImageUpload{Image: Image{ID: store.NewID()}, Original: ImageFile{ContentType: "image/png", Data: []byte("png")}}
+		if _, err := store.AddRecipeImage(brasserie, l, id, upload, true); !errors.Is(err, ErrRecipeNotFound) {
+			t.Errorf("Expected ErrRecipeNotFound on image upload, got %v", err)
+		}
+		imported := []Recipe{recipe}
+		outcomes, err := store.ImportRecipes(brasserie, l, imported, ImportOptions{Mode: ImportUpsert, DryRun: true})
+		if err != nil || outcomes[0].Status == ImportUpdated {
+			t.Errorf("Expected the import not to update the recipe of another tenant, got %v (%v)", outcomes, err)
+		}
+
+		found, err := store.FindRecipeByID(bistro, l, id)
+		if err != nil || found.Name != "Crepes" || found.Version != InitialVersion {
+			t.Errorf("Expected the recipe untouched, got %v (%v)", found, err)
+		}
+	})
+
+	t.Run("The trash is kept by tenant", func(t *testing.T) {
+		if err := store.DeleteRecipeByID(bistro, l, id, AnyVersion); err != nil {
+			t.Fatalf("Error when trying to delete recipe: %v", err)
+		}
+		if trash, err := store.FindTrashedRecipes(brasserie, l, ListOptions{}); err != nil || trash.Total != 0 {
+			t.Errorf("Expected an empty trash, got %v (%v)", trash, err)
+		}
+		if _, err := store.RestoreRecipeByID(brasserie, l, id); !errors.Is(err, ErrRecipeNotFound) {
+			t.Errorf("Expected ErrRecipeNotFound on restore, got %v", err)
+		}
+		// The purge runs for every tenant
+		if purged, err := store.PurgeDeletedRecipes(context.Background(), l, time.Now().Add(time.Second)); err != nil || purged != 1 {
+			t.Errorf("Expected 1 recipe purged, got %v (%v)", purged, err)
+		}
+		if page, _ := store.FindAllRecipes(brasserie, l, ListOptions{}); page.Total != 1 {
+			t.Errorf("Expected the recipe of the other tenant to survive the purge, got %v", page)
+		}
+	})
+
+	t.Run("Events name the tenant of their recipe", func(t *testing.T) {
+		events, err := store.PendingEvents(context.Background(), l, 10)
+		if err != nil || len(events) == 0 {
+			t.Fatalf("Expected pending events, got %v (%v)", events, err)
+		}
+		for _, event := range events {
+			expected := "bistro"
+			if event.RecipeID == other.ID {
+				expected = "brasserie"
+			}
+			if event.TenantID != expected {
+				t.Errorf("Expected the event of %v to name %v, got %v", event.RecipeID.Hex(), expected, event.TenantID)
+			}
+		}
+	})
+}
+
+func TestMemoryStoreTenants(t *testing.T) {
+	testTenantIsolation(t, NewMemoryStore())
+}
+
+func TestBoltStoreTenants(t *testing.T) {
+	testTenantIsolation(t, newTestBoltStore(t))
+}
+
+func TestDbHandlerTenants(t *testing.T) {
+	dbh, teardownTest := setupTest(t)
+	defer teardownTest(t)
+
+	for _, mode := range []TenancyMode{SharedCollection, CollectionPerTenant} {
+		t.Run(string(mode), func(t *testing.T) {
+			handler := NewDbHandler(dbh.Client, dbh.DBName, "recipe_"+primitive.NewObjectID().Hex())
+			handler.Transactions = dbh.Transactions
+			handler.Tenancy = mode
+			if err := handler.EnsureIndexes(context.Background()); err != nil {
+				t.Fatalf("Error when trying to create the indexes: %v", err)
+			}
+			testTenantIsolation(t, handler)
+
+			l := logrus.WithField("test", t.Name())
+			taken := newTestRecipe(handler, "Tarte tatin", "arsene", "apple")
+			if err := handler.SaveRecipe(WithTenant(context.Background(), "bistro"), l, &taken); err != nil {
+				t.Fatalf("Error when trying to save recipe: %v", err)
+			}
+			imported := []Recipe{taken, newTestRecipe(handler, "Clafoutis", "arsene", "cherry")}
+			outcomes, err := handler.ImportRecipes(WithTenant(context.Background(), "brasserie"), l, imported, ImportOptions{Mode: ImportUpsert})
+			// The _id of a shared collection is unique across the tenants, the other recipe of the batch is still inserted
+			expected := ImportCreated
+			if mode == SharedCollection {
+				expected = ImportFailed
+			}
+			if err != nil || outcomes[0].Status != expected || outcomes[1].Status != ImportCreated {
+				t.Errorf("Expected the import to be %v and %v, got %v (%v)", expected, ImportCreated, outcomes, err)
+			}
+			if mode == SharedCollection && !errors.Is(outcomes[0].Err, ErrDuplicateID) {
+				t.Errorf("Expected ErrDuplicateID for the ID of another tenant, got %v", outcomes[0].Err)
+			}
+			stranger := WithTenant(context.Background(), "stranger")
+			if page, err := handler.FindAllRecipes(stranger, l, ListOptions{}); err != nil || page.Total != 0 {
+				t.Errorf("Expected no recipe for an unknown tenant, got %v (%v)", page, err)
+			}
+			if results, err := handler.SearchRecipes(stranger, l, "Crepes", ListOptions{}); err != nil || results.Total != 0 {
+				t.Errorf("Expected no search result for an unknown tenant, got %v (%v)", results, err)
+			}
+			if tenants, err := handler.Tenants(context.Background()); err != nil || slices.Contains(tenants, "stranger") {
+				t.Errorf("Expected the reads not to register the tenant, got %v (%v)", tenants, err)
+			}
+			names, err := dbh.Client.Database(dbh.DBName).ListCollectionNames(context.Background(), bson.M{"name": handler.bind("stranger").recipesCollectionName()})
+			if err != nil || len(names) != 0 {
+				t.Errorf("Expected the reads not to create a collection, got %v (%v)", names, err)
+			}
+			if mode == CollectionPerTenant {
+				indexes, err := handler.bind("bistro").GetRecipeCollection().Indexes().ListSpecifications(context.Background())
+				if err != nil {
+					t.Fatalf("Error when trying to list the indexes: %v", err)
+				}
+				for _, name := range []string{"author", "ingredients_id", "created_at", "deleted_at"} {
+					if !slices.ContainsFunc(indexes, func(index *mongo.IndexSpecification) bool { return index.Name == name }) {
+						t.Errorf("Expected the %v index in the collection of the tenant, got %v", name, indexes)
+					}
+				}
+			}
+
+			if _, err := handler.FindAllRecipes(context.Background(), l, ListOptions{}); !errors.Is(err, ErrMissingTenant) {
+				t.Errorf("Expected ErrMissingTenant without tenant, got %v", err)
+			}
+			if _, err := handler.FindAllRecipes(WithTenant(context.Background(), "../admin"), l, ListOptions{}); !errors.Is(err, ErrMissingTenant) {
+				t.Errorf("Expected ErrMissingTenant for an invalid tenant, got %v", err)
+			}
+		})
+	}
+}
@@ -0,0 +1,169 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Restrict the filter to the recipes of the tenant in the trash
+func (dbh *DbHandler) trashed(filter bson.M) bson.M {
+	filter["deleted_at"] = bson.M{"$ne": nil}
+	return dbh.scope(filter)
+}
+
+func (dbh *DbHandler) FindTrashedRecipes(ctx context.Context, l *logrus.Entry, opts ListOptions) (*RecipePage, error) {
+	return dbh.findPage(ctx, l, "FindTrashedRecipes", bson.M{}, (*DbHandler).trashed, opts)
+}
+
+// RestoreRecipeByID takes the recipe out of the trash and returns it with its next version.
+// Its RecipeUpdated event is recorded.
+func (dbh *DbHandler) RestoreRecipeByID(ctx context.Context, l *logrus.Entry, id string) (*Recipe, error) {
+	ctx, end := startOperation(ctx, "RestoreRecipeByID", dbh.Timeouts.Write)
+	defer end()
+	l = l.WithContext(ctx)
+	dbh, err := dbh.forTenant(ctx)
+	if err != nil {
+		l.WithError(err).Error("Error when trying to resolve the tenant")
+		return nil, wrapError(err)
+	}
+	objectID, _ := primitive.ObjectIDFromHex(id)
+	update := bson.M{"$unset": bson.M{"deleted_at": ""}, "$inc": bson.M{"version": 1}}
+	var recipe Recipe
+	err = dbh.withTransaction(ctx, func(ctx context.Context) error {
+		err := dbh.GetRecipeCollection().FindOneAndUpdate(ctx, dbh.trashed(bson.M{"_id": objectID}), update,
+			options.FindOneAndUpdate().SetReturnDocument(options.After)).Decode(&recipe)
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			return ErrRecipeNotFound
+		}
+		if err != nil {
+			return err
+		}
+		// The recipe is back for the consumers of the events
+		return dbh.recordEvent(ctx, RecipeUpdated, &recipe)
+	})
+	if err != nil {
+		l.WithError(err).Error("Error when trying to restore recipe by id")
+		return nil, wrapError(err)
+	}
+	return &recipe, nil
+}
+
+// PurgeDeletedRecipes removes for good the recipes of every tenant trashed before the given date with their revisions,
+// and returns the number of recipes removed
+func (dbh *DbHandler) PurgeDeletedRecipes(ctx context.Context, l *logrus.Entry, before time.Time) (int64, error) {
+	if err := dbh.checkReady(); err != nil {
+		return 0, err
+	}
+	ctx, end := startOperation(ctx, "PurgeDeletedRecipes", dbh.Timeouts.Write)
+	defer end()
+	l = l.WithContext(ctx)
+	if dbh.Tenancy != CollectionPerTenant {
+		// The unbound handler reaches every tenant of the shared collection
+		return dbh.bind("").purge(ctx, l, before)
+	}
+	tenants, err := dbh.Tenants(ctx)
+	if err != nil {
+		l.WithError(err).Error("Error when trying to find the tenants to purge")
+		return 0, err
+	}
+	purged := int64(0)
+	for _, tenant := range tenants {
+		n, err := dbh.bind(tenant).purge(ctx, l.WithField("tenant", tenant), before)
+		purged += n
+		if err != nil {
+			return purged, err
+		}
+	}
+	return purged, nil
+}
+
+// Purge the trash of the collections the handler is bound to
+func (dbh *DbHandler) purge(ctx context.Context, l *logrus.Entry, before time.Time) (int64, error) {
+	filter := bson.M{"deleted_at": bson.M{"$lt": before}}
+	ids, err := dbh.GetRecipeCollection().Distinct(ctx, "_id", filter)
+	if err != nil {
+		l.WithError(err).Error("Error when trying to find the recipes to purge")
+		return 0, wrapError(err)
+	}
+	if len(ids) == 0 {
+		return 0, nil
+	}
+	// The deletion date is checked again in case a recipe was restored meanwhile
+	res, err := dbh.GetRecipeCollection().DeleteMany(ctx, bson.M{"_id": bson.M{"$in": ids}, "deleted_at": bson.M{"$lt": before}})
+	if err != nil {
+		l.WithError(err).Error("Error when trying to purge the trash")
+		return 0, wrapError(err)
+	}
+	restored, err := dbh.GetRecipeCollection().Distinct(ctx, "_id", bson.M{"_id": bson.M{"$in": ids}})
+	if err == nil {
+		_, err = dbh.GetRevisionCollection().DeleteMany(ctx, bson.M{"recipe_id": bson.M{"$in": ids, "$nin": restored}})
+	}
+	if err != nil {
+		l.WithError(err).Error("Error when trying to purge the revisions")
+		return res.DeletedCount, wrapError(err)
+	}
+	if err := dbh.purgeImages(ctx, ids, restored); err != nil {
+		l.WithError(err).Error("Error when trying to purge the images")
+		return res.DeletedCount, wrapError(err)
+	}
+	return res.DeletedCount, nil
+}
+
+// Remove the image files of the purged recipes, the files are named after the ID of their recipe
+func (dbh *DbHandler) purgeImages(ctx context.Context, ids []any, restored []any) error {
+	names := make([]string, 0, len(ids))
+	for _, id := range ids {
+		if objectID, ok := id.(primitive.ObjectID); ok && !slices.Contains(restored, id) {
+			names = append(names, objectID.Hex())
+		}
+	}
+	bucket, err := dbh.imageBucket(ctx)
+	if err != nil {
+		return err
+	}
+	cursor, err := bucket.FindContext(ctx, bson.M{"filename": bson.M{"$in": names}})
+	if err != nil {
+		return err
+	}
+	var files []struct {
+		ID primitive.ObjectID `bson:"_id"`
+	}
+	if err := cursor.All(ctx, &files); err != nil {
+		return err
+	}
+	fileIDs := make([]primitive.ObjectID, len(files))
+	for i, file := range files {
+		fileIDs[i] = file.ID
+	}
+	return dbh.deleteImageFiles(ctx, fileIDs)
+}
+
+// PurgeTrash removes every interval the recipes which spent more than the retention in the trash,
+// until the context is done
+func PurgeTrash(ctx context.Context, store RecipeStore, retention time.Duration, interval time.Duration) {
+	l := loger.WithFields(logrus.Fields{
+		"action":    "purgeTrash",
+		"retention": retention,
+	})
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		purged, err := store.PurgeDeletedRecipes(ctx, l, now().Add(-retention))
+		if err == nil && purged > 0 {
+			l.WithField("purged", purged).Info("Purged the expired recipes of the trash")
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
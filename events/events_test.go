@@ -0,0 +1,160 @@
+package events
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"recipes/db"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// recordingPublisher keeps the published events and fails while failures is positive
+type recordingPublisher struct {
+	mu        sync.Mutex
+	events    []db.Event
+	failures  int
+	callCount int
+}
+
+func (p *recordingPublisher) Publish(ctx context.Context, event db.Event) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.callCount++
+	if p.failures > 0 {
+		p.failures--
+		return errors.New("consumer unavailable")
+	}
+	p.events = append(p.events, event)
+	return nil
+}
+
+func saveTestRecipes(t *testing.T, store *db.MemoryStore, names ...string) {
+	t.Helper()
+	l := logrus.WithField("test", t.Name())
+	for _, name := range names {
+		recipe := db.Recipe{ID: store.NewID(), Name: name, Author: "arsene"}
+		if err := store.SaveRecipe(context.Background(), l, &recipe); err != nil {
+			t.Fatalf("Error when trying to save recipe: %v", err)
+		}
+	}
+}
+
+func TestRelay(t *testing.T) {
+	ctx := context.Background()
+	l := logrus.WithField("test", t.Name())
+
+	t.Run("Events are published in order and only once they succeed", func(t *testing.T) {
+		store := db.NewMemoryStore()
+		saveTestRecipes(t, store, "Crepes", "Aioli", "Bouillabaisse")
+		publisher := &recordingPublisher{failures: 1}
+		relay := NewRelay(store, publisher)
+		relay.BatchSize = 2
+
+		if published, err := relay.Flush(ctx); err == nil || published != 0 {
+			t.Errorf("Expected the failure to stop the batch, got %v published (%v)", published, err)
+		}
+		if pending, _ := store.PendingEvents(ctx, l, 10); len(pending) != 3 {
+			t.Errorf("Expected the 3 events to stay pending, got %v", pending)
+		}
+		for _, expected := range []int{2, 1, 0} {
+			if published, err := relay.Flush(ctx); err != nil || published != expected {
+				t.Errorf("Expected %v events published, got %v (%v)", expected, published, err)
+			}
+		}
+		names := make([]string, len(publisher.events))
+		for i, event := range publisher.events {
+			names[i] = event.Recipe.Name
+		}
+		if len(names) != 3 || names[0] != "Crepes" || names[1] != "Aioli" || names[2] != "Bouillabaisse" {
+			t.Errorf("Expected the events in their order of writing, got %v", names)
+		}
+	})
+
+	t.Run("Only the relay holding the lease publishes", func(t *testing.T) {
+		store := db.NewMemoryStore()
+		saveTestRecipes(t, store, "Crepes", "Aioli")
+		first, second := &recordingPublisher{}, &recordingPublisher{}
+		relay, other := NewRelay(store, first), NewRelay(store, second)
+		relay.BatchSize, other.BatchSize = 1, 1
+		relay.Lease, other.Lease = 50*time.Millisecond, 50*time.Millisecond
+
+		if published, err := relay.Flush(ctx); err != nil || published != 1 {
+			t.Fatalf("Expected the first relay to claim the outbox, got %v published (%v)", published, err)
+		}
+		if published, err := other.Flush(ctx); err != nil || published != 0 {
+			t.Errorf("Expected the other relay to wait for the lease, got %v published (%v)", published, err)
+		}
+		time.Sleep(60 * time.Millisecond)
+		if published, err := other.Flush(ctx); err != nil || published != 1 {
+			t.Errorf("Expected the other relay to take the expired lease over, got %v published (%v)", published, err)
+		}
+		if len(first.events) != 1 || len(second.events) != 1 || second.events[0].Recipe.Name != "Aioli" {
+			t.Errorf("Expected every event published once in order, got %v and %v", first.events, second.events)
+		}
+	})
+
+	t.Run("Run drains the outbox until the context is done", func(t *testing.T) {
+		store := db.NewMemoryStore()
+		saveTestRecipes(t, store, "Crepes", "Aioli", "Bouillabaisse")
+		publisher := &recordingPublisher{failures: 2}
+		relay := NewRelay(store, publisher)
+		relay.Interval = time.Millisecond
+		relay.BatchSize = 1
+
+		runCtx, cancel := context.WithCancel(ctx)
+		done := make(chan struct{})
+		go func() {
+			relay.Run(runCtx)
+			close(done)
+		}()
+		deadline := time.Now().Add(time.Second)
+		for time.Now().Before(deadline) {
+			if pending, _ := store.PendingEvents(ctx, l, 10); len(pending) == 0 {
+				break
+			}
+			time.Sleep(time.Millisecond)
+		}
+		cancel()
+		<-done
+		if len(publisher.events) != 3 || publisher.callCount != 5 {
+			t.Errorf("Expected 3 events published in 5 calls, got %v in %v", len(publisher.events), publisher.callCount)
+		}
+	})
+}
+
+func TestHTTPPublisher(t *testing.T) {
+	ctx := context.Background()
+	store := db.NewMemoryStore()
+	saveTestRecipes(t, store, "Crepes")
+	events, _ := store.PendingEvents(ctx, logrus.WithField("test", t.Name()), 1)
+
+	var received db.Event
+	status := http.StatusAccepted
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost || r.Header.Get(HeaderIdempotencyKey) != events[0].ID.Hex() {
+			t.Errorf("Expected a POST with the idempotency key, got %v %v", r.Method, r.Header)
+		}
+		json.NewDecoder(r.Body).Decode(&received)
+		w.WriteHeader(status)
+	}))
+	defer server.Close()
+
+	publisher := NewHTTPPublisher(server.URL, time.Second)
+	if err := publisher.Publish(ctx, events[0]); err != nil {
+		t.Fatalf("Error when trying to publish the event: %v", err)
+	}
+	if received.ID != events[0].ID || received.Type != db.RecipeCreated || received.Recipe == nil || received.Recipe.Name != "Crepes" {
+		t.Errorf("Expected the event to be received, got %+v", received)
+	}
+
+	status = http.StatusServiceUnavailable
+	if err := publisher.Publish(ctx, events[0]); err == nil {
+		t.Errorf("Expected an error for a 503 response")
+	}
+}
@@ -0,0 +1,73 @@
+package events
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"recipes/db"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// HeaderIdempotencyKey holds the ID of the event, the consumers drop the events delivered twice with it
+const HeaderIdempotencyKey = "Idempotency-Key"
+
+// Publisher delivers the events of the outbox to the other services.
+// An event is retried until Publish succeeds, so it may be delivered more than once.
+type Publisher interface {
+	Publish(ctx context.Context, event db.Event) error
+}
+
+// LogPublisher only logs the events, for local runs and when no consumer is deployed
+type LogPublisher struct{}
+
+func (LogPublisher) Publish(ctx context.Context, event db.Event) error {
+	logger.WithContext(ctx).WithFields(logrus.Fields{
+		"event":    event.ID.Hex(),
+		"type":     event.Type,
+		"recipeID": event.RecipeID.Hex(),
+		"version":  event.Version,
+		"actor":    event.Actor,
+	}).Info("Recipe event published")
+	return nil
+}
+
+// HTTPPublisher POSTs every event as JSON to an URL, any status but 2xx is a failure
+type HTTPPublisher struct {
+	URL    string
+	Client *http.Client
+}
+
+func NewHTTPPublisher(url string, timeout time.Duration) *HTTPPublisher {
+	return &HTTPPublisher{
+		URL:    url,
+		Client: &http.Client{Timeout: timeout},
+	}
+}
+
+func (p *HTTPPublisher) Publish(ctx context.Context, event db.Event) error {
+	body, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, p.URL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(HeaderIdempotencyKey, event.ID.Hex())
+	res, err := p.Client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	io.Copy(io.Discard, res.Body)
+	if res.StatusCode < 200 || res.StatusCode > 299 {
+		return fmt.Errorf("publishing event %v to %v: unexpected status %v", event.ID.Hex(), p.URL, res.Status)
+	}
+	return nil
+}
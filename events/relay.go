@@ -0,0 +1,98 @@
+package events
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"recipes/db"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+var logger = logrus.WithFields(logrus.Fields{
+	"context": "events/relay",
+})
+
+// Outbox is the part of the recipe store holding the events to publish
+type Outbox interface {
+	ClaimEvents(ctx context.Context, l *logrus.Entry, owner string, lease time.Duration, limit int) ([]db.Event, error)
+	MarkEventsPublished(ctx context.Context, l *logrus.Entry, ids []primitive.ObjectID) error
+}
+
+// Relay moves the events from the outbox to the publisher, in their order of writing.
+// An event is only marked as published once the publisher accepted it: it is delivered at least once.
+// The relays of the replicas share the outbox, only the one holding its lease publishes.
+type Relay struct {
+	outbox    Outbox
+	publisher Publisher
+	owner     string
+	// Interval is the time between two polls of the outbox once it is empty or the publisher failed
+	Interval time.Duration
+	// BatchSize is the number of events read from the outbox at once
+	BatchSize int
+	// Lease is how long the outbox is claimed by a flush, another relay takes it over once it expired
+	Lease time.Duration
+}
+
+func NewRelay(outbox Outbox, publisher Publisher) *Relay {
+	hostname, _ := os.Hostname()
+	return &Relay{
+		outbox:    outbox,
+		publisher: publisher,
+		owner:     fmt.Sprintf("%v-%v", hostname, primitive.NewObjectID().Hex()),
+		Interval:  time.Second,
+		BatchSize: 100,
+		Lease:     time.Minute,
+	}
+}
+
+// Run relays the events until the context is done
+func (r *Relay) Run(ctx context.Context) {
+	ticker := time.NewTicker(r.Interval)
+	defer ticker.Stop()
+	for {
+		// Keep on while full batches are published, the outbox may hold more
+		published, err := r.Flush(ctx)
+		if err == nil && published == r.BatchSize {
+			continue
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Flush claims the outbox and publishes one batch of pending events, it returns how many were published.
+// It stops at the first failure so that the next events are not delivered before it,
+// and once the lease expired since another relay may have taken the outbox over.
+func (r *Relay) Flush(ctx context.Context) (int, error) {
+	l := logger.WithContext(ctx)
+	claimedAt := time.Now()
+	events, err := r.outbox.ClaimEvents(ctx, l, r.owner, r.Lease, r.BatchSize)
+	if err != nil {
+		return 0, err
+	}
+	published := make([]primitive.ObjectID, 0, len(events))
+	for _, event := range events {
+		if time.Since(claimedAt) >= r.Lease {
+			l.WithField("published", len(published)).Warn("The lease of the outbox expired, the rest of the batch waits for the next claim")
+			break
+		}
+		if err = r.publisher.Publish(ctx, event); err != nil {
+			l.WithError(err).WithField("event", event.ID.Hex()).Warn("Failed to publish the event, it will be retried")
+			break
+		}
+		published = append(published, event.ID)
+	}
+	if len(published) > 0 {
+		// If this fails the events are published again by the next flush
+		if err := r.outbox.MarkEventsPublished(ctx, l, published); err != nil {
+			return 0, err
+		}
+	}
+	return len(published), err
+}
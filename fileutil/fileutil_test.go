@@ -0,0 +1,26 @@
+package fileutil
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestWriteFileAtomic(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "state.json")
+	for _, content := range []string{`{"first": true}`, `{}`} {
+		if err := WriteFileAtomic(path, []byte(content)); err != nil {
+			t.Fatalf("Error when trying to write the file: %v", err)
+		}
+		if data, err := os.ReadFile(path); err != nil || string(data) != content {
+			t.Errorf("Expected %v, got %v (%v)", content, string(data), err)
+		}
+	}
+	if entries, err := os.ReadDir(dir); err != nil || len(entries) != 1 {
+		t.Errorf("Expected no temporary file left, got %v (%v)", entries, err)
+	}
+	if err := WriteFileAtomic(filepath.Join(dir, "missing", "state.json"), nil); err == nil {
+		t.Errorf("Expected an error in a missing directory")
+	}
+}
@@ -0,0 +1,172 @@
+package images
+
+import (
+	"bytes"
+	"errors"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/jpeg"
+	"net/http"
+	"slices"
+
+	// Register the decoders of the other accepted types
+	_ "image/gif"
+	_ "image/png"
+)
+
+var (
+	// ErrUnsupportedType is returned when the content is not a JPEG, PNG or GIF image
+	ErrUnsupportedType = errors.New("unsupported image type, expected image/jpeg, image/png or image/gif")
+	// ErrTooManyPixels is returned when the image is larger than MaxPixels once decoded
+	ErrTooManyPixels = errors.New("image dimensions too large")
+)
+
+// ContentTypes are the types of the images accepted by Process
+var ContentTypes = []string{"image/jpeg", "image/png", "image/gif"}
+
+// MaxPixels bounds the size of the decoded images, a small file can hold huge dimensions.
+// A decoded photo weighs 2 to 4 bytes a pixel, up to 96 MB at the bound.
+const MaxPixels = 24_000_000
+
+// Thumbnails are encoded as JPEG with this quality
+const thumbnailQuality = 85
+
+// Size is a thumbnail whose width and height fit in Max pixels
+type Size struct {
+	Name string
+	Max  int
+}
+
+// Sizes are the thumbnails generated for every image, from the smallest
+var Sizes = []Size{
+	{"small", 160},
+	{"medium", 480},
+	{"large", 1024},
+}
+
+// Thumbnail is a JPEG copy of an image reduced to fit in a Size
+type Thumbnail struct {
+	Name   string
+	Width  int
+	Height int
+	Data   []byte
+}
+
+// Processed is a decoded image with its thumbnails
+type Processed struct {
+	ContentType string
+	Width       int
+	Height      int
+	Thumbnails  []Thumbnail
+}
+
+// DetectContentType sniffs the type of the content, whatever the client announced
+func DetectContentType(data []byte) (string, error) {
+	contentType := http.DetectContentType(data)
+	if !slices.Contains(ContentTypes, contentType) {
+		return "", ErrUnsupportedType
+	}
+	return contentType, nil
+}
+
+// Process checks the type and the dimensions of the image and generates a thumbnail for every size.
+// The images are never enlarged, a thumbnail of a smaller image keeps its dimensions.
+func Process(data []byte) (*Processed, error) {
+	contentType, err := DetectContentType(data)
+	if err != nil {
+		return nil, err
+	}
+	config, _, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	if config.Width*config.Height > MaxPixels {
+		return nil, ErrTooManyPixels
+	}
+	src, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+
+	// The largest thumbnail is reduced from the decoded image, the smaller ones from it
+	bounds := src.Bounds()
+	processed := &Processed{ContentType: contentType, Width: bounds.Dx(), Height: bounds.Dy()}
+	largest := reduce(src, Sizes[len(Sizes)-1].Max)
+	for _, size := range Sizes {
+		width, height := fit(processed.Width, processed.Height, size.Max)
+		var buf bytes.Buffer
+		if err := jpeg.Encode(&buf, shrink(largest, width, height), &jpeg.Options{Quality: thumbnailQuality}); err != nil {
+			return nil, err
+		}
+		processed.Thumbnails = append(processed.Thumbnails, Thumbnail{Name: size.Name, Width: width, Height: height, Data: buf.Bytes()})
+	}
+	return processed, nil
+}
+
+// Dimensions of the image reduced to fit in bound pixels, keeping its ratio
+func fit(width int, height int, bound int) (int, int) {
+	if width <= bound && height <= bound {
+		return width, height
+	}
+	if width >= height {
+		return bound, max(1, height*bound/width)
+	}
+	return max(1, width*bound/height), bound
+}
+
+// Reduce the decoded image with a box filter to fit in bound pixels, its transparent pixels flattened over white since JPEG has no alpha.
+// The image is converted band by band: only the source rows of one reduced row are held in RGBA at once,
+// a full copy would weigh 4 bytes a pixel.
+func reduce(src image.Image, bound int) *image.RGBA {
+	bounds := src.Bounds()
+	srcWidth, srcHeight := bounds.Dx(), bounds.Dy()
+	width, height := fit(srcWidth, srcHeight, bound)
+	dst := image.NewRGBA(image.Rect(0, 0, width, height))
+	band := image.NewRGBA(image.Rect(0, 0, srcWidth, srcHeight/height+1))
+	for y := 0; y < height; y++ {
+		y0, y1 := y*srcHeight/height, max((y+1)*srcHeight/height, y*srcHeight/height+1)
+		rows := image.Rect(0, 0, srcWidth, y1-y0)
+		draw.Draw(band, rows, image.NewUniform(color.White), image.Point{}, draw.Src)
+		draw.Draw(band, rows, src, image.Pt(bounds.Min.X, bounds.Min.Y+y0), draw.Over)
+		averageRow(dst, y, band, y1-y0)
+	}
+	return dst
+}
+
+// Reduce the image with a box filter, every pixel is the average of the source pixels it covers
+func shrink(src *image.RGBA, width int, height int) *image.RGBA {
+	srcWidth, srcHeight := src.Bounds().Dx(), src.Bounds().Dy()
+	if width == srcWidth && height == srcHeight {
+		return src
+	}
+	dst := image.NewRGBA(image.Rect(0, 0, width, height))
+	for y := 0; y < height; y++ {
+		y0, y1 := y*srcHeight/height, max((y+1)*srcHeight/height, y*srcHeight/height+1)
+		averageRow(dst, y, src.SubImage(image.Rect(0, y0, srcWidth, y1)).(*image.RGBA), y1-y0)
+	}
+	return dst
+}
+
+// Set the row y of dst to the averages of the first rows of src, every pixel covering its share of the columns
+func averageRow(dst *image.RGBA, y int, src *image.RGBA, rows int) {
+	width, srcWidth := dst.Bounds().Dx(), src.Bounds().Dx()
+	for x := 0; x < width; x++ {
+		x0, x1 := x*srcWidth/width, max((x+1)*srcWidth/width, x*srcWidth/width+1)
+		var sum [4]int
+		for sy := 0; sy < rows; sy++ {
+			row := src.Pix[sy*src.Stride+x0*4 : sy*src.Stride+x1*4]
+			for i := 0; i < len(row); i += 4 {
+				sum[0] += int(row[i])
+				sum[1] += int(row[i+1])
+				sum[2] += int(row[i+2])
+				sum[3] += int(row[i+3])
+			}
+		}
+		count := rows * (x1 - x0)
+		offset := y*dst.Stride + x*4
+		for i := range sum {
+			dst.Pix[offset+i] = uint8(sum[i] / count)
+		}
+	}
+}
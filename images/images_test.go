@@ -0,0 +1,116 @@
+package images
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"hash/crc32"
+	"image"
+	"image/color"
+	"image/gif"
+	"image/jpeg"
+	"image/png"
+	"testing"
+)
+
+func encodePNG(t *testing.T, width int, height int) []byte {
+	t.Helper()
+	img := image.NewNRGBA(image.Rect(0, 0, width, height))
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			img.Set(x, y, color.NRGBA{R: 200, G: 40, B: 40, A: 255})
+		}
+	}
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		t.Fatalf("Error when trying to encode the image: %v", err)
+	}
+	return buf.Bytes()
+}
+
+// The signature and header of a PNG image, enough to read its dimensions without encoding its pixels
+func pngHeader(width uint32, height uint32) []byte {
+	chunk := []byte("IHDR")
+	chunk = binary.BigEndian.AppendUint32(chunk, width)
+	chunk = binary.BigEndian.AppendUint32(chunk, height)
+	// 8 bits RGBA, default compression, filter and interlacing
+	chunk = append(chunk, 8, 6, 0, 0, 0)
+	header := []byte("\x89PNG\r\n\x1a\n")
+	header = binary.BigEndian.AppendUint32(header, uint32(len(chunk)-4))
+	header = append(header, chunk...)
+	return binary.BigEndian.AppendUint32(header, crc32.ChecksumIEEE(chunk))
+}
+
+func TestProcess(t *testing.T) {
+	var gifData bytes.Buffer
+	gif.Encode(&gifData, image.NewPaletted(image.Rect(0, 0, 40, 30), []color.Color{color.Black, color.White}), nil)
+
+	for _, test := range []struct {
+		name        string
+		data        []byte
+		contentType string
+		// Expected dimensions of the small, medium and large thumbnails
+		sizes [3][2]int
+	}{
+		{"Landscape image", encodePNG(t, 2000, 1000), "image/png", [3][2]int{{160, 80}, {480, 240}, {1024, 512}}},
+		{"Portrait image", encodePNG(t, 300, 600), "image/png", [3][2]int{{80, 160}, {240, 480}, {300, 600}}},
+		{"Image smaller than every size", gifData.Bytes(), "image/gif", [3][2]int{{40, 30}, {40, 30}, {40, 30}}},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			processed, err := Process(test.data)
+			if err != nil {
+				t.Fatalf("Error when trying to process the image: %v", err)
+			}
+			if processed.ContentType != test.contentType || len(processed.Thumbnails) != len(Sizes) {
+				t.Fatalf("Expected %v with %v thumbnails, got %+v", test.contentType, len(Sizes), processed)
+			}
+			for i, thumbnail := range processed.Thumbnails {
+				decoded, err := jpeg.Decode(bytes.NewReader(thumbnail.Data))
+				if err != nil {
+					t.Fatalf("Expected a JPEG thumbnail %v, got %v", thumbnail.Name, err)
+				}
+				size := decoded.Bounds().Size()
+				if thumbnail.Name != Sizes[i].Name || size.X != test.sizes[i][0] || size.Y != test.sizes[i][1] ||
+					thumbnail.Width != size.X || thumbnail.Height != size.Y {
+					t.Errorf("Expected the %v thumbnail in %v, got %v %v", Sizes[i].Name, test.sizes[i], thumbnail.Name, size)
+				}
+			}
+		})
+	}
+}
+
+func TestProcessKeepsTheColors(t *testing.T) {
+	processed, err := Process(encodePNG(t, 640, 320))
+	if err != nil {
+		t.Fatalf("Error when trying to process the image: %v", err)
+	}
+	decoded, _ := jpeg.Decode(bytes.NewReader(processed.Thumbnails[0].Data))
+	r, g, b, _ := decoded.At(80, 40).RGBA()
+	if r>>8 < 180 || g>>8 > 60 || b>>8 > 60 {
+		t.Errorf("Expected the red of the image in the thumbnail, got %v %v %v", r>>8, g>>8, b>>8)
+	}
+
+	var transparent bytes.Buffer
+	png.Encode(&transparent, image.NewNRGBA(image.Rect(0, 0, 2000, 100)))
+	processed, err = Process(transparent.Bytes())
+	if err != nil {
+		t.Fatalf("Error when trying to process the image: %v", err)
+	}
+	decoded, _ = jpeg.Decode(bytes.NewReader(processed.Thumbnails[1].Data))
+	if r, g, b, _ := decoded.At(240, 12).RGBA(); r>>8 < 250 || g>>8 < 250 || b>>8 < 250 {
+		t.Errorf("Expected the transparent pixels flattened over white, got %v %v %v", r>>8, g>>8, b>>8)
+	}
+}
+
+func TestProcessRejects(t *testing.T) {
+	if _, err := Process([]byte("%PDF-1.4 not an image")); !errors.Is(err, ErrUnsupportedType) {
+		t.Errorf("Expected ErrUnsupportedType, got %v", err)
+	}
+	if _, err := Process(pngHeader(10000, 5000)); !errors.Is(err, ErrTooManyPixels) {
+		t.Errorf("Expected ErrTooManyPixels, got %v", err)
+	}
+	truncated := encodePNG(t, 100, 100)
+	if _, err := Process(truncated[:len(truncated)/2]); err == nil {
+		t.Errorf("Expected an error for a truncated image")
+	}
+}
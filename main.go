@@ -1,10 +1,17 @@
 package main
 
 import (
+	"context"
 	"fmt"
+	"os"
 	"recipes/api"
+	"recipes/cache"
 	"recipes/configuration"
 	"recipes/db"
+	"recipes/events"
+	"recipes/migrations"
+	"recipes/preferences"
+	"recipes/units"
 	"recipes/validation"
 
 	"github.com/sirupsen/logrus"
@@ -19,20 +26,109 @@ func main() {
 
 	conf := configuration.New()
 	logger.Logger.SetLevel(conf.LogLevel)
-	dbh, err := db.New(conf.DBURI, conf.DBName, conf.RecipesCollectionName)
 
-	if err != nil {
+	if len(os.Args) > 1 && os.Args[1] == "migrate" {
+		if err := runMigrate(conf, os.Args[2:]); err != nil {
+			logger.WithError(err).Fatal("Migration failed")
+		}
 		return
 	}
 
+	if len(os.Args) > 1 && os.Args[1] == "seed" {
+		if err := runSeed(conf, os.Args[2:]); err != nil {
+			logger.WithError(err).Fatal("Seeding failed")
+		}
+		return
+	}
+
+	var dbh db.RecipeStore
+	switch conf.StorageBackend {
+	case configuration.MemoryBackend:
+		logger.Warn("Using the in-memory storage backend, recipes will be lost on restart")
+		dbh = db.NewMemoryStore()
+	case configuration.BoltBackend:
+		logger.WithField("path", conf.BoltPath).Info("Opening the bbolt storage file...")
+		// The lock of a file opened by another instance is awaited like a connection
+		boltStore, err := db.OpenBoltStore(conf.BoltPath, conf.DBConnectTimeout)
+		if err != nil {
+			logger.WithError(err).Fatal("Failed to open the bbolt storage file")
+		}
+		dbh = boltStore
+	default:
+		opts := db.ConnectOptions{
+			Timeouts: db.Timeouts{
+				Connect: conf.DBConnectTimeout,
+				Read:    conf.DBReadTimeout,
+				Write:   conf.DBWriteTimeout,
+			},
+			Tenancy:             db.TenancyMode(conf.TenancyMode),
+			Retries:             conf.DBConnectRetries,
+			Backoff:             conf.DBConnectBackoff,
+			MaxBackoff:          conf.DBConnectMaxBackoff,
+			Grace:               conf.DBStartupGrace,
+			AllowNoTransactions: conf.DBAllowNoTransactions,
+		}
+		if conf.DBMigrateOnStart {
+			opts.Setup = func(ctx context.Context, dbh *db.DbHandler) error {
+				_, err := migrations.New(dbh).Up(ctx, 0)
+				return err
+			}
+		}
+		logger.Info("Connecting to MongoDB...")
+		mongoHandler, err := db.Connect(context.Background(), conf.DBURI, conf.DBName, conf.RecipesCollectionName, opts)
+		if err != nil {
+			logger.WithError(err).Fatal("Failed to connect to MongoDB")
+		}
+		if conf.DBStartupGrace {
+			logger.Info("Starting before MongoDB is ready, the API reports not ready until then")
+		}
+		dbh = mongoHandler
+	}
+
+	if conf.CacheSize > 0 {
+		dbh = db.NewCachedStore(dbh, cache.NewLRU(conf.CacheSize, conf.CacheTTL))
+	}
+
+	if conf.TrashRetention > 0 {
+		go db.PurgeTrash(context.Background(), dbh, conf.TrashRetention, conf.TrashPurgeInterval)
+	}
+
+	var publisher events.Publisher
+	switch conf.EventsPublisher {
+	case configuration.LogPublisher:
+		publisher = events.LogPublisher{}
+	case configuration.HTTPPublisher:
+		publisher = events.NewHTTPPublisher(conf.EventsHTTPURL, conf.EventsHTTPTimeout)
+	}
+	if publisher != nil {
+		relay := events.NewRelay(dbh, publisher)
+		relay.Interval = conf.EventsRelayInterval
+		go relay.Run(context.Background())
+	}
+
 	val := validation.New(conf)
 	r := api.New(val)
 	v1 := r.Group(conf.ListenRoute)
 
-	if err != nil {
-		return
-	}
 	h := api.NewApiHandler(dbh, conf)
+	if conf.DensitiesPath != "" {
+		densities, err := units.LoadDensities(conf.DensitiesPath)
+		if err != nil {
+			logger.WithError(err).Fatal("Failed to load the ingredient densities")
+		}
+		h.UseDensities(densities)
+	} else {
+		logger.Warn("DENSITIES_PATH is not set, the ingredient densities will be lost on restart")
+	}
+	if conf.PreferencesPath != "" {
+		store, err := preferences.Load(conf.PreferencesPath)
+		if err != nil {
+			logger.WithError(err).Fatal("Failed to load the user preferences")
+		}
+		h.UsePreferences(store)
+	} else {
+		logger.Warn("PREFERENCES_PATH is not set, the user preferences will be lost on restart")
+	}
 
 	h.Register(v1, conf)
 	r.Logger.Fatal(r.Start(fmt.Sprintf("%v:%v", conf.ListenAddress, conf.ListenPort)))
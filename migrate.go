@@ -0,0 +1,86 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"recipes/configuration"
+	"recipes/db"
+	"recipes/migrations"
+	"time"
+)
+
+const migrateUsage = `Usage: recipes migrate [-dry-run] [-to version] up|down|status
+
+  up      apply the pending migrations up to -to (default: the latest)
+  down    revert the applied migrations above -to (default: all of them)
+  status  list the migrations and when they were applied
+`
+
+// runMigrate implements the migrate command
+func runMigrate(conf *configuration.Configuration, args []string) error {
+	flags := flag.NewFlagSet("migrate", flag.ContinueOnError)
+	flags.Usage = func() { fmt.Fprint(flags.Output(), migrateUsage) }
+	dryRun := flags.Bool("dry-run", false, "only log the migrations which would run")
+	target := flags.Int("to", 0, "target version")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if flags.NArg() != 1 {
+		flags.Usage()
+		return errors.New("expected one of up, down, status")
+	}
+	if conf.StorageBackend != configuration.MongoBackend {
+		return fmt.Errorf("migrations only apply to the %v storage backend", configuration.MongoBackend)
+	}
+
+	dbh, err := db.Connect(context.Background(), conf.DBURI, conf.DBName, conf.RecipesCollectionName, db.ConnectOptions{
+		Timeouts: db.Timeouts{
+			Connect: conf.DBConnectTimeout,
+			Read:    conf.DBReadTimeout,
+			Write:   conf.DBWriteTimeout,
+		},
+		AllowNoTransactions: conf.DBAllowNoTransactions,
+	})
+	if err != nil {
+		return err
+	}
+	migrator := migrations.New(dbh)
+	migrator.DryRun = *dryRun
+	ctx := context.Background()
+
+	var done []migrations.Migration
+	switch flags.Arg(0) {
+	case "up":
+		done, err = migrator.Up(ctx, *target)
+	case "down":
+		done, err = migrator.Down(ctx, *target)
+	case "status":
+		statuses, err := migrator.Status(ctx)
+		if err != nil {
+			return err
+		}
+		for _, status := range statuses {
+			appliedAt := "pending"
+			if status.AppliedAt != nil {
+				appliedAt = status.AppliedAt.Format(time.RFC3339)
+			}
+			fmt.Printf("%4d  %-25v  %v\n", status.Version, appliedAt, status.Description)
+		}
+		return nil
+	default:
+		flags.Usage()
+		return fmt.Errorf("unknown migrate command %v", flags.Arg(0))
+	}
+
+	verb := "Ran"
+	if *dryRun {
+		verb = "Would run"
+	}
+	for _, migration := range done {
+		fmt.Fprintf(os.Stdout, "%v %v %v: %v\n", verb, flags.Arg(0), migration.Version, migration.Description)
+	}
+	return err
+}
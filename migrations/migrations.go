@@ -0,0 +1,251 @@
+package migrations
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"recipes/db"
+	"slices"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+var logger = logrus.WithFields(logrus.Fields{
+	"context": "migrations/migrations",
+})
+
+var (
+	ErrIrreversible    = errors.New("migration cannot be reverted")
+	ErrLockNotAcquired = errors.New("could not acquire the migration lock")
+	ErrUnknownVersion  = errors.New("unknown migration version")
+)
+
+// Migration changes the schema or the data of the recipe collection.
+// Down reverts Up, a nil Down makes the migration irreversible.
+type Migration struct {
+	Version     int
+	Description string
+	Up          func(ctx context.Context, dbh *db.DbHandler) error
+	Down        func(ctx context.Context, dbh *db.DbHandler) error
+}
+
+// Record is an applied migration, as stored in the schema_migrations collection
+type Record struct {
+	Version     int       `bson:"_id"`
+	Description string    `bson:"description"`
+	AppliedAt   time.Time `bson:"applied_at"`
+}
+
+// Status tells if a known migration has been applied
+type Status struct {
+	Version     int
+	Description string
+	AppliedAt   *time.Time
+}
+
+// State keeps track of the applied migrations and of the lock shared by the replicas
+type State interface {
+	Applied(ctx context.Context) ([]Record, error)
+	Save(ctx context.Context, record Record) error
+	Remove(ctx context.Context, version int) error
+	// Lock returns false when another owner holds a lock which has not expired
+	Lock(ctx context.Context, owner string, ttl time.Duration) (bool, error)
+	Unlock(ctx context.Context, owner string) error
+}
+
+type Migrator struct {
+	dbh        *db.DbHandler
+	state      State
+	migrations []Migration
+	owner      string
+	// DryRun only logs the migrations which would run
+	DryRun bool
+	// LockTTL is the time after which the lock of a crashed replica can be taken
+	LockTTL time.Duration
+	// LockPoll is the interval between two attempts to take the lock
+	LockPoll time.Duration
+}
+
+func NewMigrator(dbh *db.DbHandler, state State, migrations []Migration) *Migrator {
+	hostname, _ := os.Hostname()
+	sorted := slices.Clone(migrations)
+	slices.SortFunc(sorted, func(a, b Migration) int { return a.Version - b.Version })
+	return &Migrator{
+		dbh:        dbh,
+		state:      state,
+		migrations: sorted,
+		owner:      fmt.Sprintf("%v-%v", hostname, primitive.NewObjectID().Hex()),
+		LockTTL:    10 * time.Minute,
+		LockPoll:   500 * time.Millisecond,
+	}
+}
+
+// New returns a migrator of the recipe collection running the registered migrations
+func New(dbh *db.DbHandler) *Migrator {
+	return NewMigrator(dbh, NewMongoState(dbh), Registered)
+}
+
+func (m *Migrator) applied(ctx context.Context) (map[int]Record, error) {
+	records, err := m.state.Applied(ctx)
+	if err != nil {
+		return nil, err
+	}
+	applied := make(map[int]Record, len(records))
+	for _, record := range records {
+		applied[record.Version] = record
+	}
+	return applied, nil
+}
+
+func (m *Migrator) find(version int) (Migration, bool) {
+	i := slices.IndexFunc(m.migrations, func(migration Migration) bool { return migration.Version == version })
+	if i < 0 {
+		return Migration{}, false
+	}
+	return m.migrations[i], true
+}
+
+func (m *Migrator) Status(ctx context.Context) ([]Status, error) {
+	applied, err := m.applied(ctx)
+	if err != nil {
+		return nil, err
+	}
+	statuses := make([]Status, len(m.migrations))
+	for i, migration := range m.migrations {
+		statuses[i] = Status{Version: migration.Version, Description: migration.Description}
+		if record, ok := applied[migration.Version]; ok {
+			statuses[i].AppliedAt = &record.AppliedAt
+		}
+	}
+	return statuses, nil
+}
+
+// Up applies in order the pending migrations up to the target version, 0 meaning the latest.
+// It returns the migrations applied, or which would be applied in dry-run mode.
+func (m *Migrator) Up(ctx context.Context, target int) ([]Migration, error) {
+	if target != 0 {
+		if _, ok := m.find(target); !ok {
+			return nil, fmt.Errorf("%w: %v", ErrUnknownVersion, target)
+		}
+	}
+	done := make([]Migration, 0)
+	for _, migration := range m.migrations {
+		if target != 0 && migration.Version > target {
+			break
+		}
+		ran, err := m.run(ctx, migration, true)
+		if err != nil {
+			return done, err
+		}
+		if ran {
+			done = append(done, migration)
+		}
+	}
+	return done, nil
+}
+
+// Down reverts in reverse order the applied migrations above the target version, 0 reverting them all.
+// It returns the migrations reverted, or which would be reverted in dry-run mode.
+func (m *Migrator) Down(ctx context.Context, target int) ([]Migration, error) {
+	if target != 0 {
+		if _, ok := m.find(target); !ok {
+			return nil, fmt.Errorf("%w: %v", ErrUnknownVersion, target)
+		}
+	}
+	done := make([]Migration, 0)
+	for i := len(m.migrations) - 1; i >= 0 && m.migrations[i].Version > target; i-- {
+		ran, err := m.run(ctx, m.migrations[i], false)
+		if err != nil {
+			return done, err
+		}
+		if ran {
+			done = append(done, m.migrations[i])
+		}
+	}
+	return done, nil
+}
+
+// Run one migration under the lock, unless another replica already did it.
+// It returns true if the migration ran.
+func (m *Migrator) run(ctx context.Context, migration Migration, up bool) (bool, error) {
+	direction := "up"
+	if !up {
+		direction = "down"
+	}
+	l := logger.WithContext(ctx).WithFields(logrus.Fields{
+		"version":     migration.Version,
+		"description": migration.Description,
+		"direction":   direction,
+		"dryRun":      m.DryRun,
+	})
+
+	if !m.DryRun {
+		if err := m.lock(ctx); err != nil {
+			return false, err
+		}
+		defer func() {
+			if err := m.state.Unlock(context.WithoutCancel(ctx), m.owner); err != nil {
+				l.WithError(err).Error("Failed to release the migration lock")
+			}
+		}()
+	}
+
+	// The state is read under the lock, another replica may have run the migration meanwhile
+	applied, err := m.applied(ctx)
+	if err != nil {
+		return false, err
+	}
+	if _, ok := applied[migration.Version]; ok == up {
+		return false, nil
+	}
+	if !up && migration.Down == nil {
+		return false, fmt.Errorf("%w: %v %v", ErrIrreversible, migration.Version, migration.Description)
+	}
+	if m.DryRun {
+		l.Info("Migration would run")
+		return true, nil
+	}
+
+	l.Info("Running migration")
+	if up {
+		err = migration.Up(ctx, m.dbh)
+	} else {
+		err = migration.Down(ctx, m.dbh)
+	}
+	if err != nil {
+		l.WithError(err).Error("Migration failed")
+		return false, fmt.Errorf("migration %v %v: %w", migration.Version, direction, err)
+	}
+	if up {
+		err = m.state.Save(ctx, Record{Version: migration.Version, Description: migration.Description, AppliedAt: time.Now().UTC()})
+	} else {
+		err = m.state.Remove(ctx, migration.Version)
+	}
+	if err != nil {
+		return true, err
+	}
+	l.Info("Migration done")
+	return true, nil
+}
+
+// Wait for the lock until the context is done
+func (m *Migrator) lock(ctx context.Context) error {
+	for {
+		locked, err := m.state.Lock(ctx, m.owner, m.LockTTL)
+		if err != nil {
+			return err
+		}
+		if locked {
+			return nil
+		}
+		logger.WithContext(ctx).Debug("Migration lock held by another replica, waiting")
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("%w: %w", ErrLockNotAcquired, ctx.Err())
+		case <-time.After(m.LockPoll):
+		}
+	}
+}
@@ -0,0 +1,206 @@
+package migrations
+
+import (
+	"context"
+	"errors"
+	"recipes/db"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+)
+
+// memoryState is a State shared by the migrators of a test
+type memoryState struct {
+	mu        sync.Mutex
+	records   map[int]Record
+	owner     string
+	expiresAt time.Time
+}
+
+func newMemoryState() *memoryState {
+	return &memoryState{records: make(map[int]Record)}
+}
+
+func (s *memoryState) Applied(ctx context.Context) ([]Record, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	records := make([]Record, 0, len(s.records))
+	for _, record := range s.records {
+		records = append(records, record)
+	}
+	return records, nil
+}
+
+func (s *memoryState) Save(ctx context.Context, record Record) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.records[record.Version] = record
+	return nil
+}
+
+func (s *memoryState) Remove(ctx context.Context, version int) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.records, version)
+	return nil
+}
+
+func (s *memoryState) Lock(ctx context.Context, owner string, ttl time.Duration) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.owner != "" && s.owner != owner && time.Now().Before(s.expiresAt) {
+		return false, nil
+	}
+	s.owner, s.expiresAt = owner, time.Now().Add(ttl)
+	return true, nil
+}
+
+func (s *memoryState) Unlock(ctx context.Context, owner string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.owner == owner {
+		s.owner = ""
+	}
+	return nil
+}
+
+// journal records the migrations run by the tests
+type journal struct {
+	mu      sync.Mutex
+	entries []string
+}
+
+func (j *journal) add(entry string) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	j.entries = append(j.entries, entry)
+}
+
+func testMigrations(j *journal) []Migration {
+	migration := func(version int, name string, reversible bool) Migration {
+		m := Migration{
+			Version:     version,
+			Description: name,
+			Up: func(ctx context.Context, dbh *db.DbHandler) error {
+				// Leave time to the other migrators to try to run it too
+				time.Sleep(time.Millisecond)
+				j.add("up " + name)
+				return nil
+			},
+		}
+		if reversible {
+			m.Down = func(ctx context.Context, dbh *db.DbHandler) error {
+				j.add("down " + name)
+				return nil
+			}
+		}
+		return m
+	}
+	// Registered out of order on purpose
+	return []Migration{migration(2, "second", true), migration(1, "first", false), migration(3, "third", true)}
+}
+
+func newTestMigrator(state State, j *journal) *Migrator {
+	m := NewMigrator(nil, state, testMigrations(j))
+	m.LockPoll = time.Millisecond
+	return m
+}
+
+func TestMigrator(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("Up applies the pending migrations in order", func(t *testing.T) {
+		j := &journal{}
+		m := newTestMigrator(newMemoryState(), j)
+		if _, err := m.Up(ctx, 2); err != nil {
+			t.Fatalf("Error when trying to migrate up: %v", err)
+		}
+		done, err := m.Up(ctx, 0)
+		if err != nil || len(done) != 1 || done[0].Version != 3 {
+			t.Fatalf("Expected only the third migration to run, got %v (%v)", done, err)
+		}
+		if done, _ := m.Up(ctx, 0); len(done) != 0 {
+			t.Errorf("Expected nothing left to run, got %v", done)
+		}
+		if expected := []string{"up first", "up second", "up third"}; !slices.Equal(j.entries, expected) {
+			t.Errorf("Expected %v, got %v", expected, j.entries)
+		}
+
+		statuses, _ := m.Status(ctx)
+		for _, status := range statuses {
+			if status.AppliedAt == nil {
+				t.Errorf("Expected migration %v to be applied", status.Version)
+			}
+		}
+	})
+
+	t.Run("Down reverts in reverse order", func(t *testing.T) {
+		j := &journal{}
+		m := newTestMigrator(newMemoryState(), j)
+		m.Up(ctx, 0)
+		done, err := m.Down(ctx, 1)
+		if err != nil || len(done) != 2 {
+			t.Fatalf("Expected 2 migrations reverted, got %v (%v)", done, err)
+		}
+		if expected := []string{"up first", "up second", "up third", "down third", "down second"}; !slices.Equal(j.entries, expected) {
+			t.Errorf("Expected %v, got %v", expected, j.entries)
+		}
+		if _, err := m.Down(ctx, 0); !errors.Is(err, ErrIrreversible) {
+			t.Errorf("Expected ErrIrreversible, got %v", err)
+		}
+		if _, err := m.Down(ctx, 42); !errors.Is(err, ErrUnknownVersion) {
+			t.Errorf("Expected ErrUnknownVersion, got %v", err)
+		}
+	})
+
+	t.Run("Dry run changes nothing", func(t *testing.T) {
+		j := &journal{}
+		state := newMemoryState()
+		m := newTestMigrator(state, j)
+		m.DryRun = true
+		done, err := m.Up(ctx, 0)
+		if err != nil || len(done) != 3 {
+			t.Fatalf("Expected 3 migrations to be planned, got %v (%v)", done, err)
+		}
+		if len(j.entries) != 0 || len(state.records) != 0 {
+			t.Errorf("Expected no migration to run, got %v and %v", j.entries, state.records)
+		}
+	})
+
+	t.Run("Concurrent replicas run each migration once", func(t *testing.T) {
+		j := &journal{}
+		state := newMemoryState()
+		var wg sync.WaitGroup
+		for i := 0; i < 5; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				if _, err := newTestMigrator(state, j).Up(ctx, 0); err != nil {
+					t.Errorf("Error when trying to migrate up: %v", err)
+				}
+			}()
+		}
+		wg.Wait()
+		if expected := []string{"up first", "up second", "up third"}; !slices.Equal(j.entries, expected) {
+			t.Errorf("Expected %v, got %v", expected, j.entries)
+		}
+	})
+
+	t.Run("Wait for the lock of another replica", func(t *testing.T) {
+		state := newMemoryState()
+		state.Lock(ctx, "another replica", time.Hour)
+		m := newTestMigrator(state, &journal{})
+		timeout, cancel := context.WithTimeout(ctx, 20*time.Millisecond)
+		defer cancel()
+		if _, err := m.Up(timeout, 0); !errors.Is(err, ErrLockNotAcquired) {
+			t.Errorf("Expected ErrLockNotAcquired, got %v", err)
+		}
+
+		// The lock of a crashed replica expires
+		state.expiresAt = time.Now().Add(-time.Second)
+		if done, err := m.Up(ctx, 0); err != nil || len(done) != 3 {
+			t.Errorf("Expected the expired lock to be taken, got %v (%v)", done, err)
+		}
+	})
+}
@@ -0,0 +1,187 @@
+package migrations
+
+import (
+	"context"
+	"recipes/db"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Registered are the migrations of the recipe collection, never change a released one.
+// Add a new migration with the next version instead.
+var Registered = []Migration{
+	{
+		Version:     1,
+		Description: "Index the recipes by author, ingredient and creation date",
+		Up: func(ctx context.Context, dbh *db.DbHandler) error {
+			_, err := dbh.GetRecipeCollection().Indexes().CreateMany(ctx, []mongo.IndexModel{
+				{Keys: bson.D{{Key: "author", Value: 1}, {Key: "_id", Value: 1}}, Options: options.Index().SetName("author")},
+				{Keys: bson.D{{Key: "ingredients._id", Value: 1}}, Options: options.Index().SetName("ingredients_id")},
+				{Keys: bson.D{{Key: "created_at", Value: 1}, {Key: "_id", Value: 1}}, Options: options.Index().SetName("created_at")},
+			})
+			return err
+		},
+		Down: func(ctx context.Context, dbh *db.DbHandler) error {
+			for _, name := range []string{"author", "ingredients_id", "created_at"} {
+				if _, err := dbh.GetRecipeCollection().Indexes().DropOne(ctx, name); err != nil {
+					return err
+				}
+			}
+			return nil
+		},
+	},
+	{
+		Version:     2,
+		Description: "Set the creation date of the recipes created before it was stored",
+		Up: func(ctx context.Context, dbh *db.DbHandler) error {
+			_, err := dbh.GetRecipeCollection().UpdateMany(ctx,
+				bson.M{"created_at": bson.M{"$exists": false}},
+				bson.A{bson.M{"$set": bson.M{"created_at": bson.M{"$toDate": "$_id"}}}},
+			)
+			return err
+		},
+		Down: func(ctx context.Context, dbh *db.DbHandler) error {
+			_, err := dbh.GetRecipeCollection().UpdateMany(ctx,
+				bson.M{"$expr": bson.M{"$eq": bson.A{"$created_at", bson.M{"$toDate": "$_id"}}}},
+				bson.M{"$unset": bson.M{"created_at": ""}},
+			)
+			return err
+		},
+	},
+	{
+		Version:     3,
+		Description: "Copy the metadata values of the recipes for the text index",
+		Up: func(ctx context.Context, dbh *db.DbHandler) error {
+			_, err := dbh.GetRecipeCollection().UpdateMany(ctx,
+				bson.M{"metadata_values": bson.M{"$exists": false}},
+				bson.A{bson.M{"$set": bson.M{"metadata_values": bson.M{"$map": bson.M{
+					"input": bson.M{"$objectToArray": bson.M{"$ifNull": bson.A{"$metadata", bson.M{}}}},
+					"as":    "entry",
+					"in":    "$$entry.v",
+				}}}}},
+			)
+			return err
+		},
+		Down: func(ctx context.Context, dbh *db.DbHandler) error {
+			_, err := dbh.GetRecipeCollection().UpdateMany(ctx, bson.M{}, bson.M{"$unset": bson.M{"metadata_values": ""}})
+			return err
+		},
+	},
+	{
+		Version:     4,
+		Description: "Index the trashed recipes by deletion date",
+		Up: func(ctx context.Context, dbh *db.DbHandler) error {
+			_, err := dbh.GetRecipeCollection().Indexes().CreateOne(ctx, mongo.IndexModel{
+				Keys:    bson.D{{Key: "deleted_at", Value: 1}},
+				Options: options.Index().SetName("deleted_at").SetSparse(true),
+			})
+			return err
+		},
+		Down: func(ctx context.Context, dbh *db.DbHandler) error {
+			_, err := dbh.GetRecipeCollection().Indexes().DropOne(ctx, "deleted_at")
+			return err
+		},
+	},
+	{
+		Version:     5,
+		Description: "Index the pending events of the outbox and expire the published ones after 7 days",
+		Up: func(ctx context.Context, dbh *db.DbHandler) error {
+			_, err := dbh.GetOutboxCollection().Indexes().CreateOne(ctx, mongo.IndexModel{
+				Keys:    bson.D{{Key: "published_at", Value: 1}},
+				Options: options.Index().SetName("published_at").SetExpireAfterSeconds(7 * 24 * 60 * 60),
+			})
+			return err
+		},
+		Down: func(ctx context.Context, dbh *db.DbHandler) error {
+			_, err := dbh.GetOutboxCollection().Indexes().DropOne(ctx, "published_at")
+			return err
+		},
+	},
+	{
+		Version:     6,
+		Description: "Sum the timers of the recipes in seconds for the total time filter",
+		Up: func(ctx context.Context, dbh *db.DbHandler) error {
+			seconds := bson.M{"$switch": bson.M{
+				"branches": bson.A{
+					bson.M{"case": bson.M{"$eq": bson.A{"$$timer.units", "hours"}}, "then": 3600},
+					bson.M{"case": bson.M{"$eq": bson.A{"$$timer.units", "minutes"}}, "then": 60},
+				},
+				"default": 1,
+			}}
+			_, err := dbh.GetRecipeCollection().UpdateMany(ctx,
+				bson.M{"total_time": bson.M{"$exists": false}},
+				bson.A{bson.M{"$set": bson.M{"total_time": bson.M{"$sum": bson.M{"$map": bson.M{
+					"input": bson.M{"$ifNull": bson.A{"$timers", bson.A{}}},
+					"as":    "timer",
+					"in":    bson.M{"$multiply": bson.A{"$$timer.quantity", seconds}},
+				}}}}}},
+			)
+			return err
+		},
+		Down: func(ctx context.Context, dbh *db.DbHandler) error {
+			_, err := dbh.GetRecipeCollection().UpdateMany(ctx, bson.M{}, bson.M{"$unset": bson.M{"total_time": ""}})
+			return err
+		},
+	},
+	{
+		Version:     7,
+		Description: "Index the recipes and revisions of the shared collection by tenant",
+		Up: func(ctx context.Context, dbh *db.DbHandler) error {
+			_, err := dbh.GetRecipeCollection().Indexes().CreateOne(ctx, mongo.IndexModel{
+				Keys:    bson.D{{Key: "tenant_id", Value: 1}, {Key: "created_at", Value: 1}},
+				Options: options.Index().SetName("tenant_created_at").SetSparse(true),
+			})
+			if err != nil {
+				return err
+			}
+			_, err = dbh.GetRevisionCollection().Indexes().CreateOne(ctx, mongo.IndexModel{
+				Keys:    bson.D{{Key: "tenant_id", Value: 1}, {Key: "recipe_id", Value: 1}},
+				Options: options.Index().SetName("tenant_recipe").SetSparse(true),
+			})
+			return err
+		},
+		Down: func(ctx context.Context, dbh *db.DbHandler) error {
+			if _, err := dbh.GetRecipeCollection().Indexes().DropOne(ctx, "tenant_created_at"); err != nil {
+				return err
+			}
+			_, err := dbh.GetRevisionCollection().Indexes().DropOne(ctx, "tenant_recipe")
+			return err
+		},
+	},
+	{
+		Version:     8,
+		Description: "Store the normalized name of the recipes for the detection of the duplicates",
+		Up: func(ctx context.Context, dbh *db.DbHandler) error {
+			// The normalization folds the diacritics, out of reach of an update pipeline. EnsureIndexes creates the index.
+			cursor, err := dbh.GetRecipeCollection().Find(ctx, bson.M{"name_key": bson.M{"$exists": false}},
+				options.Find().SetProjection(bson.M{"name": 1}))
+			if err != nil {
+				return err
+			}
+			var recipes []struct {
+				ID   any    `bson:"_id"`
+				Name string `bson:"name"`
+			}
+			if err := cursor.All(ctx, &recipes); err != nil {
+				return err
+			}
+			updates := make([]mongo.WriteModel, 0, len(recipes))
+			for _, recipe := range recipes {
+				updates = append(updates, mongo.NewUpdateOneModel().
+					SetFilter(bson.M{"_id": recipe.ID}).
+					SetUpdate(bson.M{"$set": bson.M{"name_key": db.NormalizeName(recipe.Name)}}))
+			}
+			if len(updates) == 0 {
+				return nil
+			}
+			_, err = dbh.GetRecipeCollection().BulkWrite(ctx, updates, options.BulkWrite().SetOrdered(false))
+			return err
+		},
+		Down: func(ctx context.Context, dbh *db.DbHandler) error {
+			_, err := dbh.GetRecipeCollection().UpdateMany(ctx, bson.M{}, bson.M{"$unset": bson.M{"name_key": ""}})
+			return err
+		},
+	},
+}
@@ -0,0 +1,78 @@
+package migrations
+
+import (
+	"context"
+	"recipes/db"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const (
+	MigrationsCollectionName = "schema_migrations"
+	LockCollectionName       = "schema_migrations_lock"
+	lockID                   = "lock"
+)
+
+// MongoState stores the applied migrations and the lock next to the recipe collection
+type MongoState struct {
+	migrations *mongo.Collection
+	locks      *mongo.Collection
+}
+
+func NewMongoState(dbh *db.DbHandler) *MongoState {
+	database := dbh.Client.Database(dbh.DBName)
+	return &MongoState{
+		migrations: database.Collection(MigrationsCollectionName),
+		locks:      database.Collection(LockCollectionName),
+	}
+}
+
+func (s *MongoState) Applied(ctx context.Context) ([]Record, error) {
+	cursor, err := s.migrations.Find(ctx, bson.M{}, options.Find().SetSort(bson.M{"_id": 1}))
+	if err != nil {
+		return nil, err
+	}
+	records := make([]Record, 0)
+	err = cursor.All(ctx, &records)
+	return records, err
+}
+
+func (s *MongoState) Save(ctx context.Context, record Record) error {
+	_, err := s.migrations.InsertOne(ctx, record)
+	return err
+}
+
+func (s *MongoState) Remove(ctx context.Context, version int) error {
+	_, err := s.migrations.DeleteOne(ctx, bson.M{"_id": version})
+	return err
+}
+
+// Lock takes the lock document if it is free, expired or already ours.
+// When another owner holds it the filter does not match and the upsert fails on the duplicate _id.
+func (s *MongoState) Lock(ctx context.Context, owner string, ttl time.Duration) (bool, error) {
+	now := time.Now().UTC()
+	filter := bson.M{
+		"_id": lockID,
+		"$or": bson.A{
+			bson.M{"owner": owner},
+			bson.M{"expires_at": bson.M{"$lt": now}},
+		},
+	}
+	update := bson.M{"$set": bson.M{"owner": owner, "expires_at": now.Add(ttl)}}
+	_, err := s.locks.UpdateOne(ctx, filter, update, options.Update().SetUpsert(true))
+	if mongo.IsDuplicateKeyError(err) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+func (s *MongoState) Unlock(ctx context.Context, owner string) error {
+	_, err := s.locks.DeleteOne(ctx, bson.M{"_id": lockID, "owner": owner})
+	return err
+}
@@ -0,0 +1,118 @@
+// Package preferences keeps the presentation preferences of the users, in memory or in a JSON file
+package preferences
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"recipes/fileutil"
+	"slices"
+	"strings"
+	"sync"
+)
+
+// Units systems a user can prefer, original shows the units the recipes were written with
+const (
+	OriginalSystem = "original"
+	MetricSystem   = "metric"
+	ImperialSystem = "imperial"
+)
+
+// Preferences of a user, an empty system shows the original units
+type Preferences struct {
+	System string `json:"system" validate:"omitempty,oneof=original metric imperial"`
+}
+
+// The preferences of a user of a tenant, as written in the file
+type entry struct {
+	Tenant string `json:"tenant,omitempty"`
+	User   string `json:"user"`
+	Preferences
+}
+
+type key struct {
+	tenant string
+	user   string
+}
+
+// Store holds the preferences of the users by tenant, it is safe for concurrent use.
+// A store loaded from a file writes every change back to it.
+type Store struct {
+	mu    sync.RWMutex
+	users map[key]Preferences
+	path  string
+}
+
+// NewStore returns an empty store kept in memory
+func NewStore() *Store {
+	return &Store{users: make(map[key]Preferences)}
+}
+
+// Load reads the store of the JSON file, a missing file is an empty store created on the first change
+func Load(path string) (*Store, error) {
+	s := NewStore()
+	s.path = path
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) || err == nil && len(strings.TrimSpace(string(data))) == 0 {
+		return s, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var entries []entry
+	if err := json.Unmarshal(data, &entries); err != nil {
+		return nil, err
+	}
+	for _, e := range entries {
+		s.users[key{e.Tenant, e.User}] = e.Preferences
+	}
+	return s, nil
+}
+
+// Get returns the preferences of the user of the tenant, the tenant is empty without tenancy
+func (s *Store) Get(tenant string, user string) (Preferences, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	preferences, ok := s.users[key{tenant, user}]
+	return preferences, ok
+}
+
+// Set replaces the preferences of the user of the tenant
+func (s *Store) Set(tenant string, user string, preferences Preferences) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	k := key{tenant, user}
+	previous, existed := s.users[k]
+	s.users[k] = preferences
+	if err := s.save(); err != nil {
+		if existed {
+			s.users[k] = previous
+		} else {
+			delete(s.users, k)
+		}
+		return err
+	}
+	return nil
+}
+
+// Write the store to its file, through a temporary file for a crash to never leave half of it
+func (s *Store) save() error {
+	if s.path == "" {
+		return nil
+	}
+	entries := make([]entry, 0, len(s.users))
+	for k, preferences := range s.users {
+		entries = append(entries, entry{Tenant: k.tenant, User: k.user, Preferences: preferences})
+	}
+	slices.SortFunc(entries, func(a, b entry) int {
+		if c := strings.Compare(a.Tenant, b.Tenant); c != 0 {
+			return c
+		}
+		return strings.Compare(a.User, b.User)
+	})
+	data, err := json.MarshalIndent(entries, "", "  ")
+	if err != nil {
+		return err
+	}
+	return fileutil.WriteFileAtomic(s.path, data)
+}
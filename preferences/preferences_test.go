@@ -0,0 +1,38 @@
+package preferences
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestStore(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "preferences.json")
+	s, err := Load(path)
+	if err != nil {
+		t.Fatalf("Expected a missing file to be an empty store, got %v", err)
+	}
+	if _, ok := s.Get("", "arsene"); ok {
+		t.Errorf("Expected no preferences")
+	}
+	if err := s.Set("", "arsene", Preferences{System: ImperialSystem}); err != nil {
+		t.Fatalf("Error when trying to set the preferences: %v", err)
+	}
+	s.Set("acme", "arsene", Preferences{System: MetricSystem})
+
+	loaded, err := Load(path)
+	if err != nil {
+		t.Fatalf("Error when trying to load the preferences: %v", err)
+	}
+	if preferred, _ := loaded.Get("", "arsene"); preferred.System != ImperialSystem {
+		t.Errorf("Expected the imperial system, got %+v", preferred)
+	}
+	if preferred, _ := loaded.Get("acme", "arsene"); preferred.System != MetricSystem {
+		t.Errorf("Expected the user of another tenant to keep their own preferences, got %+v", preferred)
+	}
+
+	os.WriteFile(path, []byte(`[{"user": `), 0o600)
+	if _, err := Load(path); err == nil {
+		t.Errorf("Expected a broken file to fail")
+	}
+}
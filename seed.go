@@ -0,0 +1,117 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"recipes/configuration"
+	"recipes/db"
+	"recipes/seed"
+	"recipes/validation"
+	"time"
+)
+
+const seedUsage = `Usage: recipes seed [-dry-run] [-upsert] [-tenant tenant] [-generate n] [-random-seed s] [file or directory...]
+
+  Seeds the recipes of the JSON and YAML files, a file holds one recipe or a list of recipes.
+  Without files nor -generate, seeds the sample recipes shipped with the service.
+  The recipes already stored are skipped, or updated with -upsert.
+`
+
+// runSeed implements the seed command
+func runSeed(conf *configuration.Configuration, args []string) error {
+	flags := flag.NewFlagSet("seed", flag.ContinueOnError)
+	flags.Usage = func() { fmt.Fprint(flags.Output(), seedUsage) }
+	dryRun := flags.Bool("dry-run", false, "only report what the seeding would do")
+	upsert := flags.Bool("upsert", false, "update the recipes already stored")
+	tenant := flags.String("tenant", "", "tenant of the recipes, required with TENANCY_MODE")
+	generate := flags.Int("generate", 0, "number of synthetic recipes to generate")
+	randomSeed := flags.Int64("random-seed", 1, "seed of the generated recipes, the same seed generates the same recipes")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if *generate < 0 {
+		return errors.New("-generate must be positive")
+	}
+	if *tenant != "" && !db.ValidTenant(*tenant) {
+		return fmt.Errorf("invalid tenant %v", *tenant)
+	}
+
+	recipes, err := seed.Load(flags.Args()...)
+	if err != nil {
+		return err
+	}
+	if flags.NArg() == 0 && *generate == 0 {
+		if recipes, err = seed.Fixtures(); err != nil {
+			return err
+		}
+	}
+	recipes = append(recipes, seed.Generate(*generate, *randomSeed)...)
+	if err := seed.Validate(validation.New(conf), recipes); err != nil {
+		return err
+	}
+
+	store, closeStore, err := openCommandStore(conf)
+	if err != nil {
+		return err
+	}
+	defer closeStore()
+	opts := db.ImportOptions{Mode: db.ImportSkip, DryRun: *dryRun}
+	if *upsert {
+		opts.Mode = db.ImportUpsert
+	}
+	report, err := seed.Seed(db.WithTenant(context.Background(), *tenant), logger, store, recipes, opts)
+	if err != nil {
+		return err
+	}
+
+	verb := "Seeded"
+	if *dryRun {
+		verb = "Would seed"
+	}
+	fmt.Printf("%v %v recipes: %v created, %v updated, %v skipped, %v failed\n",
+		verb, len(recipes), report.Created, report.Updated, report.Skipped, report.Failed)
+	for _, failure := range report.Failures {
+		fmt.Println("  failed", failure)
+	}
+	if report.Failed > 0 {
+		return fmt.Errorf("%v recipes failed", report.Failed)
+	}
+	return nil
+}
+
+// Open the store of the configured backend for a command, the in-memory backend would lose the recipes on exit
+func openCommandStore(conf *configuration.Configuration) (db.RecipeStore, func(), error) {
+	switch conf.StorageBackend {
+	case configuration.MemoryBackend:
+		return nil, nil, fmt.Errorf("the %v storage backend does not outlive the command", configuration.MemoryBackend)
+	case configuration.BoltBackend:
+		store, err := db.OpenBoltStore(conf.BoltPath, conf.DBConnectTimeout)
+		if err != nil {
+			return nil, nil, err
+		}
+		return store, func() { store.Close() }, nil
+	default:
+		dbh, err := db.Connect(context.Background(), conf.DBURI, conf.DBName, conf.RecipesCollectionName, db.ConnectOptions{
+			Timeouts: db.Timeouts{
+				Connect: conf.DBConnectTimeout,
+				Read:    conf.DBReadTimeout,
+				Write:   conf.DBWriteTimeout,
+			},
+			Tenancy:             db.TenancyMode(conf.TenancyMode),
+			Retries:             conf.DBConnectRetries,
+			Backoff:             conf.DBConnectBackoff,
+			MaxBackoff:          conf.DBConnectMaxBackoff,
+			AllowNoTransactions: conf.DBAllowNoTransactions,
+		})
+		if err != nil {
+			return nil, nil, err
+		}
+		return dbh, func() {
+			ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+			defer cancel()
+			dbh.Client.Disconnect(ctx)
+		}, nil
+	}
+}
@@ -0,0 +1,125 @@
+package seed
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math"
+	"math/rand"
+	"recipes/db"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Creation date of the first generated recipe, the next ones follow a minute apart
+var generatedSince = time.Date(2024, time.January, 1, 12, 0, 0, 0, time.UTC)
+
+type sampleIngredient struct {
+	id     string
+	name   string
+	unit   string
+	amount float64
+}
+
+// The ingredients of the generated recipes, with the IDs of the ingredient catalog used by the fixtures
+var sampleIngredients = []sampleIngredient{
+	{"59b40d78cc5d6a001237265e", "pasta", "g", 120},
+	{"5a60f0f6327fe00014912629", "tomato", "g", 100},
+	{"598b651ffd078b0011140a21", "parmesan", "g", 15},
+	{"598b5ebefd078b0011140a17", "onion", "i", 0.5},
+	{"598b5e26fd078b0011140a16", "garlic", "i", 1},
+	{"5f1a2b3c4d5e6f7081920a01", "pumpkin", "kg", 0.2},
+	{"5f1a2b3c4d5e6f7081920a02", "cream", "cs", 5},
+	{"5f1a2b3c4d5e6f7081920a03", "butter", "tbsp", 0.5},
+	{"5f1a2b3c4d5e6f7081920a04", "chicken", "kg", 0.3},
+	{"5f1a2b3c4d5e6f7081920a05", "thyme", "i", 1},
+	{"5f1a2b3c4d5e6f7081920a06", "salt", "tsp", 0.25},
+	{"5f1a2b3c4d5e6f7081920a07", "flour", "g", 40},
+	{"5f1a2b3c4d5e6f7081920a08", "egg", "i", 1},
+	{"5f1a2b3c4d5e6f7081920a09", "milk", "cs", 8},
+	{"5f1a2b3c4d5e6f7081920a0a", "chocolate", "g", 50},
+}
+
+var (
+	sampleAdjectives   = []string{"Rustic", "Creamy", "Spicy", "Smoky", "Golden", "Zesty", "Hearty", "Crispy"}
+	samplePreparations = map[db.Dish][]string{
+		db.Starter: {"soup", "salad", "tartlet", "velouté"},
+		db.Main:    {"gratin", "stew", "risotto", "pie", "roast"},
+		db.Dessert: {"cake", "mousse", "crumble", "pudding"},
+	}
+	sampleAuthors = []string{"Arsène Fougerouse", "Marius Rouvière", "Léa Caradec", "Inès Morel", "Tom Berthier"}
+	sampleSteps   = []string{
+		"Prepare the %v.",
+		"Cook the %v over a medium heat.",
+		"Season the %v to taste.",
+		"Mix the %v with the rest.",
+		"Let the %v rest before serving.",
+	}
+	sampleTimers = []string{"preparation time", "cooking time", "resting time"}
+	dishes       = []db.Dish{db.Starter, db.Main, db.Dessert}
+	timerUnits   = []string{"seconds", "minutes", "hours"}
+)
+
+// Generate returns n valid recipes with random ingredients, timers and steps, for load testing.
+// The same random seed generates the same recipes with the same IDs, seeding them twice only creates them once.
+func Generate(n int, randomSeed int64) []db.Recipe {
+	rng := rand.New(rand.NewSource(randomSeed))
+	recipes := make([]db.Recipe, n)
+	for i := range recipes {
+		createdAt := generatedSince.Add(time.Duration(i) * time.Minute)
+		dish := dishes[rng.Intn(len(dishes))]
+		servings := 1 + rng.Intn(8)
+
+		picked := rng.Perm(len(sampleIngredients))[:2+rng.Intn(5)]
+		ingredients := make([]db.Ingredient, len(picked))
+		for j, index := range picked {
+			sample := sampleIngredients[index]
+			ingredients[j] = db.Ingredient{
+				ID: sample.id,
+				// Rounded to one decimal, the smallest amount accepted
+				Amount: math.Round(float64(servings)*sample.amount*(0.5+rng.Float64())*10) / 10,
+				Unit:   sample.unit,
+			}
+		}
+		main := sampleIngredients[picked[0]].name
+
+		timers := make([]db.Timer, 1+rng.Intn(len(sampleTimers)))
+		for j := range timers {
+			unit := timerUnits[rng.Intn(len(timerUnits))]
+			amount := 1 + rng.Intn(59)
+			if unit == "hours" {
+				amount = 1 + rng.Intn(3)
+			}
+			timers[j] = db.Timer{Name: sampleTimers[j], Amount: amount, Unit: unit}
+		}
+
+		steps := make([]string, 1+rng.Intn(len(sampleSteps)))
+		for j := range steps {
+			steps[j] = fmt.Sprintf(sampleSteps[rng.Intn(len(sampleSteps))], sampleIngredients[picked[rng.Intn(len(picked))]].name)
+		}
+
+		preparations := samplePreparations[dish]
+		recipes[i] = db.Recipe{
+			ID:          generatedID(rng, createdAt),
+			Name:        fmt.Sprintf("%v %v %v", sampleAdjectives[rng.Intn(len(sampleAdjectives))], main, preparations[rng.Intn(len(preparations))]),
+			Author:      sampleAuthors[rng.Intn(len(sampleAuthors))],
+			Description: fmt.Sprintf("A generated %v with %v.", dish, main),
+			Dish:        dish,
+			Servings:    servings,
+			Metadata:    map[string]string{"generated": "true"},
+			Timers:      timers,
+			Steps:       steps,
+			Ingredients: ingredients,
+			CreatedAt:   createdAt,
+		}
+	}
+	return recipes
+}
+
+// An ID of the creation date whose other bytes come from the random source
+func generatedID(rng *rand.Rand, createdAt time.Time) primitive.ObjectID {
+	var id primitive.ObjectID
+	binary.BigEndian.PutUint32(id[:4], uint32(createdAt.Unix()))
+	binary.BigEndian.PutUint64(id[4:], rng.Uint64())
+	return id
+}
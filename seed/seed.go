@@ -0,0 +1,199 @@
+// Package seed fills a recipe store with fixture recipes, loaded from JSON or YAML files, or generated for load testing
+package seed
+
+import (
+	"context"
+	"embed"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"recipes/db"
+	"recipes/validation"
+	"slices"
+	"strings"
+
+	"github.com/sirupsen/logrus"
+	"gopkg.in/yaml.v3"
+)
+
+// Number of recipes written to the store at once
+const batchSize = 500
+
+// ErrInvalidFixture is returned when a fixture cannot be seeded
+var ErrInvalidFixture = errors.New("invalid fixture")
+
+//go:embed fixtures
+var fixtures embed.FS
+
+// Report counts the outcomes of a seeding, Failures details the failed recipes
+type Report struct {
+	DryRun   bool
+	Created  int
+	Updated  int
+	Skipped  int
+	Failed   int
+	Failures []string
+}
+
+// Fixtures returns the sample recipes shipped with the service
+func Fixtures() ([]db.Recipe, error) {
+	entries, err := fs.ReadDir(fixtures, "fixtures")
+	if err != nil {
+		return nil, err
+	}
+	var recipes []db.Recipe
+	for _, entry := range entries {
+		data, err := fs.ReadFile(fixtures, "fixtures/"+entry.Name())
+		if err != nil {
+			return nil, err
+		}
+		decoded, err := decode(entry.Name(), data)
+		if err != nil {
+			return nil, err
+		}
+		recipes = append(recipes, decoded...)
+	}
+	return recipes, nil
+}
+
+// Load reads the recipes of the JSON and YAML files, a directory stands for the files it holds.
+// A file holds either one recipe or a list of recipes.
+func Load(paths ...string) ([]db.Recipe, error) {
+	var recipes []db.Recipe
+	for _, path := range paths {
+		files, err := fixtureFiles(path)
+		if err != nil {
+			return nil, err
+		}
+		for _, file := range files {
+			data, err := os.ReadFile(file)
+			if err != nil {
+				return nil, err
+			}
+			decoded, err := decode(file, data)
+			if err != nil {
+				return nil, err
+			}
+			recipes = append(recipes, decoded...)
+		}
+	}
+	return recipes, nil
+}
+
+// The fixture files of the path, in the order of their names for a directory
+func fixtureFiles(path string) ([]string, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+	if !info.IsDir() {
+		return []string{path}, nil
+	}
+	entries, err := os.ReadDir(path)
+	if err != nil {
+		return nil, err
+	}
+	var files []string
+	for _, entry := range entries {
+		if !entry.IsDir() && isFixture(entry.Name()) {
+			files = append(files, filepath.Join(path, entry.Name()))
+		}
+	}
+	return files, nil
+}
+
+func isFixture(name string) bool {
+	return slices.Contains([]string{".json", ".yaml", ".yml"}, strings.ToLower(filepath.Ext(name)))
+}
+
+// Decode the recipes of a file by its extension.
+// The YAML goes through JSON for the recipes to be read with their JSON field names.
+func decode(name string, data []byte) ([]db.Recipe, error) {
+	if !isFixture(name) {
+		return nil, fmt.Errorf("%w: %v is neither JSON nor YAML", ErrInvalidFixture, name)
+	}
+	if strings.ToLower(filepath.Ext(name)) != ".json" {
+		var document any
+		if err := yaml.Unmarshal(data, &document); err != nil {
+			return nil, fmt.Errorf("%w: %v: %v", ErrInvalidFixture, name, err)
+		}
+		var err error
+		if data, err = json.Marshal(document); err != nil {
+			return nil, fmt.Errorf("%w: %v: %v", ErrInvalidFixture, name, err)
+		}
+	}
+	data = []byte(strings.TrimSpace(string(data)))
+	var recipes []db.Recipe
+	if len(data) > 0 && data[0] == '{' {
+		recipes = make([]db.Recipe, 1)
+		err := json.Unmarshal(data, &recipes[0])
+		if err != nil {
+			return nil, fmt.Errorf("%w: %v: %v", ErrInvalidFixture, name, err)
+		}
+		return recipes, nil
+	}
+	if err := json.Unmarshal(data, &recipes); err != nil {
+		return nil, fmt.Errorf("%w: %v: %v", ErrInvalidFixture, name, err)
+	}
+	return recipes, nil
+}
+
+// Validate checks the recipes like the API does, and that every one has its own ID for the seeding to be idempotent
+func Validate(v *validation.Validation, recipes []db.Recipe) error {
+	var errs []error
+	seen := make(map[string]int, len(recipes))
+	for i := range recipes {
+		recipe := &recipes[i]
+		switch first, ok := seen[recipe.ID.Hex()]; {
+		case recipe.ID.IsZero():
+			errs = append(errs, fmt.Errorf("%w: recipe %v %q has no ID", ErrInvalidFixture, i, recipe.Name))
+			continue
+		case ok:
+			errs = append(errs, fmt.Errorf("%w: recipe %v %q has the ID of recipe %v", ErrInvalidFixture, i, recipe.Name, first))
+			continue
+		}
+		seen[recipe.ID.Hex()] = i
+		if err := v.Validate.Struct(recipe); err != nil {
+			errs = append(errs, fmt.Errorf("%w: recipe %v %q: %v", ErrInvalidFixture, i, recipe.Name, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// Seed imports the recipes by batches, the recipes whose ID is stored are skipped or updated according to the mode.
+// Seeding the same recipes twice only creates them once.
+func Seed(ctx context.Context, l *logrus.Entry, store db.RecipeStore, recipes []db.Recipe, opts db.ImportOptions) (*Report, error) {
+	report := &Report{DryRun: opts.DryRun}
+	for start := 0; start < len(recipes); start += batchSize {
+		batch := recipes[start:min(start+batchSize, len(recipes))]
+		outcomes, err := store.ImportRecipes(ctx, l, batch, opts)
+		if err != nil {
+			l.WithError(err).Error("Error when trying to seed the recipes")
+			return report, err
+		}
+		for i, outcome := range outcomes {
+			switch outcome.Status {
+			case db.ImportCreated:
+				report.Created++
+			case db.ImportUpdated:
+				report.Updated++
+			case db.ImportSkipped:
+				report.Skipped++
+			default:
+				report.Failed++
+				report.Failures = append(report.Failures, fmt.Sprintf("%v %q: %v", batch[i].ID.Hex(), batch[i].Name, outcome.Err))
+			}
+		}
+	}
+	l.WithFields(logrus.Fields{
+		"dryRun":  report.DryRun,
+		"created": report.Created,
+		"updated": report.Updated,
+		"skipped": report.Skipped,
+		"failed":  report.Failed,
+	}).Info("Seeded the recipes")
+	return report, nil
+}
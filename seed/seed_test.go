@@ -0,0 +1,171 @@
+package seed
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"recipes/configuration"
+	"recipes/db"
+	"recipes/validation"
+	"reflect"
+	"testing"
+
+	"github.com/sirupsen/logrus"
+)
+
+func writeFixture(t *testing.T, dir string, name string, content string) string {
+	path := filepath.Join(dir, name)
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatalf("Error when trying to write the fixture: %v", err)
+	}
+	return path
+}
+
+func TestLoad(t *testing.T) {
+	v := validation.New(&configuration.Configuration{})
+
+	t.Run("The sample recipes are valid", func(t *testing.T) {
+		recipes, err := Fixtures()
+		if err != nil {
+			t.Fatalf("Error when trying to load the fixtures: %v", err)
+		}
+		if len(recipes) != 5 {
+			t.Errorf("Expected 5 sample recipes, got %v", len(recipes))
+		}
+		if err := Validate(v, recipes); err != nil {
+			t.Errorf("Expected valid sample recipes, got %v", err)
+		}
+	})
+
+	t.Run("Read JSON and YAML files and directories", func(t *testing.T) {
+		dir := t.TempDir()
+		writeFixture(t, dir, "a.json", `{"id": "65a1c2d3e4f5a6b7c8d9e101", "name": "Crepes", "servings": 2}`)
+		writeFixture(t, dir, "b.yml", "- id: 65a1c2d3e4f5a6b7c8d9e102\n  name: Flan\n  ingredients:\n    - id: egg\n      amount: 2\n      unit: i\n")
+		writeFixture(t, dir, "notes.txt", "not a fixture")
+		other := writeFixture(t, t.TempDir(), "c.yaml", "- id: 65a1c2d3e4f5a6b7c8d9e103\n  name: Gratin\n")
+
+		recipes, err := Load(dir, other)
+		if err != nil {
+			t.Fatalf("Error when trying to load the fixtures: %v", err)
+		}
+		var names []string
+		for _, recipe := range recipes {
+			names = append(names, recipe.Name)
+		}
+		if !reflect.DeepEqual(names, []string{"Crepes", "Flan", "Gratin"}) {
+			t.Errorf("Expected the recipes of the files in order, got %v", names)
+		}
+		if recipes[0].Servings != 2 || recipes[1].Ingredients[0].Amount != 2 || recipes[2].ID.Hex() != "65a1c2d3e4f5a6b7c8d9e103" {
+			t.Errorf("Expected the fields read with their JSON names, got %+v", recipes)
+		}
+	})
+
+	t.Run("Reject the unreadable files", func(t *testing.T) {
+		dir := t.TempDir()
+		for name, content := range map[string]string{
+			"broken.json": `[{"name": `,
+			"broken.yaml": "- name: [",
+			"wrong.json":  `{"servings": "two"}`,
+			"notes.txt":   "not a fixture",
+		} {
+			if _, err := Load(writeFixture(t, dir, name, content)); !errors.Is(err, ErrInvalidFixture) {
+				t.Errorf("Expected %v to be an invalid fixture, got %v", name, err)
+			}
+		}
+		if _, err := Load(filepath.Join(dir, "missing.json")); !errors.Is(err, os.ErrNotExist) {
+			t.Errorf("Expected a missing file to fail, got %v", err)
+		}
+	})
+
+	t.Run("Validate the recipes like the API and require their own IDs", func(t *testing.T) {
+		recipes, _ := Fixtures()
+		noID := recipes[0]
+		noID.ID = [12]byte{}
+		invalid := recipes[1]
+		invalid.Servings = 0
+		for name, recipes := range map[string][]db.Recipe{
+			"without ID":    {noID},
+			"repeated ID":   {recipes[2], recipes[2]},
+			"invalid field": {invalid},
+		} {
+			if err := Validate(v, recipes); !errors.Is(err, ErrInvalidFixture) {
+				t.Errorf("Expected a recipe %v to be invalid, got %v", name, err)
+			}
+		}
+	})
+}
+
+func TestGenerate(t *testing.T) {
+	v := validation.New(&configuration.Configuration{})
+	recipes := Generate(200, 42)
+	if len(recipes) != 200 {
+		t.Fatalf("Expected 200 recipes, got %v", len(recipes))
+	}
+	if err := Validate(v, recipes); err != nil {
+		t.Errorf("Expected valid generated recipes, got %v", err)
+	}
+	if !reflect.DeepEqual(recipes, Generate(200, 42)) {
+		t.Errorf("Expected the same seed to generate the same recipes")
+	}
+	if reflect.DeepEqual(recipes[0].ID, Generate(1, 43)[0].ID) {
+		t.Errorf("Expected another seed to generate other IDs")
+	}
+}
+
+func TestSeed(t *testing.T) {
+	l := logrus.WithField("test", t.Name())
+	ctx := context.Background()
+
+	t.Run("Seeding twice only creates the recipes once", func(t *testing.T) {
+		store := db.NewMemoryStore()
+		fixtures, _ := Fixtures()
+		// More recipes than a batch
+		recipes := append(fixtures, Generate(batchSize+10, 1)...)
+		report, err := Seed(ctx, l, store, recipes, db.ImportOptions{})
+		if err != nil {
+			t.Fatalf("Error when trying to seed: %v", err)
+		}
+		if report.Created != len(recipes) || report.Failed != 0 {
+			t.Errorf("Expected %v created recipes, got %+v", len(recipes), report)
+		}
+
+		report, err = Seed(ctx, l, store, append(fixtures, Generate(batchSize+10, 1)...), db.ImportOptions{})
+		if err != nil {
+			t.Fatalf("Error when trying to seed again: %v", err)
+		}
+		if report.Created != 0 || report.Skipped != len(recipes) {
+			t.Errorf("Expected the seeded recipes skipped, got %+v", report)
+		}
+		if all, _ := store.FindAllRecipes(ctx, l, db.ListOptions{}); all.Total != int64(len(recipes)) {
+			t.Errorf("Expected %v recipes, got %v", len(recipes), all.Total)
+		}
+	})
+
+	t.Run("Update the seeded recipes in upsert mode", func(t *testing.T) {
+		store := db.NewMemoryStore()
+		fixtures, _ := Fixtures()
+		Seed(ctx, l, store, fixtures, db.ImportOptions{})
+
+		fixtures, _ = Fixtures()
+		fixtures[0].Name = "Spaghetti"
+		report, err := Seed(ctx, l, store, fixtures, db.ImportOptions{Mode: db.ImportUpsert})
+		if err != nil || report.Updated != len(fixtures) {
+			t.Fatalf("Expected the recipes updated, got %+v (%v)", report, err)
+		}
+		if found, _ := store.FindRecipeByID(ctx, l, fixtures[0].ID.Hex()); found.Name != "Spaghetti" {
+			t.Errorf("Expected the updated name, got %v", found.Name)
+		}
+	})
+
+	t.Run("A dry run writes nothing", func(t *testing.T) {
+		store := db.NewMemoryStore()
+		report, err := Seed(ctx, l, store, Generate(3, 1), db.ImportOptions{DryRun: true})
+		if err != nil || !report.DryRun || report.Created != 3 {
+			t.Errorf("Expected 3 recipes to be created, got %+v (%v)", report, err)
+		}
+		if all, _ := store.FindAllRecipes(ctx, l, db.ListOptions{}); all.Total != 0 {
+			t.Errorf("Expected no recipe, got %v", all.Total)
+		}
+	})
+}
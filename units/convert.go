@@ -0,0 +1,34 @@
+package units
+
+import (
+	"errors"
+	"fmt"
+)
+
+var (
+	// ErrUnknownUnit is returned when a unit is neither a known abbreviation nor a known label
+	ErrUnknownUnit = errors.New("unknown unit")
+	// ErrIncompatibleUnits is returned when converting between units of different dimensions
+	ErrIncompatibleUnits = errors.New("incompatible units")
+)
+
+// Convert converts the amount between the units, given by their abbreviations or labels
+func Convert(amount float64, from string, to string) (float64, error) {
+	fromUnit, ok := Lookup(from)
+	if !ok {
+		return 0, fmt.Errorf("%w: %v", ErrUnknownUnit, from)
+	}
+	toUnit, ok := Lookup(to)
+	if !ok {
+		return 0, fmt.Errorf("%w: %v", ErrUnknownUnit, to)
+	}
+	return ConvertUnit(amount, fromUnit, toUnit)
+}
+
+// ConvertUnit converts the amount between the units, through the base unit of their dimension
+func ConvertUnit(amount float64, from Unit, to Unit) (float64, error) {
+	if from.Dimension != to.Dimension {
+		return 0, fmt.Errorf("%w: %v is a %v, %v is a %v", ErrIncompatibleUnits, from.Abbreviation, from.Dimension, to.Abbreviation, to.Dimension)
+	}
+	return amount * from.Factor / to.Factor, nil
+}
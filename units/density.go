@@ -0,0 +1,198 @@
+package units
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"recipes/fileutil"
+	"slices"
+	"strings"
+	"sync"
+
+	"gopkg.in/yaml.v3"
+)
+
+var (
+	// ErrUnknownDensity is returned when converting between a volume and a mass for an ingredient without density
+	ErrUnknownDensity = errors.New("unknown density")
+	// ErrInvalidDensity is returned when a density has no ingredient or is not positive
+	ErrInvalidDensity = errors.New("invalid density")
+)
+
+// Density of an ingredient of the catalog, in grams per millilitre
+type Density struct {
+	IngredientID string  `json:"ingredient_id" yaml:"ingredient_id"`
+	GramsPerML   float64 `json:"grams_per_ml" yaml:"grams_per_ml"`
+	// Free text for the editors, e.g. "sifted"
+	Note string `json:"note,omitempty" yaml:"note,omitempty"`
+}
+
+func (d Density) validate() error {
+	if strings.TrimSpace(d.IngredientID) == "" {
+		return fmt.Errorf("%w: the ingredient ID is required", ErrInvalidDensity)
+	}
+	if d.GramsPerML <= 0 {
+		return fmt.Errorf("%w: the density of %v must be positive", ErrInvalidDensity, d.IngredientID)
+	}
+	return nil
+}
+
+// DensityRegistry holds the densities of the ingredients by their catalog ID, it is safe for concurrent use.
+// A registry loaded from a file writes every change back to it.
+type DensityRegistry struct {
+	mu        sync.RWMutex
+	densities map[string]Density
+	path      string
+}
+
+// NewDensityRegistry returns an empty registry kept in memory
+func NewDensityRegistry() *DensityRegistry {
+	return &DensityRegistry{densities: make(map[string]Density)}
+}
+
+// LoadDensities reads the registry of the JSON or YAML file, a list of densities.
+// A missing file is an empty registry, created on the first change.
+func LoadDensities(path string) (*DensityRegistry, error) {
+	r := NewDensityRegistry()
+	r.path = path
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return r, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var densities []Density
+	if isYAML(path) {
+		err = yaml.Unmarshal(data, &densities)
+	} else if len(strings.TrimSpace(string(data))) > 0 {
+		err = json.Unmarshal(data, &densities)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v: %v", ErrInvalidDensity, path, err)
+	}
+	for _, density := range densities {
+		if err := density.validate(); err != nil {
+			return nil, fmt.Errorf("%v: %w", path, err)
+		}
+		r.densities[density.IngredientID] = density
+	}
+	return r, nil
+}
+
+func isYAML(path string) bool {
+	ext := strings.ToLower(filepath.Ext(path))
+	return ext == ".yaml" || ext == ".yml"
+}
+
+// Get returns the density of the ingredient
+func (r *DensityRegistry) Get(ingredientID string) (Density, bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	density, ok := r.densities[ingredientID]
+	return density, ok
+}
+
+// All returns the densities sorted by ingredient ID
+func (r *DensityRegistry) All() []Density {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.sorted()
+}
+
+func (r *DensityRegistry) sorted() []Density {
+	densities := make([]Density, 0, len(r.densities))
+	for _, density := range r.densities {
+		densities = append(densities, density)
+	}
+	slices.SortFunc(densities, func(a, b Density) int { return strings.Compare(a.IngredientID, b.IngredientID) })
+	return densities
+}
+
+// Set adds or replaces the density of its ingredient, it tells if the ingredient had none
+func (r *DensityRegistry) Set(density Density) (bool, error) {
+	if err := density.validate(); err != nil {
+		return false, err
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	previous, existed := r.densities[density.IngredientID]
+	r.densities[density.IngredientID] = density
+	if err := r.save(); err != nil {
+		if existed {
+			r.densities[density.IngredientID] = previous
+		} else {
+			delete(r.densities, density.IngredientID)
+		}
+		return false, err
+	}
+	return !existed, nil
+}
+
+// Delete removes the density of the ingredient, it tells if there was one
+func (r *DensityRegistry) Delete(ingredientID string) (bool, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	previous, existed := r.densities[ingredientID]
+	if !existed {
+		return false, nil
+	}
+	delete(r.densities, ingredientID)
+	if err := r.save(); err != nil {
+		r.densities[ingredientID] = previous
+		return false, err
+	}
+	return true, nil
+}
+
+// Write the registry to its file, through a temporary file for a crash to never leave half of it
+func (r *DensityRegistry) save() error {
+	if r.path == "" {
+		return nil
+	}
+	var data []byte
+	var err error
+	if isYAML(r.path) {
+		data, err = yaml.Marshal(r.sorted())
+	} else {
+		data, err = json.MarshalIndent(r.sorted(), "", "  ")
+	}
+	if err != nil {
+		return err
+	}
+	return fileutil.WriteFileAtomic(r.path, data)
+}
+
+// Convert converts the amount of the ingredient between the units, given by their abbreviations or labels.
+// A volume converts into a mass, and back, with the density of the ingredient.
+func (r *DensityRegistry) Convert(ingredientID string, amount float64, from string, to string) (float64, error) {
+	fromUnit, ok := Lookup(from)
+	if !ok {
+		return 0, fmt.Errorf("%w: %v", ErrUnknownUnit, from)
+	}
+	toUnit, ok := Lookup(to)
+	if !ok {
+		return 0, fmt.Errorf("%w: %v", ErrUnknownUnit, to)
+	}
+	return r.ConvertUnit(ingredientID, amount, fromUnit, toUnit)
+}
+
+// ConvertUnit converts the amount of the ingredient between the units, with its density between a volume and a mass
+func (r *DensityRegistry) ConvertUnit(ingredientID string, amount float64, from Unit, to Unit) (float64, error) {
+	if from.Dimension == to.Dimension {
+		return ConvertUnit(amount, from, to)
+	}
+	if from.Dimension == Count || to.Dimension == Count {
+		return ConvertUnit(amount, from, to)
+	}
+	density, ok := r.Get(ingredientID)
+	if !ok {
+		return 0, fmt.Errorf("%w: no density for ingredient %q, cannot convert %v into %v", ErrUnknownDensity, ingredientID, from.Abbreviation, to.Abbreviation)
+	}
+	if from.Dimension == Volume {
+		return amount * from.Factor * density.GramsPerML / to.Factor, nil
+	}
+	return amount * from.Factor / density.GramsPerML / to.Factor, nil
+}
@@ -0,0 +1,51 @@
+package units
+
+// A unit of a ladder, used for the amounts of at least min of it
+type rung struct {
+	abbreviation string
+	min          float64
+}
+
+// The units an amount moves between as it grows or shrinks, from the largest
+var ladders = [][]rung{
+	{{"kg", 1}, {"g", 0}},
+	{{"l", 1}, {"ml", 0}},
+	{{"lb", 1}, {"oz", 0}},
+	{{"cs", 0.25}, {"tbsp", 1}, {"tsp", 0}},
+}
+
+// Fit converts the amount into the largest unit of the ladder of its unit it fills, e.g. 1200 g into 1.2 kg
+// or 0.5 tbsp into 1.5 tsp. The items are singular up to one and plural above.
+// The amount of a unit without ladder is left as it is.
+func Fit(amount float64, unit Unit) (float64, Unit) {
+	if unit.Dimension == Count {
+		if amount > 1 {
+			unit, _ = ValueOfAbbreviation("is")
+		} else {
+			unit, _ = ValueOfAbbreviation("i")
+		}
+		return amount, unit
+	}
+	for _, ladder := range ladders {
+		if !onLadder(ladder, unit) {
+			continue
+		}
+		for _, rung := range ladder {
+			to, _ := ValueOfAbbreviation(rung.abbreviation)
+			if converted, _ := ConvertUnit(amount, unit, to); converted >= rung.min {
+				return converted, to
+			}
+		}
+	}
+	return amount, unit
+}
+
+// The singular and plural of a unit are on the same ladder
+func onLadder(ladder []rung, unit Unit) bool {
+	for _, rung := range ladder {
+		if other, _ := ValueOfAbbreviation(rung.abbreviation); other.Dimension == unit.Dimension && other.Factor == unit.Factor {
+			return true
+		}
+	}
+	return false
+}
@@ -1,22 +1,58 @@
 package units
 
+// Dimension is what a unit measures, only the units of the same dimension convert into each other
+type Dimension string
+
+const (
+	Mass   Dimension = "mass"
+	Volume Dimension = "volume"
+	Count  Dimension = "count"
+)
+
+// System is the measurement system of a unit
+type System string
+
+// The items and the spoons belong to no system, they are used with both
+const (
+	Metric   System = "metric"
+	Imperial System = "imperial"
+)
+
+// Unit is a unit of the ingredient amounts.
+// Factor is its size in the base unit of its dimension: grams for the mass, millilitres for the volume, items for the count.
+// Fractional units are measured in fractions (½ cup) rather than in decimals (0.5 l) in a kitchen.
 type Unit struct {
 	Label        string
 	Abbreviation string
+	Dimension    Dimension
+	Factor       float64
+	Fractional   bool
+	System       System
 }
 
+// The spoons and cups are the US customary ones
 var (
 	units = []Unit{
-		{"item", "i"},
-		{"items", "is"},
-		{"cup", "c"},
-		{"cups", "cs"},
-		{"tablespoon", "tbsp"},
-		{"teaspoon", "tsp"},
-		{"gram", "g"},
-		{"grams", "g"},
-		{"kilogram", "kg"},
-		{"kilograms", "kg"},
+		{"item", "i", Count, 1, true, ""},
+		{"items", "is", Count, 1, true, ""},
+		{"cup", "c", Volume, 236.5882365, true, Imperial},
+		{"cups", "cs", Volume, 236.5882365, true, Imperial},
+		{"tablespoon", "tbsp", Volume, 14.78676478125, true, ""},
+		{"teaspoon", "tsp", Volume, 4.92892159375, true, ""},
+		{"gram", "g", Mass, 1, false, Metric},
+		{"grams", "g", Mass, 1, false, Metric},
+		{"kilogram", "kg", Mass, 1000, false, Metric},
+		{"kilograms", "kg", Mass, 1000, false, Metric},
+		{"millilitre", "ml", Volume, 1, false, Metric},
+		{"centilitre", "cl", Volume, 10, false, Metric},
+		{"decilitre", "dl", Volume, 100, false, Metric},
+		{"litre", "l", Volume, 1000, false, Metric},
+		{"ounce", "oz", Mass, 28.349523125, true, Imperial},
+		{"pound", "lb", Mass, 453.59237, true, Imperial},
+		{"fluid ounce", "fl oz", Volume, 29.5735295625, true, Imperial},
+		{"pint", "pt", Volume, 473.176473, true, Imperial},
+		{"quart", "qt", Volume, 946.352946, true, Imperial},
+		{"gallon", "gal", Volume, 3785.411784, true, Imperial},
 	}
 	byLabel        = make(map[string]Unit)
 	byAbbreviation = make(map[string]Unit)
@@ -38,3 +74,11 @@ func ValueOfAbbreviation(abbreviation string) (Unit, bool) {
 	unit, ok := byAbbreviation[abbreviation]
 	return unit, ok
 }
+
+// Lookup finds a unit by its abbreviation, or by its label
+func Lookup(name string) (Unit, bool) {
+	if unit, ok := ValueOfAbbreviation(name); ok {
+		return unit, true
+	}
+	return ValueOfLabel(name)
+}
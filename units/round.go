@@ -0,0 +1,39 @@
+package units
+
+import "math"
+
+// RoundStep rounds the amount to the nearest multiple of the step
+func RoundStep(amount float64, step float64) float64 {
+	if step <= 0 {
+		return amount
+	}
+	return math.Round(amount/step) * step
+}
+
+// RoundSignificant rounds the amount to its most significant digits, e.g. 1234 to 1230 with 3 digits
+func RoundSignificant(amount float64, digits int) float64 {
+	if amount == 0 || digits <= 0 {
+		return amount
+	}
+	magnitude := math.Pow(10, float64(digits)-math.Ceil(math.Log10(math.Abs(amount))))
+	return math.Round(amount*magnitude) / magnitude
+}
+
+// RoundKitchen rounds the amount to what can be measured in a kitchen with the unit.
+// The items are counted whole, the other fractional units are rounded to the quarter, or to the eighth below a quarter,
+// the rest to 3 significant digits. A positive amount never rounds to zero.
+func RoundKitchen(amount float64, unit Unit) float64 {
+	switch {
+	case amount <= 0:
+		return amount
+	case unit.Dimension == Count:
+		return max(math.Round(amount), 1)
+	case !unit.Fractional:
+		return RoundSignificant(amount, 3)
+	}
+	step := 0.25
+	if amount < step {
+		step = 0.125
+	}
+	return max(RoundStep(amount, step), step)
+}
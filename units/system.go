@@ -0,0 +1,20 @@
+package units
+
+// The unit an amount of each dimension is converted into for a system, before fitting the ladder of the unit
+var systemUnits = map[System]map[Dimension]string{
+	Metric:   {Mass: "g", Volume: "ml"},
+	Imperial: {Mass: "oz", Volume: "cs"},
+}
+
+// ToSystem converts the amount into the units of the system, fitted and rounded for a kitchen.
+// The amounts of the units of the system, or of no system, are left as they are. It tells if the amount was converted.
+func ToSystem(amount float64, unit Unit, system System) (float64, Unit, bool) {
+	target, ok := systemUnits[system][unit.Dimension]
+	if !ok || unit.System == "" || unit.System == system {
+		return amount, unit, false
+	}
+	to, _ := ValueOfAbbreviation(target)
+	converted, _ := ConvertUnit(amount, unit, to)
+	converted, to = Fit(converted, to)
+	return RoundKitchen(converted, to), to, true
+}
@@ -0,0 +1,247 @@
+package units
+
+import (
+	"errors"
+	"math"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestConvert(t *testing.T) {
+	for _, test := range []struct {
+		amount   float64
+		from, to string
+		expected float64
+		err      error
+	}{
+		{1, "kg", "g", 1000, nil},
+		{250, "g", "kilogram", 0.25, nil},
+		{1, "cup", "tbsp", 16, nil},
+		{1, "tbsp", "tsp", 3, nil},
+		{2, "cs", "ml", 473.176473, nil},
+		{1, "l", "cl", 100, nil},
+		{1, "lb", "oz", 16, nil},
+		{1, "gal", "qt", 4, nil},
+		{1, "pt", "fl oz", 16, nil},
+		{3, "is", "i", 3, nil},
+		{1, "cup", "g", 0, ErrIncompatibleUnits},
+		{1, "i", "kg", 0, ErrIncompatibleUnits},
+		{1, "pinch", "g", 0, ErrUnknownUnit},
+		{1, "g", "stone", 0, ErrUnknownUnit},
+	} {
+		converted, err := Convert(test.amount, test.from, test.to)
+		if !errors.Is(err, test.err) {
+			t.Errorf("Expected %v %v in %v to fail with %v, got %v", test.amount, test.from, test.to, test.err, err)
+			continue
+		}
+		if math.Abs(converted-test.expected) > 1e-9 {
+			t.Errorf("Expected %v %v to be %v %v, got %v", test.amount, test.from, test.expected, test.to, converted)
+		}
+	}
+}
+
+func TestLookup(t *testing.T) {
+	for name, expected := range map[string]string{
+		"g":           "g",
+		"grams":       "g",
+		"cs":          "cs",
+		"cup":         "c",
+		"fluid ounce": "fl oz",
+	} {
+		if unit, ok := Lookup(name); !ok || unit.Abbreviation != expected {
+			t.Errorf("Expected %v to be %v, got %v (%v)", name, expected, unit.Abbreviation, ok)
+		}
+	}
+	if _, ok := Lookup("pinch"); ok {
+		t.Errorf("Expected pinch to be unknown")
+	}
+}
+
+func TestRound(t *testing.T) {
+	grams, _ := Lookup("g")
+	kilograms, _ := Lookup("kg")
+	cups, _ := Lookup("cs")
+	items, _ := Lookup("is")
+	for _, test := range []struct {
+		amount   float64
+		unit     Unit
+		expected float64
+	}{
+		{137.28, grams, 137},
+		{1234.5, grams, 1230},
+		{0.4321, grams, 0.432},
+		{0.13749, kilograms, 0.137},
+		{1.4, cups, 1.5},
+		{0.3, cups, 0.25},
+		{0.2, cups, 0.25},
+		{0.1, cups, 0.125},
+		{0.01, cups, 0.125},
+		{2.6, items, 3},
+		{2.4, items, 2},
+		{0.2, items, 1},
+		{0, items, 0},
+	} {
+		if rounded := RoundKitchen(test.amount, test.unit); math.Abs(rounded-test.expected) > 1e-9 {
+			t.Errorf("Expected %v %v to round to %v, got %v", test.amount, test.unit.Abbreviation, test.expected, rounded)
+		}
+	}
+
+	for _, test := range []struct {
+		amount, step, expected float64
+	}{
+		{7.3, 0.5, 7.5},
+		{7.2, 0.5, 7},
+		{12, 5, 10},
+		{3, 0, 3},
+	} {
+		if rounded := RoundStep(test.amount, test.step); math.Abs(rounded-test.expected) > 1e-9 {
+			t.Errorf("Expected %v to round to %v by %v, got %v", test.amount, test.expected, test.step, rounded)
+		}
+	}
+}
+
+func TestDensityRegistry(t *testing.T) {
+	t.Run("Convert between volumes and masses with the density", func(t *testing.T) {
+		r := NewDensityRegistry()
+		r.Set(Density{IngredientID: "flour", GramsPerML: 0.5})
+		for _, test := range []struct {
+			ingredient string
+			amount     float64
+			from, to   string
+			expected   float64
+			err        error
+		}{
+			{"flour", 2, "cs", "g", 236.5882365, nil},
+			{"flour", 1, "tbsp", "kg", 0.0073933823906, nil},
+			{"flour", 236.5882365, "g", "cs", 2, nil},
+			{"flour", 3, "tsp", "tbsp", 1, nil},
+			{"sugar", 1, "kg", "g", 1000, nil},
+			{"sugar", 1, "cs", "g", 0, ErrUnknownDensity},
+			{"flour", 1, "i", "g", 0, ErrIncompatibleUnits},
+			{"flour", 1, "cs", "pinch", 0, ErrUnknownUnit},
+		} {
+			converted, err := r.Convert(test.ingredient, test.amount, test.from, test.to)
+			if !errors.Is(err, test.err) {
+				t.Errorf("Expected %v %v of %v in %v to fail with %v, got %v", test.amount, test.from, test.ingredient, test.to, test.err, err)
+				continue
+			}
+			if math.Abs(converted-test.expected) > 1e-9 {
+				t.Errorf("Expected %v %v of %v to be %v %v, got %v", test.amount, test.from, test.ingredient, test.expected, test.to, converted)
+			}
+		}
+	})
+
+	t.Run("Reject the invalid densities", func(t *testing.T) {
+		r := NewDensityRegistry()
+		for _, density := range []Density{{IngredientID: "", GramsPerML: 1}, {IngredientID: "flour", GramsPerML: 0}, {IngredientID: "flour", GramsPerML: -1}} {
+			if _, err := r.Set(density); !errors.Is(err, ErrInvalidDensity) {
+				t.Errorf("Expected %+v to be invalid, got %v", density, err)
+			}
+		}
+	})
+
+	for _, name := range []string{"densities.json", "densities.yaml"} {
+		t.Run("Write the changes back to "+name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), name)
+			r, err := LoadDensities(path)
+			if err != nil {
+				t.Fatalf("Expected a missing file to be an empty registry, got %v", err)
+			}
+			if created, err := r.Set(Density{IngredientID: "milk", GramsPerML: 1.03}); !created || err != nil {
+				t.Fatalf("Expected the density to be created, got %v (%v)", created, err)
+			}
+			r.Set(Density{IngredientID: "flour", GramsPerML: 0.53, Note: "sifted"})
+			r.Set(Density{IngredientID: "sugar", GramsPerML: 0.85})
+			if deleted, _ := r.Delete("sugar"); !deleted {
+				t.Errorf("Expected the density to be deleted")
+			}
+			if deleted, _ := r.Delete("sugar"); deleted {
+				t.Errorf("Expected no density to delete")
+			}
+
+			loaded, err := LoadDensities(path)
+			if err != nil {
+				t.Fatalf("Error when trying to load the densities: %v", err)
+			}
+			expected := []Density{{IngredientID: "flour", GramsPerML: 0.53, Note: "sifted"}, {IngredientID: "milk", GramsPerML: 1.03}}
+			if !reflect.DeepEqual(loaded.All(), expected) {
+				t.Errorf("Expected %+v, got %+v", expected, loaded.All())
+			}
+		})
+	}
+
+	t.Run("Reject the invalid files", func(t *testing.T) {
+		dir := t.TempDir()
+		for name, content := range map[string]string{
+			"broken.json":   `[{"ingredient_id": `,
+			"negative.yaml": "- ingredient_id: flour\n  grams_per_ml: -1\n",
+		} {
+			path := filepath.Join(dir, name)
+			os.WriteFile(path, []byte(content), 0o600)
+			if _, err := LoadDensities(path); !errors.Is(err, ErrInvalidDensity) {
+				t.Errorf("Expected %v to be invalid, got %v", name, err)
+			}
+		}
+	})
+}
+
+func TestFit(t *testing.T) {
+	for _, test := range []struct {
+		amount   float64
+		unit     string
+		expected float64
+		fitted   string
+	}{
+		{1200, "g", 1.2, "kg"},
+		{0.5, "kg", 500, "g"},
+		{800, "g", 800, "g"},
+		{6, "tsp", 2, "tbsp"},
+		{0.5, "tbsp", 1.5, "tsp"},
+		{8, "tbsp", 0.5, "cs"},
+		{0.125, "cs", 2, "tbsp"},
+		{2, "c", 2, "cs"},
+		{1500, "ml", 1.5, "l"},
+		{24, "oz", 1.5, "lb"},
+		{3, "i", 3, "is"},
+		{1, "is", 1, "i"},
+		{3, "dl", 3, "dl"},
+	} {
+		unit, _ := Lookup(test.unit)
+		amount, fitted := Fit(test.amount, unit)
+		if math.Abs(amount-test.expected) > 1e-9 || fitted.Abbreviation != test.fitted {
+			t.Errorf("Expected %v %v to fit in %v %v, got %v %v", test.amount, test.unit, test.expected, test.fitted, amount, fitted.Abbreviation)
+		}
+	}
+}
+
+func TestToSystem(t *testing.T) {
+	for _, test := range []struct {
+		amount    float64
+		unit      string
+		system    System
+		expected  float64
+		converted string
+	}{
+		{480, "g", Imperial, 1, "lb"},
+		{200, "g", Imperial, 7, "oz"},
+		{1.5, "kg", Imperial, 3.25, "lb"},
+		{1, "cs", Metric, 237, "ml"},
+		{5, "cs", Metric, 1.18, "l"},
+		{8, "oz", Metric, 227, "g"},
+		{3, "lb", Metric, 1.36, "kg"},
+		{250, "ml", Imperial, 1, "cs"},
+		{30, "ml", Imperial, 2, "tbsp"},
+		{2, "tbsp", Metric, 2, "tbsp"},
+		{3, "is", Imperial, 3, "is"},
+		{250, "g", Metric, 250, "g"},
+		{2, "cs", Imperial, 2, "cs"},
+	} {
+		unit, _ := Lookup(test.unit)
+		amount, converted, _ := ToSystem(test.amount, unit, test.system)
+		if math.Abs(amount-test.expected) > 1e-9 || converted.Abbreviation != test.converted {
+			t.Errorf("Expected %v %v to be %v %v in %v, got %v %v", test.amount, test.unit, test.expected, test.converted, test.system, amount, converted.Abbreviation)
+		}
+	}
+}